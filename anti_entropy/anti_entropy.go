@@ -7,9 +7,8 @@ import (
 	"log"
 	"net/http"
 	"sanddb/db"
+	"sanddb/messages"
 	"sanddb/utils"
-	"sort"
-	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -38,15 +37,9 @@ func (h *AntiEntropyHandler) HandleFullRepairRequest(c *fiber.Ctx) error {
 	// LAPLUS represents the status of the anti-entropy repair process (defaults to NOTHING_CHANGED)
 	LAPLUS := NOTHING_CHANGED
 	nodeID := h.Node.Id
-	file, err := ioutil.ReadFile("data/" + strconv.Itoa(nodeID) + ".json")
+	data, err := h.Storage.LocalData()
 	if err != nil {
-		log.Println("Error reading file:", err)
-		return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
-	}
-	var data db.LocalData
-	err = json.Unmarshal([]byte(file), &data)
-	if err != nil {
-		log.Println("Error unmarshalling data:", err)
+		log.Println("Error reading data:", err)
 		return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
 	}
 
@@ -61,15 +54,16 @@ func (h *AntiEntropyHandler) HandleFullRepairRequest(c *fiber.Ctx) error {
 	var existingData []RepairGetRequest
 
 	for _, table := range data {
+		strategy := table.Strategy(ring.Strategy)
 		for _, partition := range table.Partitions {
-			index := ring.Search(partition.Metadata.PartitionKey)
+			replicas := ring.NaturalReplicasForToken(strategy, partition.Metadata.PartitionKey)
 			// Perform anti-entropy repair for the Primary Range.
 			// We should not initiate repair of data "owned" by other nodes in this node since that is not this node's responsibility!
 			// This is to avoid redundant repairs for the sake of performance and to keep the entire protocol simple.
-			if index == nodeID {
+			if len(replicas) > 0 && replicas[0].Id == nodeID {
 				for _, row := range partition.Rows {
 					// Perform the normal operation here
-					dataFromReplicas := make([]RepairGetResponse, h.Ring.ReplicationFactor)
+					dataFromReplicas := make([]RepairGetResponse, strategy.ReplicationFactor())
 					// We basically get a hash representation of the binary form of the row
 					// This is super hacky, but nobody cares!
 					cells, err := json.Marshal(row)
@@ -83,7 +77,7 @@ func (h *AntiEntropyHandler) HandleFullRepairRequest(c *fiber.Ctx) error {
 					dataFromReplicas[0] = RepairGetResponse{
 						Data:   row,
 						Hash:   hash,
-						NodeID: index,
+						NodeID: nodeID,
 					}
 					requestData := RepairGetRequest{
 						TableName:         table.TableName,
@@ -93,8 +87,11 @@ func (h *AntiEntropyHandler) HandleFullRepairRequest(c *fiber.Ctx) error {
 					}
 					// Append to existingData
 					existingData = append(existingData, requestData)
-					for i := 1; i < h.Ring.ReplicationFactor; i++ {
-						secondaryIndex := (index + i) % len(h.Ring.NodeHashes)
+					if len(replicas) != strategy.ReplicationFactor() {
+						log.Println("Not enough replicas to perform anti-entropy repair!")
+						return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: Not enough replicas to perform anti-entropy repair!")
+					}
+					for i := 1; i < strategy.ReplicationFactor(); i++ {
 						// Prepare POST body
 						requestBody, err := json.Marshal(requestData)
 						if err != nil {
@@ -103,7 +100,7 @@ func (h *AntiEntropyHandler) HandleFullRepairRequest(c *fiber.Ctx) error {
 						}
 						postBody := bytes.NewBuffer(requestBody)
 						// Perform POST request
-						response, err := netClient.Post(h.Ring.Nodes[secondaryIndex].IPAddress+h.Ring.Nodes[secondaryIndex].Port+"/internal/repair/get_data", "application/json", postBody)
+						response, err := netClient.Post(replicas[i].IPAddress+replicas[i].Port+"/internal/repair/get_data", "application/json", postBody)
 						if err != nil {
 							log.Println("Error performing POST request:", err)
 							return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
@@ -140,80 +137,30 @@ func (h *AntiEntropyHandler) HandleFullRepairRequest(c *fiber.Ctx) error {
 						dataFromReplicas[i] = responseData
 					}
 
-					if len(dataFromReplicas) != h.Ring.ReplicationFactor {
+					if len(dataFromReplicas) != strategy.ReplicationFactor() {
 						log.Println("Not enough replicas to perform anti-entropy repair!")
 						return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: Not enough replicas to perform anti-entropy repair!")
 					}
 
-					// Select the most updated data
-					latestDataIndex := 0
-					latestUpdatedAt := dataFromReplicas[0].Data.UpdatedAt.UnixNano()
-					for j := 1; j < len(dataFromReplicas); j++ {
-						replicaData := dataFromReplicas[j]
-						// TODO: Hmm seems like -1 is actually a valid legal hash value for an int64 data type, might need another placeholder for this (idea: use null/empty string check for the "data" field?)
-						if replicaData.Hash != -1 {
-							if replicaData.Data.UpdatedAt.UnixNano() > latestUpdatedAt {
-								latestUpdatedAt = replicaData.Data.UpdatedAt.UnixNano()
-								latestDataIndex = j
-							} else if replicaData.Data.UpdatedAt.UnixNano() == latestUpdatedAt {
-								latestReplicaData, err := json.Marshal(dataFromReplicas[latestDataIndex].Data)
-								if err != nil {
-									log.Println("Error marshalling row:", err)
-									return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
-								}
-								currentReplicaData, err := json.Marshal(replicaData.Data)
-								if err != nil {
-									log.Println("Error marshalling row:", err)
-									return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
-								}
-								// You might complain about this tie-breaking mechanism to get the total order, but this is what Apache Cassandra actually does :)
-								compareResult := bytes.Compare(currentReplicaData, latestReplicaData)
-								// If the data itself is equal, it doesn't matter which version we choose
-								// Additionally, if the data is the same, we won't enter this update path in the first place
-								// Hence, we just need to update the latestDataIndex if the data itself is different
-								if compareResult > 0 {
-									latestDataIndex = j
-								}
-							}
-						}
-					}
-
-					// Get all the indices from dataFromReplicas that need updating
-					nodesToUpdate := make([]int, 0)
-					for i, data := range dataFromReplicas {
-						// TODO: Ditto about the -1 hash value
-						if data.Hash == -1 {
-							// This means that the replica does not have this data and thus we need to write
-							nodesToUpdate = append(nodesToUpdate, i)
-						} else if data.Data.UpdatedAt.UnixNano() < latestUpdatedAt {
-							nodesToUpdate = append(nodesToUpdate, i)
-						} else if data.Data.UpdatedAt.UnixNano() == latestUpdatedAt && i != latestDataIndex {
-							latestReplicaData, err := json.Marshal(dataFromReplicas[latestDataIndex].Data)
-							if err != nil {
-								log.Println("Error marshalling row:", err)
-								return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
-							}
-							currentReplicaData, err := json.Marshal(data.Data)
-							if err != nil {
-								log.Println("Error marshalling row:", err)
-								return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
-							}
-							compareResult := bytes.Compare(currentReplicaData, latestReplicaData)
-							if compareResult < 0 {
-								nodesToUpdate = append(nodesToUpdate, i)
-							}
-						}
-					}
+					// Reconcile the versions held by the replicas cell by cell, and find the replicas that are missing part of it
+					latestData, nodesToUpdate := reconcileReplicaData(dataFromReplicas)
 
 					updateRequest := RepairWriteRequest{
-						TableName:          table.TableName,
-						PartitionKeyNames:  table.PartitionKeyNames,
-						ClusteringKeyNames: table.ClusteringKeyNames,
+						TableName:           table.TableName,
+						PartitionKeyNames:   table.PartitionKeyNames,
+						ClusteringKeyNames:  table.ClusteringKeyNames,
+						Columns:             table.Columns,
+						ClusteringOrder:     table.ClusteringOrder,
+						Compaction:          table.Compaction,
+						BloomFilterFPChance: table.BloomFilterFPChance,
+						View:                table.View,
+						CreatedAt:           table.CreatedAt.UnixMicro(),
+						Keyspace:            table.KeyspaceRequest(),
 						Partitions: []*db.Partition{
 							{
 								Metadata: partition.Metadata,
 								Rows: []*db.Row{
-									dataFromReplicas[latestDataIndex].Data,
+									latestData,
 								},
 							},
 						},
@@ -223,7 +170,7 @@ func (h *AntiEntropyHandler) HandleFullRepairRequest(c *fiber.Ctx) error {
 					// Perform POST request to ALL replicas specified in nodesToUpdate (which might include this primary node itself)
 					// This method ensures that there will be no further stale values/version conflicts between the data stored in the RAM and the data stored on disk for the current primary node, guaranteeing consistency
 					for _, id := range nodesToUpdate {
-						replicaID := (nodeID + id) % len(h.Ring.NodeHashes)
+						replica := replicas[id]
 
 						requestBody, err := json.Marshal(updateRequest)
 						if err != nil {
@@ -234,7 +181,7 @@ func (h *AntiEntropyHandler) HandleFullRepairRequest(c *fiber.Ctx) error {
 						// Prepare POST body
 						updateBody := bytes.NewBuffer(requestBody)
 
-						updateResponse, err := netClient.Post(h.Ring.Nodes[replicaID].IPAddress+h.Ring.Nodes[replicaID].Port+"/internal/repair/write_data", "application/json", updateBody)
+						updateResponse, err := netClient.Post(replica.IPAddress+replica.Port+"/internal/repair/write_data", "application/json", updateBody)
 						if err != nil {
 							log.Println("Error performing POST request:", err)
 							return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
@@ -254,7 +201,9 @@ func (h *AntiEntropyHandler) HandleFullRepairRequest(c *fiber.Ctx) error {
 	}
 
 	// Send missing subrepair requests (with existingData) to the replicas
-	for i := 1; i < h.Ring.ReplicationFactor; i++ {
+	// With several tokens per node, the primary ranges of this node are replicated by most of the other nodes
+	primaryRangeReplicas := ring.PrimaryRangeReplicas(h.Node, data.Strategies(ring.Strategy))
+	for _, replica := range primaryRangeReplicas[1:] {
 		subrepairRequest := SubrepairRequest{
 			ExistingData: existingData,
 			NodeID:       nodeID,
@@ -265,8 +214,7 @@ func (h *AntiEntropyHandler) HandleFullRepairRequest(c *fiber.Ctx) error {
 			return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
 		}
 		subrepairBody := bytes.NewBuffer(subrepairRequestBody)
-		replicaID := (nodeID + i) % len(h.Ring.NodeHashes)
-		subrepairResponse, err := netClient.Post(h.Ring.Nodes[replicaID].IPAddress+h.Ring.Nodes[replicaID].Port+"/internal/repair/missing_subrepair", "application/json", subrepairBody)
+		subrepairResponse, err := netClient.Post(replica.IPAddress+replica.Port+"/internal/repair/missing_subrepair", "application/json", subrepairBody)
 		if err != nil {
 			log.Println("Error performing POST request:", err)
 			return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
@@ -300,15 +248,14 @@ func (h *AntiEntropyHandler) HandleFullRepairRequest(c *fiber.Ctx) error {
 	deleteRequest := RepairDeleteRequest{
 		NodeID: nodeID,
 	}
-	for i := 0; i < h.Ring.ReplicationFactor; i++ {
+	for _, replica := range primaryRangeReplicas {
 		deleteRequestBody, err := json.Marshal(deleteRequest)
 		if err != nil {
 			log.Println("Error marshalling request data:", err)
 			return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
 		}
 		deleteBody := bytes.NewBuffer(deleteRequestBody)
-		replicaID := (nodeID + i) % len(h.Ring.NodeHashes)
-		deleteResponse, err := netClient.Post(h.Ring.Nodes[replicaID].IPAddress+h.Ring.Nodes[replicaID].Port+"/internal/repair/trigger_delete", "application/json", deleteBody)
+		deleteResponse, err := netClient.Post(replica.IPAddress+replica.Port+"/internal/repair/trigger_delete", "application/json", deleteBody)
 		if err != nil {
 			log.Println("Error performing POST request:", err)
 			return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
@@ -324,9 +271,11 @@ func (h *AntiEntropyHandler) HandleFullRepairRequest(c *fiber.Ctx) error {
 	}
 
 	// Request a primary range repair (not full repair) to all of the following nodes
-	for i := 1; i < len(h.Ring.NodeHashes); i++ {
-		replicaID := (nodeID + i) % len(h.Ring.NodeHashes)
-		primaryRangeRepairResponse, err := netClient.Post(h.Ring.Nodes[replicaID].IPAddress+h.Ring.Nodes[replicaID].Port+"/repair", "application/json", bytes.NewBuffer([]byte{}))
+	for _, node := range ring.AllNodes() {
+		if node.Id == nodeID {
+			continue
+		}
+		primaryRangeRepairResponse, err := netClient.Post(node.IPAddress+node.Port+"/repair", "application/json", bytes.NewBuffer([]byte{}))
 		if err != nil {
 			log.Println("Error performing POST request:", err)
 			return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
@@ -358,15 +307,9 @@ func (h *AntiEntropyHandler) HandleRepairRequest(c *fiber.Ctx) error {
 	}
 	LAPLUS := NOTHING_CHANGED
 	nodeID := h.Node.Id
-	file, err := ioutil.ReadFile("data/" + strconv.Itoa(nodeID) + ".json")
+	data, err := h.Storage.LocalData()
 	if err != nil {
-		log.Println("Error reading file:", err)
-		return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
-	}
-	var data db.LocalData
-	err = json.Unmarshal([]byte(file), &data)
-	if err != nil {
-		log.Println("Error unmarshalling data:", err)
+		log.Println("Error reading data:", err)
 		return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
 	}
 
@@ -375,11 +318,12 @@ func (h *AntiEntropyHandler) HandleRepairRequest(c *fiber.Ctx) error {
 	var existingData []RepairGetRequest
 
 	for _, table := range data {
+		strategy := table.Strategy(ring.Strategy)
 		for _, partition := range table.Partitions {
-			index := ring.Search(partition.Metadata.PartitionKey)
-			if index == nodeID {
+			replicas := ring.NaturalReplicasForToken(strategy, partition.Metadata.PartitionKey)
+			if len(replicas) > 0 && replicas[0].Id == nodeID {
 				for _, row := range partition.Rows {
-					dataFromReplicas := make([]RepairGetResponse, h.Ring.ReplicationFactor)
+					dataFromReplicas := make([]RepairGetResponse, strategy.ReplicationFactor())
 					cells, err := json.Marshal(row)
 					if err != nil {
 						log.Println("Error marshalling row:", err)
@@ -391,7 +335,7 @@ func (h *AntiEntropyHandler) HandleRepairRequest(c *fiber.Ctx) error {
 					dataFromReplicas[0] = RepairGetResponse{
 						Data:   row,
 						Hash:   hash,
-						NodeID: index,
+						NodeID: nodeID,
 					}
 					requestData := RepairGetRequest{
 						TableName:         table.TableName,
@@ -400,15 +344,18 @@ func (h *AntiEntropyHandler) HandleRepairRequest(c *fiber.Ctx) error {
 						NodeID:            nodeID,
 					}
 					existingData = append(existingData, requestData)
-					for i := 1; i < h.Ring.ReplicationFactor; i++ {
-						secondaryIndex := (index + i) % len(h.Ring.NodeHashes)
+					if len(replicas) != strategy.ReplicationFactor() {
+						log.Println("Not enough replicas to perform anti-entropy repair!")
+						return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: Not enough replicas to perform anti-entropy repair!")
+					}
+					for i := 1; i < strategy.ReplicationFactor(); i++ {
 						requestBody, err := json.Marshal(requestData)
 						if err != nil {
 							log.Println("Error marshalling request data:", err)
 							return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
 						}
 						postBody := bytes.NewBuffer(requestBody)
-						response, err := netClient.Post(h.Ring.Nodes[secondaryIndex].IPAddress+h.Ring.Nodes[secondaryIndex].Port+"/internal/repair/get_data", "application/json", postBody)
+						response, err := netClient.Post(replicas[i].IPAddress+replicas[i].Port+"/internal/repair/get_data", "application/json", postBody)
 						if err != nil {
 							log.Println("Error performing POST request:", err)
 							return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
@@ -443,73 +390,30 @@ func (h *AntiEntropyHandler) HandleRepairRequest(c *fiber.Ctx) error {
 						dataFromReplicas[i] = responseData
 					}
 
-					if len(dataFromReplicas) != h.Ring.ReplicationFactor {
+					if len(dataFromReplicas) != strategy.ReplicationFactor() {
 						log.Println("Not enough replicas to perform anti-entropy repair!")
 						return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: Not enough replicas to perform anti-entropy repair!")
 					}
 
-					latestDataIndex := 0
-					latestUpdatedAt := dataFromReplicas[0].Data.UpdatedAt.UnixNano()
-					for j := 1; j < len(dataFromReplicas); j++ {
-						replicaData := dataFromReplicas[j]
-						// TODO: Hmm seems like -1 is actually a valid legal hash value for an int64 data type, might need another placeholder for this (idea: use null/empty string check for the "data" field?)
-						if replicaData.Hash != -1 {
-							if replicaData.Data.UpdatedAt.UnixNano() > latestUpdatedAt {
-								latestUpdatedAt = replicaData.Data.UpdatedAt.UnixNano()
-								latestDataIndex = j
-							} else if replicaData.Data.UpdatedAt.UnixNano() == latestUpdatedAt {
-								latestReplicaData, err := json.Marshal(dataFromReplicas[latestDataIndex].Data)
-								if err != nil {
-									log.Println("Error marshalling row:", err)
-									return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
-								}
-								currentReplicaData, err := json.Marshal(replicaData.Data)
-								if err != nil {
-									log.Println("Error marshalling row:", err)
-									return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
-								}
-								compareResult := bytes.Compare(currentReplicaData, latestReplicaData)
-								if compareResult > 0 {
-									latestDataIndex = j
-								}
-							}
-						}
-					}
-
-					nodesToUpdate := make([]int, 0)
-					for i, data := range dataFromReplicas {
-						// TODO: Ditto about the -1 hash value
-						if data.Hash == -1 {
-							nodesToUpdate = append(nodesToUpdate, i)
-						} else if data.Data.UpdatedAt.UnixNano() < latestUpdatedAt {
-							nodesToUpdate = append(nodesToUpdate, i)
-						} else if data.Data.UpdatedAt.UnixNano() == latestUpdatedAt && i != latestDataIndex {
-							latestReplicaData, err := json.Marshal(dataFromReplicas[latestDataIndex].Data)
-							if err != nil {
-								log.Println("Error marshalling row:", err)
-								return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
-							}
-							currentReplicaData, err := json.Marshal(data.Data)
-							if err != nil {
-								log.Println("Error marshalling row:", err)
-								return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
-							}
-							compareResult := bytes.Compare(currentReplicaData, latestReplicaData)
-							if compareResult < 0 {
-								nodesToUpdate = append(nodesToUpdate, i)
-							}
-						}
-					}
+					// Reconcile the versions held by the replicas cell by cell, and find the replicas that are missing part of it
+					latestData, nodesToUpdate := reconcileReplicaData(dataFromReplicas)
 
 					updateRequest := RepairWriteRequest{
-						TableName:          table.TableName,
-						PartitionKeyNames:  table.PartitionKeyNames,
-						ClusteringKeyNames: table.ClusteringKeyNames,
+						TableName:           table.TableName,
+						PartitionKeyNames:   table.PartitionKeyNames,
+						ClusteringKeyNames:  table.ClusteringKeyNames,
+						Columns:             table.Columns,
+						ClusteringOrder:     table.ClusteringOrder,
+						Compaction:          table.Compaction,
+						BloomFilterFPChance: table.BloomFilterFPChance,
+						View:                table.View,
+						CreatedAt:           table.CreatedAt.UnixMicro(),
+						Keyspace:            table.KeyspaceRequest(),
 						Partitions: []*db.Partition{
 							{
 								Metadata: partition.Metadata,
 								Rows: []*db.Row{
-									dataFromReplicas[latestDataIndex].Data,
+									latestData,
 								},
 							},
 						},
@@ -517,7 +421,7 @@ func (h *AntiEntropyHandler) HandleRepairRequest(c *fiber.Ctx) error {
 					}
 
 					for _, id := range nodesToUpdate {
-						replicaID := (nodeID + id) % len(h.Ring.NodeHashes)
+						replica := replicas[id]
 
 						requestBody, err := json.Marshal(updateRequest)
 						if err != nil {
@@ -527,7 +431,7 @@ func (h *AntiEntropyHandler) HandleRepairRequest(c *fiber.Ctx) error {
 
 						updateBody := bytes.NewBuffer(requestBody)
 
-						updateResponse, err := netClient.Post(h.Ring.Nodes[replicaID].IPAddress+h.Ring.Nodes[replicaID].Port+"/internal/repair/write_data", "application/json", updateBody)
+						updateResponse, err := netClient.Post(replica.IPAddress+replica.Port+"/internal/repair/write_data", "application/json", updateBody)
 						if err != nil {
 							log.Println("Error performing POST request:", err)
 							return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
@@ -546,7 +450,9 @@ func (h *AntiEntropyHandler) HandleRepairRequest(c *fiber.Ctx) error {
 		}
 	}
 
-	for i := 1; i < h.Ring.ReplicationFactor; i++ {
+	// With several tokens per node, the primary ranges of this node are replicated by most of the other nodes
+	primaryRangeReplicas := ring.PrimaryRangeReplicas(h.Node, data.Strategies(ring.Strategy))
+	for _, replica := range primaryRangeReplicas[1:] {
 		subrepairRequest := SubrepairRequest{
 			ExistingData: existingData,
 			NodeID:       nodeID,
@@ -557,8 +463,7 @@ func (h *AntiEntropyHandler) HandleRepairRequest(c *fiber.Ctx) error {
 			return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
 		}
 		subrepairBody := bytes.NewBuffer(subrepairRequestBody)
-		replicaID := (nodeID + i) % len(h.Ring.NodeHashes)
-		subrepairResponse, err := netClient.Post(h.Ring.Nodes[replicaID].IPAddress+h.Ring.Nodes[replicaID].Port+"/internal/repair/missing_subrepair", "application/json", subrepairBody)
+		subrepairResponse, err := netClient.Post(replica.IPAddress+replica.Port+"/internal/repair/missing_subrepair", "application/json", subrepairBody)
 		if err != nil {
 			log.Println("Error performing POST request:", err)
 			return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
@@ -589,15 +494,14 @@ func (h *AntiEntropyHandler) HandleRepairRequest(c *fiber.Ctx) error {
 	deleteRequest := RepairDeleteRequest{
 		NodeID: nodeID,
 	}
-	for i := 0; i < h.Ring.ReplicationFactor; i++ {
+	for _, replica := range primaryRangeReplicas {
 		deleteRequestBody, err := json.Marshal(deleteRequest)
 		if err != nil {
 			log.Println("Error marshalling request data:", err)
 			return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
 		}
 		deleteBody := bytes.NewBuffer(deleteRequestBody)
-		replicaID := (nodeID + i) % len(h.Ring.NodeHashes)
-		deleteResponse, err := netClient.Post(h.Ring.Nodes[replicaID].IPAddress+h.Ring.Nodes[replicaID].Port+"/internal/repair/trigger_delete", "application/json", deleteBody)
+		deleteResponse, err := netClient.Post(replica.IPAddress+replica.Port+"/internal/repair/trigger_delete", "application/json", deleteBody)
 		if err != nil {
 			log.Println("Error performing POST request:", err)
 			return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
@@ -630,15 +534,9 @@ func (h *AntiEntropyHandler) HandleRepairGetRequest(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
 	}
 	nodeID := h.Node.Id
-	file, err := ioutil.ReadFile("data/" + strconv.Itoa(nodeID) + ".json")
+	data, err := h.Storage.LocalData()
 	if err != nil {
-		log.Println("Error reading file:", err)
-		return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
-	}
-	var data db.LocalData
-	err = json.Unmarshal([]byte(file), &data)
-	if err != nil {
-		log.Println("Error unmarshalling data:", err)
+		log.Println("Error reading data:", err)
 		return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
 	}
 
@@ -696,144 +594,61 @@ func (h *AntiEntropyHandler) HandleRepairWriteRequest(c *fiber.Ctx) error {
 		log.Println("Error parsing request body:", err)
 		return c.Status(fiber.StatusBadRequest).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
 	}
-	nodeID := h.Node.Id
-	file, err := ioutil.ReadFile("data/" + strconv.Itoa(nodeID) + ".json")
-	if err != nil {
-		log.Println("Error reading file:", err)
-		return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
-	}
-	var data db.LocalData
-	err = json.Unmarshal([]byte(file), &data)
-	if err != nil {
-		log.Println("Error unmarshalling data:", err)
-		return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
-	}
 
 	dataIsUpdated := false
-	dataIsFound := false
-
-	// Edge case where there is no data in local file yet
-	if len(data) == 0 {
-		newTable := &db.Table{
-			TableName:          requestData.TableName,
-			PartitionKeyNames:  requestData.PartitionKeyNames,
-			ClusteringKeyNames: requestData.ClusteringKeyNames,
-			Partitions:         requestData.Partitions,
-		}
-		data = append(data, newTable)
-		if !dataIsUpdated {
-			dataIsUpdated = true
-		}
-	} else {
-	out:
-		for i, table := range data {
-			if table.TableName == requestData.TableName {
-				for j, partition := range table.Partitions {
-					if partition.Metadata.PartitionKey == requestData.Partitions[0].Metadata.PartitionKey {
-						for k, row := range partition.Rows {
-							if row.ClusteringKeyHash == requestData.Partitions[0].Rows[0].ClusteringKeyHash {
-								dataIsFound = true
-								incomingData := requestData.Partitions[0].Rows[0]
-								// Additional check to only execute writing if the incoming data is actually newer than the existing data (which should always be the case if a write_data request is performed in the first place, but just in case)
-								if row.UpdatedAt.UnixNano() < incomingData.UpdatedAt.UnixNano() {
-									if !dataIsUpdated {
-										dataIsUpdated = true
-									}
-									// Update the row data
-									data[i].Partitions[j].Rows[k] = incomingData
-									break out
-								} else if row.UpdatedAt.UnixNano() == incomingData.UpdatedAt.UnixNano() {
-									incomingDataByteArray, err := json.Marshal(incomingData)
-									if err != nil {
-										log.Println("Error marshalling row:", err)
-										return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
-									}
-									currentDataByteArray, err := json.Marshal(row)
-									if err != nil {
-										log.Println("Error marshalling row:", err)
-										return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
-									}
-									compareResult := bytes.Compare(currentDataByteArray, incomingDataByteArray)
-									if compareResult < 0 {
-										if !dataIsUpdated {
-											dataIsUpdated = true
-										}
-										data[i].Partitions[j].Rows[k] = incomingData
-										break out
-									}
-								}
-							}
-						}
-						// Add missing data
-						if !dataIsUpdated && !dataIsFound {
-							dataIsUpdated = true
-							data[i].Partitions[j].Rows = append(data[i].Partitions[j].Rows, requestData.Partitions[0].Rows[0])
-							break out
-						}
-					}
-				}
-				// Add missing data
-				if !dataIsUpdated && !dataIsFound {
-					dataIsUpdated = true
-					data[i].Partitions = append(data[i].Partitions, requestData.Partitions[0])
-					break out
-				}
-			}
-		}
-		// Add missing data
-		if !dataIsUpdated && !dataIsFound {
-			dataIsUpdated = true
-			newTable := &db.Table{
-				TableName:          requestData.TableName,
-				PartitionKeyNames:  requestData.PartitionKeyNames,
-				ClusteringKeyNames: requestData.ClusteringKeyNames,
-				Partitions:         requestData.Partitions,
+
+	// Edge case where the table does not exist locally yet
+	if h.Storage.GetSchema(requestData.TableName) == nil {
+		if requestData.Keyspace != nil {
+			err := h.Storage.CreateKeyspace(*requestData.Keyspace)
+			if err == db.ErrKeyspaceDropped {
+				return c.Status(fiber.StatusBadRequest).SendString("Failed to perform the anti-entropy repair. Keyspace " + requestData.Keyspace.Name + " has been dropped.")
+			} else if err != nil && err != db.ErrKeyspaceExists {
+				log.Println("Error creating keyspace:", err)
+				return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
 			}
-			data = append(data, newTable)
 		}
-	}
-
-	// Sort the rows in each partition
-	for _, table := range data {
-		for _, partition := range table.Partitions {
-			sort.SliceStable(partition.Rows, func(i, j int) bool {
-				return partition.Rows[i].ClusteringKeyHash < partition.Rows[j].ClusteringKeyHash
-			})
+		createRequest := messages.CreateRequest{
+			TableName:           requestData.TableName,
+			PartitionKeyNames:   requestData.PartitionKeyNames,
+			ClusteringKeyNames:  requestData.ClusteringKeyNames,
+			Columns:             requestData.Columns,
+			ClusteringOrder:     requestData.ClusteringOrder,
+			Compaction:          requestData.Compaction,
+			BloomFilterFPChance: requestData.BloomFilterFPChance,
+			View:                requestData.View,
+			Timestamp:           requestData.CreatedAt,
+		}
+		err := h.Storage.CreateTable(createRequest)
+		if err == db.ErrTableDropped {
+			return c.Status(fiber.StatusBadRequest).SendString("Failed to perform the anti-entropy repair. Table " + requestData.TableName + " has been dropped.")
+		} else if err != nil && err != db.ErrTableExists {
+			log.Println("Error creating table:", err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
 		}
 	}
 
-	// Sort the partitions in each table
-	for _, table := range data {
-		sort.SliceStable(table.Partitions, func(i, j int) bool {
-			if table.Partitions[i].Metadata.PartitionKey == table.Partitions[j].Metadata.PartitionKey {
-				return table.Partitions[i].Rows[0].ClusteringKeyHash < table.Partitions[j].Rows[0].ClusteringKeyHash
+	for _, partition := range requestData.Partitions {
+		for _, incomingData := range partition.Rows {
+			row, err := h.Storage.ReadRow(requestData.TableName, partition.Metadata.PartitionKey, incomingData.ClusteringKeyValues)
+			if err != nil {
+				log.Println("Error reading row:", err)
+				return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
 			}
-			return table.Partitions[i].Metadata.PartitionKey < table.Partitions[j].Metadata.PartitionKey
-		})
-	}
-
-	// Sort the tables based on table name, partition key, and clustering key hash before writing to file
-	sort.SliceStable(data, func(i, j int) bool {
-		if data[i].TableName == data[j].TableName {
-			if data[i].Partitions[0].Metadata.PartitionKey == data[j].Partitions[0].Metadata.PartitionKey {
-				return data[i].Partitions[0].Rows[0].ClusteringKeyHash < data[j].Partitions[0].Rows[0].ClusteringKeyHash
+			// Additional check to only execute writing if the incoming data actually has cells newer than the existing data (which should always be the case if a write_data request is performed in the first place, but just in case)
+			if row != nil && db.SameRow(db.MergeRows(row, incomingData), row) {
+				continue
 			}
-			return data[i].Partitions[0].Metadata.PartitionKey < data[j].Partitions[0].Metadata.PartitionKey
-		}
-		return data[i].TableName < data[j].TableName
-	})
-
-	// Write the data back to disk
-	if dataIsUpdated {
-		file, err := json.MarshalIndent(data, "", "  ")
-		if err != nil {
-			log.Println("Error marshalling data:", err)
-			return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
-		}
-		err = ioutil.WriteFile("data/"+strconv.Itoa(nodeID)+".json", file, 0644)
-		if err != nil {
-			log.Println("Error writing file:", err)
-			return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
+			// The storage engine keeps the timestamps of the incoming row as they are
+			err = h.Storage.WritePartition(requestData.TableName, &db.Partition{
+				Metadata: partition.Metadata,
+				Rows:     []*db.Row{incomingData},
+			})
+			if err != nil {
+				log.Println("Error writing row:", err)
+				return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
+			}
+			dataIsUpdated = true
 		}
 	}
 
@@ -858,16 +673,9 @@ func (h *AntiEntropyHandler) HandleRepairDeleteRequest(c *fiber.Ctx) error {
 		log.Println("Error parsing request body:", err)
 		return c.Status(fiber.StatusBadRequest).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
 	}
-	nodeID := h.Node.Id
-	file, err := ioutil.ReadFile("data/" + strconv.Itoa(nodeID) + ".json")
+	data, err := h.Storage.LocalData()
 	if err != nil {
-		log.Println("Error reading file:", err)
-		return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
-	}
-	var data db.LocalData
-	err = json.Unmarshal([]byte(file), &data)
-	if err != nil {
-		log.Println("Error unmarshalling data:", err)
+		log.Println("Error reading data:", err)
 		return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
 	}
 
@@ -875,36 +683,33 @@ func (h *AntiEntropyHandler) HandleRepairDeleteRequest(c *fiber.Ctx) error {
 
 	dataDeleted := false
 
-	for i, table := range data {
-		for j, partition := range table.Partitions {
-			index := ring.Search(partition.Metadata.PartitionKey)
-			// We perform only primary range deletion on behalf of the requestor node
-			if index == requestData.NodeID {
-				for k, row := range partition.Rows {
-					// Technically, negative epoch time is actually valid (before January 1, 1970), but we use it in this middleware application as invalid (other placeholders could be considered in the future)
-					if row.DeletedAt.UnixNano() >= 0 && time.Since(time.Unix(0, row.DeletedAt.UnixNano())) > GC_GRACE_SECONDS {
-						// Delete the row
-						data[i].Partitions[j].Rows = append(partition.Rows[:k], partition.Rows[k+1:]...)
-						if !dataDeleted {
-							dataDeleted = true
-						}
-					}
-				}
-			}
-		}
+	isPurgeable := func(strategy utils.ReplicationStrategy, metadata *db.PartitionMetadata, row *db.Row) bool {
+		primary := ring.PrimaryReplica(strategy, metadata.PartitionKey)
+		// We perform only primary range deletion on behalf of the requestor node
+		// Technically, negative epoch time is actually valid (before January 1, 1970), but we use it in this middleware application as invalid (other placeholders could be considered in the future)
+		return primary != nil && primary.Id == requestData.NodeID && row.IsTombstone() && time.Since(time.Unix(0, row.DeletedAt.UnixNano())) > GC_GRACE_SECONDS
 	}
 
-	// Write the data back to disk
-	if dataDeleted {
-		file, err := json.MarshalIndent(data, "", "  ")
-		if err != nil {
-			log.Println("Error marshalling data:", err)
-			return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
+	for _, table := range data {
+		strategy := table.Strategy(ring.Strategy)
+		tableHasTombstones := false
+		for _, partition := range table.Partitions {
+			for _, row := range partition.Rows {
+				if isPurgeable(strategy, partition.Metadata, row) {
+					tableHasTombstones = true
+				}
+			}
 		}
-		err = ioutil.WriteFile("data/"+strconv.Itoa(nodeID)+".json", file, 0644)
-		if err != nil {
-			log.Println("Error writing file:", err)
-			return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
+		// Tombstones can only be dropped by rewriting the table's SSTables without them
+		if tableHasTombstones {
+			err = h.Storage.Rewrite(table.TableName, func(metadata *db.PartitionMetadata, row *db.Row) bool {
+				return !isPurgeable(strategy, metadata, row)
+			})
+			if err != nil {
+				log.Println("Error rewriting table:", err)
+				return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
+			}
+			dataDeleted = true
 		}
 	}
 
@@ -932,15 +737,9 @@ func (h *AntiEntropyHandler) HandleMissingSubrepairRequest(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
 	}
 	nodeID := h.Node.Id
-	file, err := ioutil.ReadFile("data/" + strconv.Itoa(nodeID) + ".json")
+	data, err := h.Storage.LocalData()
 	if err != nil {
-		log.Println("Error reading file:", err)
-		return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
-	}
-	var data db.LocalData
-	err = json.Unmarshal([]byte(file), &data)
-	if err != nil {
-		log.Println("Error unmarshalling data:", err)
+		log.Println("Error reading data:", err)
 		return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
 	}
 
@@ -952,10 +751,11 @@ func (h *AntiEntropyHandler) HandleMissingSubrepairRequest(c *fiber.Ctx) error {
 	}
 
 	for _, table := range data {
+		strategy := table.Strategy(ring.Strategy)
 		for _, partition := range table.Partitions {
-			index := ring.Search(partition.Metadata.PartitionKey)
+			replicas := ring.NaturalReplicasForToken(strategy, partition.Metadata.PartitionKey)
 			// Do primary range repair for the requestor node
-			if index == requestData.NodeID {
+			if len(replicas) == strategy.ReplicationFactor() && replicas[0].Id == requestData.NodeID {
 				for _, row := range partition.Rows {
 					rowData := RepairGetRequest{
 						TableName:         table.TableName,
@@ -966,7 +766,7 @@ func (h *AntiEntropyHandler) HandleMissingSubrepairRequest(c *fiber.Ctx) error {
 					// Execute repair only if data is not found in the attached existingData
 					if !ExistingDataContains(requestData.ExistingData, rowData) {
 						subrepairResponse.DataToAdd = append(subrepairResponse.DataToAdd, rowData)
-						dataFromReplicas := make([]RepairGetResponse, h.Ring.ReplicationFactor)
+						dataFromReplicas := make([]RepairGetResponse, strategy.ReplicationFactor())
 						cells, err := json.Marshal(row)
 						if err != nil {
 							log.Println("Error marshalling row:", err)
@@ -978,12 +778,11 @@ func (h *AntiEntropyHandler) HandleMissingSubrepairRequest(c *fiber.Ctx) error {
 						dataFromReplicas[0] = RepairGetResponse{
 							Data:   &db.Row{},
 							Hash:   -1,
-							NodeID: index,
+							NodeID: replicas[0].Id,
 						}
 
-						for i := 1; i < h.Ring.ReplicationFactor; i++ {
-							secondaryIndex := (index + i) % len(h.Ring.NodeHashes)
-							if secondaryIndex == nodeID {
+						for i := 1; i < strategy.ReplicationFactor(); i++ {
+							if replicas[i].Id == nodeID {
 								dataFromReplicas[i] = RepairGetResponse{
 									Data:   row,
 									Hash:   hash,
@@ -996,7 +795,7 @@ func (h *AntiEntropyHandler) HandleMissingSubrepairRequest(c *fiber.Ctx) error {
 									return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
 								}
 								postBody := bytes.NewBuffer(requestBody)
-								response, err := netClient.Post(h.Ring.Nodes[secondaryIndex].IPAddress+h.Ring.Nodes[secondaryIndex].Port+"/internal/repair/get_data", "application/json", postBody)
+								response, err := netClient.Post(replicas[i].IPAddress+replicas[i].Port+"/internal/repair/get_data", "application/json", postBody)
 								if err != nil {
 									log.Println("Error performing POST request:", err)
 									return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
@@ -1032,73 +831,30 @@ func (h *AntiEntropyHandler) HandleMissingSubrepairRequest(c *fiber.Ctx) error {
 							}
 						}
 
-						if len(dataFromReplicas) != h.Ring.ReplicationFactor {
+						if len(dataFromReplicas) != strategy.ReplicationFactor() {
 							log.Println("Not enough replicas to perform anti-entropy repair!")
 							return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: Not enough replicas to perform anti-entropy repair!")
 						}
 
-						latestDataIndex := ((nodeID-index)%len(h.Ring.NodeHashes) + len(h.Ring.NodeHashes)) % len(h.Ring.NodeHashes)
-						latestUpdatedAt := dataFromReplicas[latestDataIndex].Data.UpdatedAt.UnixNano()
-						for j := 1; j < len(dataFromReplicas); j++ {
-							replicaData := dataFromReplicas[j]
-							// TODO: Hmm seems like -1 is actually a valid legal hash value for an int64 data type, might need another placeholder for this (idea: use null/empty string check for the "data" field?)
-							if replicaData.Hash != -1 {
-								if replicaData.Data.UpdatedAt.UnixNano() > latestUpdatedAt {
-									latestUpdatedAt = replicaData.Data.UpdatedAt.UnixNano()
-									latestDataIndex = j
-								} else if replicaData.Data.UpdatedAt.UnixNano() == latestUpdatedAt && j != latestDataIndex {
-									latestReplicaData, err := json.Marshal(dataFromReplicas[latestDataIndex].Data)
-									if err != nil {
-										log.Println("Error marshalling row:", err)
-										return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
-									}
-									currentReplicaData, err := json.Marshal(replicaData.Data)
-									if err != nil {
-										log.Println("Error marshalling row:", err)
-										return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
-									}
-									compareResult := bytes.Compare(currentReplicaData, latestReplicaData)
-									if compareResult > 0 {
-										latestDataIndex = j
-									}
-								}
-							}
-						}
-
-						nodesToUpdate := make([]int, 0)
-						for i, data := range dataFromReplicas {
-							// TODO: Ditto about the -1 hash value
-							if data.Hash == -1 {
-								nodesToUpdate = append(nodesToUpdate, i)
-							} else if data.Data.UpdatedAt.UnixNano() < latestUpdatedAt {
-								nodesToUpdate = append(nodesToUpdate, i)
-							} else if data.Data.UpdatedAt.UnixNano() == latestUpdatedAt && i != latestDataIndex {
-								latestReplicaData, err := json.Marshal(dataFromReplicas[latestDataIndex].Data)
-								if err != nil {
-									log.Println("Error marshalling row:", err)
-									return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
-								}
-								currentReplicaData, err := json.Marshal(data.Data)
-								if err != nil {
-									log.Println("Error marshalling row:", err)
-									return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
-								}
-								compareResult := bytes.Compare(currentReplicaData, latestReplicaData)
-								if compareResult < 0 {
-									nodesToUpdate = append(nodesToUpdate, i)
-								}
-							}
-						}
+						// Reconcile the versions held by the replicas cell by cell, and find the replicas that are missing part of it
+						latestData, nodesToUpdate := reconcileReplicaData(dataFromReplicas)
 
 						updateRequest := RepairWriteRequest{
-							TableName:          table.TableName,
-							PartitionKeyNames:  table.PartitionKeyNames,
-							ClusteringKeyNames: table.ClusteringKeyNames,
+							TableName:           table.TableName,
+							PartitionKeyNames:   table.PartitionKeyNames,
+							ClusteringKeyNames:  table.ClusteringKeyNames,
+							Columns:             table.Columns,
+							ClusteringOrder:     table.ClusteringOrder,
+							Compaction:          table.Compaction,
+							BloomFilterFPChance: table.BloomFilterFPChance,
+							View:                table.View,
+							CreatedAt:           table.CreatedAt.UnixMicro(),
+							Keyspace:            table.KeyspaceRequest(),
 							Partitions: []*db.Partition{
 								{
 									Metadata: partition.Metadata,
 									Rows: []*db.Row{
-										dataFromReplicas[latestDataIndex].Data,
+										latestData,
 									},
 								},
 							},
@@ -1106,7 +862,7 @@ func (h *AntiEntropyHandler) HandleMissingSubrepairRequest(c *fiber.Ctx) error {
 						}
 
 						for _, id := range nodesToUpdate {
-							replicaID := (index + id) % len(h.Ring.NodeHashes)
+							replica := replicas[id]
 
 							requestBody, err := json.Marshal(updateRequest)
 							if err != nil {
@@ -1116,7 +872,7 @@ func (h *AntiEntropyHandler) HandleMissingSubrepairRequest(c *fiber.Ctx) error {
 
 							updateBody := bytes.NewBuffer(requestBody)
 
-							updateResponse, err := netClient.Post(h.Ring.Nodes[replicaID].IPAddress+h.Ring.Nodes[replicaID].Port+"/internal/repair/write_data", "application/json", updateBody)
+							updateResponse, err := netClient.Post(replica.IPAddress+replica.Port+"/internal/repair/write_data", "application/json", updateBody)
 							if err != nil {
 								log.Println("Error performing POST request:", err)
 								return c.Status(fiber.StatusInternalServerError).SendString("Failed to perform the anti-entropy repair. Error: " + err.Error())
@@ -1156,3 +912,23 @@ func ExistingDataContains(existingData []RepairGetRequest, row RepairGetRequest)
 	}
 	return false
 }
+
+// Reconcile the versions of a row held by the replicas, the same way the storage engine merges them
+// Returns the reconciled row, along with the indices of the replicas that do not hold exactly that version
+func reconcileReplicaData(dataFromReplicas []RepairGetResponse) (*db.Row, []int) {
+	var latestData *db.Row
+	for _, replicaData := range dataFromReplicas {
+		// TODO: Hmm seems like -1 is actually a valid legal hash value for an int64 data type, might need another placeholder for this (idea: use null/empty string check for the "data" field?)
+		if replicaData.Hash != -1 {
+			latestData = db.MergeRows(latestData, replicaData.Data)
+		}
+	}
+	nodesToUpdate := make([]int, 0)
+	for i, data := range dataFromReplicas {
+		// TODO: Ditto about the -1 hash value
+		if data.Hash == -1 || !db.SameRow(data.Data, latestData) {
+			nodesToUpdate = append(nodesToUpdate, i)
+		}
+	}
+	return latestData, nodesToUpdate
+}
@@ -2,6 +2,7 @@ package anti_entropy
 
 import (
 	"sanddb/db"
+	"sanddb/messages"
 	"sanddb/utils"
 	"time"
 )
@@ -20,6 +21,7 @@ type AntiEntropyHandler struct {
 	RepairTimeout          time.Duration
 	InternalRequestTimeout time.Duration
 	GCGraceSeconds         int
+	Storage                *db.StorageEngine
 }
 
 type RepairGetRequest struct {
@@ -35,12 +37,23 @@ type RepairGetResponse struct {
 	NodeID int     `json:"node_id"`
 }
 
+/* RepairWriteRequest
+Carries the definition of the table along with the repaired partitions, for a replica that does not have the table yet.
+CreatedAt: time at which the table was created in microseconds since epoch, a replica that dropped the table after then does not create it again
+*/
 type RepairWriteRequest struct {
-	TableName          string          `json:"table_name"`
-	PartitionKeyNames  []string        `json:"partition_key_names"`
-	ClusteringKeyNames []string        `json:"clustering_key_names"`
-	Partitions         []*db.Partition `json:"partitions"`
-	NodeID             int             `json:"node_id"`
+	TableName           string                      `json:"table_name"`
+	PartitionKeyNames   []string                    `json:"partition_key_names"`
+	ClusteringKeyNames  []string                    `json:"clustering_key_names"`
+	Columns             []messages.ColumnDefinition `json:"columns,omitempty"`
+	ClusteringOrder     []string                    `json:"clustering_order,omitempty"`
+	Compaction          *messages.CompactionOptions `json:"compaction,omitempty"`
+	BloomFilterFPChance float64                     `json:"bloom_filter_fp_chance,omitempty"`
+	View                *messages.ViewOptions       `json:"view,omitempty"`
+	CreatedAt           int64                       `json:"created_at,omitempty"`
+	Keyspace            *messages.KeyspaceRequest   `json:"keyspace,omitempty"`
+	Partitions          []*db.Partition             `json:"partitions"`
+	NodeID              int                         `json:"node_id"`
 }
 
 type SubrepairRequest struct {
@@ -12,4 +12,15 @@ type Configurations struct {
 	ReplicationFactor      int        `mapstructure:"replication_factor"`
 	GCGraceSeconds         int        `mapstructure:"gc_grace_seconds"`
 	Timeout                int        `mapstructure:"timeout"`
+	CommitLogSync          string     `mapstructure:"commitlog_sync"`
+	CommitLogSyncPeriod    int        `mapstructure:"commitlog_sync_period_ms"`
+	CommitLogBatchWindow   int        `mapstructure:"commitlog_batch_window_ms"`
+	MemtableMaxMutations   int        `mapstructure:"memtable_max_mutations"`
+	ConsistencyLevel       string     `mapstructure:"consistency_level"`
+	MaxHintWindow          int        `mapstructure:"max_hint_window_ms"`
+	Seeds                  []string   `mapstructure:"seeds"`
+	GossipInterval         int        `mapstructure:"gossip_interval_ms"`
+	PhiConvictThreshold    float64    `mapstructure:"phi_convict_threshold"`
+	NumTokens              int        `mapstructure:"num_tokens"`
+	Partitioner            string     `mapstructure:"partitioner"`
 }
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+const (
+	DEFAULT_BLOOM_FILTER_FP_CHANCE = 0.01
+	// Upper bound on the number of hash functions, so that very low false-positive chances do not make lookups slow
+	maxBloomFilterHashes  = 20
+	bloomFilterHeaderSize = 12
+)
+
+var errCorruptBloomFilter = errors.New("corrupt bloom filter")
+
+// BloomFilter is a probabilistic set of partition key hashes: it never reports a key that was added as absent,
+// and reports a key that was not added as present with a configurable false-positive chance.
+type BloomFilter struct {
+	hashes int
+	bits   []uint64
+}
+
+// NewBloomFilter sizes a filter for the expected number of keys and false-positive chance.
+// A false-positive chance of 1 or more builds an empty filter that lets every key through.
+func NewBloomFilter(expectedKeys int, fpChance float64) *BloomFilter {
+	if fpChance >= 1 {
+		return &BloomFilter{}
+	}
+	if expectedKeys < 1 {
+		expectedKeys = 1
+	}
+	// Optimal number of bits and hash functions for n keys and false-positive chance p:
+	// m = -n ln(p) / ln(2)^2, k = m/n ln(2)
+	numBits := math.Ceil(-float64(expectedKeys) * math.Log(fpChance) / (math.Ln2 * math.Ln2))
+	hashes := int(math.Round(numBits / float64(expectedKeys) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	} else if hashes > maxBloomFilterHashes {
+		hashes = maxBloomFilterHashes
+	}
+	return &BloomFilter{
+		hashes: hashes,
+		bits:   make([]uint64, (int(numBits)+63)/64),
+	}
+}
+
+// bitIndexes derives the bit positions of a key by double hashing.
+// Partition keys are tokens, which are already hashes unless the order-preserving partitioner is used, so the second hash only needs to remix the bits of the first.
+func (f *BloomFilter) bitIndexes(key int64) []uint64 {
+	numBits := uint64(len(f.bits)) * 64
+	h1 := uint64(key)
+	h2 := h1 ^ (h1 >> 33)
+	h2 *= 0xff51afd7ed558ccd
+	h2 ^= h2 >> 33
+	h2 *= 0xc4ceb9fe1a85ec53
+	h2 ^= h2 >> 33
+	indexes := make([]uint64, f.hashes)
+	for i := range indexes {
+		indexes[i] = (h1 + uint64(i)*h2) % numBits
+	}
+	return indexes
+}
+
+func (f *BloomFilter) Add(key int64) {
+	if len(f.bits) == 0 {
+		return
+	}
+	for _, index := range f.bitIndexes(key) {
+		f.bits[index/64] |= 1 << (index % 64)
+	}
+}
+
+// MightContain returns false only if the key was definitely never added to the filter.
+func (f *BloomFilter) MightContain(key int64) bool {
+	if len(f.bits) == 0 {
+		return true
+	}
+	for _, index := range f.bitIndexes(key) {
+		if f.bits[index/64]&(1<<(index%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// SizeInBytes returns the amount of memory taken by the filter's bits.
+func (f *BloomFilter) SizeInBytes() int64 {
+	return int64(len(f.bits)) * 8
+}
+
+// Serialize encodes the filter as its number of hash functions and bit words, followed by the bit words.
+func (f *BloomFilter) Serialize() []byte {
+	content := make([]byte, bloomFilterHeaderSize+len(f.bits)*8)
+	binary.LittleEndian.PutUint32(content[0:4], uint32(f.hashes))
+	binary.LittleEndian.PutUint64(content[4:12], uint64(len(f.bits)))
+	for i, word := range f.bits {
+		binary.LittleEndian.PutUint64(content[bloomFilterHeaderSize+i*8:], word)
+	}
+	return content
+}
+
+func DeserializeBloomFilter(content []byte) (*BloomFilter, error) {
+	if len(content) < bloomFilterHeaderSize {
+		return nil, errCorruptBloomFilter
+	}
+	hashes := int(binary.LittleEndian.Uint32(content[0:4]))
+	words := binary.LittleEndian.Uint64(content[4:12])
+	if uint64(len(content)-bloomFilterHeaderSize) != words*8 {
+		return nil, errCorruptBloomFilter
+	}
+	filter := &BloomFilter{
+		hashes: hashes,
+		bits:   make([]uint64, words),
+	}
+	for i := range filter.bits {
+		filter.bits[i] = binary.LittleEndian.Uint64(content[bloomFilterHeaderSize+i*8:])
+	}
+	return filter, nil
+}
@@ -0,0 +1,253 @@
+package db
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sanddb/messages"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CLUSTERING_ASC  = "ASC"
+	CLUSTERING_DESC = "DESC"
+)
+
+// validateClusteringOrder checks that the clustering order of a table that is about to be created gives ASC or DESC for every clustering key.
+func validateClusteringOrder(req messages.CreateRequest) error {
+	if len(req.ClusteringOrder) == 0 {
+		return nil
+	}
+	if len(req.ClusteringOrder) != len(req.ClusteringKeyNames) {
+		return fmt.Errorf("expected a clustering_order for each of the %d clustering keys %v, got %d", len(req.ClusteringKeyNames), req.ClusteringKeyNames, len(req.ClusteringOrder))
+	}
+	for i, order := range req.ClusteringOrder {
+		if !strings.EqualFold(order, CLUSTERING_ASC) && !strings.EqualFold(order, CLUSTERING_DESC) {
+			return fmt.Errorf("invalid clustering_order %q of clustering key %s, expected %s or %s", order, req.ClusteringKeyNames[i], CLUSTERING_ASC, CLUSTERING_DESC)
+		}
+	}
+	return nil
+}
+
+// descending reports whether the i-th clustering key of the table is sorted in descending order.
+func (t *Table) descending(i int) bool {
+	return i < len(t.ClusteringOrder) && strings.EqualFold(t.ClusteringOrder[i], CLUSTERING_DESC)
+}
+
+// ClusteringKey encodes the values of the first clustering keys of the table into a string that sorts in their clustering order,
+// according to the type and the order of each key. The encoding of a prefix of the values is a prefix of the encoding of all of them.
+func (t *Table) ClusteringKey(values []string) string {
+	var encoded []byte
+	for i, value := range values {
+		columnType := TYPE_TEXT
+		if i < len(t.ClusteringKeyNames) {
+			columnType, _ = t.ColumnType(t.ClusteringKeyNames[i])
+		}
+		component := encodeClusteringValue(columnType, value)
+		if t.descending(i) {
+			for j := range component {
+				component[j] = ^component[j]
+			}
+		}
+		encoded = append(encoded, component...)
+	}
+	return hex.EncodeToString(encoded)
+}
+
+// encodeClusteringValue encodes a stored value of columnType into bytes that compare in the order of the values.
+// Numbers have a fixed size, and every other value is terminated, so that no encoding is the prefix of another.
+func encodeClusteringValue(columnType string, value string) []byte {
+	switch columnType {
+	case TYPE_INT, TYPE_BIGINT:
+		if number, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return encodeOrderedInt(number)
+		}
+	case TYPE_TIMESTAMP:
+		if t, err := time.Parse(TIMESTAMP_FORMAT, value); err == nil {
+			return encodeOrderedInt(t.UnixNano() / int64(time.Millisecond))
+		}
+	case TYPE_DOUBLE:
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			bits := math.Float64bits(number)
+			// Positive numbers sort after negative ones, and negative ones in the reverse order of their magnitude
+			if bits>>63 == 0 {
+				bits ^= 1 << 63
+			} else {
+				bits = ^bits
+			}
+			encoded := make([]byte, 8)
+			binary.BigEndian.PutUint64(encoded, bits)
+			return encoded
+		}
+	case TYPE_BOOLEAN:
+		if value == "true" {
+			return []byte{1}
+		}
+		return []byte{0}
+	case TYPE_BLOB:
+		if decoded, err := hex.DecodeString(value[2:]); err == nil {
+			return encodeOrderedBytes(decoded)
+		}
+	}
+	return encodeOrderedBytes([]byte(value))
+}
+
+func encodeOrderedInt(number int64) []byte {
+	encoded := make([]byte, 8)
+	binary.BigEndian.PutUint64(encoded, uint64(number)^(1<<63))
+	return encoded
+}
+
+// encodeOrderedBytes escapes every zero byte as 0x00 0xff and terminates the value with 0x00 0x00,
+// which keeps the order of the values and makes the end of the value sort before any byte that could follow it.
+func encodeOrderedBytes(value []byte) []byte {
+	encoded := make([]byte, 0, len(value)+2)
+	for _, b := range value {
+		encoded = append(encoded, b)
+		if b == 0 {
+			encoded = append(encoded, 0xff)
+		}
+	}
+	return append(encoded, 0, 0)
+}
+
+// withClusteringKey returns the row with its clustering key set, for rows written before rows were sorted by it.
+func (t *Table) withClusteringKey(row *Row) *Row {
+	if row == nil || row.ClusteringKey != "" {
+		return row
+	}
+	keyed := *row
+	keyed.ClusteringKey = t.ClusteringKey(row.ClusteringKeyValues)
+	return &keyed
+}
+
+// compareClusteringPrefix compares clustering key values against a bound that may only cover the first few clustering keys.
+// Values that start with the bound compare as equal to it.
+func compareClusteringPrefix(values []string, bound []string) int {
+	for i := 0; i < len(bound) && i < len(values); i++ {
+		if values[i] < bound[i] {
+			return -1
+		} else if values[i] > bound[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// compareClusteringBound compares a row against a bound, by their clustering keys if both have one, and by their values as text otherwise.
+// Rows that start with the bound compare as equal to it.
+func compareClusteringBound(values []string, key string, bound []string, boundKey string) int {
+	if key == "" || boundKey == "" {
+		return compareClusteringPrefix(values, bound)
+	}
+	if strings.HasPrefix(key, boundKey) {
+		return 0
+	}
+	return strings.Compare(key, boundKey)
+}
+
+// Contains reports whether the row with the given clustering key values and clustering key falls within the bounds.
+func (b *ClusteringBounds) Contains(clusteringKeyValues []string, clusteringKey string) bool {
+	if len(b.Start) > 0 {
+		cmp := compareClusteringBound(clusteringKeyValues, clusteringKey, b.Start, b.StartKey)
+		if cmp < 0 || (cmp == 0 && !b.StartInclusive) {
+			return false
+		}
+	}
+	if len(b.End) > 0 {
+		cmp := compareClusteringBound(clusteringKeyValues, clusteringKey, b.End, b.EndKey)
+		if cmp > 0 || (cmp == 0 && !b.EndInclusive) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *ClusteringBounds) sameBounds(other *ClusteringBounds) bool {
+	return b.StartInclusive == other.StartInclusive && b.EndInclusive == other.EndInclusive &&
+		equalKeys(b.Start, other.Start) && equalKeys(b.End, other.End)
+}
+
+// setKeys sets the clustering keys of the bounds of a range of rows of the table.
+func (t *Table) setKeys(bounds *ClusteringBounds) {
+	if len(bounds.Start) > 0 {
+		bounds.StartKey = t.ClusteringKey(bounds.Start)
+	}
+	if len(bounds.End) > 0 {
+		bounds.EndKey = t.ClusteringKey(bounds.End)
+	}
+}
+
+// ReadsSlice reports whether a read asks for a slice of a partition rather than a single row, i.e. does not give every clustering key.
+func (t *Table) ReadsSlice(req *messages.ReadRequest) bool {
+	return len(req.ClusteringKeyValues) < len(t.ClusteringKeyNames)
+}
+
+// SliceBounds returns the bounds of the rows read by a slice that has been validated:
+// the rows that start with its clustering keys, and whose next clustering key falls within its range.
+// A slice that resumes from a paging state only reads the rows that come after the last row it returned.
+func (t *Table) SliceBounds(req *messages.ReadRequest) *ClusteringBounds {
+	prefix := []string(req.ClusteringKeyValues)
+	bounds := &ClusteringBounds{
+		Start:          prefix,
+		StartInclusive: true,
+		End:            prefix,
+		EndInclusive:   true,
+	}
+	if req.Range != nil {
+		lower, lowerInclusive := req.Range.GreaterThan, false
+		if req.Range.GreaterThanOrEqual != nil {
+			lower, lowerInclusive = req.Range.GreaterThanOrEqual, true
+		}
+		upper, upperInclusive := req.Range.LessThan, false
+		if req.Range.LessThanOrEqual != nil {
+			upper, upperInclusive = req.Range.LessThanOrEqual, true
+		}
+		// The lowest values of a descending clustering key come last
+		if t.descending(len(prefix)) {
+			lower, upper = upper, lower
+			lowerInclusive, upperInclusive = upperInclusive, lowerInclusive
+		}
+		if lower != nil {
+			bounds.Start = append(append([]string{}, prefix...), string(*lower))
+			bounds.StartInclusive = lowerInclusive
+		}
+		if upper != nil {
+			bounds.End = append(append([]string{}, prefix...), string(*upper))
+			bounds.EndInclusive = upperInclusive
+		}
+	}
+	t.setKeys(bounds)
+	if state, _ := messages.DecodePagingState(req.PagingState); state != nil {
+		last := state.ClusteringKeyValues
+		lastKey := t.ClusteringKey(last)
+		// The paging state can only narrow the slice down
+		if !req.Reverse && (len(bounds.Start) == 0 || compareClusteringBound(last, lastKey, bounds.Start, bounds.StartKey) >= 0) {
+			bounds.Start, bounds.StartKey, bounds.StartInclusive = last, lastKey, false
+		} else if req.Reverse && (len(bounds.End) == 0 || compareClusteringBound(last, lastKey, bounds.End, bounds.EndKey) <= 0) {
+			bounds.End, bounds.EndKey, bounds.EndInclusive = last, lastKey, false
+		}
+	}
+	return bounds
+}
+
+// LiveRows returns the rows of a partition as seen by clients at the given time, with its range tombstones applied, in clustering order.
+func (t *Table) LiveRows(partition *Partition, now time.Time) []*Row {
+	rows := make([]*Row, 0)
+	if partition == nil {
+		return rows
+	}
+	for _, row := range partition.materialize().Rows {
+		if liveRow := t.LiveView(t.withClusteringKey(row), now); liveRow != nil {
+			rows = append(rows, liveRow)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].ClusteringKey < rows[j].ClusteringKey
+	})
+	return rows
+}
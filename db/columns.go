@@ -0,0 +1,429 @@
+package db
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sanddb/messages"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TYPE_TEXT      = "text"
+	TYPE_INT       = "int"
+	TYPE_BIGINT    = "bigint"
+	TYPE_BOOLEAN   = "boolean"
+	TYPE_DOUBLE    = "double"
+	TYPE_TIMESTAMP = "timestamp"
+	TYPE_UUID      = "uuid"
+	TYPE_BLOB      = "blob"
+)
+
+var columnTypes = []string{TYPE_TEXT, TYPE_INT, TYPE_BIGINT, TYPE_BOOLEAN, TYPE_DOUBLE, TYPE_TIMESTAMP, TYPE_UUID, TYPE_BLOB}
+
+// TIMESTAMP_FORMAT is how timestamp values are stored, in UTC with a fixed millisecond precision so that they sort as text
+const TIMESTAMP_FORMAT = "2006-01-02T15:04:05.000Z07:00"
+
+var (
+	ErrInvalidWrite = errors.New("invalid write")
+	ErrInvalidRead  = errors.New("invalid read")
+	ErrInvalidScan  = errors.New("invalid scan")
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// timestampLayouts are the text forms accepted for timestamp values, besides a number of milliseconds since epoch
+var timestampLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999Z07:00", "2006-01-02 15:04:05.999999999", "2006-01-02"}
+
+// ValidateColumns checks the key and column definitions of a table that is about to be created.
+func ValidateColumns(req messages.CreateRequest) error {
+	if len(req.PartitionKeyNames) == 0 {
+		return errors.New("a table needs at least one partition key")
+	}
+	keys := make(map[string]bool)
+	for _, name := range append(append([]string{}, req.PartitionKeyNames...), req.ClusteringKeyNames...) {
+		if name == "" {
+			return errors.New("key names can not be empty")
+		} else if keys[name] {
+			return fmt.Errorf("key %s is listed more than once", name)
+		}
+		keys[name] = true
+	}
+	columns := make(map[string]bool)
+	for _, column := range req.Columns {
+		if column.Name == "" {
+			return errors.New("column names can not be empty")
+		} else if columns[column.Name] {
+			return fmt.Errorf("column %s is defined more than once", column.Name)
+		}
+		columns[column.Name] = true
+		if !validColumnType(column.Type) {
+			return fmt.Errorf("unknown type %s of column %s, expected %s", column.Type, column.Name, strings.Join(columnTypes, ", "))
+		}
+	}
+	return validateClusteringOrder(req)
+}
+
+func validColumnType(columnType string) bool {
+	for _, valid := range columnTypes {
+		if columnType == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeValue checks that value is a valid value of columnType, and returns the canonical text form that it is stored in,
+// so that e.g. the int values 007 and 7 are the same partition key.
+func NormalizeValue(columnType string, value string) (string, error) {
+	switch columnType {
+	case TYPE_TEXT:
+		return value, nil
+	case TYPE_INT, TYPE_BIGINT:
+		bitSize := 32
+		if columnType == TYPE_BIGINT {
+			bitSize = 64
+		}
+		number, err := strconv.ParseInt(value, 10, bitSize)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a valid %s", value, columnType)
+		}
+		return strconv.FormatInt(number, 10), nil
+	case TYPE_BOOLEAN:
+		switch strings.ToLower(value) {
+		case "true", "false":
+			return strings.ToLower(value), nil
+		}
+		return "", fmt.Errorf("%q is not a valid %s, expected true or false", value, columnType)
+	case TYPE_DOUBLE:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+			return "", fmt.Errorf("%q is not a valid %s", value, columnType)
+		}
+		return strconv.FormatFloat(number, 'g', -1, 64), nil
+	case TYPE_TIMESTAMP:
+		if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return time.Unix(0, millis*int64(time.Millisecond)).UTC().Format(TIMESTAMP_FORMAT), nil
+		}
+		for _, layout := range timestampLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				return t.UTC().Format(TIMESTAMP_FORMAT), nil
+			}
+		}
+		return "", fmt.Errorf("%q is not a valid %s, expected milliseconds since epoch or an RFC 3339 date", value, columnType)
+	case TYPE_UUID:
+		if !uuidPattern.MatchString(value) {
+			return "", fmt.Errorf("%q is not a valid %s", value, columnType)
+		}
+		return strings.ToLower(value), nil
+	case TYPE_BLOB:
+		if !strings.HasPrefix(value, "0x") && !strings.HasPrefix(value, "0X") {
+			return "", fmt.Errorf("%q is not a valid %s, expected hexadecimal bytes starting with 0x", value, columnType)
+		}
+		if _, err := hex.DecodeString(value[2:]); err != nil {
+			return "", fmt.Errorf("%q is not a valid %s, expected hexadecimal bytes starting with 0x", value, columnType)
+		}
+		return strings.ToLower(value), nil
+	}
+	return "", fmt.Errorf("unknown column type %s", columnType)
+}
+
+// EncodeValue returns the JSON form of a stored value of columnType: numbers and booleans as such, everything else as a string.
+func EncodeValue(columnType string, value string) json.RawMessage {
+	switch columnType {
+	case TYPE_INT, TYPE_BIGINT, TYPE_BOOLEAN, TYPE_DOUBLE:
+		if _, err := NormalizeValue(columnType, value); err == nil {
+			return json.RawMessage(value)
+		}
+	}
+	encoded, _ := json.Marshal(value)
+	return encoded
+}
+
+// Typed reports whether the columns of the table are defined, otherwise any cell can be written and every value is text.
+func (t *Table) Typed() bool {
+	return len(t.Columns) > 0
+}
+
+// ColumnType returns the type of a column, and false if the table has no such column. Key columns are text unless they are defined.
+func (t *Table) ColumnType(name string) (string, bool) {
+	for _, column := range t.Columns {
+		if column.Name == name {
+			return column.Type, true
+		}
+	}
+	if !t.Typed() || t.isKey(name) {
+		return TYPE_TEXT, true
+	}
+	return "", false
+}
+
+func (t *Table) isKey(name string) bool {
+	for _, key := range append(append([]string{}, t.PartitionKeyNames...), t.ClusteringKeyNames...) {
+		if key == name {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeKeys checks the values of the first keys of names, and turns them into their canonical form in place.
+func (t *Table) normalizeKeys(names []string, values []string) error {
+	for i, value := range values {
+		columnType, _ := t.ColumnType(names[i])
+		normalized, err := NormalizeValue(columnType, value)
+		if err != nil {
+			return fmt.Errorf("key %s: %s", names[i], err.Error())
+		}
+		values[i] = normalized
+	}
+	return nil
+}
+
+// checkPartitionKeys makes sure that values has a value for every partition key, and turns them into their canonical form.
+func (t *Table) checkPartitionKeys(values []string) error {
+	if len(values) != len(t.PartitionKeyNames) {
+		return fmt.Errorf("expected %d partition keys %v, got %d", len(t.PartitionKeyNames), t.PartitionKeyNames, len(values))
+	}
+	return t.normalizeKeys(t.PartitionKeyNames, values)
+}
+
+// checkClusteringKeys makes sure that values has a value for every clustering key, or at most as many if prefix is set, and turns them into their canonical form.
+func (t *Table) checkClusteringKeys(values []string, prefix bool) error {
+	if len(values) > len(t.ClusteringKeyNames) || (!prefix && len(values) != len(t.ClusteringKeyNames)) {
+		expected := "expected"
+		if prefix {
+			expected = "expected at most"
+		}
+		return fmt.Errorf("%s %d clustering keys %v, got %d", expected, len(t.ClusteringKeyNames), t.ClusteringKeyNames, len(values))
+	}
+	return t.normalizeKeys(t.ClusteringKeyNames, values)
+}
+
+// checkCellNames makes sure that names are distinct columns of the table that are not keys.
+func (t *Table) checkCellNames(names []string) error {
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			return fmt.Errorf("cell %s is given more than once", name)
+		}
+		seen[name] = true
+		if t.Typed() && t.isKey(name) {
+			return fmt.Errorf("%s is a key of the table, it can not be written as a cell", name)
+		}
+		if _, ok := t.ColumnType(name); !ok {
+			return fmt.Errorf("table %s has no column %s", t.TableName, name)
+		}
+	}
+	return nil
+}
+
+// ValidateWrite checks the keys and cells of a write against the columns of the table, and turns its values into their canonical form.
+// It has to be done before the partition key is hashed, as the token depends on the canonical form.
+func (t *Table) ValidateWrite(req *messages.WriteRequest) error {
+	if t.View != nil {
+		return fmt.Errorf("%w: %s is a materialized view, it is written through its base table %s", ErrInvalidWrite, t.TableName, t.View.BaseTable)
+	}
+	if err := t.checkPartitionKeys(req.PartitionKeyValues); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidWrite, err.Error())
+	}
+	if err := t.checkClusteringKeys(req.ClusteringKeyValues, false); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidWrite, err.Error())
+	}
+	if len(req.CellNames) != len(req.CellValues) {
+		return fmt.Errorf("%w: %d cell_names for %d cell_values", ErrInvalidWrite, len(req.CellNames), len(req.CellValues))
+	}
+	if err := t.checkCellNames(req.CellNames); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidWrite, err.Error())
+	}
+	for i, name := range req.CellNames {
+		columnType, _ := t.ColumnType(name)
+		value, err := NormalizeValue(columnType, req.CellValues[i])
+		if err != nil {
+			return fmt.Errorf("%w: column %s: %s", ErrInvalidWrite, name, err.Error())
+		}
+		req.CellValues[i] = value
+	}
+	return nil
+}
+
+// ValidateRead checks the keys and bounds of a read of a single row or of a slice of a partition, and turns them into their canonical form.
+func (t *Table) ValidateRead(req *messages.ReadRequest) error {
+	if err := t.checkPartitionKeys(req.PartitionKeyValues); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidRead, err.Error())
+	}
+	if err := t.checkClusteringKeys(req.ClusteringKeyValues, true); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidRead, err.Error())
+	}
+	if req.Limit < 0 {
+		return fmt.Errorf("%w: invalid limit %d", ErrInvalidRead, req.Limit)
+	}
+	if req.PageSize < 0 {
+		return fmt.Errorf("%w: invalid page_size %d", ErrInvalidRead, req.PageSize)
+	}
+	state, err := messages.DecodePagingState(req.PagingState)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidRead, err.Error())
+	}
+	if state != nil {
+		if err = t.checkClusteringKeys(state.ClusteringKeyValues, false); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrInvalidRead, messages.ErrInvalidPagingState.Error(), err.Error())
+		}
+	}
+	if req.Range != nil {
+		if err := t.checkSliceRange(len(req.ClusteringKeyValues), req.Range); err != nil {
+			return fmt.Errorf("%w: range: %s", ErrInvalidRead, err.Error())
+		}
+	}
+	return nil
+}
+
+// ValidateScan checks the page size, the restriction and the paging state of a scan of the tokens from start, excluded, up to end.
+func (t *Table) ValidateScan(req *messages.ScanRequest, start int64, end int64) error {
+	if start >= end {
+		return fmt.Errorf("%w: start_token %d has to be lower than end_token %d", ErrInvalidScan, start, end)
+	}
+	if req.PageSize < 0 {
+		return fmt.Errorf("%w: invalid page_size %d", ErrInvalidScan, req.PageSize)
+	}
+	if req.Where != nil {
+		if err := t.validateWhere(req.Where); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidScan, err.Error())
+		}
+	}
+	state, err := messages.DecodePagingState(req.PagingState)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidScan, err.Error())
+	}
+	if state != nil {
+		if state.Token <= start || state.Token > end {
+			return fmt.Errorf("%w: %s: it belongs to another token range", ErrInvalidScan, messages.ErrInvalidPagingState.Error())
+		}
+		if err = t.checkClusteringKeys(state.ClusteringKeyValues, false); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrInvalidScan, messages.ErrInvalidPagingState.Error(), err.Error())
+		}
+	}
+	return nil
+}
+
+// checkSliceRange makes sure that a range bounds the clustering key that follows the first given clustering keys, with at most one bound on each side,
+// and turns its values into their canonical form.
+func (t *Table) checkSliceRange(given int, bounds *messages.SliceRange) error {
+	if given >= len(t.ClusteringKeyNames) {
+		return fmt.Errorf("every one of the %d clustering keys %v is given, there is no clustering key left to bound", len(t.ClusteringKeyNames), t.ClusteringKeyNames)
+	}
+	if bounds.GreaterThan != nil && bounds.GreaterThanOrEqual != nil {
+		return errors.New("gt and gte can not be used together")
+	}
+	if bounds.LessThan != nil && bounds.LessThanOrEqual != nil {
+		return errors.New("lt and lte can not be used together")
+	}
+	name := t.ClusteringKeyNames[given]
+	columnType, _ := t.ColumnType(name)
+	for _, value := range []*messages.Value{bounds.GreaterThan, bounds.GreaterThanOrEqual, bounds.LessThan, bounds.LessThanOrEqual} {
+		if value == nil {
+			continue
+		}
+		normalized, err := NormalizeValue(columnType, string(*value))
+		if err != nil {
+			return fmt.Errorf("key %s: %s", name, err.Error())
+		}
+		*value = messages.Value(normalized)
+	}
+	return nil
+}
+
+// ValidateDelete checks the keys, bounds and cells of a delete, and turns its values into their canonical form.
+func (t *Table) ValidateDelete(req *messages.DeleteRequest) error {
+	if t.View != nil {
+		return fmt.Errorf("%w: %s is a materialized view, its rows are deleted through its base table %s", ErrInvalidDelete, t.TableName, t.View.BaseTable)
+	}
+	if err := t.checkPartitionKeys(req.PartitionKeyValues); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidDelete, err.Error())
+	}
+	if err := t.checkClusteringKeys(req.ClusteringKeyValues, true); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidDelete, err.Error())
+	}
+	if req.ClusteringRange != nil {
+		if err := t.checkClusteringKeys(req.ClusteringRange.Start, true); err != nil {
+			return fmt.Errorf("%w: clustering_range start: %s", ErrInvalidDelete, err.Error())
+		}
+		if err := t.checkClusteringKeys(req.ClusteringRange.End, true); err != nil {
+			return fmt.Errorf("%w: clustering_range end: %s", ErrInvalidDelete, err.Error())
+		}
+	}
+	if err := t.checkCellNames(req.CellNames); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidDelete, err.Error())
+	}
+	return nil
+}
+
+/* TypedRow
+Row as it is returned to clients, with the values of its clustering keys and cells encoded according to the type of their column
+*/
+type TypedRow struct {
+	CreatedAt           EpochTime         `json:"created_at"`
+	UpdatedAt           EpochTime         `json:"updated_at"`
+	DeletedAt           EpochTime         `json:"deleted_at"`
+	ClusteringKeyHash   int64             `json:"clustering_key_hash"`
+	ClusteringKeyValues []json.RawMessage `json:"clustering_key_values"`
+	Cells               []*TypedCell      `json:"cells"`
+}
+
+type TypedCell struct {
+	Name      string          `json:"name"`
+	Value     json.RawMessage `json:"value"`
+	Timestamp EpochTime       `json:"timestamp"`
+	TTL       int             `json:"ttl,omitempty"`
+}
+
+// TypedPartitionKeys returns the partition key values of a partition in the form they are returned to clients.
+func (t *Table) TypedPartitionKeys(values []string) []json.RawMessage {
+	typed := make([]json.RawMessage, 0, len(values))
+	for i, value := range values {
+		columnType := TYPE_TEXT
+		if i < len(t.PartitionKeyNames) {
+			columnType, _ = t.ColumnType(t.PartitionKeyNames[i])
+		}
+		typed = append(typed, EncodeValue(columnType, value))
+	}
+	return typed
+}
+
+// TypedRow returns a live row in the form it is returned to clients.
+func (t *Table) TypedRow(row *Row) *TypedRow {
+	typed := &TypedRow{
+		CreatedAt:           row.CreatedAt,
+		UpdatedAt:           row.UpdatedAt,
+		DeletedAt:           row.DeletedAt,
+		ClusteringKeyHash:   row.ClusteringKeyHash,
+		ClusteringKeyValues: make([]json.RawMessage, 0, len(row.ClusteringKeyValues)),
+		Cells:               make([]*TypedCell, 0, len(row.Cells)),
+	}
+	for i, value := range row.ClusteringKeyValues {
+		columnType := TYPE_TEXT
+		if i < len(t.ClusteringKeyNames) {
+			columnType, _ = t.ColumnType(t.ClusteringKeyNames[i])
+		}
+		typed.ClusteringKeyValues = append(typed.ClusteringKeyValues, EncodeValue(columnType, value))
+	}
+	for _, cell := range row.Cells {
+		if t.View != nil && cell.Name == t.View.KeyColumn {
+			// Returned with the other keys of the view
+			continue
+		}
+		columnType, _ := t.ColumnType(cell.Name)
+		typed.Cells = append(typed.Cells, &TypedCell{
+			Name:      cell.Name,
+			Value:     EncodeValue(columnType, cell.Value),
+			Timestamp: cell.Timestamp,
+			TTL:       cell.TTL,
+		})
+	}
+	return typed
+}
@@ -0,0 +1,294 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sanddb/messages"
+	"sync"
+	"time"
+)
+
+type CommitLogSyncMode string
+
+const (
+	// SYNC_PER_WRITE fsyncs the commit log before every single write is acknowledged.
+	SYNC_PER_WRITE CommitLogSyncMode = "per_write"
+	// SYNC_BATCH holds acknowledgements until the next group fsync, which covers every write appended within the batch window.
+	SYNC_BATCH CommitLogSyncMode = "batch"
+	// SYNC_PERIODIC acknowledges writes as soon as they reach the OS and fsyncs in the background every sync period.
+	SYNC_PERIODIC CommitLogSyncMode = "periodic"
+)
+
+type CommitLogEntryType int
+
+const (
+	LOG_INSERT CommitLogEntryType = iota
+	LOG_CREATE_TABLE
+	// LOG_WRITE_PARTITION carries rows whose timestamps must be preserved as is, e.g. repair writes
+	LOG_WRITE_PARTITION
+)
+
+// CommitLogEntry is a single mutation as it was received by this node.
+// Timestamp is assigned when the mutation is logged so that a replay reproduces the exact same row versions.
+type CommitLogEntry struct {
+	Type      CommitLogEntryType      `json:"type"`
+	Timestamp EpochTime               `json:"timestamp"`
+	Write     *messages.WriteRequest  `json:"write,omitempty"`
+	Create    *messages.CreateRequest `json:"create,omitempty"`
+	TableName string                  `json:"table_name,omitempty"`
+	Partition *Partition              `json:"partition,omitempty"`
+}
+
+// CommitLog is an append-only, checksummed write-ahead log.
+// Every mutation is appended here before it is applied to the node's data so that it can be replayed after a crash.
+type CommitLog struct {
+	mu          sync.Mutex
+	synced      *sync.Cond
+	file        *os.File
+	filename    string
+	mode        CommitLogSyncMode
+	syncPeriod  time.Duration
+	batchWindow time.Duration
+	// written is the sequence number of the last appended entry, lastSynced the last one known to be on disk
+	written    uint64
+	lastSynced uint64
+	// failedThrough is the sequence number of the last entry covered by a failed fsync, and syncErr the error of that fsync.
+	// Those entries may not be on disk even once a later fsync succeeds, so their writers are failed, but later writes are not.
+	failedThrough uint64
+	syncErr       error
+	closed        bool
+	// wake is used to notify the batch syncer that there are unsynced entries
+	wake chan struct{}
+}
+
+// OpenCommitLog opens (or creates) the commit log at filename.
+// A torn record at the end of the log (e.g. from a crash in the middle of an append) is truncated away.
+func OpenCommitLog(filename string, mode CommitLogSyncMode, syncPeriod time.Duration, batchWindow time.Duration) (*CommitLog, error) {
+	switch mode {
+	case SYNC_PER_WRITE, SYNC_BATCH, SYNC_PERIODIC:
+	case "":
+		mode = SYNC_PERIODIC
+	default:
+		return nil, fmt.Errorf("unknown commit log sync mode %q", mode)
+	}
+	if mode == SYNC_PERIODIC && syncPeriod <= 0 {
+		syncPeriod = 10 * time.Second
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, err
+	}
+	_, validSize, err := readCommitLog(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err = os.Truncate(filename, validSize); err != nil {
+			return nil, err
+		}
+	}
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l := &CommitLog{
+		file:        file,
+		filename:    filename,
+		mode:        mode,
+		syncPeriod:  syncPeriod,
+		batchWindow: batchWindow,
+		wake:        make(chan struct{}, 1),
+	}
+	l.synced = sync.NewCond(&l.mu)
+	switch mode {
+	case SYNC_BATCH:
+		go l.batchSyncer()
+	case SYNC_PERIODIC:
+		go l.periodicSyncer()
+	}
+	return l, nil
+}
+
+// Append writes entry to the end of the log and returns its sequence number.
+// The entry has reached the OS once Append returns; use Sync to wait until it is durable.
+func (l *CommitLog) Append(entry *CommitLogEntry) (uint64, error) {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+	record := encodeRecord(payload)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, errors.New("commit log is closed")
+	}
+	if _, err = l.file.Write(record); err != nil {
+		fmt.Printf("Error appending to commit log: %s\n", err.Error())
+		return 0, err
+	}
+	l.written++
+	return l.written, nil
+}
+
+// Sync blocks until the entry with sequence number seq is durable according to the configured sync mode.
+func (l *CommitLog) Sync(seq uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch l.mode {
+	case SYNC_PER_WRITE:
+		if l.lastSynced < seq && seq > l.failedThrough {
+			l.syncLocked()
+		}
+		return l.syncResultLocked(seq)
+	case SYNC_BATCH:
+		if l.closed {
+			return errors.New("commit log is closed")
+		}
+		select {
+		case l.wake <- struct{}{}:
+		default:
+		}
+		for l.lastSynced < seq && seq > l.failedThrough && !l.closed {
+			l.synced.Wait()
+		}
+		return l.syncResultLocked(seq)
+	default:
+		// Periodic mode trades the last sync period worth of writes on a machine crash for latency
+		return nil
+	}
+}
+
+// syncResultLocked returns the error of the fsync that covered the entry with sequence number seq, if it failed. l.mu must be held.
+func (l *CommitLog) syncResultLocked(seq uint64) error {
+	if seq <= l.failedThrough {
+		return l.syncErr
+	}
+	return nil
+}
+
+// syncLocked fsyncs the log file. l.mu must be held.
+func (l *CommitLog) syncLocked() {
+	target := l.written
+	if err := l.file.Sync(); err != nil {
+		fmt.Printf("Error syncing commit log entries up to %d: %s\n", target, err.Error())
+		l.failedThrough = target
+		l.syncErr = err
+	} else if target > l.lastSynced {
+		l.lastSynced = target
+	}
+	l.synced.Broadcast()
+}
+
+func (l *CommitLog) batchSyncer() {
+	for range l.wake {
+		// Give concurrent writers the batch window to join this group sync
+		time.Sleep(l.batchWindow)
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			return
+		}
+		l.syncLocked()
+		l.mu.Unlock()
+	}
+}
+
+func (l *CommitLog) periodicSyncer() {
+	ticker := time.NewTicker(l.syncPeriod)
+	defer ticker.Stop()
+	for range ticker.C {
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			return
+		}
+		if l.lastSynced < l.written {
+			l.syncLocked()
+		}
+		l.mu.Unlock()
+	}
+}
+
+// Replay calls apply on every entry in the log, in the order in which they were appended.
+func (l *CommitLog) Replay(apply func(entry *CommitLogEntry) error) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries, _, err := readCommitLog(l.filename)
+	if err != nil {
+		return 0, err
+	}
+	for i, entry := range entries {
+		if err = apply(entry); err != nil {
+			return i, err
+		}
+	}
+	return len(entries), nil
+}
+
+// Reset discards every entry in the log.
+// It must only be called once all logged mutations are safely persisted elsewhere.
+func (l *CommitLog) Reset() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.file.Truncate(0); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.lastSynced = l.written
+	l.synced.Broadcast()
+	return nil
+}
+
+// Close syncs and closes the log, releasing any writers waiting on a sync.
+func (l *CommitLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.syncLocked()
+	l.closed = true
+	close(l.wake)
+	l.synced.Broadcast()
+	return l.file.Close()
+}
+
+// readCommitLog decodes every intact record in the log.
+// It stops at the first truncated or corrupted record and reports the size of the valid prefix of the file.
+func readCommitLog(filename string) ([]*CommitLogEntry, int64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	entries := make([]*CommitLogEntry, 0)
+	offset := int64(0)
+	for offset < int64(len(content)) {
+		payload, next, err := decodeRecord(content, offset)
+		if err != nil {
+			fmt.Printf("Commit log %s has a %s at offset %d.\n", filename, err.Error(), offset)
+			break
+		}
+		entry := &CommitLogEntry{}
+		if err = json.Unmarshal(payload, entry); err != nil {
+			fmt.Printf("Commit log %s has an undecodable record at offset %d: %s\n", filename, offset, err.Error())
+			break
+		}
+		entries = append(entries, entry)
+		offset = next
+	}
+	if offset < int64(len(content)) {
+		fmt.Printf("Discarding %d trailing bytes of commit log %s.\n", int64(len(content))-offset, filename)
+	}
+	return entries, offset, nil
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"sanddb/messages"
+)
+
+// HandleCompactRequest starts a major compaction of one table, or of every table if no table name is given.
+// Similar to "nodetool compact", the compactions run in the background and their progress is reported by HandleCompactionStats.
+func (h *Handler) HandleCompactRequest(c *fiber.Ctx) error {
+	var (
+		reqBody messages.CompactRequest
+	)
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&reqBody); err != nil {
+			return fiber.NewError(http.StatusBadRequest, err.Error())
+		}
+	}
+	tableNames := make([]string, 0)
+	if reqBody.TableName != "" {
+		tableNames = append(tableNames, messages.CanonicalTableName(reqBody.TableName))
+	} else {
+		for _, table := range h.Storage.Tables() {
+			tableNames = append(tableNames, table.TableName)
+		}
+	}
+	tasks := make([]*CompactionTask, 0, len(tableNames))
+	for _, tableName := range tableNames {
+		task, err := h.Storage.CompactTable(tableName)
+		if err == ErrTableNotFound {
+			errMsg := fmt.Sprintf("Table %s does not exist.", tableName)
+			return fiber.NewError(http.StatusBadRequest, errMsg)
+		} else if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, err.Error())
+		}
+		tasks = append(tasks, task)
+	}
+	body, err := json.Marshal(tasks)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusAccepted).Send(body)
+}
+
+// HandleCompactionStats reports the running and recently finished compactions of this node, similar to "nodetool compactionstats".
+func (h *Handler) HandleCompactionStats(c *fiber.Ctx) error {
+	body, err := json.Marshal(h.Storage.CompactionTasks())
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).Send(body)
+}
@@ -0,0 +1,303 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"sanddb/messages"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	SIZE_TIERED_COMPACTION = "SizeTieredCompactionStrategy"
+	LEVELED_COMPACTION     = "LeveledCompactionStrategy"
+	TIME_WINDOW_COMPACTION = "TimeWindowCompactionStrategy"
+)
+
+const (
+	defaultMinThreshold  = 4
+	defaultMaxThreshold  = 32
+	defaultSSTableSizeKB = 160
+	// SSTables smaller than this are all put into the same size tier
+	minTierSize = 4 * 1024
+	// Size tiers span SSTables between half and one and a half times the average size of the tier
+	tierLow  = 0.5
+	tierHigh = 1.5
+	// Each level of the leveled strategy is this many times larger than the previous one
+	levelFanout = 10
+)
+
+// CompactionStrategy decides which SSTables of a table are merged together.
+type CompactionStrategy interface {
+	Name() string
+	// NextBackgroundCompaction picks the SSTables that should be compacted next, or returns nil if there is nothing to do.
+	NextBackgroundCompaction(sstables []*SSTable) *CompactionCandidate
+	// MajorCompaction groups every SSTable of the table into a single compaction.
+	MajorCompaction(sstables []*SSTable) *CompactionCandidate
+}
+
+// CompactionCandidate describes a single compaction: the SSTables to merge and the shape of the output.
+// A MaxOutputSize of 0 means that the output is written to a single SSTable.
+type CompactionCandidate struct {
+	SSTables      []*SSTable
+	OutputLevel   int
+	MaxOutputSize int64
+}
+
+// NewCompactionStrategy validates the compaction options of a table and builds the corresponding strategy.
+func NewCompactionStrategy(options *messages.CompactionOptions) (CompactionStrategy, error) {
+	if options == nil {
+		options = &messages.CompactionOptions{}
+	}
+	minThreshold, maxThreshold := options.MinThreshold, options.MaxThreshold
+	if minThreshold == 0 {
+		minThreshold = defaultMinThreshold
+	}
+	if maxThreshold == 0 {
+		maxThreshold = defaultMaxThreshold
+	}
+	if minThreshold < 2 || maxThreshold < minThreshold {
+		return nil, fmt.Errorf("invalid compaction thresholds: min_threshold must be at least 2 and at most max_threshold")
+	}
+	switch options.Class {
+	case "", SIZE_TIERED_COMPACTION:
+		return &SizeTieredCompactionStrategy{
+			MinThreshold: minThreshold,
+			MaxThreshold: maxThreshold,
+		}, nil
+	case LEVELED_COMPACTION:
+		sstableSizeKB := options.SSTableSizeKB
+		if sstableSizeKB == 0 {
+			sstableSizeKB = defaultSSTableSizeKB
+		} else if sstableSizeKB < 0 {
+			return nil, fmt.Errorf("invalid sstable_size_in_kb %d", sstableSizeKB)
+		}
+		return &LeveledCompactionStrategy{
+			SSTableSize:     int64(sstableSizeKB) * 1024,
+			Level0Threshold: minThreshold,
+			MaxThreshold:    maxThreshold,
+		}, nil
+	case TIME_WINDOW_COMPACTION:
+		var unit time.Duration
+		switch strings.ToUpper(options.WindowUnit) {
+		case "MINUTES":
+			unit = time.Minute
+		case "", "HOURS":
+			unit = time.Hour
+		case "DAYS":
+			unit = 24 * time.Hour
+		default:
+			return nil, fmt.Errorf("invalid compaction_window_unit %s: must be MINUTES, HOURS or DAYS", options.WindowUnit)
+		}
+		windowSize := options.WindowSize
+		if windowSize == 0 {
+			windowSize = 1
+		} else if windowSize < 0 {
+			return nil, fmt.Errorf("invalid compaction_window_size %d", windowSize)
+		}
+		return &TimeWindowCompactionStrategy{
+			Window: time.Duration(windowSize) * unit,
+			SizeTiered: &SizeTieredCompactionStrategy{
+				MinThreshold: minThreshold,
+				MaxThreshold: maxThreshold,
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown compaction class %s", options.Class)
+	}
+}
+
+// SizeTieredCompactionStrategy merges SSTables of similar sizes once there are enough of them.
+type SizeTieredCompactionStrategy struct {
+	MinThreshold int
+	MaxThreshold int
+}
+
+func (s *SizeTieredCompactionStrategy) Name() string {
+	return SIZE_TIERED_COMPACTION
+}
+
+func (s *SizeTieredCompactionStrategy) NextBackgroundCompaction(sstables []*SSTable) *CompactionCandidate {
+	var chosen []*SSTable
+	for _, tier := range sizeTiers(sstables) {
+		if len(tier) >= s.MinThreshold && len(tier) > len(chosen) {
+			chosen = tier
+		}
+	}
+	if chosen == nil {
+		return nil
+	}
+	if len(chosen) > s.MaxThreshold {
+		chosen = chosen[:s.MaxThreshold]
+	}
+	return &CompactionCandidate{SSTables: chosen}
+}
+
+func (s *SizeTieredCompactionStrategy) MajorCompaction(sstables []*SSTable) *CompactionCandidate {
+	return &CompactionCandidate{SSTables: sstables}
+}
+
+// sizeTiers buckets SSTables of similar sizes together, smallest SSTables first.
+func sizeTiers(sstables []*SSTable) [][]*SSTable {
+	sorted := make([]*SSTable, len(sstables))
+	copy(sorted, sstables)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].DataSize() < sorted[j].DataSize()
+	})
+	tiers := make([][]*SSTable, 0)
+	var tier []*SSTable
+	var average float64
+	for _, sstable := range sorted {
+		size := float64(sstable.DataSize())
+		fits := tier != nil && ((size >= average*tierLow && size <= average*tierHigh) || (size < minTierSize && average < minTierSize))
+		if !fits {
+			if tier != nil {
+				tiers = append(tiers, tier)
+			}
+			tier = make([]*SSTable, 0)
+			average = 0
+		}
+		average = (average*float64(len(tier)) + size) / float64(len(tier)+1)
+		tier = append(tier, sstable)
+	}
+	if tier != nil {
+		tiers = append(tiers, tier)
+	}
+	return tiers
+}
+
+// LeveledCompactionStrategy keeps SSTables in levels of non-overlapping, fixed-size SSTables,
+// where each level is levelFanout times as large as the previous one. Flushed SSTables start in level 0.
+type LeveledCompactionStrategy struct {
+	SSTableSize     int64
+	Level0Threshold int
+	MaxThreshold    int
+}
+
+func (s *LeveledCompactionStrategy) Name() string {
+	return LEVELED_COMPACTION
+}
+
+func (s *LeveledCompactionStrategy) NextBackgroundCompaction(sstables []*SSTable) *CompactionCandidate {
+	levels := make(map[int][]*SSTable)
+	maxLevel := 0
+	for _, sstable := range sstables {
+		level := sstable.Level()
+		levels[level] = append(levels[level], sstable)
+		if level > maxLevel {
+			maxLevel = level
+		}
+	}
+	// Level 0 SSTables overlap each other, so they are merged together with every overlapping SSTable in level 1
+	if len(levels[0]) >= s.Level0Threshold {
+		inputs := levels[0]
+		if len(inputs) > s.MaxThreshold {
+			inputs = inputs[:s.MaxThreshold]
+		}
+		return s.withOverlapping(inputs, levels[1], 1)
+	}
+	for level := 1; level <= maxLevel; level++ {
+		size := int64(0)
+		for _, sstable := range levels[level] {
+			size += sstable.DataSize()
+		}
+		if float64(size) > float64(s.SSTableSize)*math.Pow(levelFanout, float64(level)) {
+			// Promote the oldest SSTable of the level into the next one
+			oldest := levels[level][0]
+			for _, sstable := range levels[level] {
+				if sstable.Generation < oldest.Generation {
+					oldest = sstable
+				}
+			}
+			return s.withOverlapping([]*SSTable{oldest}, levels[level+1], level+1)
+		}
+	}
+	return nil
+}
+
+func (s *LeveledCompactionStrategy) MajorCompaction(sstables []*SSTable) *CompactionCandidate {
+	outputLevel := 1
+	for _, sstable := range sstables {
+		if sstable.Level() > outputLevel {
+			outputLevel = sstable.Level()
+		}
+	}
+	return &CompactionCandidate{
+		SSTables:      sstables,
+		OutputLevel:   outputLevel,
+		MaxOutputSize: s.SSTableSize,
+	}
+}
+
+func (s *LeveledCompactionStrategy) withOverlapping(inputs []*SSTable, nextLevel []*SSTable, outputLevel int) *CompactionCandidate {
+	minKey, maxKey := int64(math.MaxInt64), int64(math.MinInt64)
+	for _, sstable := range inputs {
+		if sstable.summary.PartitionCount == 0 {
+			continue
+		}
+		if sstable.summary.MinKey < minKey {
+			minKey = sstable.summary.MinKey
+		}
+		if sstable.summary.MaxKey > maxKey {
+			maxKey = sstable.summary.MaxKey
+		}
+	}
+	candidates := append([]*SSTable{}, inputs...)
+	for _, sstable := range nextLevel {
+		if sstable.summary.PartitionCount > 0 && sstable.summary.MinKey <= maxKey && sstable.summary.MaxKey >= minKey {
+			candidates = append(candidates, sstable)
+		}
+	}
+	return &CompactionCandidate{
+		SSTables:      candidates,
+		OutputLevel:   outputLevel,
+		MaxOutputSize: s.SSTableSize,
+	}
+}
+
+// TimeWindowCompactionStrategy groups SSTables by the time window of their newest write.
+// SSTables of the current window are compacted with the size-tiered strategy, and every older window is compacted down to a single SSTable.
+type TimeWindowCompactionStrategy struct {
+	Window     time.Duration
+	SizeTiered *SizeTieredCompactionStrategy
+}
+
+func (s *TimeWindowCompactionStrategy) Name() string {
+	return TIME_WINDOW_COMPACTION
+}
+
+func (s *TimeWindowCompactionStrategy) NextBackgroundCompaction(sstables []*SSTable) *CompactionCandidate {
+	windows := make(map[int64][]*SSTable)
+	for _, sstable := range sstables {
+		window := sstable.summary.MaxTimestamp / s.Window.Nanoseconds()
+		windows[window] = append(windows[window], sstable)
+	}
+	currentWindow := time.Now().UnixNano() / s.Window.Nanoseconds()
+	if candidate := s.SizeTiered.NextBackgroundCompaction(windows[currentWindow]); candidate != nil {
+		return candidate
+	}
+	keys := make([]int64, 0, len(windows))
+	for window := range windows {
+		keys = append(keys, window)
+	}
+	// Newest windows first
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] > keys[j]
+	})
+	for _, window := range keys {
+		if window == currentWindow || len(windows[window]) < 2 {
+			continue
+		}
+		inputs := windows[window]
+		if len(inputs) > s.SizeTiered.MaxThreshold {
+			inputs = inputs[:s.SizeTiered.MaxThreshold]
+		}
+		return &CompactionCandidate{SSTables: inputs}
+	}
+	return nil
+}
+
+func (s *TimeWindowCompactionStrategy) MajorCompaction(sstables []*SSTable) *CompactionCandidate {
+	return &CompactionCandidate{SSTables: sstables}
+}
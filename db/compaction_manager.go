@@ -0,0 +1,496 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+type CompactionState string
+
+const (
+	COMPACTION_PENDING CompactionState = "pending"
+	COMPACTION_RUNNING CompactionState = "running"
+	COMPACTION_DONE    CompactionState = "done"
+	COMPACTION_FAILED  CompactionState = "failed"
+)
+
+// Number of finished compactions kept around for compaction stats
+const compactionHistorySize = 50
+
+/* CompactionTask
+Progress: percentage of the input partitions that have been merged so far
+DroppedVersions: row versions shadowed by a newer version of the same row
+PurgedTombstones: tombstones older than gc grace that were dropped
+*/
+type CompactionTask struct {
+	ID                  int             `json:"id"`
+	TableName           string          `json:"table_name"`
+	Strategy            string          `json:"strategy"`
+	Major               bool            `json:"major"`
+	State               CompactionState `json:"state"`
+	InputSSTables       []int           `json:"input_sstables"`
+	OutputSSTables      []int           `json:"output_sstables"`
+	InputBytes          int64           `json:"input_bytes"`
+	TotalPartitions     int             `json:"total_partitions"`
+	ProcessedPartitions int             `json:"processed_partitions"`
+	Progress            float64         `json:"progress"`
+	DroppedVersions     int             `json:"dropped_versions"`
+	PurgedTombstones    int             `json:"purged_tombstones"`
+	StartedAt           *time.Time      `json:"started_at,omitempty"`
+	FinishedAt          *time.Time      `json:"finished_at,omitempty"`
+	Error               string          `json:"error,omitempty"`
+}
+
+var errCompactionInputsChanged = errors.New("compaction inputs were modified concurrently")
+
+// compactionWorker runs background compactions whenever a flush happens, and at least once a minute.
+func (e *StorageEngine) compactionWorker() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.compactionWake:
+		case <-ticker.C:
+		}
+		for _, table := range e.Tables() {
+			e.compactUntilDone(table.TableName)
+		}
+	}
+}
+
+func (e *StorageEngine) wakeCompactionWorker() {
+	select {
+	case e.compactionWake <- struct{}{}:
+	default:
+	}
+}
+
+// compactUntilDone runs background compactions on a table until its strategy has nothing left to do.
+func (e *StorageEngine) compactUntilDone(tableName string) {
+	e.compactionMu.Lock()
+	defer e.compactionMu.Unlock()
+	for {
+		strategy, sstables, err := e.compactionSnapshot(tableName)
+		if err != nil {
+			return
+		}
+		candidate := strategy.NextBackgroundCompaction(sstables)
+		if candidate == nil || len(candidate.SSTables) == 0 {
+			return
+		}
+		task := e.newCompactionTask(tableName, strategy.Name(), false)
+		if err = e.runCompaction(task, candidate, nil); err != nil {
+			fmt.Printf("Error in compacting table %s: %s\n", tableName, err.Error())
+			return
+		}
+	}
+}
+
+// CompactTable starts a major compaction of a table in the background and returns the task tracking its progress.
+func (e *StorageEngine) CompactTable(tableName string) (*CompactionTask, error) {
+	strategy, _, err := e.compactionSnapshot(tableName)
+	if err != nil {
+		return nil, err
+	}
+	task := e.newCompactionTask(tableName, strategy.Name(), true)
+	go func() {
+		if err := e.majorCompaction(task, nil); err != nil {
+			fmt.Printf("Error in major compaction of table %s: %s\n", tableName, err.Error())
+		}
+	}()
+	return task.snapshot(e), nil
+}
+
+// Rewrite flushes the memtable and rewrites every SSTable of a table into a major compaction,
+// dropping the rows for which keep returns false.
+func (e *StorageEngine) Rewrite(tableName string, keep func(metadata *PartitionMetadata, row *Row) bool) error {
+	strategy, _, err := e.compactionSnapshot(tableName)
+	if err != nil {
+		return err
+	}
+	return e.majorCompaction(e.newCompactionTask(tableName, strategy.Name(), true), keep)
+}
+
+func (e *StorageEngine) majorCompaction(task *CompactionTask, keep func(metadata *PartitionMetadata, row *Row) bool) error {
+	e.compactionMu.Lock()
+	defer e.compactionMu.Unlock()
+	if err := e.Flush(); err != nil {
+		e.finishCompactionTask(task, err)
+		return err
+	}
+	strategy, sstables, err := e.compactionSnapshot(task.TableName)
+	if err != nil {
+		e.finishCompactionTask(task, err)
+		return err
+	}
+	return e.runCompaction(task, strategy.MajorCompaction(sstables), keep)
+}
+
+// compactionSnapshot returns the compaction strategy of a table along with its current SSTables.
+func (e *StorageEngine) compactionSnapshot(tableName string) (CompactionStrategy, []*SSTable, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	table := GetTable(tableName, e.schema)
+	if table == nil {
+		return nil, nil, ErrTableNotFound
+	}
+	strategy, err := NewCompactionStrategy(table.Compaction)
+	if err != nil {
+		return nil, nil, err
+	}
+	sstables := make([]*SSTable, len(e.sstables[tableName]))
+	copy(sstables, e.sstables[tableName])
+	return strategy, sstables, nil
+}
+
+// runCompaction merges the candidate SSTables into new ones, keeping only the latest version of each row
+// and purging tombstones that are older than gc grace. e.compactionMu must be held.
+func (e *StorageEngine) runCompaction(task *CompactionTask, candidate *CompactionCandidate, keep func(metadata *PartitionMetadata, row *Row) bool) error {
+	tableName := task.TableName
+	table := e.GetSchema(tableName)
+	if table == nil {
+		e.finishCompactionTask(task, ErrTableNotFound)
+		return ErrTableNotFound
+	}
+	inputs := make(map[int]bool)
+	e.tasksMu.Lock()
+	now := time.Now()
+	task.State = COMPACTION_RUNNING
+	task.StartedAt = &now
+	for _, sstable := range candidate.SSTables {
+		inputs[sstable.Generation] = true
+		task.InputSSTables = append(task.InputSSTables, sstable.Generation)
+		task.InputBytes += sstable.DataSize()
+		task.TotalPartitions += sstable.PartitionCount()
+	}
+	e.tasksMu.Unlock()
+
+	versions := make(map[int64][]*Partition)
+	for _, sstable := range candidate.SSTables {
+		partitions, err := sstable.Partitions()
+		if err != nil {
+			e.finishCompactionTask(task, err)
+			return err
+		}
+		for _, partition := range partitions {
+			key := partition.Metadata.PartitionKey
+			versions[key] = append(versions[key], partition)
+		}
+	}
+	keys := make([]int64, 0, len(versions))
+	for key := range versions {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+
+	merged := make([]*Partition, 0, len(keys))
+	for _, key := range keys {
+		inputRows := 0
+		for _, version := range versions[key] {
+			inputRows += len(version.Rows)
+		}
+		partition := MergePartitions(versions[key]...)
+		evaluator := &purgeEvaluator{engine: e, tableName: tableName, partitionKey: key, inputs: inputs}
+		rows := make([]*Row, 0, len(partition.Rows))
+		purged := 0
+		for _, row := range partition.Rows {
+			if keep != nil && !keep(partition.Metadata, row) {
+				continue
+			}
+			// Rows deleted by a partition or range tombstone are dropped, the tombstone takes care of older versions elsewhere
+			if partition.isShadowed(row) {
+				continue
+			}
+			row = partition.resolveRow(row)
+			// The cells of dropped columns are never read again, whatever the other SSTables hold
+			if withoutDropped, droppedCells := table.withoutDroppedCells(row); droppedCells > 0 {
+				purged += droppedCells
+				if len(withoutDropped.Cells) == 0 && !row.IsTombstone() {
+					continue
+				}
+				row = withoutDropped
+			}
+			if row.IsTombstone() && evaluator.canPurge(row.DeletedAt.Time(), row.DeletedAt) {
+				purged++
+				continue
+			}
+			var purgedCells int
+			row, purgedCells = evaluator.purgeCells(row)
+			purged += purgedCells
+			if row == nil {
+				continue
+			}
+			rows = append(rows, row)
+		}
+		var tombstones []*RangeTombstone
+		for _, tombstone := range partition.Tombstones {
+			if evaluator.canPurge(tombstone.DeletedAt.Time(), tombstone.DeletedAt) {
+				purged++
+				continue
+			}
+			tombstones = append(tombstones, tombstone)
+		}
+		if len(rows) > 0 || len(tombstones) > 0 {
+			partition.Rows = rows
+			partition.Tombstones = tombstones
+			merged = append(merged, partition)
+		}
+		e.tasksMu.Lock()
+		task.ProcessedPartitions += len(versions[key])
+		task.DroppedVersions += inputRows - len(partition.Rows)
+		task.PurgedTombstones += purged
+		e.tasksMu.Unlock()
+	}
+
+	outputs, err := e.writeCompactionOutput(tableName, candidate, merged)
+	if err != nil {
+		e.finishCompactionTask(task, err)
+		return err
+	}
+
+	e.mu.Lock()
+	current := e.sstables[tableName]
+	found := 0
+	for _, sstable := range current {
+		if inputs[sstable.Generation] {
+			found++
+		}
+	}
+	if found != len(inputs) {
+		e.mu.Unlock()
+		for _, output := range outputs {
+			_ = output.Delete()
+		}
+		e.finishCompactionTask(task, errCompactionInputsChanged)
+		return errCompactionInputsChanged
+	}
+	remaining := make([]*SSTable, 0, len(current)-len(inputs)+len(outputs))
+	for _, sstable := range current {
+		if inputs[sstable.Generation] {
+			if err = sstable.Delete(); err != nil {
+				fmt.Printf("Error in deleting compacted SSTable %d of table %s: %s\n", sstable.Generation, tableName, err.Error())
+			}
+			continue
+		}
+		remaining = append(remaining, sstable)
+	}
+	e.sstables[tableName] = append(remaining, outputs...)
+	e.mu.Unlock()
+
+	e.tasksMu.Lock()
+	for _, output := range outputs {
+		task.OutputSSTables = append(task.OutputSSTables, output.Generation)
+	}
+	e.tasksMu.Unlock()
+	e.finishCompactionTask(task, nil)
+	fmt.Printf("Compacted %d SSTables of table %s into %d.\n", len(inputs), tableName, len(outputs))
+	return nil
+}
+
+/* purgeEvaluator
+Decides which tombstones of a partition a compaction may purge, like the purge evaluator of Cassandra. A tombstone past gc grace is only
+purged if it is older than all the data of the partition held outside of the compaction, in the memtable or in other SSTables, as it may
+otherwise still shadow some of it, e.g. a hint or a repair with an older timestamp that is still in the memtable.
+minTimestamp: earliest timestamp of that data, only looked up once a tombstone past gc grace is found
+*/
+type purgeEvaluator struct {
+	engine       *StorageEngine
+	tableName    string
+	partitionKey int64
+	inputs       map[int]bool
+	minTimestamp *int64
+}
+
+// canPurge reports whether a tombstone written at timestamp, which can be collected once gc grace has passed since deletedAt, can be purged.
+func (p *purgeEvaluator) canPurge(deletedAt time.Time, timestamp EpochTime) bool {
+	if time.Since(deletedAt) <= p.engine.gcGrace {
+		return false
+	}
+	if p.minTimestamp == nil {
+		minTimestamp := p.engine.minTimestamp(p.tableName, p.partitionKey, p.inputs)
+		p.minTimestamp = &minTimestamp
+	}
+	return timestamp.UnixNano() < *p.minTimestamp
+}
+
+// purgeCells drops the cells of a row that were deleted or have expired more than gc_grace_seconds ago, and that can be purged.
+// The row itself is dropped if it is left with none of the cells it had.
+func (p *purgeEvaluator) purgeCells(row *Row) (*Row, int) {
+	cells := make([]*Cell, 0, len(row.Cells))
+	for _, cell := range row.Cells {
+		switch {
+		case cell.Deleted && p.canPurge(cell.Timestamp.Time(), cell.Timestamp):
+		case cell.TTL > 0 && p.canPurge(cell.ExpiresAt(), cell.Timestamp):
+		default:
+			cells = append(cells, cell)
+		}
+	}
+	purged := len(row.Cells) - len(cells)
+	if purged == 0 {
+		return row, 0
+	}
+	if len(cells) == 0 && !row.IsTombstone() {
+		return nil, purged
+	}
+	purgedRow := *row
+	purgedRow.Cells = cells
+	return &purgedRow, purged
+}
+
+// writeCompactionOutput writes the merged partitions to one or more new SSTables, splitting them by size if the strategy asks for it.
+func (e *StorageEngine) writeCompactionOutput(tableName string, candidate *CompactionCandidate, partitions []*Partition) ([]*SSTable, error) {
+	chunks := make([][]*Partition, 0)
+	chunk := make([]*Partition, 0)
+	chunkSize := int64(0)
+	for _, partition := range partitions {
+		if candidate.MaxOutputSize > 0 {
+			encoded, err := json.Marshal(partition)
+			if err != nil {
+				return nil, err
+			}
+			if chunkSize > 0 && chunkSize+int64(len(encoded)) > candidate.MaxOutputSize {
+				chunks = append(chunks, chunk)
+				chunk = make([]*Partition, 0)
+				chunkSize = 0
+			}
+			chunkSize += int64(len(encoded))
+		}
+		chunk = append(chunk, partition)
+	}
+	if len(chunk) > 0 || len(chunks) == 0 {
+		chunks = append(chunks, chunk)
+	}
+	fpChance := DEFAULT_BLOOM_FILTER_FP_CHANCE
+	e.mu.RLock()
+	if table := GetTable(tableName, e.schema); table != nil {
+		fpChance = table.BloomFilterChance()
+	}
+	e.mu.RUnlock()
+	outputs := make([]*SSTable, 0, len(chunks))
+	for _, chunk := range chunks {
+		e.mu.Lock()
+		generation := e.nextGeneration
+		e.nextGeneration++
+		e.mu.Unlock()
+		sstable, err := WriteSSTable(e.tableDir(tableName), tableName, generation, candidate.OutputLevel, fpChance, chunk)
+		if err != nil {
+			for _, output := range outputs {
+				_ = output.Delete()
+			}
+			return nil, err
+		}
+		outputs = append(outputs, sstable)
+	}
+	return outputs, nil
+}
+
+// minTimestamp returns the earliest timestamp, in nanoseconds, of the data of a partition held by the memtable and by the SSTables that
+// are not inputs of a compaction, or math.MaxInt64 if they hold none of it.
+func (e *StorageEngine) minTimestamp(tableName string, partitionKey int64, inputs map[int]bool) int64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	versions := []*Partition{e.memtable.GetPartition(tableName, partitionKey)}
+	for _, sstable := range e.sstables[tableName] {
+		if inputs[sstable.Generation] || !sstable.MightContain(partitionKey) {
+			continue
+		}
+		partition, err := sstable.GetPartition(partitionKey)
+		if err != nil {
+			// Keep the tombstones when in doubt
+			return math.MinInt64
+		}
+		versions = append(versions, partition)
+	}
+	minTimestamp := int64(math.MaxInt64)
+	observe := func(timestamp EpochTime) {
+		// Rows that were never deleted have a zero DeletedAt, which is not a timestamp
+		if nanos := timestamp.UnixNano(); nanos >= 0 && nanos < minTimestamp {
+			minTimestamp = nanos
+		}
+	}
+	for _, partition := range versions {
+		if partition == nil {
+			continue
+		}
+		for _, row := range partition.Rows {
+			observe(row.CreatedAt)
+			observe(row.UpdatedAt)
+			observe(row.DeletedAt)
+			for _, cell := range row.Cells {
+				observe(cell.Timestamp)
+			}
+		}
+		for _, tombstone := range partition.Tombstones {
+			observe(tombstone.DeletedAt)
+		}
+	}
+	return minTimestamp
+}
+
+func (e *StorageEngine) newCompactionTask(tableName string, strategy string, major bool) *CompactionTask {
+	e.tasksMu.Lock()
+	defer e.tasksMu.Unlock()
+	e.nextTaskID++
+	task := &CompactionTask{
+		ID:             e.nextTaskID,
+		TableName:      tableName,
+		Strategy:       strategy,
+		Major:          major,
+		State:          COMPACTION_PENDING,
+		InputSSTables:  make([]int, 0),
+		OutputSSTables: make([]int, 0),
+	}
+	e.tasks = append(e.tasks, task)
+	if len(e.tasks) > compactionHistorySize {
+		e.tasks = e.tasks[len(e.tasks)-compactionHistorySize:]
+	}
+	return task
+}
+
+func (e *StorageEngine) finishCompactionTask(task *CompactionTask, err error) {
+	e.tasksMu.Lock()
+	defer e.tasksMu.Unlock()
+	now := time.Now()
+	task.FinishedAt = &now
+	if err != nil {
+		task.State = COMPACTION_FAILED
+		task.Error = err.Error()
+	} else {
+		task.State = COMPACTION_DONE
+		task.ProcessedPartitions = task.TotalPartitions
+	}
+}
+
+// snapshot returns a copy of the task that is safe to serialize while the compaction is still running.
+func (task *CompactionTask) snapshot(e *StorageEngine) *CompactionTask {
+	e.tasksMu.Lock()
+	defer e.tasksMu.Unlock()
+	copied := *task
+	copied.InputSSTables = append([]int{}, task.InputSSTables...)
+	copied.OutputSSTables = append([]int{}, task.OutputSSTables...)
+	if copied.TotalPartitions > 0 {
+		copied.Progress = 100 * float64(copied.ProcessedPartitions) / float64(copied.TotalPartitions)
+	} else if copied.State == COMPACTION_DONE {
+		copied.Progress = 100
+	}
+	return &copied
+}
+
+// CompactionTasks returns the running and recently finished compactions, most recent first.
+func (e *StorageEngine) CompactionTasks() []*CompactionTask {
+	e.tasksMu.Lock()
+	tasks := make([]*CompactionTask, len(e.tasks))
+	copy(tasks, e.tasks)
+	e.tasksMu.Unlock()
+	snapshots := make([]*CompactionTask, 0, len(tasks))
+	for i := len(tasks) - 1; i >= 0; i-- {
+		snapshots = append(snapshots, tasks[i].snapshot(e))
+	}
+	return snapshots
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"net/http"
@@ -12,31 +13,30 @@ func (h *Handler) HandleCreateTable(c *fiber.Ctx) error {
 	var (
 		reqBody messages.CreateRequest
 	)
-	filename := fmt.Sprintf("data/%d.json", h.Node.Id)
-	localData, err := ReadJSON(filename)
-	err = c.BodyParser(&reqBody)
+	err := c.BodyParser(&reqBody)
 	if err != nil {
 		_ = c.SendStatus(http.StatusInternalServerError)
 		return err
 	}
-	tableExists := CheckTableExists(reqBody.TableName, localData)
-	if tableExists {
+	if err = ValidateTableOptions(reqBody); err != nil {
+		err = fiber.NewError(http.StatusBadRequest, err.Error())
+		errBody, _ := json.Marshal(err)
+		_ = c.Status(http.StatusBadRequest).Send(errBody)
+		return err
+	}
+	err = h.Storage.CreateTable(reqBody)
+	if err == ErrTableExists {
 		errMsg := fmt.Sprintf("Table %s already exists.", reqBody.TableName)
 		err = fiber.NewError(http.StatusBadRequest, errMsg)
 		errBody, _ := json.Marshal(err)
 		_ = c.Status(http.StatusBadRequest).Send(errBody)
 		return err
-	}
-	partitions := make([]*Partition, 0)
-	table := &Table{
-		TableName:          reqBody.TableName,
-		PartitionKeyNames:  reqBody.PartitionKeyNames,
-		ClusteringKeyNames: reqBody.ClusteringKeyNames,
-		Partitions:         partitions,
-	}
-
-	err = PersistNewTable(localData, filename, table)
-	if err != nil {
+	} else if errors.Is(err, ErrInvalidView) {
+		err = fiber.NewError(http.StatusBadRequest, err.Error())
+		errBody, _ := json.Marshal(err)
+		_ = c.Status(http.StatusBadRequest).Send(errBody)
+		return err
+	} else if err != nil {
 		_ = c.SendStatus(http.StatusInternalServerError)
 		return err
 	}
@@ -52,6 +52,6 @@ func (h *Handler) HandleCreateTable(c *fiber.Ctx) error {
 		return err
 	}
 	_ = c.Status(http.StatusCreated).Send(resp)
-	fmt.Printf("Finished creating Table: %s", table.TableName)
+	fmt.Printf("Finished creating Table: %s", reqBody.TableName)
 	return nil
 }
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"sanddb/messages"
+	"time"
+)
+
+func (h *Handler) HandleDBDelete(c *fiber.Ctx) error {
+	var (
+		reqBody messages.DeleteRequest
+	)
+	if err := c.BodyParser(&reqBody); err != nil {
+		return err
+	}
+	timestamp := EpochTime(time.Now())
+	if reqBody.Timestamp > 0 {
+		timestamp = EpochTimeFromMicro(reqBody.Timestamp)
+	}
+	err := h.Storage.Delete(reqBody, timestamp)
+	if err == ErrTableNotFound {
+		errMsg := fmt.Sprintf("Table %s does not exist.", reqBody.TableName)
+		err = fiber.NewError(http.StatusBadRequest, errMsg)
+		errBody, _ := json.Marshal(err)
+		_ = c.Status(http.StatusBadRequest).Send(errBody)
+		return err
+	} else if errors.Is(err, ErrInvalidDelete) {
+		err = fiber.NewError(http.StatusBadRequest, err.Error())
+		errBody, _ := json.Marshal(err)
+		_ = c.Status(http.StatusBadRequest).Send(errBody)
+		return err
+	} else if err != nil {
+		_ = c.SendStatus(http.StatusInternalServerError)
+		return err
+	}
+	reply := &messages.PeerMessage{
+		Type:     messages.DELETE_ACK,
+		Content:  "1",
+		SourceID: h.Node.Id,
+	}
+	resp, err := json.Marshal(reply)
+	if err != nil {
+		_ = c.SendStatus(http.StatusInternalServerError)
+		return err
+	}
+	_ = c.Send(resp)
+	return nil
+}
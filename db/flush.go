@@ -0,0 +1,15 @@
+package db
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+)
+
+// HandleFlush forces the node's memtable to be flushed to SSTables, similar to "nodetool flush".
+func (h *Handler) HandleFlush(c *fiber.Ctx) error {
+	if err := h.Storage.Flush(); err != nil {
+		_ = c.Status(http.StatusInternalServerError).SendString("Failed to flush memtable. Error: " + err.Error())
+		return err
+	}
+	return c.Status(http.StatusOK).SendString("Successfully flushed memtable.")
+}
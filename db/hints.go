@@ -0,0 +1,279 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sanddb/messages"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HINT_FILE_EXTENSION   = ".log"
+	HINT_REPLAY_EXTENSION = ".replay"
+)
+
+/* Hint
+A mutation that a replica missed, kept by the coordinator until the replica is alive again.
+Target: ID of the replica the mutation is meant for
+CreatedAt: time at which the coordinator stored the hint, used to expire it after the max hint window
+Write/Delete: the mutation as it was sent to the other replicas, with its timestamp already assigned
+View: rows of a materialized view that a replica of its base table could not deliver to the replica of the view
+Repair: versions of rows and tombstones that a read repair could not deliver to a stale replica
+*/
+type Hint struct {
+	Target    int                     `json:"target"`
+	CreatedAt EpochTime               `json:"created_at"`
+	Write     *messages.WriteRequest  `json:"write,omitempty"`
+	Delete    *messages.DeleteRequest `json:"delete,omitempty"`
+	View      *RepairRequest          `json:"view,omitempty"`
+	Repair    *RepairRequest          `json:"repair,omitempty"`
+}
+
+/* HintStats
+Hints: number of hints waiting to be replayed to the target
+OldestHint: creation time of the oldest of them
+*/
+type HintStats struct {
+	Target     int       `json:"target"`
+	Hints      int       `json:"hints"`
+	OldestHint EpochTime `json:"oldest_hint"`
+}
+
+// HintStore keeps the hints of every target in its own file, framed and checksummed like the commit log.
+// While the hints of a target are replayed, its file is moved aside so that new hints for it can still be stored.
+type HintStore struct {
+	mu  sync.Mutex
+	dir string
+	// maxHintWindow is how long a hint is kept before it is dropped, 0 to keep hints until they are replayed
+	maxHintWindow time.Duration
+	replaying     map[int]bool
+}
+
+// OpenHintStore opens (or creates) the hint directory.
+// Hints left aside by a replay that was interrupted are put back, and hints older than the max hint window are dropped.
+func OpenHintStore(dir string, maxHintWindow time.Duration) (*HintStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &HintStore{
+		dir:           dir,
+		maxHintWindow: maxHintWindow,
+		replaying:     make(map[int]bool),
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), HINT_REPLAY_EXTENSION) {
+			continue
+		}
+		target, err := strconv.Atoi(strings.TrimSuffix(file.Name(), HINT_REPLAY_EXTENSION))
+		if err != nil {
+			continue
+		}
+		hints, err := readHints(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if err = s.appendHints(target, hints); err != nil {
+			return nil, err
+		}
+		if err = os.Remove(filepath.Join(dir, file.Name())); err != nil {
+			return nil, err
+		}
+	}
+	for _, target := range s.targets() {
+		hints, err := readHints(s.hintFile(target))
+		if err != nil {
+			return nil, err
+		}
+		live := s.liveHints(hints, time.Now())
+		if len(live) == len(hints) {
+			continue
+		}
+		fmt.Printf("Dropping %d hints for node %d older than the max hint window.\n", len(hints)-len(live), target)
+		if err = os.Remove(s.hintFile(target)); err != nil {
+			return nil, err
+		}
+		if err = s.appendHints(target, live); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Store durably appends hint to the hints of its target.
+func (s *HintStore) Store(hint *Hint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.appendHints(hint.Target, []*Hint{hint})
+}
+
+// Replay hands the hints of target to deliver, oldest first, and removes the ones that were delivered.
+// Delivery stops at the first error, and the remaining hints are kept for the next replay. Expired hints are dropped.
+func (s *HintStore) Replay(target int, deliver func(hint *Hint) error) (int, error) {
+	s.mu.Lock()
+	if s.replaying[target] {
+		s.mu.Unlock()
+		return 0, nil
+	}
+	replayFile := filepath.Join(s.dir, strconv.Itoa(target)+HINT_REPLAY_EXTENSION)
+	if err := os.Rename(s.hintFile(target), replayFile); err != nil {
+		s.mu.Unlock()
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	s.replaying[target] = true
+	s.mu.Unlock()
+
+	hints, err := readHints(replayFile)
+	delivered := 0
+	var remaining []*Hint
+	if err == nil {
+		hints = s.liveHints(hints, time.Now())
+		for i, hint := range hints {
+			if err = deliver(hint); err != nil {
+				remaining = hints[i:]
+				break
+			}
+			delivered++
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.replaying, target)
+	if appendErr := s.appendHints(target, remaining); appendErr != nil {
+		// The replay file is kept, so that the hints are put back the next time the store is opened
+		return delivered, appendErr
+	}
+	if removeErr := os.Remove(replayFile); removeErr != nil {
+		return delivered, removeErr
+	}
+	return delivered, err
+}
+
+// Stats reports the number of hints waiting for every target, similar to "nodetool listpendinghints".
+func (s *HintStore) Stats() ([]*HintStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	stats := make([]*HintStats, 0)
+	for _, target := range s.targets() {
+		hints, err := readHints(s.hintFile(target))
+		if err != nil {
+			return nil, err
+		}
+		hints = s.liveHints(hints, now)
+		if len(hints) == 0 {
+			continue
+		}
+		stats = append(stats, &HintStats{
+			Target:     target,
+			Hints:      len(hints),
+			OldestHint: hints[0].CreatedAt,
+		})
+	}
+	return stats, nil
+}
+
+// Targets returns the nodes that have hints waiting for them.
+func (s *HintStore) Targets() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.targets()
+}
+
+func (s *HintStore) targets() []int {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil
+	}
+	targets := make([]int, 0, len(files))
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), HINT_FILE_EXTENSION) {
+			continue
+		}
+		if target, err := strconv.Atoi(strings.TrimSuffix(file.Name(), HINT_FILE_EXTENSION)); err == nil {
+			targets = append(targets, target)
+		}
+	}
+	sort.Ints(targets)
+	return targets
+}
+
+func (s *HintStore) hintFile(target int) string {
+	return filepath.Join(s.dir, strconv.Itoa(target)+HINT_FILE_EXTENSION)
+}
+
+func (s *HintStore) liveHints(hints []*Hint, now time.Time) []*Hint {
+	if s.maxHintWindow <= 0 {
+		return hints
+	}
+	live := make([]*Hint, 0, len(hints))
+	for _, hint := range hints {
+		if now.Sub(time.Time(hint.CreatedAt)) <= s.maxHintWindow {
+			live = append(live, hint)
+		}
+	}
+	return live
+}
+
+// appendHints appends hints to the file of target and fsyncs it, so that a hint is never acknowledged before it is durable.
+func (s *HintStore) appendHints(target int, hints []*Hint) error {
+	if len(hints) == 0 {
+		return nil
+	}
+	file, err := os.OpenFile(s.hintFile(target), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	for _, hint := range hints {
+		payload, err := json.Marshal(hint)
+		if err != nil {
+			return err
+		}
+		if _, err = file.Write(encodeRecord(payload)); err != nil {
+			return err
+		}
+	}
+	return file.Sync()
+}
+
+// readHints decodes every intact hint in filename, stopping at the first truncated or corrupted record.
+func readHints(filename string) ([]*Hint, error) {
+	content, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	hints := make([]*Hint, 0)
+	offset := int64(0)
+	for offset < int64(len(content)) {
+		payload, next, err := decodeRecord(content, offset)
+		if err != nil {
+			fmt.Printf("Hint file %s has a %s at offset %d.\n", filename, err.Error(), offset)
+			break
+		}
+		hint := &Hint{}
+		if err = json.Unmarshal(payload, hint); err != nil {
+			fmt.Printf("Hint file %s has an undecodable record at offset %d: %s\n", filename, offset, err.Error())
+			break
+		}
+		hints = append(hints, hint)
+		offset = next
+	}
+	return hints, nil
+}
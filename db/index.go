@@ -0,0 +1,472 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"sanddb/messages"
+	"sort"
+	"time"
+)
+
+var (
+	ErrIndexNotFound = errors.New("index does not exist")
+	ErrIndexNotBuilt = errors.New("index is still being built")
+	ErrInvalidIndex  = errors.New("invalid index")
+)
+
+type IndexState string
+
+const (
+	INDEX_BUILDING IndexState = "building"
+	INDEX_BUILT    IndexState = "built"
+	INDEX_FAILED   IndexState = "failed"
+)
+
+/* IndexDefinition
+Name: name of the index, unique within its table
+Column: regular column of the table whose values are indexed
+*/
+type IndexDefinition struct {
+	Name   string `json:"name"`
+	Column string `json:"column"`
+}
+
+/* IndexStatus
+State: building until every partition held by the node when the index was created has been indexed, failed if reading one of them failed
+TotalPartitions/BuiltPartitions: number of partitions to index, and indexed so far, by the build
+*/
+type IndexStatus struct {
+	TableName       string     `json:"table_name"`
+	IndexName       string     `json:"index_name"`
+	Column          string     `json:"column"`
+	State           IndexState `json:"state"`
+	TotalPartitions int        `json:"total_partitions"`
+	BuiltPartitions int        `json:"built_partitions"`
+	Progress        float64    `json:"progress"`
+}
+
+// secondaryIndex maps the values of a column of a table to the rows of this node that hold them.
+// It is only held in memory, and only changed while e.mu is held for writing, along with the writes to the rows that it indexes.
+type secondaryIndex struct {
+	tableName  string
+	definition IndexDefinition
+	status     IndexStatus
+	// rows holds the rows that hold each value, by partition key hash and then by clustering key hash
+	rows map[string]map[int64]map[int64]*indexEntry
+	// values holds the value indexed for each row, so that the row can be moved to its new value
+	values map[int64]map[int64]string
+}
+
+type indexEntry struct {
+	partitionKey      int64
+	clusteringKeyHash int64
+	clusteringKey     string
+}
+
+func newSecondaryIndex(tableName string, definition IndexDefinition) *secondaryIndex {
+	index := &secondaryIndex{
+		tableName:  tableName,
+		definition: definition,
+		status: IndexStatus{
+			TableName: tableName,
+			IndexName: definition.Name,
+			Column:    definition.Column,
+			State:     INDEX_BUILDING,
+		},
+	}
+	index.reset()
+	return index
+}
+
+func (i *secondaryIndex) reset() {
+	i.rows = make(map[string]map[int64]map[int64]*indexEntry)
+	i.values = make(map[int64]map[int64]string)
+}
+
+// set indexes a row of a partition under value, or removes it from the index if it holds no value.
+func (i *secondaryIndex) set(partitionKey int64, row *Row, value string, ok bool) {
+	if current, indexed := i.values[partitionKey][row.ClusteringKeyHash]; indexed {
+		if ok && current == value {
+			return
+		}
+		delete(i.rows[current][partitionKey], row.ClusteringKeyHash)
+		if len(i.rows[current][partitionKey]) == 0 {
+			delete(i.rows[current], partitionKey)
+		}
+		if len(i.rows[current]) == 0 {
+			delete(i.rows, current)
+		}
+		delete(i.values[partitionKey], row.ClusteringKeyHash)
+		if len(i.values[partitionKey]) == 0 {
+			delete(i.values, partitionKey)
+		}
+	}
+	if !ok {
+		return
+	}
+	if i.rows[value] == nil {
+		i.rows[value] = make(map[int64]map[int64]*indexEntry)
+	}
+	if i.rows[value][partitionKey] == nil {
+		i.rows[value][partitionKey] = make(map[int64]*indexEntry)
+	}
+	i.rows[value][partitionKey][row.ClusteringKeyHash] = &indexEntry{
+		partitionKey:      partitionKey,
+		clusteringKeyHash: row.ClusteringKeyHash,
+		clusteringKey:     row.ClusteringKey,
+	}
+	if i.values[partitionKey] == nil {
+		i.values[partitionKey] = make(map[int64]string)
+	}
+	i.values[partitionKey][row.ClusteringKeyHash] = value
+}
+
+// Index returns the definition of the index on a column of the table, or nil if the column is not indexed.
+func (t *Table) Index(column string) *IndexDefinition {
+	for _, index := range t.Indexes {
+		if index.Column == column {
+			return index
+		}
+	}
+	return nil
+}
+
+// indexedValue returns the value of a column held by a row that has been resolved against the tombstones of its partition, if it is live.
+func (t *Table) indexedValue(row *Row, column string, now time.Time) (string, bool) {
+	live := t.LiveView(row, now)
+	if live == nil {
+		return "", false
+	}
+	for _, cell := range live.Cells {
+		if cell.Name == column {
+			return cell.Value, true
+		}
+	}
+	return "", false
+}
+
+// Matches reports whether a live row holds the given value in every one of the given columns.
+func (t *Table) Matches(row *Row, where map[string]messages.Value) bool {
+	for column, value := range where {
+		if current, ok := t.indexedValue(row, column, time.Now()); !ok || current != string(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateCreateIndex checks the index about to be created on a table, and names it if it has no name.
+func ValidateCreateIndex(table *Table, req *messages.CreateIndexRequest) error {
+	if req.Column == "" {
+		return fmt.Errorf("%w: no column to index", ErrInvalidIndex)
+	}
+	if table.View != nil {
+		return fmt.Errorf("%w: %s is a materialized view, only tables can be indexed", ErrInvalidIndex, table.TableName)
+	}
+	if table.isKey(req.Column) {
+		return fmt.Errorf("%w: %s is a key of the table, only regular columns can be indexed", ErrInvalidIndex, req.Column)
+	}
+	if _, ok := table.ColumnType(req.Column); !ok {
+		return fmt.Errorf("%w: table %s has no column %s", ErrInvalidIndex, table.TableName, req.Column)
+	}
+	if req.IndexName == "" {
+		_, name := messages.SplitTableName(table.TableName)
+		req.IndexName = fmt.Sprintf("%s_%s_idx", name, req.Column)
+	}
+	for _, index := range table.Indexes {
+		if index.Name == req.IndexName {
+			return fmt.Errorf("%w: table %s already has an index %s", ErrInvalidIndex, table.TableName, req.IndexName)
+		} else if index.Column == req.Column {
+			return fmt.Errorf("%w: column %s is already indexed by %s", ErrInvalidIndex, req.Column, index.Name)
+		}
+	}
+	return nil
+}
+
+// validateWhere checks that a query restricts a single indexed column, and turns the value into its canonical form.
+func (t *Table) validateWhere(where map[string]messages.Value) error {
+	if len(where) != 1 {
+		return fmt.Errorf("expected a single indexed column in where, got %d", len(where))
+	}
+	for column, value := range where {
+		if t.Index(column) == nil {
+			return fmt.Errorf("column %s of table %s is not indexed", column, t.TableName)
+		}
+		columnType, _ := t.ColumnType(column)
+		canonical, err := NormalizeValue(columnType, string(value))
+		if err != nil {
+			return fmt.Errorf("where %s: %s", column, err.Error())
+		}
+		where[column] = messages.Value(canonical)
+	}
+	return nil
+}
+
+// CreateIndex adds a secondary index to a table, which is then built in the background from the data already held by the node.
+func (e *StorageEngine) CreateIndex(req messages.CreateIndexRequest) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	table := GetTable(req.TableName, e.schema)
+	if table == nil {
+		return ErrTableNotFound
+	}
+	if err := ValidateCreateIndex(table, &req); err != nil {
+		return err
+	}
+	// The definitions are replaced rather than changed in place, as copies of them may be in use
+	indexes := make([]*IndexDefinition, 0, len(table.Indexes)+1)
+	table.Indexes = append(append(indexes, table.Indexes...), &IndexDefinition{Name: req.IndexName, Column: req.Column})
+	table.UpdatedAt = schemaTimestamp(req.Timestamp)
+	if err := e.persistSchema(); err != nil {
+		return err
+	}
+	e.syncIndexesLocked()
+	return nil
+}
+
+// syncIndexesLocked starts building the indexes that were added to the schema, and forgets the ones of the tables that were dropped. e.mu must be held.
+func (e *StorageEngine) syncIndexesLocked() {
+	indexes := make(map[string][]*secondaryIndex)
+	var added []*secondaryIndex
+	for _, table := range e.schema {
+		for _, definition := range table.Indexes {
+			var index *secondaryIndex
+			for _, existing := range e.indexes[table.TableName] {
+				if existing.definition == *definition {
+					index = existing
+				}
+			}
+			if index == nil {
+				index = newSecondaryIndex(table.TableName, *definition)
+				added = append(added, index)
+			}
+			indexes[table.TableName] = append(indexes[table.TableName], index)
+		}
+	}
+	e.indexes = indexes
+	// The builds only start once the indexes are in use, as they stop as soon as theirs is not
+	for _, index := range added {
+		go e.buildIndex(index)
+	}
+}
+
+// isIndexed reports whether the index is still in use, i.e. its table has not been dropped. e.mu must be held.
+func (e *StorageEngine) isIndexed(index *secondaryIndex) bool {
+	for _, existing := range e.indexes[index.tableName] {
+		if existing == index {
+			return true
+		}
+	}
+	return false
+}
+
+// buildIndex indexes every partition of the table of an index, one at a time, while writes keep the partitions they change up to date.
+func (e *StorageEngine) buildIndex(index *secondaryIndex) {
+	e.mu.RLock()
+	partitions, err := e.mergedPartitionsLocked(index.tableName, func(int64) bool { return true })
+	e.mu.RUnlock()
+	if err != nil {
+		e.failIndexBuild(index, err)
+		return
+	}
+	e.mu.Lock()
+	index.status.TotalPartitions = len(partitions)
+	e.mu.Unlock()
+	fmt.Printf("Building index %s of table %s from %d partitions.\n", index.definition.Name, index.tableName, len(partitions))
+	for _, listed := range partitions {
+		e.mu.Lock()
+		table := GetTable(index.tableName, e.schema)
+		if table == nil || !e.isIndexed(index) {
+			e.mu.Unlock()
+			return
+		}
+		// The partition is read again, as it may have been written to since it was listed
+		partition, err := e.readPartitionLocked(index.tableName, listed.Metadata.PartitionKey)
+		if err != nil {
+			e.mu.Unlock()
+			e.failIndexBuild(index, err)
+			return
+		}
+		if partition != nil {
+			indexRows(table, []*secondaryIndex{index}, partition, partition.Rows)
+		}
+		index.status.BuiltPartitions++
+		e.mu.Unlock()
+	}
+	e.mu.Lock()
+	index.status.State = INDEX_BUILT
+	e.mu.Unlock()
+	fmt.Printf("Built index %s of table %s.\n", index.definition.Name, index.tableName)
+}
+
+func (e *StorageEngine) failIndexBuild(index *secondaryIndex, err error) {
+	e.mu.Lock()
+	index.status.State = INDEX_FAILED
+	e.mu.Unlock()
+	fmt.Printf("Error in building index %s of table %s: %s\n", index.definition.Name, index.tableName, err.Error())
+}
+
+// updateIndexesLocked updates the indexes of a table with the rows changed by a mutation, given the partition as it is once the mutation is applied.
+// A mutation with range tombstones may change any row of the partition. e.mu must be held for writing.
+func (e *StorageEngine) updateIndexesLocked(table *Table, partition *Partition, fragment *Partition) {
+	rows := partition.Rows
+	if len(fragment.Tombstones) == 0 {
+		changed := make(map[int64]bool, len(fragment.Rows))
+		for _, row := range fragment.Rows {
+			changed[row.ClusteringKeyHash] = true
+		}
+		rows = make([]*Row, 0, len(fragment.Rows))
+		for _, row := range partition.Rows {
+			if changed[row.ClusteringKeyHash] {
+				rows = append(rows, row)
+			}
+		}
+	}
+	indexRows(table, e.indexes[table.TableName], partition, rows)
+}
+
+// indexRows indexes rows of a partition under the values that they hold once resolved against the tombstones of the partition.
+func indexRows(table *Table, indexes []*secondaryIndex, partition *Partition, rows []*Row) {
+	now := time.Now()
+	for _, row := range rows {
+		resolved := partition.resolveRow(table.withClusteringKey(row))
+		for _, index := range indexes {
+			value, ok := table.indexedValue(resolved, index.definition.Column, now)
+			index.set(partition.Metadata.PartitionKey, resolved, value, ok)
+		}
+	}
+}
+
+// resetIndexesLocked empties the indexes of a table whose data has been deleted. e.mu must be held for writing.
+func (e *StorageEngine) resetIndexesLocked(tableName string) {
+	for _, index := range e.indexes[tableName] {
+		index.reset()
+	}
+}
+
+// IndexStatuses returns the state of every secondary index of this node.
+func (e *StorageEngine) IndexStatuses() []*IndexStatus {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	statuses := make([]*IndexStatus, 0)
+	for _, indexes := range e.indexes {
+		for _, index := range indexes {
+			status := index.status
+			if status.State == INDEX_BUILT {
+				status.Progress = 100
+			} else if status.TotalPartitions > 0 {
+				status.Progress = float64(status.BuiltPartitions) * 100 / float64(status.TotalPartitions)
+			}
+			statuses = append(statuses, &status)
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].TableName != statuses[j].TableName {
+			return statuses[i].TableName < statuses[j].TableName
+		}
+		return statuses[i].IndexName < statuses[j].IndexName
+	})
+	return statuses
+}
+
+// IndexScan returns, like ScanRange, the versions of the rows of a token range that hold value in an indexed column, as found by the index of this node.
+// The index has to be built, as rows that it does not hold yet would be missed.
+func (e *StorageEngine) IndexScan(tableName string, column string, value string, start int64, end int64, resume *messages.PagingState, limit int) ([]*Partition, error) {
+	table := e.GetSchema(tableName)
+	if table == nil {
+		return nil, ErrTableNotFound
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	var index *secondaryIndex
+	for _, existing := range e.indexes[tableName] {
+		if existing.definition.Column == column {
+			index = existing
+		}
+	}
+	if index == nil {
+		return nil, fmt.Errorf("%w: column %s of table %s is not indexed on this node", ErrIndexNotFound, column, tableName)
+	} else if index.status.State != INDEX_BUILT {
+		return nil, fmt.Errorf("%w: index %s of table %s is %s on this node", ErrIndexNotBuilt, index.definition.Name, tableName, index.status.State)
+	}
+	var resumeKey string
+	if resume != nil {
+		resumeKey = table.ClusteringKey(resume.ClusteringKeyValues)
+	}
+	entries := make([]*indexEntry, 0)
+	for partitionKey, rows := range index.rows[value] {
+		if partitionKey <= start || partitionKey > end || (resume != nil && partitionKey < resume.Token) {
+			continue
+		}
+		for _, entry := range rows {
+			if resume == nil || partitionKey > resume.Token || entry.clusteringKey > resumeKey {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].partitionKey != entries[j].partitionKey {
+			return entries[i].partitionKey < entries[j].partitionKey
+		}
+		return entries[i].clusteringKey < entries[j].clusteringKey
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	scanned := make([]*Partition, 0)
+	var source *Partition
+	var rows map[int64]*Row
+	for _, entry := range entries {
+		if source == nil || source.Metadata.PartitionKey != entry.partitionKey {
+			partition, err := e.readPartitionLocked(tableName, entry.partitionKey)
+			if err != nil {
+				return nil, err
+			}
+			// The index is changed along with the data, so it only holds rows of partitions that exist
+			source = &Partition{
+				Metadata:   partition.Metadata,
+				Rows:       make([]*Row, 0),
+				Tombstones: partition.Tombstones,
+			}
+			rows = make(map[int64]*Row, len(partition.Rows))
+			for _, row := range partition.Rows {
+				rows[row.ClusteringKeyHash] = row
+			}
+			scanned = append(scanned, source)
+		}
+		if row, ok := rows[entry.clusteringKeyHash]; ok {
+			source.Rows = append(source.Rows, table.withClusteringKey(row))
+		}
+	}
+	return scanned, nil
+}
+
+func (h *Handler) HandleCreateIndex(c *fiber.Ctx) error {
+	var req messages.CreateIndexRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	err := h.Storage.CreateIndex(req)
+	if err == ErrTableNotFound {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Table %s does not exist.", req.TableName))
+	} else if errors.Is(err, ErrInvalidIndex) {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	} else if err != nil {
+		return err
+	}
+	fmt.Printf("Created index %s on table %s.\n", req.IndexName, req.TableName)
+	return h.sendAck(c, messages.SCHEMA_ACK)
+}
+
+// HandleIndexStatus reports the state of the builds of the secondary indexes of this node.
+func (h *Handler) HandleIndexStatus(c *fiber.Ctx) error {
+	body, err := json.Marshal(h.Storage.IndexStatuses())
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).Send(body)
+}
@@ -2,11 +2,11 @@ package db
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"net/http"
 	"sanddb/messages"
-	"sanddb/utils"
 	"time"
 )
 
@@ -14,35 +14,30 @@ func (h *Handler) HandleDBInsert(c *fiber.Ctx) error {
 	var (
 		reqBody messages.WriteRequest
 	)
-	filename := fmt.Sprintf("data/%d.json", h.Node.Id)
-	localData, err := ReadJSON(filename)
 	if err := c.BodyParser(&reqBody); err != nil {
 		return err
 	}
-	table := GetTable(reqBody.TableName, localData)
-	if table == nil {
+	// Writes are versioned with the timestamp assigned by the coordinator, so that every replica stores the same version
+	timestamp := EpochTime(time.Now())
+	if reqBody.Timestamp > 0 {
+		timestamp = EpochTimeFromMicro(reqBody.Timestamp)
+	}
+	err := h.Storage.Insert(reqBody, timestamp)
+	if err == ErrStaleWrite {
+		fmt.Printf("Ignoring write to table %s at %d: a more recent version is already stored.\n", reqBody.TableName, reqBody.Timestamp)
+	} else if err == ErrTableNotFound {
 		errMsg := fmt.Sprintf("Table %s does not exist.", reqBody.TableName)
 		err = fiber.NewError(http.StatusBadRequest, errMsg)
 		errBody, _ := json.Marshal(err)
 		_ = c.Status(http.StatusBadRequest).Send(errBody)
 		return err
-	}
-	clusteringKeyConcat := ""
-	for _, clusteringKey := range reqBody.ClusteringKeyValues {
-		clusteringKeyConcat += clusteringKey
-	}
-	clusteringKeyHash := utils.GetHash(clusteringKeyConcat)
-	updatedPartition := GetPartition(table, reqBody.HashedPK)
-	if updatedPartition == nil {
-		if err = createNewPartition(reqBody, table, clusteringKeyHash); err != nil {
-			return err
-		}
-	} else {
-		if err = updatePartition(updatedPartition, reqBody, clusteringKeyHash); err != nil {
-			return err
-		}
-	}
-	if err = PersistTable(localData, filename, table); err != nil {
+	} else if errors.Is(err, ErrInvalidWrite) {
+		err = fiber.NewError(http.StatusBadRequest, err.Error())
+		errBody, _ := json.Marshal(err)
+		_ = c.Status(http.StatusBadRequest).Send(errBody)
+		return err
+	} else if err != nil {
+		_ = c.SendStatus(http.StatusInternalServerError)
 		return err
 	}
 	reply := &messages.PeerMessage{
@@ -58,58 +53,3 @@ func (h *Handler) HandleDBInsert(c *fiber.Ctx) error {
 	_ = c.Send(resp)
 	return nil
 }
-
-func createNewPartition(req messages.WriteRequest, table *Table, clusteringKeyHash int64) error {
-	metadata := &PartitionMetadata{
-		PartitionKey:       req.HashedPK,
-		PartitionKeyValues: req.PartitionKeyValues,
-	}
-	rows := make([]*Row, 0)
-	cells := make([]*Cell, 0)
-	for i := range req.CellNames {
-		cell := &Cell{
-			Name:  req.CellNames[i],
-			Value: req.CellValues[i],
-		}
-		cells = append(cells, cell)
-	}
-	row := &Row{
-		CreatedAt:           EpochTime(time.Now()),
-		ClusteringKeyHash:   clusteringKeyHash,
-		ClusteringKeyValues: req.ClusteringKeyValues,
-		Cells:               cells,
-	}
-	rows = append(rows, row)
-	partition := &Partition{
-		Metadata: metadata,
-		Rows:     rows,
-	}
-	table.Partitions = append(table.Partitions, partition)
-	return nil
-}
-
-func updatePartition(partition *Partition, req messages.WriteRequest, clusteringKeyHash int64) error {
-	cells := make([]*Cell, 0)
-	for i := range req.CellNames {
-		cell := &Cell{
-			Name:  req.CellNames[i],
-			Value: req.CellValues[i],
-		}
-		cells = append(cells, cell)
-	}
-	for _, row := range partition.Rows {
-		if row.ClusteringKeyHash == clusteringKeyHash {
-			row.Cells = cells
-			row.UpdatedAt = EpochTime(time.Now())
-			return nil
-		}
-	}
-	newRow := &Row{
-		CreatedAt:           EpochTime(time.Now()),
-		ClusteringKeyHash:   clusteringKeyHash,
-		ClusteringKeyValues: req.ClusteringKeyValues,
-		Cells:               cells,
-	}
-	partition.Rows = append(partition.Rows, newRow)
-	return nil
-}
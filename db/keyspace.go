@@ -0,0 +1,267 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sanddb/messages"
+	"sanddb/utils"
+)
+
+var (
+	ErrKeyspaceNotFound = errors.New("keyspace does not exist")
+	ErrKeyspaceExists   = errors.New("keyspace already exists")
+	ErrDefaultKeyspace  = errors.New("the default keyspace can not be dropped")
+)
+
+const keyspacesFilename = "keyspaces.json"
+
+// keyspaceNamePattern is what keyspace names are made of, as they are the prefix of the names of their tables
+var keyspaceNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// loadKeyspaces reads the keyspaces stored in e.dir, and adds the default keyspace if it is not there yet.
+func (e *StorageEngine) loadKeyspaces() error {
+	content, err := ioutil.ReadFile(filepath.Join(e.dir, keyspacesFilename))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	} else if err == nil {
+		if err = json.Unmarshal(content, &e.keyspaces); err != nil {
+			return err
+		}
+	}
+	if e.getKeyspace(messages.DEFAULT_KEYSPACE) == nil {
+		epoch := EpochTimeFromMicro(0)
+		e.keyspaces = append(e.keyspaces, &Keyspace{Name: messages.DEFAULT_KEYSPACE, CreatedAt: epoch, UpdatedAt: epoch})
+		return e.persistKeyspaces()
+	}
+	return nil
+}
+
+func (e *StorageEngine) persistKeyspaces() error {
+	e.updateSchemaVersion()
+	content, err := json.MarshalIndent(e.keyspaces, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(e.dir, keyspacesFilename), content)
+}
+
+// getKeyspace returns the keyspace called name, or nil if it does not exist. e.mu must be held.
+func (e *StorageEngine) getKeyspace(name string) *Keyspace {
+	for _, keyspace := range e.keyspaces {
+		if keyspace.Name == name {
+			return keyspace
+		}
+	}
+	return nil
+}
+
+// definition returns a copy of the definition of a table, along with the replication options of its keyspace. e.mu must be held.
+func (e *StorageEngine) definition(table *Table) *Table {
+	definition := *table
+	if keyspace := e.getKeyspace(table.Keyspace()); keyspace != nil {
+		definition.Replication = keyspace.Replication
+	}
+	return &definition
+}
+
+// Keyspace returns the definition of a keyspace, or nil if it does not exist.
+func (e *StorageEngine) Keyspace(name string) *Keyspace {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	keyspace := e.getKeyspace(name)
+	if keyspace == nil {
+		return nil
+	}
+	definition := *keyspace
+	return &definition
+}
+
+// Keyspaces returns the definitions of every keyspace.
+func (e *StorageEngine) Keyspaces() []*Keyspace {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	keyspaces := make([]*Keyspace, 0, len(e.keyspaces))
+	for _, keyspace := range e.keyspaces {
+		definition := *keyspace
+		keyspaces = append(keyspaces, &definition)
+	}
+	return keyspaces
+}
+
+// CreateKeyspace adds a new keyspace, which tables can then be created in.
+// It fails with ErrKeyspaceDropped if a keyspace of the same name was dropped after req was made, e.g. for a repair from a node that missed the drop.
+func (e *StorageEngine) CreateKeyspace(req messages.KeyspaceRequest) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.getKeyspace(req.Name) != nil {
+		return ErrKeyspaceExists
+	}
+	if err := ValidateKeyspace(req); err != nil {
+		return err
+	}
+	timestamp := schemaTimestamp(req.Timestamp)
+	if droppedAt, ok := e.dropped.Keyspaces[req.Name]; ok && !timestamp.After(droppedAt) {
+		return ErrKeyspaceDropped
+	}
+	e.keyspaces = append(e.keyspaces, &Keyspace{Name: req.Name, Replication: req.Replication, CreatedAt: timestamp, UpdatedAt: timestamp})
+	return e.persistKeyspaces()
+}
+
+// AlterKeyspace changes the replication of a keyspace. The data already written is only moved to its new replicas by a repair.
+func (e *StorageEngine) AlterKeyspace(req messages.KeyspaceRequest) error {
+	if err := ValidateKeyspace(req); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	keyspace := e.getKeyspace(req.Name)
+	if keyspace == nil {
+		return ErrKeyspaceNotFound
+	}
+	keyspace.Replication = req.Replication
+	keyspace.UpdatedAt = schemaTimestamp(req.Timestamp)
+	return e.persistKeyspaces()
+}
+
+// DropKeyspace removes a keyspace along with every table in it and all of their data.
+func (e *StorageEngine) DropKeyspace(req messages.KeyspaceRequest) error {
+	if req.Name == messages.DEFAULT_KEYSPACE {
+		return ErrDefaultKeyspace
+	}
+	// No compaction can be writing the SSTables of the dropped tables
+	e.compactionMu.Lock()
+	defer e.compactionMu.Unlock()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.getKeyspace(req.Name) == nil {
+		return ErrKeyspaceNotFound
+	}
+	timestamp := schemaTimestamp(req.Timestamp)
+	err := e.removeTablesLocked(func(table *Table) (EpochTime, bool) {
+		return timestamp, table.Keyspace() == req.Name
+	})
+	if err != nil {
+		return err
+	}
+	keyspaces := make([]*Keyspace, 0, len(e.keyspaces))
+	for _, keyspace := range e.keyspaces {
+		if keyspace.Name != req.Name {
+			keyspaces = append(keyspaces, keyspace)
+		}
+	}
+	e.keyspaces = keyspaces
+	recordDrop(e.dropped.Keyspaces, req.Name, timestamp)
+	if err = e.persistDropped(); err != nil {
+		return err
+	}
+	return e.persistKeyspaces()
+}
+
+// ValidateKeyspace checks the name and the replication options of a keyspace that is about to be created or altered.
+func ValidateKeyspace(req messages.KeyspaceRequest) error {
+	if !keyspaceNamePattern.MatchString(req.Name) {
+		return fmt.Errorf("invalid keyspace name %q: only letters, digits and underscores are allowed", req.Name)
+	}
+	if req.Replication == nil {
+		return fmt.Errorf("keyspace %s needs replication options", req.Name)
+	}
+	_, err := NewReplicationStrategy(req.Replication)
+	return err
+}
+
+// Strategy returns the strategy that places the replicas of the tables of the keyspace, defaultStrategy if it does not define its replication.
+func (k *Keyspace) Strategy(defaultStrategy utils.ReplicationStrategy) utils.ReplicationStrategy {
+	if k.Replication == nil {
+		return defaultStrategy
+	}
+	strategy, err := NewReplicationStrategy(k.Replication)
+	if err != nil {
+		// The options were validated when the keyspace was created or altered
+		return defaultStrategy
+	}
+	return strategy
+}
+
+// Keyspace returns the name of the keyspace of the table.
+func (t *Table) Keyspace() string {
+	keyspace, _ := messages.SplitTableName(t.TableName)
+	return keyspace
+}
+
+// KeyspaceRequest returns the request that creates the keyspace of the table, from the replication options that come with its definition.
+// It is dated from the creation of the table, for the keyspace not to be created again on a node that has dropped it since.
+func (t *Table) KeyspaceRequest() *messages.KeyspaceRequest {
+	return &messages.KeyspaceRequest{
+		Name:        t.Keyspace(),
+		Replication: t.Replication,
+		Timestamp:   t.CreatedAt.UnixMicro(),
+	}
+}
+
+func (h *Handler) HandleCreateKeyspace(c *fiber.Ctx) error {
+	var req messages.KeyspaceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return err
+	}
+	err := h.Storage.CreateKeyspace(req)
+	if err == ErrKeyspaceExists {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Keyspace %s already exists.", req.Name))
+	} else if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	fmt.Printf("Created keyspace %s.\n", req.Name)
+	return h.sendAck(c, messages.KEYSPACE_ACK)
+}
+
+func (h *Handler) HandleAlterKeyspace(c *fiber.Ctx) error {
+	var req messages.KeyspaceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return err
+	}
+	err := h.Storage.AlterKeyspace(req)
+	if err == ErrKeyspaceNotFound {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Keyspace %s does not exist.", req.Name))
+	} else if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	fmt.Printf("Altered keyspace %s.\n", req.Name)
+	return h.sendAck(c, messages.KEYSPACE_ACK)
+}
+
+func (h *Handler) HandleDropKeyspace(c *fiber.Ctx) error {
+	var req messages.KeyspaceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return err
+	}
+	err := h.Storage.DropKeyspace(req)
+	if err == ErrKeyspaceNotFound {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Keyspace %s does not exist.", req.Name))
+	} else if err == ErrDefaultKeyspace {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Keyspace %s is the default keyspace, it can not be dropped.", req.Name))
+	} else if err != nil {
+		return err
+	}
+	fmt.Printf("Dropped keyspace %s.\n", req.Name)
+	return h.sendAck(c, messages.KEYSPACE_ACK)
+}
+
+// sendAck acknowledges a change to the schema, or a truncation, to the node that coordinates it.
+func (h *Handler) sendAck(c *fiber.Ctx, ackType messages.MessageType) error {
+	reply := &messages.PeerMessage{
+		Type:     ackType,
+		Content:  "1",
+		SourceID: h.Node.Id,
+	}
+	resp, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).Send(resp)
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"sort"
+)
+
+// Memtable holds the most recent writes of a node in memory until they are flushed to an immutable SSTable.
+// Partitions are kept sorted by partition key hash within each table, and rows by clustering key within each partition.
+type Memtable struct {
+	tables    map[string]*memtablePartitions
+	mutations int
+}
+
+type memtablePartitions struct {
+	keys       []int64
+	partitions map[int64]*Partition
+}
+
+func NewMemtable() *Memtable {
+	return &Memtable{
+		tables: make(map[string]*memtablePartitions),
+	}
+}
+
+// Apply merges a partition fragment into the memtable.
+func (m *Memtable) Apply(tableName string, fragment *Partition) {
+	table, ok := m.tables[tableName]
+	if !ok {
+		table = &memtablePartitions{
+			keys:       make([]int64, 0),
+			partitions: make(map[int64]*Partition),
+		}
+		m.tables[tableName] = table
+	}
+	key := fragment.Metadata.PartitionKey
+	existing, ok := table.partitions[key]
+	if !ok {
+		index := sort.Search(len(table.keys), func(i int) bool {
+			return table.keys[i] >= key
+		})
+		table.keys = append(table.keys, 0)
+		copy(table.keys[index+1:], table.keys[index:])
+		table.keys[index] = key
+	}
+	table.partitions[key] = MergePartitions(existing, fragment)
+	m.mutations++
+}
+
+// GetPartition returns the memtable's version of a partition, or nil if it has not been written to since the last flush.
+func (m *Memtable) GetPartition(tableName string, partitionKey int64) *Partition {
+	table, ok := m.tables[tableName]
+	if !ok {
+		return nil
+	}
+	return table.partitions[partitionKey]
+}
+
+// Partitions returns every partition of a table held in the memtable, sorted by partition key hash.
+func (m *Memtable) Partitions(tableName string) []*Partition {
+	partitions := make([]*Partition, 0)
+	table, ok := m.tables[tableName]
+	if !ok {
+		return partitions
+	}
+	for _, key := range table.keys {
+		partitions = append(partitions, table.partitions[key])
+	}
+	return partitions
+}
+
+func (m *Memtable) TableNames() []string {
+	names := make([]string, 0, len(m.tables))
+	for name := range m.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Mutations returns the number of writes applied since the memtable was created.
+func (m *Memtable) Mutations() int {
+	return m.mutations
+}
@@ -0,0 +1,233 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// Timestamp returns the write timestamp of this version of the row, in epoch nanoseconds.
+func (r *Row) Timestamp() int64 {
+	if r.UpdatedAt.UnixNano() > r.CreatedAt.UnixNano() {
+		return r.UpdatedAt.UnixNano()
+	}
+	return r.CreatedAt.UnixNano()
+}
+
+// IsTombstone reports whether this version of the row marks it as deleted, i.e. nothing was written to it after its deletion.
+// Technically, negative epoch time is actually valid, but it is used to mark rows that have not been deleted.
+func (r *Row) IsTombstone() bool {
+	return r.DeletedAt.UnixNano() >= 0 && r.UpdatedAt.UnixNano() <= r.DeletedAt.UnixNano()
+}
+
+// LiveView returns the row as seen by clients at the given time, with only the cells that are neither deleted nor expired.
+// It returns nil if the row is deleted, or if every one of its cells is.
+func (r *Row) LiveView(now time.Time) *Row {
+	if r.IsTombstone() {
+		return nil
+	}
+	view := *r
+	view.Cells = make([]*Cell, 0, len(r.Cells))
+	for _, cell := range r.Cells {
+		if cell.IsLive(now) {
+			view.Cells = append(view.Cells, cell)
+		}
+	}
+	if len(r.Cells) > 0 && len(view.Cells) == 0 {
+		return nil
+	}
+	return &view
+}
+
+// IsLive reports whether the cell holds a value at the given time.
+func (c *Cell) IsLive(now time.Time) bool {
+	return !c.Deleted && (c.TTL == 0 || now.Before(c.ExpiresAt()))
+}
+
+// ExpiresAt returns the time at which a cell written with a TTL expires.
+func (c *Cell) ExpiresAt() time.Time {
+	return c.Timestamp.Time().Add(time.Duration(c.TTL) * time.Second)
+}
+
+// MergeCells reconciles two versions of the same cell: the last write wins.
+// Like Cassandra, ties are broken in favour of deletions, then of the greatest value.
+func MergeCells(a *Cell, b *Cell) *Cell {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if a.Timestamp.UnixNano() != b.Timestamp.UnixNano() {
+		if b.Timestamp.UnixNano() > a.Timestamp.UnixNano() {
+			return b
+		}
+		return a
+	}
+	if a.Deleted != b.Deleted {
+		if b.Deleted {
+			return b
+		}
+		return a
+	}
+	if b.Value > a.Value || (b.Value == a.Value && b.TTL > a.TTL) {
+		return b
+	}
+	return a
+}
+
+// MergeRows reconciles two versions of the same row cell by cell, keeping the newest version of each cell.
+// Cells written at or before the deletion of the row are dropped.
+// The result is normalized, with its cells sorted by name, so merging a row with nil normalizes it.
+func MergeRows(a *Row, b *Row) *Row {
+	if a == nil && b == nil {
+		return nil
+	}
+	var merged *Row
+	cells := make(map[string]*Cell)
+	for _, row := range []*Row{a, b} {
+		if row == nil {
+			continue
+		}
+		if merged == nil {
+			merged = &Row{
+				CreatedAt:           row.CreatedAt,
+				UpdatedAt:           row.UpdatedAt,
+				DeletedAt:           row.DeletedAt,
+				ClusteringKeyHash:   row.ClusteringKeyHash,
+				ClusteringKey:       row.ClusteringKey,
+				ClusteringKeyValues: row.ClusteringKeyValues,
+			}
+		} else {
+			if merged.ClusteringKey == "" {
+				merged.ClusteringKey = row.ClusteringKey
+			}
+			// Keep the time at which the row was first created
+			if row.CreatedAt.UnixNano() > 0 && (merged.CreatedAt.UnixNano() <= 0 || row.CreatedAt.UnixNano() < merged.CreatedAt.UnixNano()) {
+				merged.CreatedAt = row.CreatedAt
+			}
+			if row.UpdatedAt.UnixNano() > merged.UpdatedAt.UnixNano() {
+				merged.UpdatedAt = row.UpdatedAt
+			}
+			if row.DeletedAt.UnixNano() > merged.DeletedAt.UnixNano() {
+				merged.DeletedAt = row.DeletedAt
+			}
+		}
+		for _, cell := range row.Cells {
+			// Cells written before cells had their own timestamps take the timestamp of their row
+			if cell.Timestamp.UnixNano() <= 0 {
+				legacyCell := *cell
+				legacyCell.Timestamp = EpochTime(time.Unix(0, row.Timestamp()))
+				cell = &legacyCell
+			}
+			cells[cell.Name] = MergeCells(cells[cell.Name], cell)
+		}
+	}
+	merged.Cells = make([]*Cell, 0, len(cells))
+	for _, cell := range cells {
+		if merged.DeletedAt.UnixNano() >= 0 && cell.Timestamp.UnixNano() <= merged.DeletedAt.UnixNano() {
+			continue
+		}
+		merged.Cells = append(merged.Cells, cell)
+	}
+	sort.Slice(merged.Cells, func(i, j int) bool {
+		return merged.Cells[i].Name < merged.Cells[j].Name
+	})
+	return merged
+}
+
+// SameRow reports whether two versions of a row hold exactly the same data.
+func SameRow(a *Row, b *Row) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	aBytes, _ := json.Marshal(MergeRows(a, nil))
+	bBytes, _ := json.Marshal(MergeRows(b, nil))
+	return bytes.Equal(aBytes, bBytes)
+}
+
+// MergePartitions reconciles several versions of the same partition row by row, and unions their range tombstones.
+// The rows of the result are sorted by clustering key.
+func MergePartitions(partitions ...*Partition) *Partition {
+	var merged *Partition
+	rows := make(map[int64]*Row)
+	for _, partition := range partitions {
+		if partition == nil {
+			continue
+		}
+		if merged == nil {
+			merged = &Partition{Metadata: partition.Metadata}
+		}
+		for _, row := range partition.Rows {
+			rows[row.ClusteringKeyHash] = MergeRows(rows[row.ClusteringKeyHash], row)
+		}
+		merged.Tombstones = mergeTombstones(merged.Tombstones, partition.Tombstones)
+	}
+	if merged == nil {
+		return nil
+	}
+	merged.Rows = make([]*Row, 0, len(rows))
+	for _, row := range rows {
+		merged.Rows = append(merged.Rows, row)
+	}
+	sortRows(merged.Rows)
+	return merged
+}
+
+func sortRows(rows []*Row) {
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].ClusteringKey != rows[j].ClusteringKey {
+			return rows[i].ClusteringKey < rows[j].ClusteringKey
+		}
+		return rows[i].ClusteringKeyHash < rows[j].ClusteringKeyHash
+	})
+}
+
+// HasRow reports whether the partition holds a version of the row with the given clustering key hash.
+func (p *Partition) HasRow(clusteringKeyHash int64) bool {
+	for _, row := range p.Rows {
+		if row.ClusteringKeyHash == clusteringKeyHash {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff returns the rows and range tombstones of a partition that another version of it is missing, or holds an older version of,
+// or nil if that version is up to date. It is what a replica holding the version needs to be repaired with.
+func (p *Partition) Diff(version *Partition) *Partition {
+	held := make(map[int64]*Row)
+	var tombstones []*RangeTombstone
+	if version != nil {
+		for _, row := range version.Rows {
+			held[row.ClusteringKeyHash] = row
+		}
+		tombstones = version.Tombstones
+	}
+	diff := &Partition{
+		Metadata: p.Metadata,
+		Rows:     make([]*Row, 0),
+	}
+	for _, row := range p.Rows {
+		if !SameRow(held[row.ClusteringKeyHash], row) {
+			diff.Rows = append(diff.Rows, row)
+		}
+	}
+	for _, tombstone := range p.Tombstones {
+		found := false
+		for _, other := range tombstones {
+			if other.sameBounds(&tombstone.ClusteringBounds) && other.DeletedAt.UnixNano() >= tombstone.DeletedAt.UnixNano() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			diff.Tombstones = append(diff.Tombstones, tombstone)
+		}
+	}
+	if len(diff.Rows) == 0 && len(diff.Tombstones) == 0 {
+		return nil
+	}
+	return diff
+}
@@ -6,49 +6,56 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"net/http"
 	"sanddb/messages"
-	"sanddb/utils"
 )
 
 func (h *Handler) HandleDBRead(c *fiber.Ctx) error {
 	var (
-		reqBody messages.ReadRequest
-		readRow *Row
+		reqBody       messages.ReadRequest
+		readRow       *Row
+		readPartition *Partition
 	)
 	err := c.BodyParser(&reqBody)
 	if err != nil {
 		return err
 	}
-	filename := fmt.Sprintf("data/%d.json", h.Node.Id)
-	localData, err := ReadJSON(filename)
-
-	table := GetTable(reqBody.TableName, localData)
-	if table == nil {
+	table := h.Storage.GetSchema(reqBody.TableName)
+	if table != nil {
+		if err = table.ValidateRead(&reqBody); err != nil {
+			err = fiber.NewError(http.StatusBadRequest, err.Error())
+			errBody, _ := json.Marshal(err)
+			_ = c.Status(http.StatusBadRequest).Send(errBody)
+			return err
+		}
+	}
+	// Tombstones are sent back as well, so that the coordinator can reconcile them with the other replicas
+	if table != nil && table.ReadsSlice(&reqBody) {
+		readPartition, err = h.Storage.ReadSlice(reqBody.TableName, reqBody.HashedPK, table.SliceBounds(&reqBody), reqBody.Reverse, reqBody.PageSize)
+	} else {
+		readRow, err = h.Storage.ReadRow(reqBody.TableName, reqBody.HashedPK, reqBody.ClusteringKeyValues)
+	}
+	if err == ErrTableNotFound {
 		errMsg := fmt.Sprintf("Table %s does not exist.", reqBody.TableName)
 		err = fiber.NewError(http.StatusBadRequest, errMsg)
 		errBody, _ := json.Marshal(err)
 		_ = c.Status(http.StatusBadRequest).Send(errBody)
 		return err
-	}
-	readPartition := GetPartition(table, reqBody.HashedPK)
-	clusteringKeyHash := utils.GetHashFromKeys(reqBody.ClusteringKeyValues)
-
-	for _, row := range readPartition.Rows {
-		if row.ClusteringKeyHash == clusteringKeyHash {
-			readRow = row
-		}
+	} else if err != nil {
+		_ = c.SendStatus(http.StatusInternalServerError)
+		return err
 	}
 
-	if readRow == nil {
+	if readRow == nil && readPartition == nil {
 		errMsg := fmt.Sprintf("Row not found.")
-		err = fiber.NewError(http.StatusBadRequest, errMsg)
+		err = fiber.NewError(http.StatusNotFound, errMsg)
 		errBody, _ := json.Marshal(err)
-		_ = c.Status(http.StatusBadRequest).Send(errBody)
+		_ = c.Status(http.StatusNotFound).Send(errBody)
 		return err
 	}
 	node := h.Node
 	reply := ReadResponse{
 		SourceNode: node,
-		Row:        *readRow,
+		Row:        readRow,
+		Partition:  readPartition,
 	}
 
 	body, err := json.Marshal(reply)
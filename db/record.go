@@ -0,0 +1,62 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+)
+
+// Records in the commit log and in SSTable data files are framed as
+// [payload length (4 bytes)][CRC32 of payload (4 bytes)][payload].
+const recordHeaderSize = 8
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	errTornRecord    = errors.New("torn record")
+	errCorruptRecord = errors.New("record checksum mismatch")
+)
+
+func encodeRecord(payload []byte) []byte {
+	record := make([]byte, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+	copy(record[recordHeaderSize:], payload)
+	return record
+}
+
+// decodeRecord decodes the record starting at offset in content and returns its payload along with the offset of the next record.
+func decodeRecord(content []byte, offset int64) ([]byte, int64, error) {
+	if int64(len(content))-offset < recordHeaderSize {
+		return nil, offset, errTornRecord
+	}
+	length := int64(binary.LittleEndian.Uint32(content[offset : offset+4]))
+	checksum := binary.LittleEndian.Uint32(content[offset+4 : offset+8])
+	start := offset + recordHeaderSize
+	if int64(len(content))-start < length {
+		return nil, offset, errTornRecord
+	}
+	payload := content[start : start+length]
+	if crc32.Checksum(payload, crcTable) != checksum {
+		return nil, offset, errCorruptRecord
+	}
+	return payload, start + length, nil
+}
+
+// readRecordAt reads a single record at offset from file without loading the rest of the file.
+func readRecordAt(file *os.File, offset int64) ([]byte, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := file.ReadAt(header, offset); err != nil {
+		return nil, err
+	}
+	length := binary.LittleEndian.Uint32(header[0:4])
+	payload := make([]byte, length)
+	if _, err := file.ReadAt(payload, offset+recordHeaderSize); err != nil {
+		return nil, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[4:8]) {
+		return nil, errCorruptRecord
+	}
+	return payload, nil
+}
@@ -0,0 +1,45 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"sanddb/messages"
+)
+
+// HandleDBRepair writes the versions of rows sent by a coordinator for read repair, keeping their original timestamps.
+func (h *Handler) HandleDBRepair(c *fiber.Ctx) error {
+	var (
+		reqBody RepairRequest
+	)
+	if err := c.BodyParser(&reqBody); err != nil {
+		return err
+	}
+	if reqBody.Partition == nil || reqBody.Partition.Metadata == nil {
+		return fiber.NewError(http.StatusBadRequest, "partition is required.")
+	}
+	err := h.Storage.WritePartition(reqBody.TableName, reqBody.Partition)
+	if err == ErrTableNotFound {
+		errMsg := fmt.Sprintf("Table %s does not exist.", reqBody.TableName)
+		err = fiber.NewError(http.StatusBadRequest, errMsg)
+		errBody, _ := json.Marshal(err)
+		_ = c.Status(http.StatusBadRequest).Send(errBody)
+		return err
+	} else if err != nil {
+		_ = c.SendStatus(http.StatusInternalServerError)
+		return err
+	}
+	reply := &messages.PeerMessage{
+		Type:     messages.WRITE_ACK,
+		Content:  "1",
+		SourceID: h.Node.Id,
+	}
+	resp, err := json.Marshal(reply)
+	if err != nil {
+		_ = c.SendStatus(http.StatusInternalServerError)
+		return err
+	}
+	_ = c.Send(resp)
+	return nil
+}
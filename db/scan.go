@@ -0,0 +1,50 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"sanddb/messages"
+)
+
+// HandleDBScan returns the partitions of a token range held by this node. The coordinator always gives both ends of the range.
+func (h *Handler) HandleDBScan(c *fiber.Ctx) error {
+	var reqBody messages.ScanRequest
+	if err := c.BodyParser(&reqBody); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	if reqBody.StartToken == nil || reqBody.EndToken == nil {
+		return fiber.NewError(http.StatusBadRequest, "start_token and end_token are required.")
+	}
+	resume, err := messages.DecodePagingState(reqBody.PagingState)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	var partitions []*Partition
+	if len(reqBody.Where) == 0 {
+		partitions, err = h.Storage.ScanRange(reqBody.TableName, *reqBody.StartToken, *reqBody.EndToken, resume, reqBody.PageSize)
+	} else {
+		// The coordinator has already checked that there is a single restriction, and turned its value into its canonical form
+		for column, value := range reqBody.Where {
+			partitions, err = h.Storage.IndexScan(reqBody.TableName, column, string(value), *reqBody.StartToken, *reqBody.EndToken, resume, reqBody.PageSize)
+		}
+	}
+	if err == ErrTableNotFound {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Table %s does not exist.", reqBody.TableName))
+	} else if errors.Is(err, ErrIndexNotFound) || errors.Is(err, ErrIndexNotBuilt) {
+		// Like an overloaded replica, a replica that can not use the index yet only fails its own answer
+		return fiber.NewError(http.StatusServiceUnavailable, err.Error())
+	} else if err != nil {
+		return err
+	}
+	body, err := json.Marshal(ScanResponse{
+		SourceNode: h.Node,
+		Partitions: partitions,
+	})
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).Send(body)
+}
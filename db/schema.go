@@ -0,0 +1,590 @@
+package db
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sanddb/messages"
+	"sort"
+	"time"
+)
+
+var (
+	ErrTableDropped    = errors.New("table was dropped after it was created")
+	ErrKeyspaceDropped = errors.New("keyspace was dropped after it was created")
+	ErrInvalidAlter    = errors.New("invalid alter")
+)
+
+const droppedFilename = "dropped.json"
+
+/* Schema
+Definitions of every keyspace and table of a node, as they are handed to other nodes to agree on the schema.
+Version: hash of the definitions, the same on every node that has the same keyspaces and tables
+DroppedKeyspaces/DroppedTables: time at which each dropped keyspace and table was dropped, so that a node that missed the drop does not bring it back
+*/
+type Schema struct {
+	Version          string               `json:"version"`
+	Keyspaces        []*Keyspace          `json:"keyspaces"`
+	Tables           LocalData            `json:"tables"`
+	DroppedKeyspaces map[string]EpochTime `json:"dropped_keyspaces"`
+	DroppedTables    map[string]EpochTime `json:"dropped_tables"`
+}
+
+// droppedSchema is what is stored in dropped.json, along with the time at which each table was last truncated.
+type droppedSchema struct {
+	Keyspaces map[string]EpochTime `json:"keyspaces"`
+	Tables    map[string]EpochTime `json:"tables"`
+	Truncated map[string]EpochTime `json:"truncated"`
+}
+
+// schemaTimestamp returns the time of a schema change given in microseconds since epoch, now if the change has no timestamp.
+func schemaTimestamp(micros int64) EpochTime {
+	if micros == 0 {
+		return EpochTime(time.Now())
+	}
+	return EpochTimeFromMicro(micros)
+}
+
+func (e *StorageEngine) loadDropped() error {
+	e.dropped = droppedSchema{
+		Keyspaces: make(map[string]EpochTime),
+		Tables:    make(map[string]EpochTime),
+		Truncated: make(map[string]EpochTime),
+	}
+	content, err := ioutil.ReadFile(filepath.Join(e.dir, droppedFilename))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, &e.dropped)
+}
+
+func (e *StorageEngine) persistDropped() error {
+	content, err := json.MarshalIndent(e.dropped, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(e.dir, droppedFilename), content)
+}
+
+// updateSchemaVersion hashes the definitions of every keyspace and table, leaving out when they were changed. e.mu must be held.
+func (e *StorageEngine) updateSchemaVersion() {
+	keyspaces := make([]Keyspace, 0, len(e.keyspaces))
+	for _, keyspace := range e.keyspaces {
+		keyspaces = append(keyspaces, Keyspace{Name: keyspace.Name, Replication: keyspace.Replication})
+	}
+	sort.Slice(keyspaces, func(i, j int) bool {
+		return keyspaces[i].Name < keyspaces[j].Name
+	})
+	tables := make([]Table, 0, len(e.schema))
+	for _, table := range e.schema {
+		definition := *table
+		definition.CreatedAt, definition.UpdatedAt, definition.Partitions = EpochTime{}, EpochTime{}, nil
+		tables = append(tables, definition)
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].TableName < tables[j].TableName
+	})
+	content, err := json.Marshal(struct {
+		Keyspaces []Keyspace `json:"keyspaces"`
+		Tables    []Table    `json:"tables"`
+	}{keyspaces, tables})
+	if err != nil {
+		fmt.Printf("Error in hashing schema: %s\n", err.Error())
+		return
+	}
+	// Formatted as a UUID, like the schema versions of Cassandra
+	sum := md5.Sum(content)
+	e.schemaVersion = fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// SchemaVersion returns the hash of the current schema, which nodes gossip to find out whether they agree on it.
+func (e *StorageEngine) SchemaVersion() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.schemaVersion
+}
+
+// Schema returns the definitions of every keyspace and table, along with the keyspaces and tables that were dropped.
+func (e *StorageEngine) Schema() *Schema {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	schema := &Schema{
+		Version:          e.schemaVersion,
+		Keyspaces:        make([]*Keyspace, 0, len(e.keyspaces)),
+		Tables:           make(LocalData, 0, len(e.schema)),
+		DroppedKeyspaces: make(map[string]EpochTime, len(e.dropped.Keyspaces)),
+		DroppedTables:    make(map[string]EpochTime, len(e.dropped.Tables)),
+	}
+	for _, keyspace := range e.keyspaces {
+		definition := *keyspace
+		schema.Keyspaces = append(schema.Keyspaces, &definition)
+	}
+	for _, table := range e.schema {
+		schema.Tables = append(schema.Tables, e.definition(table))
+	}
+	for name, droppedAt := range e.dropped.Keyspaces {
+		schema.DroppedKeyspaces[name] = droppedAt
+	}
+	for name, droppedAt := range e.dropped.Tables {
+		schema.DroppedTables[name] = droppedAt
+	}
+	return schema
+}
+
+// MergeSchema brings the schema of this node up to date with the schema of another node, and returns the number of changes it made.
+// Like in Cassandra, the latest change to a keyspace or table wins: keyspaces and tables are created, altered or dropped
+// depending on whether they were created, changed or dropped more recently on the other node.
+func (e *StorageEngine) MergeSchema(remote *Schema) (int, error) {
+	e.compactionMu.Lock()
+	defer e.compactionMu.Unlock()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	changes := 0
+	droppedKeyspaces := make(map[string]EpochTime)
+	for name, droppedAt := range remote.DroppedKeyspaces {
+		if recordDrop(e.dropped.Keyspaces, name, droppedAt) {
+			changes++
+		}
+		if keyspace := e.getKeyspace(name); keyspace != nil && name != messages.DEFAULT_KEYSPACE && !keyspace.CreatedAt.After(droppedAt) {
+			droppedKeyspaces[name] = droppedAt
+		}
+	}
+	for name, droppedAt := range remote.DroppedTables {
+		if recordDrop(e.dropped.Tables, name, droppedAt) {
+			changes++
+		}
+	}
+	dropped := 0
+	err := e.removeTablesLocked(func(table *Table) (EpochTime, bool) {
+		if droppedAt, ok := droppedKeyspaces[table.Keyspace()]; ok {
+			dropped++
+			return droppedAt, true
+		}
+		droppedAt, ok := e.dropped.Tables[table.TableName]
+		if ok && !table.CreatedAt.After(droppedAt) {
+			dropped++
+			return droppedAt, true
+		}
+		return EpochTime{}, false
+	})
+	if err != nil {
+		return changes, err
+	}
+	changes += dropped
+	if len(droppedKeyspaces) > 0 {
+		keyspaces := make([]*Keyspace, 0, len(e.keyspaces))
+		for _, keyspace := range e.keyspaces {
+			if _, ok := droppedKeyspaces[keyspace.Name]; !ok {
+				keyspaces = append(keyspaces, keyspace)
+			}
+		}
+		e.keyspaces = keyspaces
+		changes += len(droppedKeyspaces)
+	}
+	for _, remoteKeyspace := range remote.Keyspaces {
+		if droppedAt, ok := e.dropped.Keyspaces[remoteKeyspace.Name]; ok && !remoteKeyspace.CreatedAt.After(droppedAt) {
+			continue
+		}
+		keyspace := e.getKeyspace(remoteKeyspace.Name)
+		if keyspace == nil {
+			definition := *remoteKeyspace
+			e.keyspaces = append(e.keyspaces, &definition)
+			fmt.Printf("Created keyspace %s from the schema of another node.\n", remoteKeyspace.Name)
+			changes++
+		} else if remoteKeyspace.UpdatedAt.After(keyspace.UpdatedAt) {
+			keyspace.Replication = remoteKeyspace.Replication
+			keyspace.UpdatedAt = remoteKeyspace.UpdatedAt
+			changes++
+		}
+	}
+	for _, remoteTable := range remote.Tables {
+		if droppedAt, ok := e.dropped.Tables[remoteTable.TableName]; ok && !remoteTable.CreatedAt.After(droppedAt) {
+			continue
+		}
+		if e.getKeyspace(remoteTable.Keyspace()) == nil {
+			continue
+		}
+		table := GetTable(remoteTable.TableName, e.schema)
+		if table == nil {
+			definition := *remoteTable
+			definition.Replication, definition.Partitions = nil, make([]*Partition, 0)
+			e.schema = append(e.schema, &definition)
+			e.filterStats[definition.TableName] = &bloomFilterStats{}
+			fmt.Printf("Created table %s from the schema of another node.\n", remoteTable.TableName)
+			if definition.View != nil {
+				// The node missed the creation of the view, the rows of its base table that it holds still have to be copied to it
+				go e.buildView(definition.TableName)
+			}
+			changes++
+		} else if remoteTable.UpdatedAt.After(table.UpdatedAt) {
+			table.Columns = remoteTable.Columns
+			table.DroppedColumns = remoteTable.DroppedColumns
+			table.Indexes = remoteTable.Indexes
+			table.Compaction = remoteTable.Compaction
+			table.BloomFilterFPChance = remoteTable.BloomFilterFPChance
+			table.UpdatedAt = remoteTable.UpdatedAt
+			fmt.Printf("Altered table %s from the schema of another node.\n", remoteTable.TableName)
+			changes++
+		}
+	}
+	if changes == 0 {
+		return 0, nil
+	}
+	e.syncIndexesLocked()
+	if err = e.persistDropped(); err != nil {
+		return changes, err
+	}
+	if err = e.persistKeyspaces(); err != nil {
+		return changes, err
+	}
+	return changes, e.persistSchema()
+}
+
+// recordDrop keeps the latest time at which a keyspace or table was dropped, and reports whether it changed.
+func recordDrop(dropped map[string]EpochTime, name string, droppedAt EpochTime) bool {
+	if current, ok := dropped[name]; ok && !droppedAt.After(current) {
+		return false
+	}
+	dropped[name] = droppedAt
+	return true
+}
+
+// removeTablesLocked drops the tables that drop picks, along with all of their data, and records when they were dropped.
+// e.compactionMu and e.mu must be held.
+func (e *StorageEngine) removeTablesLocked(drop func(table *Table) (EpochTime, bool)) error {
+	removed := make(map[string]EpochTime)
+	for _, table := range e.schema {
+		if droppedAt, ok := drop(table); ok {
+			removed[table.TableName] = droppedAt
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	// The commit log must not hold the creation or the writes of the dropped tables, or replaying it would bring them back
+	if err := e.flushLocked(); err != nil {
+		return err
+	}
+	if err := e.commitLog.Reset(); err != nil {
+		return err
+	}
+	tables := make(LocalData, 0, len(e.schema))
+	for _, table := range e.schema {
+		droppedAt, ok := removed[table.TableName]
+		if !ok {
+			tables = append(tables, table)
+			continue
+		}
+		if err := e.deleteTableData(table.TableName); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(e.tableDir(table.TableName)); err != nil {
+			return err
+		}
+		delete(e.sstables, table.TableName)
+		delete(e.filterStats, table.TableName)
+		recordDrop(e.dropped.Tables, table.TableName, droppedAt)
+		fmt.Printf("Dropped table %s.\n", table.TableName)
+	}
+	e.schema = tables
+	e.syncIndexesLocked()
+	if err := e.persistDropped(); err != nil {
+		return err
+	}
+	return e.persistSchema()
+}
+
+// deleteTableData deletes every SSTable of a table. The memtable must have been flushed. e.mu must be held.
+func (e *StorageEngine) deleteTableData(tableName string) error {
+	for _, sstable := range e.sstables[tableName] {
+		if err := sstable.Delete(); err != nil {
+			return err
+		}
+	}
+	e.sstables[tableName] = nil
+	return nil
+}
+
+// AlterTable adds columns to a typed table or drops some of its columns.
+func (e *StorageEngine) AlterTable(req messages.AlterTableRequest) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	table := GetTable(req.TableName, e.schema)
+	if table == nil {
+		return ErrTableNotFound
+	}
+	if err := ValidateAlterTable(table, e.viewsLocked(table.TableName), req); err != nil {
+		return err
+	}
+	timestamp := schemaTimestamp(req.Timestamp)
+	// The definition is replaced rather than changed in place, as copies of it may be in use
+	dropped := make(map[string]bool, len(req.DropColumns))
+	droppedColumns := make(map[string]EpochTime, len(table.DroppedColumns)+len(req.DropColumns))
+	for name, droppedAt := range table.DroppedColumns {
+		droppedColumns[name] = droppedAt
+	}
+	for _, name := range req.DropColumns {
+		dropped[name] = true
+		droppedColumns[name] = timestamp
+	}
+	columns := make([]messages.ColumnDefinition, 0, len(table.Columns)+len(req.AddColumns))
+	for _, column := range table.Columns {
+		if !dropped[column.Name] {
+			columns = append(columns, column)
+		}
+	}
+	table.Columns = append(columns, req.AddColumns...)
+	if len(droppedColumns) > 0 {
+		table.DroppedColumns = droppedColumns
+	}
+	table.UpdatedAt = timestamp
+	// The rows of the views of the table are copies of its rows, so the views follow its columns
+	for _, view := range e.viewsLocked(table.TableName) {
+		view.Columns = table.Columns
+		view.DroppedColumns = table.DroppedColumns
+		view.UpdatedAt = timestamp
+	}
+	return e.persistSchema()
+}
+
+// DropTable removes a table along with all of its data.
+func (e *StorageEngine) DropTable(req messages.TableRequest) error {
+	e.compactionMu.Lock()
+	defer e.compactionMu.Unlock()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if GetTable(req.TableName, e.schema) == nil {
+		return ErrTableNotFound
+	}
+	timestamp := schemaTimestamp(req.Timestamp)
+	return e.removeTablesLocked(func(table *Table) (EpochTime, bool) {
+		return timestamp, table.TableName == req.TableName
+	})
+}
+
+// TruncateTable deletes all of the data of a table, which is left empty, along with the data of its materialized views.
+// The time of the truncation is recorded, so that writes, repairs and hints that are older than it do not bring the data back.
+func (e *StorageEngine) TruncateTable(req messages.TableRequest) error {
+	e.compactionMu.Lock()
+	defer e.compactionMu.Unlock()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	tableName := req.TableName
+	if GetTable(tableName, e.schema) == nil {
+		return ErrTableNotFound
+	}
+	timestamp := schemaTimestamp(req.Timestamp)
+	// Like for a drop, replaying the commit log must not bring the data back
+	if err := e.flushLocked(); err != nil {
+		return err
+	}
+	if err := e.commitLog.Reset(); err != nil {
+		return err
+	}
+	truncated := []string{tableName}
+	for _, view := range e.viewsLocked(tableName) {
+		truncated = append(truncated, view.TableName)
+	}
+	for _, name := range truncated {
+		if err := e.deleteTableData(name); err != nil {
+			return err
+		}
+		e.filterStats[name] = &bloomFilterStats{}
+		e.resetIndexesLocked(name)
+		recordDrop(e.dropped.Truncated, name, timestamp)
+	}
+	return e.persistDropped()
+}
+
+// withoutTruncated returns the part of a partition fragment written after its table was truncated at truncatedAt, nil if there is none.
+// The fragment itself is returned if all of it was written after the truncation.
+func withoutTruncated(fragment *Partition, truncatedAt EpochTime) *Partition {
+	truncated := truncatedAt.UnixNano()
+	kept := &Partition{
+		Metadata: fragment.Metadata,
+		Rows:     make([]*Row, 0, len(fragment.Rows)),
+	}
+	changed := false
+	for _, row := range fragment.Rows {
+		deleted := row.DeletedAt.UnixNano() > truncated
+		if row.Timestamp() <= truncated && !deleted {
+			changed = true
+			continue
+		}
+		keptRow := *row
+		keptRow.Cells = make([]*Cell, 0, len(row.Cells))
+		for _, cell := range row.Cells {
+			// Cells written before cells had their own timestamps have the timestamp of their row
+			timestamp := cell.Timestamp.UnixNano()
+			if timestamp <= 0 {
+				timestamp = row.Timestamp()
+			}
+			if timestamp > truncated {
+				keptRow.Cells = append(keptRow.Cells, cell)
+			}
+		}
+		if !deleted && row.DeletedAt.UnixNano() >= 0 {
+			// The row was deleted before the truncation, which deleted it anyway
+			keptRow.DeletedAt = EpochTime{}
+			changed = true
+		}
+		if len(keptRow.Cells) < len(row.Cells) {
+			changed = true
+			if len(keptRow.Cells) == 0 && !deleted {
+				continue
+			}
+		}
+		kept.Rows = append(kept.Rows, &keptRow)
+	}
+	for _, tombstone := range fragment.Tombstones {
+		if tombstone.DeletedAt.UnixNano() > truncated {
+			kept.Tombstones = append(kept.Tombstones, tombstone)
+		} else {
+			changed = true
+		}
+	}
+	if len(kept.Rows) == 0 && len(kept.Tombstones) == 0 {
+		return nil
+	} else if !changed {
+		return fragment
+	}
+	return kept
+}
+
+// ValidateAlterTable checks the columns added to or dropped from a table, given its materialized views.
+func ValidateAlterTable(table *Table, views []*Table, req messages.AlterTableRequest) error {
+	if len(req.AddColumns) == 0 && len(req.DropColumns) == 0 {
+		return fmt.Errorf("%w: no column to add or drop", ErrInvalidAlter)
+	}
+	if table.View != nil {
+		return fmt.Errorf("%w: %s is a materialized view, it follows the columns of its base table %s", ErrInvalidAlter, table.TableName, table.View.BaseTable)
+	}
+	if !table.Typed() {
+		return fmt.Errorf("%w: table %s has no column definitions, every cell can already be written to it", ErrInvalidAlter, table.TableName)
+	}
+	dropped := make(map[string]bool)
+	for _, name := range req.DropColumns {
+		if table.isKey(name) {
+			return fmt.Errorf("%w: %s is a key of the table, it can not be dropped", ErrInvalidAlter, name)
+		} else if _, ok := table.ColumnType(name); !ok {
+			return fmt.Errorf("%w: table %s has no column %s", ErrInvalidAlter, table.TableName, name)
+		} else if dropped[name] {
+			return fmt.Errorf("%w: column %s is dropped more than once", ErrInvalidAlter, name)
+		} else if index := table.Index(name); index != nil {
+			return fmt.Errorf("%w: column %s is indexed by %s, it can not be dropped", ErrInvalidAlter, name, index.Name)
+		}
+		for _, view := range views {
+			if view.isKey(name) {
+				return fmt.Errorf("%w: column %s is a key of materialized view %s, it can not be dropped", ErrInvalidAlter, name, view.TableName)
+			}
+		}
+		dropped[name] = true
+	}
+	if len(dropped) == len(table.Columns) && len(req.AddColumns) == 0 {
+		return fmt.Errorf("%w: table %s must keep at least one column", ErrInvalidAlter, table.TableName)
+	}
+	added := make(map[string]bool)
+	for _, column := range req.AddColumns {
+		if column.Name == "" {
+			return fmt.Errorf("%w: column names can not be empty", ErrInvalidAlter)
+		} else if _, ok := table.ColumnType(column.Name); (ok && !dropped[column.Name]) || added[column.Name] {
+			return fmt.Errorf("%w: table %s already has a column %s", ErrInvalidAlter, table.TableName, column.Name)
+		} else if !validColumnType(column.Type) {
+			return fmt.Errorf("%w: unknown type %s of column %s", ErrInvalidAlter, column.Type, column.Name)
+		}
+		added[column.Name] = true
+	}
+	return nil
+}
+
+// isDropped reports whether a cell was written to a column before the column was dropped.
+func (t *Table) isDropped(cell *Cell) bool {
+	droppedAt, ok := t.DroppedColumns[cell.Name]
+	return ok && !cell.Timestamp.After(droppedAt)
+}
+
+// withoutDroppedCells returns a row without the cells of its dropped columns, along with the number of cells left out.
+func (t *Table) withoutDroppedCells(row *Row) (*Row, int) {
+	if len(t.DroppedColumns) == 0 {
+		return row, 0
+	}
+	cells := make([]*Cell, 0, len(row.Cells))
+	for _, cell := range row.Cells {
+		if !t.isDropped(cell) {
+			cells = append(cells, cell)
+		}
+	}
+	if len(cells) == len(row.Cells) {
+		return row, 0
+	}
+	withoutDropped := *row
+	withoutDropped.Cells = cells
+	return &withoutDropped, len(row.Cells) - len(cells)
+}
+
+// LiveView returns a row as seen by clients at the given time, without the cells of dropped columns. See Row.LiveView.
+func (t *Table) LiveView(row *Row, now time.Time) *Row {
+	view, dropped := t.withoutDroppedCells(row)
+	if dropped > 0 && len(view.Cells) == 0 {
+		// The row only held values of dropped columns
+		return nil
+	}
+	if !t.hasKey(view, now) {
+		return nil
+	}
+	return view.LiveView(now)
+}
+
+func (h *Handler) HandleAlterTable(c *fiber.Ctx) error {
+	var req messages.AlterTableRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	err := h.Storage.AlterTable(req)
+	if err == ErrTableNotFound {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Table %s does not exist.", req.TableName))
+	} else if errors.Is(err, ErrInvalidAlter) {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	} else if err != nil {
+		return err
+	}
+	fmt.Printf("Altered table %s.\n", req.TableName)
+	return h.sendAck(c, messages.SCHEMA_ACK)
+}
+
+func (h *Handler) HandleDropTable(c *fiber.Ctx) error {
+	var req messages.TableRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	err := h.Storage.DropTable(req)
+	if err == ErrTableNotFound {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Table %s does not exist.", req.TableName))
+	} else if err != nil {
+		return err
+	}
+	return h.sendAck(c, messages.SCHEMA_ACK)
+}
+
+func (h *Handler) HandleTruncateTable(c *fiber.Ctx) error {
+	var req messages.TableRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	err := h.Storage.TruncateTable(req)
+	if err == ErrTableNotFound {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Table %s does not exist.", req.TableName))
+	} else if err != nil {
+		return err
+	}
+	fmt.Printf("Truncated table %s.\n", req.TableName)
+	return h.sendAck(c, messages.TRUNCATE_ACK)
+}
@@ -0,0 +1,361 @@
+package db
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SSTable components:
+// Data holds every partition of the SSTable as a checksummed JSON record, sorted by partition key hash.
+// Index holds one fixed-size (partition key hash, data offset) entry per partition, in the same order.
+// Summary holds every summaryInterval-th index entry and is kept in memory to narrow down the part of the index to read.
+// Filter holds a Bloom filter of the partition key hashes, kept in memory to skip SSTables that cannot hold a partition.
+// The summary is written last and doubles as the marker that the SSTable is complete.
+const (
+	DATA_COMPONENT    = "Data"
+	INDEX_COMPONENT   = "Index"
+	SUMMARY_COMPONENT = "Summary"
+	FILTER_COMPONENT  = "Filter"
+)
+
+const (
+	indexEntrySize  = 16
+	summaryInterval = 128
+)
+
+/* SSTableSummary
+MinKey/MaxKey: range of partition key hashes in the SSTable
+MinTimestamp/MaxTimestamp: range of row timestamps in the SSTable, in epoch nanoseconds
+Level: level of the SSTable for leveled compaction, flushed SSTables are in level 0
+*/
+type SSTableSummary struct {
+	MinKey         int64          `json:"min_key"`
+	MaxKey         int64          `json:"max_key"`
+	MinTimestamp   int64          `json:"min_timestamp"`
+	MaxTimestamp   int64          `json:"max_timestamp"`
+	Level          int            `json:"level"`
+	PartitionCount int            `json:"partition_count"`
+	Entries        []SummaryEntry `json:"entries"`
+}
+
+type SummaryEntry struct {
+	PartitionKey int64 `json:"partition_key"`
+	IndexOffset  int64 `json:"index_offset"`
+}
+
+// SSTable is an immutable, sorted on-disk segment of a single table.
+type SSTable struct {
+	TableName  string
+	Generation int
+	dir        string
+	summary    *SSTableSummary
+	dataFile   *os.File
+	indexFile  *os.File
+	dataSize   int64
+	filter     *BloomFilter
+}
+
+func sstableFilename(dir string, generation int, component string) string {
+	return filepath.Join(dir, fmt.Sprintf("%d-%s.db", generation, component))
+}
+
+// WriteSSTable writes partitions, which must be sorted by partition key hash, to a new SSTable generation in dir.
+// The Bloom filter of the SSTable is sized for the given false-positive chance.
+func WriteSSTable(dir string, tableName string, generation int, level int, fpChance float64, partitions []*Partition) (*SSTable, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0)
+	index := make([]byte, 0, len(partitions)*indexEntrySize)
+	summary := &SSTableSummary{
+		MinTimestamp:   math.MaxInt64,
+		MaxTimestamp:   math.MinInt64,
+		Level:          level,
+		PartitionCount: len(partitions),
+		Entries:        make([]SummaryEntry, 0, len(partitions)/summaryInterval+1),
+	}
+	filter := NewBloomFilter(len(partitions), fpChance)
+	for i, partition := range partitions {
+		payload, err := json.Marshal(partition)
+		if err != nil {
+			return nil, err
+		}
+		key := partition.Metadata.PartitionKey
+		if i == 0 {
+			summary.MinKey = key
+		}
+		summary.MaxKey = key
+		filter.Add(key)
+		for _, row := range partition.Rows {
+			if row.Timestamp() < summary.MinTimestamp {
+				summary.MinTimestamp = row.Timestamp()
+			}
+			if row.Timestamp() > summary.MaxTimestamp {
+				summary.MaxTimestamp = row.Timestamp()
+			}
+		}
+		if i%summaryInterval == 0 {
+			summary.Entries = append(summary.Entries, SummaryEntry{
+				PartitionKey: key,
+				IndexOffset:  int64(len(index)),
+			})
+		}
+		entry := make([]byte, indexEntrySize)
+		binary.LittleEndian.PutUint64(entry[0:8], uint64(key))
+		binary.LittleEndian.PutUint64(entry[8:16], uint64(len(data)))
+		index = append(index, entry...)
+		data = append(data, encodeRecord(payload)...)
+	}
+	summaryFile, err := json.Marshal(summary)
+	if err != nil {
+		return nil, err
+	}
+	// The summary goes last: an SSTable without one is an incomplete flush and is discarded on startup
+	if err = writeFileAtomic(sstableFilename(dir, generation, DATA_COMPONENT), data); err != nil {
+		return nil, err
+	}
+	if err = writeFileAtomic(sstableFilename(dir, generation, INDEX_COMPONENT), index); err != nil {
+		return nil, err
+	}
+	if err = writeFileAtomic(sstableFilename(dir, generation, FILTER_COMPONENT), filter.Serialize()); err != nil {
+		return nil, err
+	}
+	if err = writeFileAtomic(sstableFilename(dir, generation, SUMMARY_COMPONENT), summaryFile); err != nil {
+		return nil, err
+	}
+	return OpenSSTable(dir, tableName, generation)
+}
+
+func OpenSSTable(dir string, tableName string, generation int) (*SSTable, error) {
+	summaryFile, err := ioutil.ReadFile(sstableFilename(dir, generation, SUMMARY_COMPONENT))
+	if err != nil {
+		return nil, err
+	}
+	summary := &SSTableSummary{}
+	if err = json.Unmarshal(summaryFile, summary); err != nil {
+		return nil, err
+	}
+	dataFile, err := os.Open(sstableFilename(dir, generation, DATA_COMPONENT))
+	if err != nil {
+		return nil, err
+	}
+	indexFile, err := os.Open(sstableFilename(dir, generation, INDEX_COMPONENT))
+	if err != nil {
+		dataFile.Close()
+		return nil, err
+	}
+	info, err := dataFile.Stat()
+	if err != nil {
+		dataFile.Close()
+		indexFile.Close()
+		return nil, err
+	}
+	sstable := &SSTable{
+		TableName:  tableName,
+		Generation: generation,
+		dir:        dir,
+		summary:    summary,
+		dataFile:   dataFile,
+		indexFile:  indexFile,
+		dataSize:   info.Size(),
+	}
+	if sstable.filter, err = sstable.loadFilter(); err != nil {
+		sstable.Close()
+		return nil, err
+	}
+	return sstable, nil
+}
+
+// loadFilter reads the Bloom filter of the SSTable.
+// SSTables written before Bloom filters existed get one rebuilt from their index.
+func (s *SSTable) loadFilter() (*BloomFilter, error) {
+	filename := sstableFilename(s.dir, s.Generation, FILTER_COMPONENT)
+	content, err := ioutil.ReadFile(filename)
+	if err == nil {
+		return DeserializeBloomFilter(content)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	index, err := ioutil.ReadFile(sstableFilename(s.dir, s.Generation, INDEX_COMPONENT))
+	if err != nil {
+		return nil, err
+	}
+	filter := NewBloomFilter(s.summary.PartitionCount, DEFAULT_BLOOM_FILTER_FP_CHANCE)
+	for offset := 0; offset+indexEntrySize <= len(index); offset += indexEntrySize {
+		filter.Add(int64(binary.LittleEndian.Uint64(index[offset:])))
+	}
+	fmt.Printf("Rebuilt missing Bloom filter of SSTable %s generation %d.\n", s.TableName, s.Generation)
+	return filter, writeFileAtomic(filename, filter.Serialize())
+}
+
+// OpenSSTables opens every complete SSTable in dir, oldest generation first.
+// Components of incomplete SSTables (e.g. from a crash during a flush) are removed.
+func OpenSSTables(dir string, tableName string) ([]*SSTable, error) {
+	sstables := make([]*SSTable, 0)
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return sstables, nil
+	} else if err != nil {
+		return nil, err
+	}
+	complete := make(map[int]bool)
+	generations := make([]int, 0)
+	for _, file := range files {
+		if generation, component, ok := parseSSTableFilename(file.Name()); ok && component == SUMMARY_COMPONENT {
+			complete[generation] = true
+			generations = append(generations, generation)
+		}
+	}
+	for _, file := range files {
+		generation, _, ok := parseSSTableFilename(file.Name())
+		if !ok || !complete[generation] {
+			fmt.Printf("Removing leftover SSTable component %s.\n", file.Name())
+			_ = os.Remove(filepath.Join(dir, file.Name()))
+		}
+	}
+	sort.Ints(generations)
+	for _, generation := range generations {
+		sstable, err := OpenSSTable(dir, tableName, generation)
+		if err != nil {
+			return nil, err
+		}
+		sstables = append(sstables, sstable)
+	}
+	return sstables, nil
+}
+
+func parseSSTableFilename(filename string) (int, string, bool) {
+	if !strings.HasSuffix(filename, ".db") {
+		return 0, "", false
+	}
+	parts := strings.SplitN(strings.TrimSuffix(filename, ".db"), "-", 2)
+	if len(parts) != 2 {
+		return 0, "", false
+	}
+	generation, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", false
+	}
+	return generation, parts[1], true
+}
+
+// MightContain checks the key range and Bloom filter of the SSTable without touching the disk.
+// It returns false only if the SSTable definitely does not hold the partition.
+func (s *SSTable) MightContain(partitionKey int64) bool {
+	summary := s.summary
+	if summary.PartitionCount == 0 || partitionKey < summary.MinKey || partitionKey > summary.MaxKey {
+		return false
+	}
+	return s.filter.MightContain(partitionKey)
+}
+
+// GetPartition looks up a single partition, reading only the relevant part of the index and one data record.
+func (s *SSTable) GetPartition(partitionKey int64) (*Partition, error) {
+	summary := s.summary
+	if !s.MightContain(partitionKey) {
+		return nil, nil
+	}
+	// Find the last summary entry at or before the key
+	i := sort.Search(len(summary.Entries), func(i int) bool {
+		return summary.Entries[i].PartitionKey > partitionKey
+	}) - 1
+	if i < 0 {
+		return nil, nil
+	}
+	start := summary.Entries[i].IndexOffset
+	end := int64(summary.PartitionCount * indexEntrySize)
+	if i+1 < len(summary.Entries) {
+		end = summary.Entries[i+1].IndexOffset
+	}
+	block := make([]byte, end-start)
+	if _, err := s.indexFile.ReadAt(block, start); err != nil {
+		return nil, err
+	}
+	entries := len(block) / indexEntrySize
+	j := sort.Search(entries, func(j int) bool {
+		return int64(binary.LittleEndian.Uint64(block[j*indexEntrySize:])) >= partitionKey
+	})
+	if j == entries || int64(binary.LittleEndian.Uint64(block[j*indexEntrySize:])) != partitionKey {
+		return nil, nil
+	}
+	offset := int64(binary.LittleEndian.Uint64(block[j*indexEntrySize+8:]))
+	return s.readPartitionAt(offset)
+}
+
+func (s *SSTable) readPartitionAt(offset int64) (*Partition, error) {
+	payload, err := readRecordAt(s.dataFile, offset)
+	if err != nil {
+		return nil, err
+	}
+	partition := &Partition{}
+	if err = json.Unmarshal(payload, partition); err != nil {
+		return nil, err
+	}
+	return partition, nil
+}
+
+// Partitions reads every partition of the SSTable, in partition key order.
+func (s *SSTable) Partitions() ([]*Partition, error) {
+	content, err := ioutil.ReadFile(sstableFilename(s.dir, s.Generation, DATA_COMPONENT))
+	if err != nil {
+		return nil, err
+	}
+	partitions := make([]*Partition, 0, s.summary.PartitionCount)
+	for offset := int64(0); offset < int64(len(content)); {
+		payload, next, err := decodeRecord(content, offset)
+		if err != nil {
+			return nil, fmt.Errorf("SSTable %s generation %d: %s at offset %d", s.TableName, s.Generation, err.Error(), offset)
+		}
+		partition := &Partition{}
+		if err = json.Unmarshal(payload, partition); err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, partition)
+		offset = next
+	}
+	return partitions, nil
+}
+
+// Level returns the level of the SSTable for leveled compaction.
+func (s *SSTable) Level() int {
+	return s.summary.Level
+}
+
+// PartitionCount returns the number of partitions in the SSTable.
+func (s *SSTable) PartitionCount() int {
+	return s.summary.PartitionCount
+}
+
+// FilterSize returns the size of the SSTable's Bloom filter in bytes.
+func (s *SSTable) FilterSize() int64 {
+	return s.filter.SizeInBytes()
+}
+
+// DataSize returns the size of the SSTable's data component in bytes.
+func (s *SSTable) DataSize() int64 {
+	return s.dataSize
+}
+
+func (s *SSTable) Close() {
+	_ = s.dataFile.Close()
+	_ = s.indexFile.Close()
+}
+
+// Delete closes the SSTable and removes all of its components from disk.
+func (s *SSTable) Delete() error {
+	s.Close()
+	for _, component := range []string{SUMMARY_COMPONENT, FILTER_COMPONENT, INDEX_COMPONENT, DATA_COMPONENT} {
+		if err := os.Remove(sstableFilename(s.dir, s.Generation, component)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,780 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sanddb/messages"
+	"sanddb/utils"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrTableNotFound = errors.New("table does not exist")
+	ErrTableExists   = errors.New("table already exists")
+	// ErrStaleWrite is returned for a write that is older than the version of the row already held, which is ignored
+	ErrStaleWrite = errors.New("write is older than the current version of the row")
+)
+
+const schemaFilename = "schema.json"
+
+// StorageEngine is a log-structured store for all tables of a node.
+// Writes go to the commit log and then to the memtable, which is flushed to an immutable SSTable per table once it grows too large.
+// Reads merge the memtable and every SSTable of the table by timestamp.
+type StorageEngine struct {
+	mu        sync.RWMutex
+	dir       string
+	commitLog *CommitLog
+	// schema holds the definition of every table; the partitions of these tables are always empty
+	schema               LocalData
+	keyspaces            []*Keyspace
+	dropped              droppedSchema
+	schemaVersion        string
+	memtable             *Memtable
+	sstables             map[string][]*SSTable
+	nextGeneration       int
+	memtableMaxMutations int
+	gcGrace              time.Duration
+	filterStats          map[string]*bloomFilterStats
+	// indexes holds the secondary indexes of each table, which are only changed while mu is held for writing
+	indexes map[string][]*secondaryIndex
+	// viewWriter delivers the rows of the materialized views changed by writes to their base tables
+	viewWriter ViewWriter
+	// compactionMu makes sure that only one compaction runs at a time
+	compactionMu   sync.Mutex
+	compactionWake chan struct{}
+	tasksMu        sync.Mutex
+	tasks          []*CompactionTask
+	nextTaskID     int
+}
+
+// bloomFilterStats counts the outcome of the Bloom filter checks done by reads of a table, across all of its SSTables.
+// The counters are updated atomically since reads only hold e.mu for reading.
+type bloomFilterStats struct {
+	negatives      int64
+	truePositives  int64
+	falsePositives int64
+}
+
+/* StorageOptions
+MemtableMaxMutations: number of writes held in the memtable before it is flushed
+GCGrace: how long tombstones are kept around before compaction is allowed to purge them
+*/
+type StorageOptions struct {
+	MemtableMaxMutations int
+	GCGrace              time.Duration
+}
+
+// OpenStorageEngine loads the schema and SSTables stored in dir and replays the commit log into a fresh memtable.
+// A legacy single-file JSON data set (dir + ".json") is imported the first time the engine is opened.
+func OpenStorageEngine(dir string, commitLog *CommitLog, options StorageOptions) (*StorageEngine, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	e := &StorageEngine{
+		dir:                  dir,
+		commitLog:            commitLog,
+		schema:               make(LocalData, 0),
+		keyspaces:            make([]*Keyspace, 0),
+		memtable:             NewMemtable(),
+		sstables:             make(map[string][]*SSTable),
+		filterStats:          make(map[string]*bloomFilterStats),
+		indexes:              make(map[string][]*secondaryIndex),
+		memtableMaxMutations: options.MemtableMaxMutations,
+		gcGrace:              options.GCGrace,
+		compactionWake:       make(chan struct{}, 1),
+		tasks:                make([]*CompactionTask, 0),
+	}
+	schemaFile, err := ioutil.ReadFile(filepath.Join(dir, schemaFilename))
+	if os.IsNotExist(err) {
+		if err = e.importLegacyData(dir + ".json"); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else if err = json.Unmarshal(schemaFile, &e.schema); err != nil {
+		return nil, err
+	}
+	if err = e.loadKeyspaces(); err != nil {
+		return nil, err
+	}
+	if err = e.loadDropped(); err != nil {
+		return nil, err
+	}
+	e.updateSchemaVersion()
+	for _, table := range e.schema {
+		e.filterStats[table.TableName] = &bloomFilterStats{}
+		sstables, err := OpenSSTables(e.tableDir(table.TableName), table.TableName)
+		if err != nil {
+			return nil, err
+		}
+		e.sstables[table.TableName] = sstables
+		for _, sstable := range sstables {
+			if sstable.Generation >= e.nextGeneration {
+				e.nextGeneration = sstable.Generation + 1
+			}
+		}
+	}
+	replayed, err := commitLog.Replay(e.replayEntry)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Storage engine opened with %d keyspaces and %d tables, replayed %d commit log entries.\n", len(e.keyspaces), len(e.schema), replayed)
+	// Indexes are only held in memory, so they are built again from the data every time the node starts
+	e.mu.Lock()
+	e.syncIndexesLocked()
+	e.mu.Unlock()
+	go e.compactionWorker()
+	e.wakeCompactionWorker()
+	return e, nil
+}
+
+func (e *StorageEngine) replayEntry(entry *CommitLogEntry) error {
+	switch entry.Type {
+	case LOG_CREATE_TABLE:
+		if GetTable(entry.Create.TableName, e.schema) == nil {
+			return e.addTable(newTable(*entry.Create))
+		}
+	case LOG_INSERT:
+		table := GetTable(entry.Write.TableName, e.schema)
+		if table == nil {
+			fmt.Printf("Skipping replay of insert into missing table %s.\n", entry.Write.TableName)
+			return nil
+		}
+		e.memtable.Apply(entry.Write.TableName, newPartitionFragment(table, *entry.Write, entry.Timestamp))
+	case LOG_WRITE_PARTITION:
+		if GetTable(entry.TableName, e.schema) == nil {
+			fmt.Printf("Skipping replay of write into missing table %s.\n", entry.TableName)
+			return nil
+		}
+		e.memtable.Apply(entry.TableName, entry.Partition)
+	}
+	return nil
+}
+
+func (e *StorageEngine) importLegacyData(filename string) error {
+	legacyData, err := ReadJSON(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	fmt.Printf("Importing legacy data file %s.\n", filename)
+	for _, table := range legacyData {
+		if err = e.addTable(newTable(messages.CreateRequest{
+			TableName:          table.TableName,
+			PartitionKeyNames:  table.PartitionKeyNames,
+			ClusteringKeyNames: table.ClusteringKeyNames,
+		})); err != nil {
+			return err
+		}
+		for _, partition := range table.Partitions {
+			e.memtable.Apply(table.TableName, partition)
+		}
+	}
+	return e.flushLocked()
+}
+
+func (e *StorageEngine) tableDir(tableName string) string {
+	return filepath.Join(e.dir, url.PathEscape(tableName))
+}
+
+// addTable adds a table definition to the schema and persists it. e.mu must be held.
+func (e *StorageEngine) addTable(table *Table) error {
+	e.schema = append(e.schema, table)
+	e.filterStats[table.TableName] = &bloomFilterStats{}
+	return e.persistSchema()
+}
+
+func (e *StorageEngine) persistSchema() error {
+	e.updateSchemaVersion()
+	schemaFile, err := json.MarshalIndent(e.schema, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(e.dir, schemaFilename), schemaFile)
+}
+
+// GetSchema returns the definition of a table, or nil if it does not exist.
+func (e *StorageEngine) GetSchema(tableName string) *Table {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	table := GetTable(tableName, e.schema)
+	if table == nil {
+		return nil
+	}
+	return e.definition(table)
+}
+
+// Tables returns the definitions of every table.
+func (e *StorageEngine) Tables() LocalData {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	tables := make(LocalData, 0, len(e.schema))
+	for _, table := range e.schema {
+		tables = append(tables, e.definition(table))
+	}
+	return tables
+}
+
+// CreateTable adds a new table to the schema, in a keyspace that has to exist already.
+// It fails with ErrTableDropped if a table of the same name was dropped after req was made, e.g. for a repair from a node that missed the drop.
+func (e *StorageEngine) CreateTable(req messages.CreateRequest) error {
+	e.mu.Lock()
+	if CheckTableExists(req.TableName, e.schema) {
+		e.mu.Unlock()
+		return ErrTableExists
+	}
+	if keyspace, _ := messages.SplitTableName(req.TableName); e.getKeyspace(keyspace) == nil {
+		e.mu.Unlock()
+		return ErrKeyspaceNotFound
+	}
+	if err := ValidateTableOptions(req); err != nil {
+		e.mu.Unlock()
+		return err
+	}
+	if req.View != nil {
+		base := GetTable(req.View.BaseTable, e.schema)
+		if base == nil {
+			e.mu.Unlock()
+			return fmt.Errorf("%w: base table %s does not exist", ErrInvalidView, req.View.BaseTable)
+		}
+		if err := validateView(base, req); err != nil {
+			e.mu.Unlock()
+			return err
+		}
+	}
+	timestamp := schemaTimestamp(req.Timestamp)
+	if droppedAt, ok := e.dropped.Tables[req.TableName]; ok && !timestamp.After(droppedAt) {
+		e.mu.Unlock()
+		return ErrTableDropped
+	}
+	// Replaying the commit log creates the table with the same timestamp
+	req.Timestamp = timestamp.UnixMicro()
+	seq, err := e.commitLog.Append(&CommitLogEntry{
+		Type:      LOG_CREATE_TABLE,
+		Timestamp: timestamp,
+		Create:    &req,
+	})
+	if err == nil {
+		err = e.addTable(newTable(req))
+	}
+	e.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err = e.commitLog.Sync(seq); err != nil {
+		return err
+	}
+	if req.View != nil {
+		go e.buildView(req.TableName)
+	}
+	return nil
+}
+
+// Insert upserts the row described by req, versioned at timestamp, once its values are checked against the columns of the table.
+// The write is ignored if every one of its cells is older than the version already held, or than a tombstone covering the row.
+func (e *StorageEngine) Insert(req messages.WriteRequest, timestamp EpochTime) error {
+	table := e.GetSchema(req.TableName)
+	if table == nil {
+		return ErrTableNotFound
+	}
+	if err := table.ValidateWrite(&req); err != nil {
+		return err
+	}
+	current, err := e.ReadRow(req.TableName, req.HashedPK, req.ClusteringKeyValues)
+	if err != nil {
+		return err
+	}
+	fragment := newPartitionFragment(table, req, timestamp)
+	// Even if a newer version sneaks in before the write is applied, last-write-wins merging still keeps the newer cells
+	if current != nil && SameRow(MergeRows(current, fragment.Rows[0]), current) {
+		return ErrStaleWrite
+	}
+	entry := &CommitLogEntry{
+		Type:      LOG_INSERT,
+		Timestamp: timestamp,
+		Write:     &req,
+	}
+	return e.apply(req.TableName, entry, fragment)
+}
+
+// Delete writes the tombstone described by req, versioned at timestamp.
+func (e *StorageEngine) Delete(req messages.DeleteRequest, timestamp EpochTime) error {
+	table := e.GetSchema(req.TableName)
+	if table == nil {
+		return ErrTableNotFound
+	}
+	fragment, err := newDeleteFragment(table, req, timestamp)
+	if err != nil {
+		return err
+	}
+	// Tombstones are logged as partition writes, so that replaying them keeps the deletion time
+	entry := &CommitLogEntry{
+		Type:      LOG_WRITE_PARTITION,
+		TableName: req.TableName,
+		Partition: fragment,
+	}
+	return e.apply(req.TableName, entry, fragment)
+}
+
+// WritePartition merges the rows of partition, with their timestamps preserved, into a table.
+func (e *StorageEngine) WritePartition(tableName string, partition *Partition) error {
+	entry := &CommitLogEntry{
+		Type:      LOG_WRITE_PARTITION,
+		TableName: tableName,
+		Partition: partition,
+	}
+	return e.apply(tableName, entry, partition)
+}
+
+// apply logs a mutation, merges it into the memtable along with the secondary indexes of the table, and waits for the commit log to be durable.
+// The rows of the materialized views of the table changed by the mutation are then handed to the view writer.
+func (e *StorageEngine) apply(tableName string, entry *CommitLogEntry, fragment *Partition) error {
+	e.mu.Lock()
+	table := GetTable(tableName, e.schema)
+	if table == nil {
+		e.mu.Unlock()
+		return ErrTableNotFound
+	}
+	// Writes, repairs and hints older than a truncation would bring back the data it deleted
+	if truncatedAt, ok := e.dropped.Truncated[tableName]; ok {
+		kept := withoutTruncated(fragment, truncatedAt)
+		if kept == nil {
+			e.mu.Unlock()
+			fmt.Printf("Ignoring write to table %s: it is older than the truncation of the table.\n", tableName)
+			return nil
+		} else if kept != fragment {
+			fragment = kept
+			entry = &CommitLogEntry{Type: LOG_WRITE_PARTITION, TableName: tableName, Partition: fragment}
+		}
+	}
+	// The indexes and the views need the rows as they are before and after the mutation, which are read first so that the mutation fails as a whole if they can not be
+	views := e.viewsLocked(tableName)
+	var current, merged *Partition
+	if len(e.indexes[tableName]) > 0 || len(views) > 0 {
+		var err error
+		current, err = e.readPartitionLocked(tableName, fragment.Metadata.PartitionKey)
+		if err != nil {
+			e.mu.Unlock()
+			return err
+		}
+		merged = MergePartitions(current, fragment)
+	}
+	// The mutation has to hit the commit log before it is applied to the memtable
+	seq, err := e.commitLog.Append(entry)
+	if err != nil {
+		e.mu.Unlock()
+		return err
+	}
+	e.memtable.Apply(tableName, fragment)
+	if len(e.indexes[tableName]) > 0 {
+		e.updateIndexesLocked(table, merged, fragment)
+	}
+	updates := viewUpdates(table, views, current, merged, fragment)
+	if e.memtable.Mutations() >= e.memtableMaxMutations {
+		err = e.flushLocked()
+	}
+	e.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err = e.commitLog.Sync(seq); err != nil {
+		return err
+	}
+	e.writeViews(updates)
+	return nil
+}
+
+// ReadPartition returns the latest version of a partition, merged from the memtable and every SSTable of the table.
+func (e *StorageEngine) ReadPartition(tableName string, partitionKey int64) (*Partition, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if GetTable(tableName, e.schema) == nil {
+		return nil, ErrTableNotFound
+	}
+	return e.readPartitionLocked(tableName, partitionKey)
+}
+
+func (e *StorageEngine) readPartitionLocked(tableName string, partitionKey int64) (*Partition, error) {
+	stats := e.filterStats[tableName]
+	versions := make([]*Partition, 0)
+	for _, sstable := range e.sstables[tableName] {
+		if !sstable.MightContain(partitionKey) {
+			atomic.AddInt64(&stats.negatives, 1)
+			continue
+		}
+		partition, err := sstable.GetPartition(partitionKey)
+		if err != nil {
+			return nil, err
+		}
+		if partition == nil {
+			atomic.AddInt64(&stats.falsePositives, 1)
+			continue
+		}
+		atomic.AddInt64(&stats.truePositives, 1)
+		versions = append(versions, partition)
+	}
+	versions = append(versions, e.memtable.GetPartition(tableName, partitionKey))
+	return MergePartitions(versions...), nil
+}
+
+// ReadRow returns the latest version of a single row, or nil if it does not exist.
+// The latest version is a tombstone if the row has been deleted, either on its own or by a partition or range deletion.
+func (e *StorageEngine) ReadRow(tableName string, partitionKey int64, clusteringKeyValues []string) (*Row, error) {
+	table := e.GetSchema(tableName)
+	if table == nil {
+		return nil, ErrTableNotFound
+	}
+	partition, err := e.ReadPartition(tableName, partitionKey)
+	if err != nil || partition == nil {
+		return nil, err
+	}
+	clusteringKeyHash := utils.GetHashFromKeys(clusteringKeyValues)
+	for _, row := range partition.Rows {
+		if row.ClusteringKeyHash == clusteringKeyHash {
+			return partition.resolveRow(table.withClusteringKey(row)), nil
+		}
+	}
+	clusteringKey := table.ClusteringKey(clusteringKeyValues)
+	if deletedAt, found := partition.deletionTime(clusteringKeyValues, clusteringKey); found {
+		return newRowTombstone(clusteringKeyValues, clusteringKey, deletedAt), nil
+	}
+	return nil, nil
+}
+
+// ReadSlice returns the versions of the rows of a partition that fall within bounds, in clustering order, along with every range tombstone of the partition,
+// so that the coordinator can reconcile them with the other replicas. It returns nil if the partition does not exist.
+// If limit is set, only the first limit rows are returned, or the last ones if reverse is set, whether they are live or not.
+func (e *StorageEngine) ReadSlice(tableName string, partitionKey int64, bounds *ClusteringBounds, reverse bool, limit int) (*Partition, error) {
+	table := e.GetSchema(tableName)
+	if table == nil {
+		return nil, ErrTableNotFound
+	}
+	partition, err := e.ReadPartition(tableName, partitionKey)
+	if err != nil || partition == nil {
+		return nil, err
+	}
+	slice := &Partition{
+		Metadata:   partition.Metadata,
+		Rows:       make([]*Row, 0),
+		Tombstones: partition.Tombstones,
+	}
+	for _, row := range partition.Rows {
+		row = table.withClusteringKey(row)
+		if bounds.Contains(row.ClusteringKeyValues, row.ClusteringKey) {
+			slice.Rows = append(slice.Rows, row)
+		}
+	}
+	sortRows(slice.Rows)
+	if limit > 0 && len(slice.Rows) > limit {
+		if reverse {
+			slice.Rows = slice.Rows[len(slice.Rows)-limit:]
+		} else {
+			slice.Rows = slice.Rows[:limit]
+		}
+	}
+	return slice, nil
+}
+
+// ReadTable returns every partition of a table, merged and sorted by partition key hash.
+// Rows deleted by a partition or range deletion are returned as row tombstones.
+func (e *StorageEngine) ReadTable(tableName string) ([]*Partition, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if GetTable(tableName, e.schema) == nil {
+		return nil, ErrTableNotFound
+	}
+	return e.readTableLocked(tableName, func(int64) bool { return true })
+}
+
+// ReadTableAfter is ReadTable for the partitions whose partition key hash is greater than after, for a table to be read a page at a time.
+func (e *StorageEngine) ReadTableAfter(tableName string, after int64) ([]*Partition, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if GetTable(tableName, e.schema) == nil {
+		return nil, ErrTableNotFound
+	}
+	return e.readTableLocked(tableName, func(partitionKey int64) bool { return partitionKey > after })
+}
+
+func (e *StorageEngine) readTableLocked(tableName string, keep func(partitionKey int64) bool) ([]*Partition, error) {
+	partitions, err := e.mergedPartitionsLocked(tableName, keep)
+	if err != nil {
+		return nil, err
+	}
+	for i, partition := range partitions {
+		partitions[i] = partition.materialize()
+	}
+	return partitions, nil
+}
+
+// mergedPartitionsLocked returns the partitions of a table whose partition key hash is kept by keep, merged and sorted by partition key hash.
+func (e *StorageEngine) mergedPartitionsLocked(tableName string, keep func(partitionKey int64) bool) ([]*Partition, error) {
+	versions := make(map[int64][]*Partition)
+	for _, sstable := range e.sstables[tableName] {
+		partitions, err := sstable.Partitions()
+		if err != nil {
+			return nil, err
+		}
+		for _, partition := range partitions {
+			key := partition.Metadata.PartitionKey
+			if keep(key) {
+				versions[key] = append(versions[key], partition)
+			}
+		}
+	}
+	for _, partition := range e.memtable.Partitions(tableName) {
+		key := partition.Metadata.PartitionKey
+		if keep(key) {
+			versions[key] = append(versions[key], partition)
+		}
+	}
+	keys := make([]int64, 0, len(versions))
+	for key := range versions {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+	partitions := make([]*Partition, 0, len(keys))
+	for _, key := range keys {
+		partitions = append(partitions, MergePartitions(versions[key]...))
+	}
+	return partitions, nil
+}
+
+// ScanRange returns the versions of the partitions of a table whose token is in the range from start, excluded, up to end, in token order,
+// with their rows in clustering order and every one of their range tombstones, so that the coordinator can reconcile them with the other replicas.
+// A scan that resumes from a paging state only returns the rows that come after the last row it returned.
+// If limit is set, only the first limit rows are returned, whether they are live or not.
+func (e *StorageEngine) ScanRange(tableName string, start int64, end int64, resume *messages.PagingState, limit int) ([]*Partition, error) {
+	table := e.GetSchema(tableName)
+	if table == nil {
+		return nil, ErrTableNotFound
+	}
+	e.mu.RLock()
+	partitions, err := e.mergedPartitionsLocked(tableName, func(partitionKey int64) bool {
+		return partitionKey > start && partitionKey <= end && (resume == nil || partitionKey >= resume.Token)
+	})
+	e.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	var resumeKey string
+	if resume != nil {
+		resumeKey = table.ClusteringKey(resume.ClusteringKeyValues)
+	}
+	scanned := make([]*Partition, 0)
+	count := 0
+	for _, partition := range partitions {
+		if limit > 0 && count == limit {
+			break
+		}
+		rows := make([]*Row, 0, len(partition.Rows))
+		for _, row := range partition.Rows {
+			row = table.withClusteringKey(row)
+			if resume != nil && partition.Metadata.PartitionKey == resume.Token && row.ClusteringKey <= resumeKey {
+				continue
+			}
+			rows = append(rows, row)
+		}
+		sortRows(rows)
+		if limit > 0 && count+len(rows) > limit {
+			rows = rows[:limit-count]
+		}
+		count += len(rows)
+		if len(rows) == 0 && len(partition.Tombstones) == 0 {
+			continue
+		}
+		scanned = append(scanned, &Partition{
+			Metadata:   partition.Metadata,
+			Rows:       rows,
+			Tombstones: partition.Tombstones,
+		})
+	}
+	return scanned, nil
+}
+
+// LocalData materializes every table stored on this node along with all of its data.
+func (e *StorageEngine) LocalData() (LocalData, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	data := make(LocalData, 0, len(e.schema))
+	for _, definition := range e.schema {
+		partitions, err := e.readTableLocked(definition.TableName, func(int64) bool { return true })
+		if err != nil {
+			return nil, err
+		}
+		table := e.definition(definition)
+		table.Partitions = partitions
+		data = append(data, table)
+	}
+	return data, nil
+}
+
+// Flush writes the memtable out as one new SSTable per table and discards the commit log it covered.
+func (e *StorageEngine) Flush() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.flushLocked()
+}
+
+func (e *StorageEngine) flushLocked() error {
+	if e.memtable.Mutations() == 0 {
+		return nil
+	}
+	for _, tableName := range e.memtable.TableNames() {
+		fpChance := GetTable(tableName, e.schema).BloomFilterChance()
+		sstable, err := WriteSSTable(e.tableDir(tableName), tableName, e.nextGeneration, 0, fpChance, e.memtable.Partitions(tableName))
+		if err != nil {
+			fmt.Printf("Error in flushing memtable of table %s: %s\n", tableName, err.Error())
+			return err
+		}
+		e.nextGeneration++
+		e.sstables[tableName] = append(e.sstables[tableName], sstable)
+	}
+	fmt.Printf("Flushed %d mutations to SSTables.\n", e.memtable.Mutations())
+	e.memtable = NewMemtable()
+	e.wakeCompactionWorker()
+	// Everything in the commit log is now in an SSTable
+	return e.commitLog.Reset()
+}
+
+// Close flushes the memtable and closes the commit log.
+func (e *StorageEngine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.flushLocked(); err != nil {
+		return err
+	}
+	for _, sstables := range e.sstables {
+		for _, sstable := range sstables {
+			sstable.Close()
+		}
+	}
+	return e.commitLog.Close()
+}
+
+func newTable(req messages.CreateRequest) *Table {
+	partitions := make([]*Partition, 0)
+	return &Table{
+		TableName:           req.TableName,
+		PartitionKeyNames:   req.PartitionKeyNames,
+		ClusteringKeyNames:  req.ClusteringKeyNames,
+		Columns:             req.Columns,
+		ClusteringOrder:     req.ClusteringOrder,
+		Compaction:          req.Compaction,
+		BloomFilterFPChance: req.BloomFilterFPChance,
+		View:                req.View,
+		CreatedAt:           EpochTimeFromMicro(req.Timestamp),
+		UpdatedAt:           EpochTimeFromMicro(req.Timestamp),
+		Partitions:          partitions,
+	}
+}
+
+// CreateRequest returns the request that creates a table with the same definition, e.g. to hand the schema to a joining node.
+func (t *Table) CreateRequest() messages.CreateRequest {
+	return messages.CreateRequest{
+		TableName:           t.TableName,
+		PartitionKeyNames:   t.PartitionKeyNames,
+		ClusteringKeyNames:  t.ClusteringKeyNames,
+		Columns:             t.Columns,
+		ClusteringOrder:     t.ClusteringOrder,
+		Compaction:          t.Compaction,
+		BloomFilterFPChance: t.BloomFilterFPChance,
+		View:                t.View,
+		Timestamp:           t.CreatedAt.UnixMicro(),
+	}
+}
+
+// ValidateTableOptions checks the name, the columns and the storage options of a table that is about to be created.
+func ValidateTableOptions(req messages.CreateRequest) error {
+	if _, table := messages.SplitTableName(req.TableName); table == "" || strings.Contains(table, ".") {
+		return fmt.Errorf("invalid table name %q: expected keyspace.table, or the name alone for a table of the default keyspace", req.TableName)
+	}
+	if err := ValidateColumns(req); err != nil {
+		return err
+	}
+	if _, err := NewCompactionStrategy(req.Compaction); err != nil {
+		return err
+	}
+	if req.BloomFilterFPChance < 0 || req.BloomFilterFPChance > 1 {
+		return fmt.Errorf("invalid bloom_filter_fp_chance %g: must be between 0 and 1", req.BloomFilterFPChance)
+	}
+	return nil
+}
+
+// NewReplicationStrategy returns the strategy that places the replicas of a keyspace with the given replication options.
+func NewReplicationStrategy(options *messages.ReplicationOptions) (utils.ReplicationStrategy, error) {
+	return utils.NewReplicationStrategy(options.Class, options.ReplicationFactor, options.Datacenters)
+}
+
+// Strategies returns the strategies that place the replicas of the tables, along with defaultStrategy.
+func (data LocalData) Strategies(defaultStrategy utils.ReplicationStrategy) []utils.ReplicationStrategy {
+	strategies := []utils.ReplicationStrategy{defaultStrategy}
+	for _, table := range data {
+		if table.Replication != nil {
+			strategies = append(strategies, table.Strategy(defaultStrategy))
+		}
+	}
+	return strategies
+}
+
+// Strategy returns the strategy that places the replicas of the table, defaultStrategy if its keyspace does not define its replication.
+func (t *Table) Strategy(defaultStrategy utils.ReplicationStrategy) utils.ReplicationStrategy {
+	if t.Replication == nil {
+		return defaultStrategy
+	}
+	strategy, err := NewReplicationStrategy(t.Replication)
+	if err != nil {
+		// The options were validated when the keyspace was created or altered
+		return defaultStrategy
+	}
+	return strategy
+}
+
+// BloomFilterChance returns the false-positive chance of the Bloom filters of the table's SSTables.
+func (t *Table) BloomFilterChance() float64 {
+	if t.BloomFilterFPChance == 0 {
+		return DEFAULT_BLOOM_FILTER_FP_CHANCE
+	}
+	return t.BloomFilterFPChance
+}
+
+// newPartitionFragment builds the partition fragment that represents a single insert into table.
+func newPartitionFragment(table *Table, req messages.WriteRequest, timestamp EpochTime) *Partition {
+	cells := make([]*Cell, 0)
+	for i := range req.CellNames {
+		cell := &Cell{
+			Name:      req.CellNames[i],
+			Value:     req.CellValues[i],
+			Timestamp: timestamp,
+			TTL:       req.TTL,
+		}
+		cells = append(cells, cell)
+	}
+	row := &Row{
+		CreatedAt:           timestamp,
+		UpdatedAt:           timestamp,
+		ClusteringKeyHash:   utils.GetHashFromKeys(req.ClusteringKeyValues),
+		ClusteringKey:       table.ClusteringKey(req.ClusteringKeyValues),
+		ClusteringKeyValues: req.ClusteringKeyValues,
+		Cells:               cells,
+	}
+	return &Partition{
+		Metadata: &PartitionMetadata{
+			PartitionKey:       req.HashedPK,
+			PartitionKeyValues: req.PartitionKeyValues,
+		},
+		Rows: []*Row{MergeRows(row, nil)},
+	}
+}
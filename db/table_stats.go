@@ -0,0 +1,63 @@
+package db
+
+import (
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"sync/atomic"
+)
+
+/* TableStats
+SSTableReadsSkipped: SSTable lookups avoided because the key range or Bloom filter ruled the partition out
+BloomFilterFalsePositives: lookups that went to disk because of the Bloom filter but found no partition
+BloomFilterFalseRatio: false positives over all lookups the Bloom filter let through
+*/
+type TableStats struct {
+	TableName                 string  `json:"table_name"`
+	SSTableCount              int     `json:"sstable_count"`
+	SpaceUsed                 int64   `json:"space_used"`
+	MemtablePartitions        int     `json:"memtable_partitions"`
+	BloomFilterFPChance       float64 `json:"bloom_filter_fp_chance"`
+	BloomFilterSpaceUsed      int64   `json:"bloom_filter_space_used"`
+	SSTableReadsSkipped       int64   `json:"sstable_reads_skipped"`
+	BloomFilterTruePositives  int64   `json:"bloom_filter_true_positives"`
+	BloomFilterFalsePositives int64   `json:"bloom_filter_false_positives"`
+	BloomFilterFalseRatio     float64 `json:"bloom_filter_false_ratio"`
+}
+
+// TableStats reports the storage statistics of every table, similar to "nodetool tablestats".
+func (e *StorageEngine) TableStats() []*TableStats {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	stats := make([]*TableStats, 0, len(e.schema))
+	for _, table := range e.schema {
+		tableStats := &TableStats{
+			TableName:           table.TableName,
+			SSTableCount:        len(e.sstables[table.TableName]),
+			MemtablePartitions:  len(e.memtable.Partitions(table.TableName)),
+			BloomFilterFPChance: table.BloomFilterChance(),
+		}
+		for _, sstable := range e.sstables[table.TableName] {
+			tableStats.SpaceUsed += sstable.DataSize()
+			tableStats.BloomFilterSpaceUsed += sstable.FilterSize()
+		}
+		if filterStats, ok := e.filterStats[table.TableName]; ok {
+			tableStats.SSTableReadsSkipped = atomic.LoadInt64(&filterStats.negatives)
+			tableStats.BloomFilterTruePositives = atomic.LoadInt64(&filterStats.truePositives)
+			tableStats.BloomFilterFalsePositives = atomic.LoadInt64(&filterStats.falsePositives)
+		}
+		if positives := tableStats.BloomFilterTruePositives + tableStats.BloomFilterFalsePositives; positives > 0 {
+			tableStats.BloomFilterFalseRatio = float64(tableStats.BloomFilterFalsePositives) / float64(positives)
+		}
+		stats = append(stats, tableStats)
+	}
+	return stats
+}
+
+func (h *Handler) HandleTableStats(c *fiber.Ctx) error {
+	body, err := json.Marshal(h.Storage.TableStats())
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).Send(body)
+}
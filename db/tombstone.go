@@ -0,0 +1,180 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sanddb/messages"
+	"sanddb/utils"
+)
+
+var ErrInvalidDelete = errors.New("invalid delete")
+
+func equalKeys(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeTombstones unions the range tombstones of several versions of a partition.
+// Tombstones over the same range are collapsed into the most recent one.
+func mergeTombstones(merged []*RangeTombstone, tombstones []*RangeTombstone) []*RangeTombstone {
+	for _, tombstone := range tombstones {
+		found := false
+		for i, existing := range merged {
+			if existing.sameBounds(&tombstone.ClusteringBounds) {
+				if tombstone.DeletedAt.UnixNano() > existing.DeletedAt.UnixNano() {
+					merged[i] = tombstone
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, tombstone)
+		}
+	}
+	return merged
+}
+
+// deletionTime returns the time of the most recent range tombstone of the partition covering a row, or false if there is none.
+func (p *Partition) deletionTime(clusteringKeyValues []string, clusteringKey string) (EpochTime, bool) {
+	var deletedAt EpochTime
+	found := false
+	for _, tombstone := range p.Tombstones {
+		if tombstone.Contains(clusteringKeyValues, clusteringKey) && (!found || tombstone.DeletedAt.UnixNano() > deletedAt.UnixNano()) {
+			deletedAt = tombstone.DeletedAt
+			found = true
+		}
+	}
+	return deletedAt, found
+}
+
+// isShadowed reports whether a version of a row is entirely deleted by one of the range tombstones of the partition,
+// in which case the row does not need to be kept around.
+func (p *Partition) isShadowed(row *Row) bool {
+	deletedAt, found := p.deletionTime(row.ClusteringKeyValues, row.ClusteringKey)
+	return found && row.DeletedAt.UnixNano() <= deletedAt.UnixNano() && p.resolveRow(row).IsTombstone()
+}
+
+// resolveRow applies the range tombstones of the partition to a version of one of its rows, the same way a row deletion would.
+// Cells written before the deletion are dropped, and a row with nothing written after it becomes a row tombstone,
+// so that it can be reconciled with other replicas.
+func (p *Partition) resolveRow(row *Row) *Row {
+	deletedAt, found := p.deletionTime(row.ClusteringKeyValues, row.ClusteringKey)
+	if !found {
+		return row
+	}
+	return MergeRows(row, newRowTombstone(row.ClusteringKeyValues, row.ClusteringKey, deletedAt))
+}
+
+// materialize returns a copy of the partition with the range tombstones applied to its rows.
+func (p *Partition) materialize() *Partition {
+	if len(p.Tombstones) == 0 {
+		return p
+	}
+	materialized := &Partition{
+		Metadata:   p.Metadata,
+		Rows:       make([]*Row, 0, len(p.Rows)),
+		Tombstones: p.Tombstones,
+	}
+	for _, row := range p.Rows {
+		materialized.Rows = append(materialized.Rows, p.resolveRow(row))
+	}
+	return materialized
+}
+
+func newRowTombstone(clusteringKeyValues []string, clusteringKey string, deletedAt EpochTime) *Row {
+	return &Row{
+		CreatedAt:           deletedAt,
+		UpdatedAt:           deletedAt,
+		DeletedAt:           deletedAt,
+		ClusteringKeyHash:   utils.GetHashFromKeys(clusteringKeyValues),
+		ClusteringKey:       clusteringKey,
+		ClusteringKeyValues: clusteringKeyValues,
+		Cells:               make([]*Cell, 0),
+	}
+}
+
+// newDeleteFragment builds the partition fragment that represents a single delete:
+// cell tombstones if cell names are given, a row tombstone if every clustering key is given,
+// a range tombstone for a clustering key prefix or range, and a partition tombstone if neither is given.
+func newDeleteFragment(table *Table, req messages.DeleteRequest, timestamp EpochTime) (*Partition, error) {
+	partition := &Partition{
+		Metadata: &PartitionMetadata{
+			PartitionKey:       req.HashedPK,
+			PartitionKeyValues: req.PartitionKeyValues,
+		},
+		Rows: make([]*Row, 0),
+	}
+	if err := table.ValidateDelete(&req); err != nil {
+		return nil, err
+	}
+	switch {
+	case len(req.CellNames) > 0:
+		if req.ClusteringRange != nil || len(req.ClusteringKeyValues) != len(table.ClusteringKeyNames) {
+			return nil, fmt.Errorf("%w: cell_names can only be deleted from a single row", ErrInvalidDelete)
+		}
+		row := &Row{
+			CreatedAt:           timestamp,
+			UpdatedAt:           timestamp,
+			ClusteringKeyHash:   utils.GetHashFromKeys(req.ClusteringKeyValues),
+			ClusteringKey:       table.ClusteringKey(req.ClusteringKeyValues),
+			ClusteringKeyValues: req.ClusteringKeyValues,
+			Cells:               make([]*Cell, 0, len(req.CellNames)),
+		}
+		for _, name := range req.CellNames {
+			row.Cells = append(row.Cells, &Cell{
+				Name:      name,
+				Timestamp: timestamp,
+				Deleted:   true,
+			})
+		}
+		partition.Rows = append(partition.Rows, MergeRows(row, nil))
+	case req.ClusteringRange != nil:
+		if len(req.ClusteringKeyValues) > 0 {
+			return nil, fmt.Errorf("%w: clustering_keys and clustering_range cannot be used together", ErrInvalidDelete)
+		}
+		bounds := req.ClusteringRange
+		if len(bounds.Start) > len(table.ClusteringKeyNames) || len(bounds.End) > len(table.ClusteringKeyNames) {
+			return nil, fmt.Errorf("%w: clustering_range bounds have more values than the %d clustering keys", ErrInvalidDelete, len(table.ClusteringKeyNames))
+		}
+		if len(bounds.Start) == 0 && len(bounds.End) == 0 {
+			return nil, fmt.Errorf("%w: clustering_range needs a start or an end", ErrInvalidDelete)
+		}
+		tombstone := &RangeTombstone{
+			ClusteringBounds: ClusteringBounds{
+				Start:          bounds.Start,
+				StartInclusive: bounds.StartInclusive,
+				End:            bounds.End,
+				EndInclusive:   bounds.EndInclusive,
+			},
+			DeletedAt: timestamp,
+		}
+		table.setKeys(&tombstone.ClusteringBounds)
+		partition.Tombstones = []*RangeTombstone{tombstone}
+	case len(req.ClusteringKeyValues) == len(table.ClusteringKeyNames) && len(req.ClusteringKeyValues) > 0:
+		partition.Rows = append(partition.Rows, newRowTombstone(req.ClusteringKeyValues, table.ClusteringKey(req.ClusteringKeyValues), timestamp))
+	case len(req.ClusteringKeyValues) > 0:
+		// A clustering key prefix deletes every row that starts with it
+		tombstone := &RangeTombstone{
+			ClusteringBounds: ClusteringBounds{
+				Start:          req.ClusteringKeyValues,
+				StartInclusive: true,
+				End:            req.ClusteringKeyValues,
+				EndInclusive:   true,
+			},
+			DeletedAt: timestamp,
+		}
+		table.setKeys(&tombstone.ClusteringBounds)
+		partition.Tombstones = []*RangeTombstone{tombstone}
+	default:
+		partition.Tombstones = []*RangeTombstone{{DeletedAt: timestamp}}
+	}
+	return partition, nil
+}
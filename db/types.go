@@ -1,6 +1,7 @@
 package db
 
 import (
+	"sanddb/messages"
 	"sanddb/utils"
 	"strconv"
 	"time"
@@ -8,23 +9,78 @@ import (
 
 // Handler is for each individual node to handle local read/write to file
 type Handler struct {
-	Node *utils.Node
+	Node    *utils.Node
+	Storage *StorageEngine
 }
 
 // EpochTime defines a timestamp encoded as epoch nanoseconds in JSON
 type EpochTime time.Time
 
 type LocalData []*Table
+
+/* Keyspace
+Replication: how the replicas of the tables of the keyspace are placed, nil for the default keyspace until it is altered, which then uses the replication_factor of config.yml
+CreatedAt/UpdatedAt: times of the creation and of the last change of the keyspace, the latest change wins when nodes disagree on the schema
+*/
+type Keyspace struct {
+	Name        string                       `json:"name"`
+	Replication *messages.ReplicationOptions `json:"replication,omitempty"`
+	CreatedAt   EpochTime                    `json:"created_at"`
+	UpdatedAt   EpochTime                    `json:"updated_at"`
+}
+
+/* Table
+TableName: keyspace.table, or the name alone for a table of the default keyspace
+ClusteringOrder: ASC or DESC for every clustering key, empty if every clustering key is ascending
+DroppedColumns: time at which each dropped column was dropped, its cells written before then are not read anymore
+Indexes: secondary indexes on regular columns of the table
+View: set if the table is a materialized view, whose rows are only written by the replicas of its base table
+Replication: replication options of the keyspace of the table, filled in by the storage engine when it hands out the definition of the table
+CreatedAt/UpdatedAt: times of the creation and of the last change of the table, the latest change wins when nodes disagree on the schema
+*/
 type Table struct {
-	TableName          string       `json:"table_name"`
-	PartitionKeyNames  []string     `json:"partition_key_names"`
-	ClusteringKeyNames []string     `json:"clustering_key_names"`
-	Partitions         []*Partition `json:"partitions"`
+	TableName           string                       `json:"table_name"`
+	PartitionKeyNames   []string                     `json:"partition_key_names"`
+	ClusteringKeyNames  []string                     `json:"clustering_key_names"`
+	Columns             []messages.ColumnDefinition  `json:"columns,omitempty"`
+	ClusteringOrder     []string                     `json:"clustering_order,omitempty"`
+	DroppedColumns      map[string]EpochTime         `json:"dropped_columns,omitempty"`
+	Indexes             []*IndexDefinition           `json:"indexes,omitempty"`
+	Compaction          *messages.CompactionOptions  `json:"compaction,omitempty"`
+	BloomFilterFPChance float64                      `json:"bloom_filter_fp_chance,omitempty"`
+	View                *messages.ViewOptions        `json:"view,omitempty"`
+	Replication         *messages.ReplicationOptions `json:"-"`
+	CreatedAt           EpochTime                    `json:"created_at"`
+	UpdatedAt           EpochTime                    `json:"updated_at"`
+	Partitions          []*Partition                 `json:"partitions"`
 }
 
 type Partition struct {
-	Metadata *PartitionMetadata `json:"partition_metadata"`
-	Rows     []*Row             `json:"rows"`
+	Metadata   *PartitionMetadata `json:"partition_metadata"`
+	Rows       []*Row             `json:"rows"`
+	Tombstones []*RangeTombstone  `json:"tombstones,omitempty"`
+}
+
+/* ClusteringBounds
+Start/End: clustering key prefixes bounding a range of rows, an empty bound leaves that side of the range open
+StartKey/EndKey: clustering keys of Start and End, see Table.ClusteringKey, empty for tombstones written before rows were sorted by them
+*/
+type ClusteringBounds struct {
+	Start          []string `json:"start,omitempty"`
+	StartKey       string   `json:"start_key,omitempty"`
+	StartInclusive bool     `json:"start_inclusive"`
+	End            []string `json:"end,omitempty"`
+	EndKey         string   `json:"end_key,omitempty"`
+	EndInclusive   bool     `json:"end_inclusive"`
+}
+
+/* RangeTombstone
+DeletedAt: time of the deletion, rows written at or before it are deleted
+A tombstone with both bounds empty deletes the whole partition.
+*/
+type RangeTombstone struct {
+	ClusteringBounds
+	DeletedAt EpochTime `json:"deleted_at"`
 }
 
 /* PartitionMetadata
@@ -36,18 +92,30 @@ type PartitionMetadata struct {
 	PartitionKeyValues []string `json:"partition_key_values"`
 }
 
+/* Row
+ClusteringKey: clustering key values encoded so that rows sort in the clustering order of their table, see Table.ClusteringKey
+*/
 type Row struct {
 	CreatedAt           EpochTime `json:"created_at"`
 	UpdatedAt           EpochTime `json:"updated_at"`
 	DeletedAt           EpochTime `json:"deleted_at"`
 	ClusteringKeyHash   int64     `json:"clustering_key_hash"`
+	ClusteringKey       string    `json:"clustering_key,omitempty"`
 	ClusteringKeyValues []string  `json:"clustering_key_values"`
 	Cells               []*Cell   `json:"cells"`
 }
 
+/* Cell
+Timestamp: write timestamp of this version of the cell
+TTL: number of seconds after Timestamp at which the cell expires, 0 if it never does
+Deleted: marks the cell as deleted at Timestamp
+*/
 type Cell struct {
-	Name  string `json:"name"`
-	Value string `json:"value"`
+	Name      string    `json:"name"`
+	Value     string    `json:"value"`
+	Timestamp EpochTime `json:"timestamp"`
+	TTL       int       `json:"ttl,omitempty"`
+	Deleted   bool      `json:"deleted,omitempty"`
 }
 
 // MarshalJSON is used to convert the timestamp to JSON
@@ -73,6 +141,22 @@ func (t EpochTime) Unix() int64 {
 	return time.Time(t).Unix()
 }
 
+// UnixMicro returns t as a Unix time, the number of microseconds elapsed
+// since January 1, 1970 UTC, which is the precision of write timestamps.
+func (t EpochTime) UnixMicro() int64 {
+	return time.Time(t).UnixNano() / int64(time.Microsecond)
+}
+
+// EpochTimeFromMicro returns the EpochTime of a write timestamp given in microseconds since January 1, 1970 UTC.
+func EpochTimeFromMicro(micros int64) EpochTime {
+	return EpochTime(time.Unix(0, micros*int64(time.Microsecond)))
+}
+
+// After reports whether t is later than u.
+func (t EpochTime) After(u EpochTime) bool {
+	return t.UnixNano() > u.UnixNano()
+}
+
 // UnixNano returns t as a Unix time, the number of nanoseconds elapsed
 // since January 1, 1970 UTC. The result does not depend on the
 // location associated with t.
@@ -90,7 +174,25 @@ func (t EpochTime) String() string {
 	return t.Time().String()
 }
 
+/* RepairRequest
+Partition: versions of rows and tombstones to be written with their timestamps preserved
+*/
+type RepairRequest struct {
+	TableName string     `json:"table_name"`
+	Partition *Partition `json:"partition"`
+}
+
+// ReadResponse carries the latest version of a row held by a replica, which may be a tombstone.
+// Row is nil if the replica does not hold the row at all.
+// For a slice, Partition carries the versions of the rows of the slice held by the replica along with the range tombstones of the partition.
 type ReadResponse struct {
 	SourceNode *utils.Node
-	Row        Row
+	Row        *Row       `json:",omitempty"`
+	Partition  *Partition `json:",omitempty"`
+}
+
+// ScanResponse carries the versions of the partitions of a token range held by a replica, in token order, with their tombstones.
+type ScanResponse struct {
+	SourceNode *utils.Node
+	Partitions []*Partition
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
 )
 
 func ReadJSON(filename string) (LocalData, error) {
@@ -42,49 +43,37 @@ func GetTable(tableName string, data LocalData) *Table {
 	}
 	return nil
 }
+
+// GetPartition binary searches the partitions of a table, which are sorted by partition key hash.
 func GetPartition(table *Table, hashedPK int64) *Partition {
-	for _, partition := range table.Partitions {
-		if partition.Metadata.PartitionKey == hashedPK {
-			return partition
-		}
+	i := sort.Search(len(table.Partitions), func(i int) bool {
+		return table.Partitions[i].Metadata.PartitionKey >= hashedPK
+	})
+	if i < len(table.Partitions) && table.Partitions[i].Metadata.PartitionKey == hashedPK {
+		return table.Partitions[i]
 	}
 	return nil
 }
-func PersistNewTable(data LocalData, filename string, table *Table) error {
-	data = append(data, table)
-	//MarshalIndent instead of Marshal for legibility during debug
-	jsonFile, err := json.MarshalIndent(data, "", "")
-	if err != nil {
-		fmt.Printf("Error in marshalling data: %s\n", err.Error())
-		return err
-	}
+
+// writeFileAtomic writes content to a temporary file and renames it over filename,
+// so that a crash in the middle of a write never leaves a half-written data file behind.
+func writeFileAtomic(filename string, content []byte) error {
+	tmpFilename := filename + ".tmp"
 	//set permission to readable by all, writeable by user
-	err = ioutil.WriteFile(filename, jsonFile, 0644)
+	file, err := os.OpenFile(tmpFilename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
-		fmt.Printf("Error in writing file: %s\n", err.Error())
 		return err
 	}
-	fmt.Println("Successfully persisted table")
-	return nil
-}
-
-func PersistTable(data LocalData, filename string, table *Table) error {
-	for _, tableData := range data {
-		if tableData.TableName == table.TableName {
-
-		}
+	if _, err = file.Write(content); err != nil {
+		file.Close()
+		return err
 	}
-	jsonFile, err := json.MarshalIndent(data, "", "\t")
-	if err != nil {
-		fmt.Printf("Error in marshalling data: %s\n", err.Error())
+	if err = file.Sync(); err != nil {
+		file.Close()
 		return err
 	}
-
-	err = ioutil.WriteFile(filename, jsonFile, 0644)
-	if err != nil {
-		fmt.Printf("Error in writing file: %s\n", err.Error())
+	if err = file.Close(); err != nil {
 		return err
 	}
-	fmt.Println("Successfully persisted table")
-	return nil
+	return os.Rename(tmpFilename, filename)
 }
@@ -0,0 +1,321 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sanddb/messages"
+	"sanddb/utils"
+	"strings"
+	"time"
+)
+
+var ErrInvalidView = errors.New("invalid materialized view")
+
+/* ViewUpdate
+ViewName: materialized view that the rows are written to
+BaseTable/BasePartitionKey: base table and partition key hash of the write that changed the rows, whose replicas are paired with the replicas of the view partition
+Partition: rows of a partition of the view, whose partition key hash is left for the ViewWriter to fill in
+*/
+type ViewUpdate struct {
+	ViewName         string
+	BaseTable        string
+	BasePartitionKey int64
+	Partition        *Partition
+}
+
+// ViewWriter delivers the rows of materialized views to the replicas of their partitions, which the storage engine knows nothing about.
+type ViewWriter interface {
+	WriteView(update *ViewUpdate)
+}
+
+// viewKey holds the values of the keys of the row of a view that holds a row of its base table.
+type viewKey struct {
+	partitionKeyValues  []string
+	clusteringKeyValues []string
+}
+
+func (k *viewKey) equal(other *viewKey) bool {
+	return equalKeys(k.partitionKeyValues, other.partitionKeyValues) && equalKeys(k.clusteringKeyValues, other.clusteringKeyValues)
+}
+
+// SetViewWriter sets where the rows of materialized views changed by writes to their base tables are sent.
+func (e *StorageEngine) SetViewWriter(writer ViewWriter) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.viewWriter = writer
+}
+
+// NewViewRequest returns the request that creates a materialized view of a table, as a table with the columns of its base table.
+func NewViewRequest(base *Table, req messages.CreateViewRequest) (messages.CreateRequest, error) {
+	create := messages.CreateRequest{
+		TableName:          req.ViewName,
+		PartitionKeyNames:  req.PartitionKeyNames,
+		ClusteringKeyNames: req.ClusteringKeyNames,
+		Columns:            base.Columns,
+		ClusteringOrder:    req.ClusteringOrder,
+		Compaction:         req.Compaction,
+		View:               &messages.ViewOptions{BaseTable: base.TableName},
+	}
+	for _, name := range append(append([]string{}, req.PartitionKeyNames...), req.ClusteringKeyNames...) {
+		if !base.isKey(name) && create.View.KeyColumn == "" {
+			create.View.KeyColumn = name
+		}
+	}
+	return create, validateView(base, create)
+}
+
+// validateView checks the keys of a materialized view against its base table: every key of the base table has to be a key of the view,
+// so that each row of the base table is held by a single row of the view, and at most one other column of the base table can be.
+func validateView(base *Table, req messages.CreateRequest) error {
+	if base.View != nil {
+		return fmt.Errorf("%w: %s is itself a materialized view", ErrInvalidView, base.TableName)
+	}
+	if viewKeyspace, _ := messages.SplitTableName(req.TableName); viewKeyspace != base.Keyspace() {
+		return fmt.Errorf("%w: a materialized view has to be in the keyspace %s of its base table", ErrInvalidView, base.Keyspace())
+	}
+	keys := make(map[string]bool)
+	for _, name := range append(append([]string{}, req.PartitionKeyNames...), req.ClusteringKeyNames...) {
+		keys[name] = true
+		if base.isKey(name) {
+			continue
+		} else if name != req.View.KeyColumn {
+			return fmt.Errorf("%w: %s is not a key of table %s, and a view can only add one column of its base table to its keys", ErrInvalidView, name, base.TableName)
+		} else if _, ok := base.ColumnType(name); !ok {
+			return fmt.Errorf("%w: table %s has no column %s", ErrInvalidView, base.TableName, name)
+		}
+	}
+	for _, name := range append(append([]string{}, base.PartitionKeyNames...), base.ClusteringKeyNames...) {
+		if !keys[name] {
+			return fmt.Errorf("%w: key %s of table %s has to be a key of the view", ErrInvalidView, name, base.TableName)
+		}
+	}
+	return nil
+}
+
+// viewsLocked returns the materialized views of a table. e.mu must be held.
+func (e *StorageEngine) viewsLocked(tableName string) []*Table {
+	views := make([]*Table, 0)
+	for _, table := range e.schema {
+		if table.View != nil && table.View.BaseTable == tableName {
+			views = append(views, table)
+		}
+	}
+	return views
+}
+
+// Views returns the definitions of the materialized views of a table.
+func (e *StorageEngine) Views(tableName string) []*Table {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	views := e.viewsLocked(tableName)
+	for i, view := range views {
+		views[i] = e.definition(view)
+	}
+	return views
+}
+
+// keyOf returns the key of the row of the view that holds a version of a row of the base table,
+// or nil if the view holds no such row: the row is deleted, or the key column of the view has no value in it.
+func (t *Table) keyOf(base *Table, metadata *PartitionMetadata, row *Row) *viewKey {
+	if row == nil || row.IsTombstone() {
+		return nil
+	}
+	key := &viewKey{
+		partitionKeyValues:  make([]string, 0, len(t.PartitionKeyNames)),
+		clusteringKeyValues: make([]string, 0, len(t.ClusteringKeyNames)),
+	}
+	for _, name := range t.PartitionKeyNames {
+		value, ok := base.keyValue(name, metadata, row)
+		if !ok {
+			return nil
+		}
+		key.partitionKeyValues = append(key.partitionKeyValues, value)
+	}
+	for _, name := range t.ClusteringKeyNames {
+		value, ok := base.keyValue(name, metadata, row)
+		if !ok {
+			return nil
+		}
+		key.clusteringKeyValues = append(key.clusteringKeyValues, value)
+	}
+	return key
+}
+
+// keyValue returns the value of a column of a row of the table that is a key of one of its views.
+func (t *Table) keyValue(name string, metadata *PartitionMetadata, row *Row) (string, bool) {
+	for i, key := range t.PartitionKeyNames {
+		if key == name && i < len(metadata.PartitionKeyValues) {
+			return metadata.PartitionKeyValues[i], true
+		}
+	}
+	for i, key := range t.ClusteringKeyNames {
+		if key == name && i < len(row.ClusteringKeyValues) {
+			return row.ClusteringKeyValues[i], true
+		}
+	}
+	for _, cell := range row.Cells {
+		if cell.Name == name && !cell.Deleted {
+			return cell.Value, true
+		}
+	}
+	return "", false
+}
+
+// viewRow returns the row of the view under key that holds a version of a row of the base table: a copy of the row, cells and timestamps included.
+// If moved is set, the row of the base table does not belong under key anymore, and the cell of the key column of the view is deleted
+// at the timestamp of its new value, which deletes the row of the view without getting in the way of the row moving back under key later on.
+func (t *Table) viewRow(base *Table, key *viewKey, row *Row, moved bool) *Row {
+	copied, _ := base.withoutDroppedCells(row)
+	viewRow := &Row{
+		CreatedAt:           copied.CreatedAt,
+		UpdatedAt:           copied.UpdatedAt,
+		DeletedAt:           copied.DeletedAt,
+		ClusteringKeyHash:   utils.GetHashFromKeys(key.clusteringKeyValues),
+		ClusteringKey:       t.ClusteringKey(key.clusteringKeyValues),
+		ClusteringKeyValues: key.clusteringKeyValues,
+		Cells:               make([]*Cell, 0, len(copied.Cells)),
+	}
+	for _, cell := range copied.Cells {
+		if moved && cell.Name == t.View.KeyColumn {
+			cell = &Cell{
+				Name:      cell.Name,
+				Timestamp: cell.Timestamp,
+				Deleted:   true,
+			}
+		}
+		viewRow.Cells = append(viewRow.Cells, cell)
+	}
+	return viewRow
+}
+
+// hasKey reports whether a row of the view still holds the value of the key column of the view, without which the row is deleted.
+func (t *Table) hasKey(row *Row, now time.Time) bool {
+	if t.View == nil || t.View.KeyColumn == "" {
+		return true
+	}
+	for _, cell := range row.Cells {
+		if cell.Name == t.View.KeyColumn {
+			return cell.IsLive(now)
+		}
+	}
+	return false
+}
+
+// viewUpdates returns the rows of the materialized views of a table changed by a mutation of one of its partitions, given the partition before and after the mutation.
+// Every row of the base table changed by the mutation is copied to the views under its keys after the mutation,
+// and deleted from under its keys before the mutation if they are not the same, e.g. because the value of the key column of a view changed.
+// A nil mutation changes every row of the partition, for building a view.
+func viewUpdates(base *Table, views []*Table, before *Partition, after *Partition, fragment *Partition) []*ViewUpdate {
+	if after == nil {
+		return nil
+	}
+	var changed map[int64]bool
+	if fragment != nil && len(fragment.Tombstones) == 0 {
+		changed = make(map[int64]bool, len(fragment.Rows))
+		for _, row := range fragment.Rows {
+			changed[row.ClusteringKeyHash] = true
+		}
+	}
+	previous := make(map[int64]*Row)
+	if before != nil {
+		for _, row := range before.Rows {
+			previous[row.ClusteringKeyHash] = before.resolveRow(base.withClusteringKey(row))
+		}
+	}
+	updates := make([]*ViewUpdate, 0)
+	partitions := make(map[string]*Partition)
+	add := func(view *Table, key *viewKey, row *Row) {
+		id := view.TableName + "\x00" + strings.Join(key.partitionKeyValues, "\x00")
+		partition, ok := partitions[id]
+		if !ok {
+			partition = &Partition{
+				Metadata: &PartitionMetadata{PartitionKeyValues: key.partitionKeyValues},
+				Rows:     make([]*Row, 0),
+			}
+			partitions[id] = partition
+			updates = append(updates, &ViewUpdate{
+				ViewName:         view.TableName,
+				BaseTable:        base.TableName,
+				BasePartitionKey: after.Metadata.PartitionKey,
+				Partition:        partition,
+			})
+		}
+		partition.Rows = append(partition.Rows, row)
+	}
+	for _, row := range after.Rows {
+		if changed != nil && !changed[row.ClusteringKeyHash] {
+			continue
+		}
+		newRow := after.resolveRow(base.withClusteringKey(row))
+		oldRow := previous[row.ClusteringKeyHash]
+		if oldRow != nil && SameRow(oldRow, newRow) {
+			continue
+		}
+		for _, view := range views {
+			oldKey := view.keyOf(base, after.Metadata, oldRow)
+			newKey := view.keyOf(base, after.Metadata, newRow)
+			if newKey != nil {
+				add(view, newKey, view.viewRow(base, newKey, newRow, false))
+			}
+			if oldKey != nil && (newKey == nil || !oldKey.equal(newKey)) {
+				add(view, oldKey, view.viewRow(base, oldKey, newRow, true))
+			}
+		}
+	}
+	return updates
+}
+
+// writeViews hands the rows of materialized views changed by a write to the view writer.
+func (e *StorageEngine) writeViews(updates []*ViewUpdate) {
+	if len(updates) == 0 {
+		return
+	}
+	e.mu.RLock()
+	writer := e.viewWriter
+	e.mu.RUnlock()
+	if writer == nil {
+		fmt.Printf("Dropping the rows of %d materialized view partitions: there is no view writer.\n", len(updates))
+		return
+	}
+	for _, update := range updates {
+		writer.WriteView(update)
+	}
+}
+
+// buildView writes the rows of a materialized view for the rows of its base table held by this node, one partition at a time,
+// while writes to the base table keep the rows they change up to date. Rows are copied with their timestamps, so copying them twice does no harm.
+func (e *StorageEngine) buildView(viewName string) {
+	e.mu.RLock()
+	view := GetTable(viewName, e.schema)
+	if view == nil || view.View == nil || GetTable(view.View.BaseTable, e.schema) == nil {
+		e.mu.RUnlock()
+		return
+	}
+	baseName := view.View.BaseTable
+	partitions, err := e.mergedPartitionsLocked(baseName, func(int64) bool { return true })
+	e.mu.RUnlock()
+	if err != nil {
+		fmt.Printf("Error in building materialized view %s: %s\n", viewName, err.Error())
+		return
+	}
+	fmt.Printf("Building materialized view %s from %d partitions of table %s.\n", viewName, len(partitions), baseName)
+	for _, listed := range partitions {
+		e.mu.RLock()
+		view, base := GetTable(viewName, e.schema), GetTable(baseName, e.schema)
+		if view == nil || base == nil {
+			e.mu.RUnlock()
+			return
+		}
+		// The partition is read again, as it may have been written to since it was listed
+		partition, err := e.readPartitionLocked(baseName, listed.Metadata.PartitionKey)
+		if err != nil {
+			e.mu.RUnlock()
+			fmt.Printf("Error in building materialized view %s: %s\n", viewName, err.Error())
+			return
+		}
+		updates := viewUpdates(base, []*Table{view}, nil, partition, nil)
+		e.mu.RUnlock()
+		e.writeViews(updates)
+	}
+	fmt.Printf("Built materialized view %s.\n", viewName)
+}
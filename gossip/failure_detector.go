@@ -0,0 +1,99 @@
+package gossip
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// ARRIVAL_WINDOW_SIZE is the number of heartbeat intervals the mean is computed over
+	ARRIVAL_WINDOW_SIZE = 1000
+	// PHI_FACTOR turns the time since the last heartbeat over the mean interval into phi, for exponentially distributed intervals
+	PHI_FACTOR = 1.0 / math.Ln10
+)
+
+// arrivalWindow holds the most recent intervals between the heartbeats of a node, in milliseconds.
+type arrivalWindow struct {
+	last      time.Time
+	intervals []float64
+	sum       float64
+}
+
+func (w *arrivalWindow) add(interval float64) {
+	if len(w.intervals) == ARRIVAL_WINDOW_SIZE {
+		w.sum -= w.intervals[0]
+		w.intervals = w.intervals[1:]
+	}
+	w.intervals = append(w.intervals, interval)
+	w.sum += interval
+}
+
+func (w *arrivalWindow) mean() float64 {
+	return w.sum / float64(len(w.intervals))
+}
+
+// FailureDetector is a phi accrual failure detector, as described in "The φ Accrual Failure Detector" (Hayashibara et al.) and used by Cassandra.
+// Instead of a fixed timeout, it measures how unlikely it is that a heartbeat would still be on its way given the intervals observed so far.
+type FailureDetector struct {
+	mu sync.Mutex
+	// Threshold is the phi above which a node is convicted
+	Threshold float64
+	// initialInterval seeds the window of a node that has not sent a second heartbeat yet
+	initialInterval time.Duration
+	// maxInterval is the longest interval that is recorded, longer ones come from the node having been down and would skew the mean
+	maxInterval time.Duration
+	windows     map[int]*arrivalWindow
+}
+
+func NewFailureDetector(threshold float64, gossipInterval time.Duration) *FailureDetector {
+	maxInterval := 2 * gossipInterval
+	if maxInterval < 2*time.Second {
+		maxInterval = 2 * time.Second
+	}
+	return &FailureDetector{
+		Threshold:       threshold,
+		initialInterval: gossipInterval,
+		maxInterval:     maxInterval,
+		windows:         make(map[int]*arrivalWindow),
+	}
+}
+
+// Report records that a new heartbeat of the node arrived at now.
+func (d *FailureDetector) Report(nodeID int, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	window, ok := d.windows[nodeID]
+	if !ok {
+		window = &arrivalWindow{}
+		window.add(float64(d.initialInterval) / float64(time.Millisecond))
+		d.windows[nodeID] = window
+	} else if interval := now.Sub(window.last); interval <= d.maxInterval {
+		window.add(float64(interval) / float64(time.Millisecond))
+	}
+	window.last = now
+}
+
+// Phi returns the suspicion level of the node at now, 0 if it never sent a heartbeat.
+func (d *FailureDetector) Phi(nodeID int, now time.Time) float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	window, ok := d.windows[nodeID]
+	if !ok {
+		return 0
+	}
+	delta := float64(now.Sub(window.last)) / float64(time.Millisecond)
+	return PHI_FACTOR * delta / window.mean()
+}
+
+// IsAlive reports whether the phi of the node is still below the threshold.
+func (d *FailureDetector) IsAlive(nodeID int, now time.Time) bool {
+	return d.Phi(nodeID, now) < d.Threshold
+}
+
+// Remove forgets the intervals of the node, e.g. after it was restarted.
+func (d *FailureDetector) Remove(nodeID int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.windows, nodeID)
+}
@@ -0,0 +1,554 @@
+package gossip
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sanddb/utils"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	DEFAULT_GOSSIP_INTERVAL       = time.Second
+	DEFAULT_PHI_CONVICT_THRESHOLD = 8
+	// RING_DELAY_ROUNDS is the number of gossip rounds a joining node waits for the ring to settle
+	RING_DELAY_ROUNDS = 3
+)
+
+func NewGossiper(node *utils.Node, ring *utils.Ring, seeds []string, interval time.Duration, phiConvictThreshold float64) *Gossiper {
+	if interval <= 0 {
+		interval = DEFAULT_GOSSIP_INTERVAL
+	}
+	if phiConvictThreshold <= 0 {
+		phiConvictThreshold = DEFAULT_PHI_CONVICT_THRESHOLD
+	}
+	g := &Gossiper{
+		Node:     node,
+		Ring:     ring,
+		Seeds:    seeds,
+		Interval: interval,
+		Detector: NewFailureDetector(phiConvictThreshold, interval),
+		states:   make(map[int]*EndpointState),
+		random:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		client:   &http.Client{Timeout: interval},
+		stop:     make(chan struct{}),
+	}
+	g.states[node.Id] = &EndpointState{
+		NodeID:     node.Id,
+		Generation: time.Now().UnixNano() / int64(time.Millisecond),
+		State:      node.State,
+		IPAddress:  node.IPAddress,
+		Port:       node.Port,
+		Datacenter: node.Datacenter,
+		Rack:       node.Rack,
+	}
+	// A joining node only gossips its tokens once it can take writes, see AnnounceTokens
+	if node.State != utils.STATE_JOINING {
+		g.states[node.Id].Tokens = node.Tokens
+	}
+	// The nodes listed in the config are assumed to be up until the failure detector convicts them
+	now := time.Now()
+	for _, peer := range ring.Nodes {
+		if peer.Id != node.Id {
+			g.Detector.Report(peer.Id, now)
+		}
+	}
+	return g
+}
+
+// Subscribe registers l to be told when nodes are marked alive or dead.
+func (g *Gossiper) Subscribe(l Listener) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.listeners = append(g.listeners, l)
+}
+
+// SubscribeSchema registers l to be told about the nodes that do not agree with this one on the schema.
+func (g *Gossiper) SubscribeSchema(l SchemaListener) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.schemaListeners = append(g.schemaListeners, l)
+}
+
+// Start runs a gossip round every interval until Shutdown is called.
+func (g *Gossiper) Start() {
+	go func() {
+		ticker := time.NewTicker(g.Interval)
+		defer ticker.Stop()
+		for {
+			g.round()
+			select {
+			case <-ticker.C:
+			case <-g.stop:
+				return
+			}
+		}
+	}()
+}
+
+// round bumps the heartbeat of this node and exchanges states with a random alive node, like Cassandra.
+// A random dead node is also tried, more likely the more nodes are dead, and a seed if none was picked so far.
+func (g *Gossiper) round() {
+	schemaVersion := g.schemaVersion()
+	g.mu.Lock()
+	g.states[g.Node.Id].Version++
+	g.states[g.Node.Id].SchemaVersion = schemaVersion
+	g.mu.Unlock()
+
+	live := make([]*utils.Node, 0)
+	unreachable := make([]*utils.Node, 0)
+	for _, node := range g.Ring.AllNodes() {
+		if node.Id == g.Node.Id {
+			continue
+		}
+		if g.Ring.IsAlive(node) {
+			live = append(live, node)
+		} else {
+			unreachable = append(unreachable, node)
+		}
+	}
+	gossipedToSeed := false
+	if len(live) > 0 {
+		peer := address(live[g.random.Intn(len(live))])
+		go g.gossipTo(peer)
+		gossipedToSeed = g.isSeed(peer)
+	}
+	if len(unreachable) > 0 && g.random.Float64() < float64(len(unreachable))/float64(len(live)+1) {
+		go g.gossipTo(address(unreachable[g.random.Intn(len(unreachable))]))
+	}
+	seeds := make([]string, 0, len(g.Seeds))
+	for _, seed := range g.Seeds {
+		if seed != address(g.Node) {
+			seeds = append(seeds, seed)
+		}
+	}
+	if !gossipedToSeed && len(seeds) > 0 && (len(live) == 0 || g.random.Float64() <= float64(len(seeds))/float64(len(live)+len(unreachable))) {
+		go g.gossipTo(seeds[g.random.Intn(len(seeds))])
+	}
+	g.convict()
+}
+
+// convict marks dead every alive node whose phi went over the threshold.
+func (g *Gossiper) convict() {
+	now := time.Now()
+	for _, node := range g.Ring.AllNodes() {
+		if node.Id == g.Node.Id || !g.Ring.IsAlive(node) {
+			continue
+		}
+		if phi := g.Detector.Phi(node.Id, now); phi >= g.Detector.Threshold {
+			fmt.Printf("Convicting node %d with phi %.2f.\n", node.Id, phi)
+			g.markDead(node)
+		}
+	}
+}
+
+func (g *Gossiper) gossipTo(peer string) {
+	body, err := json.Marshal(g.message())
+	if err != nil {
+		fmt.Printf("Error in marshalling gossip message: %s\n", err.Error())
+		return
+	}
+	response, err := g.client.Post(peer+"/internal/gossip", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		fmt.Printf("Error in gossiping with %s: %s\n", peer, err.Error())
+		return
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(response.Body)
+		fmt.Printf("Error in gossiping with %s: %s: %s\n", peer, response.Status, string(message))
+		return
+	}
+	var reply GossipMessage
+	if err = json.NewDecoder(response.Body).Decode(&reply); err != nil {
+		fmt.Printf("Error in unmarshalling gossip reply from %s: %s\n", peer, err.Error())
+		return
+	}
+	if err = g.checkPartitioner(reply); err != nil {
+		fmt.Printf("Error in gossiping with %s: %s\n", peer, err.Error())
+		return
+	}
+	g.apply(reply.States)
+}
+
+// message returns every endpoint state known to this node.
+func (g *Gossiper) message() GossipMessage {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	states := make([]*EndpointState, 0, len(g.states))
+	for _, state := range g.states {
+		stateCopy := *state
+		states = append(states, &stateCopy)
+	}
+	return GossipMessage{SourceID: g.Node.Id, Partitioner: g.Ring.Partitioner.Name(), States: states}
+}
+
+// checkPartitioner returns an error if the sender of msg does not use the partitioner of this node.
+func (g *Gossiper) checkPartitioner(msg GossipMessage) error {
+	if partitioner := g.Ring.Partitioner.Name(); msg.Partitioner != partitioner {
+		return fmt.Errorf("node %d uses the %s but node %d uses the %s, every node must use the same partitioner", msg.SourceID, msg.Partitioner, g.Node.Id, partitioner)
+	}
+	return nil
+}
+
+// newerStates returns the states known to this node that are newer than, or missing from, the ones of a peer.
+func (g *Gossiper) newerStates(theirs []*EndpointState) []*EndpointState {
+	known := make(map[int]*EndpointState, len(theirs))
+	for _, state := range theirs {
+		known[state.NodeID] = state
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	states := make([]*EndpointState, 0)
+	for id, state := range g.states {
+		if theirState, ok := known[id]; !ok || state.newerThan(theirState) {
+			stateCopy := *state
+			states = append(states, &stateCopy)
+		}
+	}
+	return states
+}
+
+// apply merges the states received from a peer. A newer heartbeat of a node is reported to the failure detector
+// and brings the node back to life, while a node that announced its shutdown is marked dead right away.
+func (g *Gossiper) apply(states []*EndpointState) {
+	// Gossip rounds overlap, so states are applied one exchange at a time for a node not to be marked alive by an older heartbeat after its shutdown
+	g.applyMu.Lock()
+	defer g.applyMu.Unlock()
+	now := time.Now()
+	for _, remote := range states {
+		if remote.NodeID == g.Node.Id {
+			continue
+		}
+		g.mu.Lock()
+		local, ok := g.states[remote.NodeID]
+		if ok && !remote.newerThan(local) {
+			g.mu.Unlock()
+			continue
+		}
+		state := *remote
+		g.states[remote.NodeID] = &state
+		g.mu.Unlock()
+
+		if ok && remote.Generation != local.Generation {
+			fmt.Printf("Node %d has restarted, new generation %d.\n", remote.NodeID, remote.Generation)
+			g.Detector.Remove(remote.NodeID)
+		}
+		node := g.Ring.NodeByID(remote.NodeID)
+		if node == nil {
+			if remote.State == utils.STATE_LEFT {
+				continue
+			}
+			if node = g.addNode(remote); node == nil {
+				continue
+			}
+		}
+		switch remote.State {
+		case utils.STATE_SHUTDOWN:
+			g.markDead(node)
+			continue
+		case utils.STATE_LEFT:
+			g.removeNode(node)
+			continue
+		case utils.STATE_REMOVING:
+			// The state was bumped by the node running removenode, not by a heartbeat of the removed node
+			g.markDead(node)
+			g.Ring.SetState(node, remote.State)
+			continue
+		}
+		g.Detector.Report(remote.NodeID, now)
+		g.markAlive(node)
+		if g.Ring.SetState(node, remote.State) {
+			fmt.Printf("Node %d is now %s.\n", node.Id, remote.State)
+		}
+		if remote.SchemaVersion != "" && remote.SchemaVersion != g.schemaVersion() {
+			for _, l := range g.schemaSubscribers() {
+				l.OnSchemaMismatch(node, remote.SchemaVersion)
+			}
+		}
+	}
+}
+
+func (g *Gossiper) schemaVersion() string {
+	if g.SchemaVersion == nil {
+		return ""
+	}
+	return g.SchemaVersion()
+}
+
+// SchemaVersions returns the schema version last gossiped by every node, along with the current one of this node.
+func (g *Gossiper) SchemaVersions() map[int]string {
+	versions := make(map[int]string)
+	g.mu.Lock()
+	for id, state := range g.states {
+		versions[id] = state.SchemaVersion
+	}
+	g.mu.Unlock()
+	versions[g.Node.Id] = g.schemaVersion()
+	return versions
+}
+
+// removeNode takes a node that left the ring out of it. Its state is still gossiped, for the nodes that have not heard of it yet.
+func (g *Gossiper) removeNode(node *utils.Node) {
+	g.Ring.RemoveNode(node)
+	g.Detector.Remove(node.Id)
+	fmt.Printf("Node %d has left the ring.\n", node.Id)
+}
+
+// addNode adds a node that is not in config.yml to the ring, the first time it is heard of.
+func (g *Gossiper) addNode(state *EndpointState) *utils.Node {
+	if len(state.Tokens) == 0 {
+		// The node is joining and has not announced its tokens yet
+		return nil
+	}
+	if state.IPAddress == "" {
+		fmt.Printf("Ignoring gossip about unknown node %d without an address.\n", state.NodeID)
+		return nil
+	}
+	node := &utils.Node{
+		Id:         state.NodeID,
+		IPAddress:  state.IPAddress,
+		Port:       state.Port,
+		Tokens:     state.Tokens,
+		Status:     utils.DEAD,
+		State:      state.State,
+		Datacenter: state.Datacenter,
+		Rack:       state.Rack,
+	}
+	if state.State == utils.STATE_SHUTDOWN {
+		node.State = utils.STATE_NORMAL
+	}
+	g.Ring.AddNode(node)
+	fmt.Printf("Node %d at %s%s in %s/%s joined the ring with %d tokens.\n", node.Id, node.IPAddress, node.Port, node.Datacenter, node.Rack, len(node.Tokens))
+	return node
+}
+
+// SetState changes the state of this node and gossips it from the next round on.
+func (g *Gossiper) SetState(state utils.NodeState) {
+	g.Ring.SetState(g.Node, state)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.states[g.Node.Id].State = state
+	g.states[g.Node.Id].Version++
+}
+
+// SetNodeState changes the state of a dead node on its behalf, as removenode does, and gossips it from the next round on.
+func (g *Gossiper) SetNodeState(node *utils.Node, state utils.NodeState) {
+	g.mu.Lock()
+	endpoint, ok := g.states[node.Id]
+	if !ok {
+		endpoint = &EndpointState{
+			NodeID:     node.Id,
+			IPAddress:  node.IPAddress,
+			Port:       node.Port,
+			Tokens:     node.Tokens,
+			Datacenter: node.Datacenter,
+			Rack:       node.Rack,
+		}
+		g.states[node.Id] = endpoint
+	}
+	endpoint.State = state
+	endpoint.Version++
+	g.mu.Unlock()
+	if state == utils.STATE_LEFT {
+		g.removeNode(node)
+	} else {
+		g.Ring.SetState(node, state)
+	}
+}
+
+// Stop stops gossiping, without announcing anything, e.g. once a decommissioned node has announced that it left.
+func (g *Gossiper) Stop() {
+	g.stopOnce.Do(func() {
+		close(g.stop)
+	})
+}
+
+// AnnounceTokens gossips the tokens of a joining node, and waits a few rounds for the whole ring to learn of them,
+// so that the node receives every write to the ranges it takes over before it starts streaming them.
+func (g *Gossiper) AnnounceTokens() {
+	g.mu.Lock()
+	g.states[g.Node.Id].Tokens = g.Node.Tokens
+	g.states[g.Node.Id].Version++
+	g.mu.Unlock()
+	time.Sleep(RING_DELAY_ROUNDS * g.Interval)
+}
+
+// WaitForRing blocks until this node has heard of another node that owns its tokens, and then for a few more rounds,
+// so that the whole ring is known and every node knows of this one.
+func (g *Gossiper) WaitForRing() {
+	for !g.heardOfNormalNode() {
+		time.Sleep(g.Interval)
+	}
+	time.Sleep(RING_DELAY_ROUNDS * g.Interval)
+}
+
+func (g *Gossiper) heardOfNormalNode() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for id, state := range g.states {
+		if id != g.Node.Id && state.State == utils.STATE_NORMAL {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Gossiper) markAlive(node *utils.Node) {
+	if !g.Ring.MarkAlive(node) {
+		return
+	}
+	fmt.Printf("Node %d is now UP.\n", node.Id)
+	for _, l := range g.subscribers() {
+		l.OnAlive(node)
+	}
+}
+
+func (g *Gossiper) markDead(node *utils.Node) {
+	if !g.Ring.MarkDead(node) {
+		return
+	}
+	fmt.Printf("Node %d is now DOWN.\n", node.Id)
+	for _, l := range g.subscribers() {
+		l.OnDead(node)
+	}
+}
+
+func (g *Gossiper) subscribers() []Listener {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]Listener{}, g.listeners...)
+}
+
+func (g *Gossiper) schemaSubscribers() []SchemaListener {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]SchemaListener{}, g.schemaListeners...)
+}
+
+// Shutdown stops gossiping and announces to every alive node that this node is going down.
+func (g *Gossiper) Shutdown() {
+	g.mu.Lock()
+	// A node that left the ring is not in the others' anymore
+	if state := g.states[g.Node.Id].State; state == utils.STATE_SHUTDOWN || state == utils.STATE_LEFT {
+		g.mu.Unlock()
+		return
+	}
+	g.Stop()
+	g.states[g.Node.Id].State = utils.STATE_SHUTDOWN
+	g.states[g.Node.Id].Version++
+	g.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, node := range g.Ring.AliveNodes() {
+		if node.Id == g.Node.Id {
+			continue
+		}
+		fmt.Printf("Announcing shutdown to node %d.\n", node.Id)
+		wg.Add(1)
+		go func(node *utils.Node) {
+			defer wg.Done()
+			g.gossipTo(address(node))
+		}(node)
+	}
+	wg.Wait()
+}
+
+func (g *Gossiper) isSeed(peer string) bool {
+	for _, seed := range g.Seeds {
+		if seed == peer {
+			return true
+		}
+	}
+	return false
+}
+
+func address(node *utils.Node) string {
+	return node.IPAddress + node.Port
+}
+
+func (g *Gossiper) HandleGossip(c *fiber.Ctx) error {
+	var msg GossipMessage
+	if err := c.BodyParser(&msg); err != nil {
+		return err
+	}
+	if err := g.checkPartitioner(msg); err != nil {
+		fmt.Printf("Rejected gossip: %s\n", err.Error())
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	reply := GossipMessage{
+		SourceID:    g.Node.Id,
+		Partitioner: g.Ring.Partitioner.Name(),
+		States:      g.newerStates(msg.States),
+	}
+	g.apply(msg.States)
+	body, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).Send(body)
+}
+
+// HandleStatus reports the state of every node of the ring and how much of the token space it owns, similar to "nodetool status".
+func (g *Gossiper) HandleStatus(c *fiber.Ctx) error {
+	primary, effective := g.Ring.Ownership(g.Ring.Strategy)
+	nodes := g.Ring.AllNodes()
+	statuses := make([]*EndpointStatus, 0, len(nodes))
+	for _, node := range nodes {
+		status := utils.DEAD
+		if g.Ring.IsAlive(node) {
+			status = utils.ALIVE
+		}
+		statuses = append(statuses, &EndpointStatus{
+			NodeID:        node.Id,
+			Address:       address(node),
+			Datacenter:    node.Datacenter,
+			Rack:          node.Rack,
+			Status:        status.String(),
+			State:         g.Ring.State(node),
+			Tokens:        len(node.Tokens),
+			Owns:          primary[node.Id] * 100,
+			EffectiveOwns: effective[node.Id] * 100,
+		})
+	}
+	body, err := json.Marshal(statuses)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).Send(body)
+}
+
+// HandleGossipInfo reports what this node knows about every node of the ring, similar to "nodetool gossipinfo".
+func (g *Gossiper) HandleGossipInfo(c *fiber.Ctx) error {
+	now := time.Now()
+	nodes := g.Ring.AllNodes()
+	infos := make([]*EndpointInfo, 0, len(nodes))
+	for _, node := range nodes {
+		info := &EndpointInfo{
+			EndpointState: EndpointState{NodeID: node.Id},
+			Status:        utils.DEAD.String(),
+		}
+		g.mu.Lock()
+		if state, ok := g.states[node.Id]; ok {
+			info.EndpointState = *state
+		}
+		g.mu.Unlock()
+		if g.Ring.IsAlive(node) {
+			info.Status = utils.ALIVE.String()
+		}
+		if node.Id != g.Node.Id {
+			info.Phi = g.Detector.Phi(node.Id, now)
+		}
+		infos = append(infos, info)
+	}
+	body, err := json.Marshal(infos)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).Send(body)
+}
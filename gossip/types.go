@@ -0,0 +1,109 @@
+package gossip
+
+import (
+	"math/rand"
+	"net/http"
+	"sanddb/utils"
+	"sync"
+	"time"
+)
+
+/* EndpointState
+What a node knows about another node's heartbeat, as it is passed around by gossip.
+Generation: time at which the node was started, in milliseconds, so that the heartbeats of a restarted node supersede the ones from before
+Version: heartbeat counter, bumped by the node itself every gossip round
+IPAddress/Port/Tokens: where the node can be reached and the tokens it owns, so that nodes missing from config.yml can be added to the ring
+Datacenter/Rack: failure domains of the node, for the nodes that add it to the ring
+SchemaVersion: hash of the schema of the node, the nodes that have another one pull its schema to agree on it
+*/
+type EndpointState struct {
+	NodeID        int             `json:"node_id"`
+	Generation    int64           `json:"generation"`
+	Version       int64           `json:"version"`
+	State         utils.NodeState `json:"state"`
+	IPAddress     string          `json:"ip_address"`
+	Port          string          `json:"port"`
+	Tokens        []int64         `json:"tokens"`
+	Datacenter    string          `json:"datacenter"`
+	Rack          string          `json:"rack"`
+	SchemaVersion string          `json:"schema_version,omitempty"`
+}
+
+// newerThan reports whether s carries a more recent heartbeat than other.
+func (s *EndpointState) newerThan(other *EndpointState) bool {
+	if s.Generation != other.Generation {
+		return s.Generation > other.Generation
+	}
+	return s.Version > other.Version
+}
+
+/* GossipMessage
+Sent to a peer with every endpoint state the sender knows of. The peer merges them into its own, and answers with the states that are newer on its side.
+Partitioner: partitioner of the sender, a peer that uses another one rejects the message, as the two nodes would place partitions differently
+*/
+type GossipMessage struct {
+	SourceID    int              `json:"source_id"`
+	Partitioner string           `json:"partitioner"`
+	States      []*EndpointState `json:"states"`
+}
+
+/* EndpointInfo
+Status: whether the ring currently treats the node as alive or dead
+Phi: suspicion level of the failure detector, the node is convicted once it goes over phi_convict_threshold
+*/
+type EndpointInfo struct {
+	EndpointState
+	Status string  `json:"status"`
+	Phi    float64 `json:"phi"`
+}
+
+/* EndpointStatus
+Owns: percentage of the token space that the node is the primary replica of
+EffectiveOwns: percentage of the token space that the node holds a replica of, given the replication_factor of config.yml
+*/
+type EndpointStatus struct {
+	NodeID        int             `json:"node_id"`
+	Address       string          `json:"address"`
+	Datacenter    string          `json:"datacenter"`
+	Rack          string          `json:"rack"`
+	Status        string          `json:"status"`
+	State         utils.NodeState `json:"state"`
+	Tokens        int             `json:"tokens"`
+	Owns          float64         `json:"owns"`
+	EffectiveOwns float64         `json:"effective_owns"`
+}
+
+// Listener is told about the nodes that the gossiper marks alive or dead.
+type Listener interface {
+	OnAlive(node *utils.Node)
+	OnDead(node *utils.Node)
+}
+
+// SchemaListener is told about the alive nodes that gossip a schema version other than the one of this node.
+type SchemaListener interface {
+	OnSchemaMismatch(node *utils.Node, version string)
+}
+
+/* Gossiper
+Seeds: addresses of the nodes that are always gossiped with, so that every node eventually hears of every other
+Interval: time between two gossip rounds, a heartbeat is sent every round
+SchemaVersion: returns the schema version of this node, gossiped along with its heartbeat
+*/
+type Gossiper struct {
+	Node          *utils.Node
+	Ring          *utils.Ring
+	Seeds         []string
+	Interval      time.Duration
+	Detector      *FailureDetector
+	SchemaVersion func() string
+
+	mu              sync.Mutex
+	applyMu         sync.Mutex
+	states          map[int]*EndpointState
+	listeners       []Listener
+	schemaListeners []SchemaListener
+	random          *rand.Rand
+	client          *http.Client
+	stop            chan struct{}
+	stopOnce        sync.Once
+}
@@ -11,8 +11,12 @@ import (
 
 	"os/signal"
 	"sanddb/db"
+	"sanddb/gossip"
+	"sanddb/messages"
 	"sanddb/read_write"
+	"sanddb/streaming"
 	"sanddb/utils"
+	"strings"
 	"syscall"
 
 	"github.com/gofiber/fiber/v2"
@@ -27,71 +31,79 @@ func hello(c *fiber.Ctx) error {
 	}
 	return err
 }
-func gracefulShutdown(h *read_write.Handler) {
+func gracefulShutdown(h *read_write.Handler, storage *db.StorageEngine) {
 	s := make(chan os.Signal, 1)
 	signal.Notify(s, os.Interrupt)
 	signal.Notify(s, syscall.SIGTERM)
 	go func() {
 		<-s
 		fmt.Println("Shutting down gracefully.")
-		// // update node status to dead
-		// h.Node.Status = consistent_hashing.DEAD
-		// h.Ring.NodeMap[h.Node.Hash] = h.Node
-
-		// values := make([]*consistent_hashing.Node, 0, len(h.Ring.NodeMap))
-
-		// for _, v := range h.Ring.NodeMap {
-		// 	if v.Status == consistent_hashing.DEAD {
-		// 		continue
-		// 	}
-		// 	values = append(values, v)
-		// }
-		// h.Ring.Nodes = values
-		for _, node := range h.Ring.Nodes {
-			if node.Id == h.Node.Id {
-				fmt.Printf("Killed node %d.\n", node.Id)
-				continue
-			}
-			fmt.Printf("Sending kill request to node %d.\n", node.Id)
-
-			// inform nodes that this node is dead
-			h.SendKillRequest(node)
+		// inform nodes that this node is dead, instead of waiting for their failure detectors to notice
+		h.Gossiper.Shutdown()
+		fmt.Printf("Killed node %d.\n", h.Node.Id)
+		if err := storage.Close(); err != nil {
+			fmt.Printf("Error in flushing storage engine: %s\n", err)
 		}
 		os.Exit(0)
 	}()
 }
 
-func setupRing(config c.Configurations) *utils.Ring {
+func setupRing(config *c.Configurations, partitioner utils.Partitioner) *utils.Ring {
 	ring := &config.Ring
 	ring.NodeMap = make(map[int64]*utils.Node)
-	ring.ReplicationFactor = config.ReplicationFactor
+	ring.Strategy = &utils.SimpleStrategy{Factor: config.ReplicationFactor}
+	ring.Partitioner = partitioner
 
 	for _, node := range ring.Nodes {
-		node.Hash = utils.GetHash(strconv.Itoa(node.Id))
-		ring.NodeMap[node.Hash] = node
-		ring.NodeHashes = append(ring.NodeHashes, node.Hash)
+		setLocation(node, node.Datacenter, node.Rack)
+		node.Tokens = utils.NodeTokens(partitioner, node.Id, config.NumTokens)
+		// The nodes in config.yml are the ones the ring was created with, they own their tokens from the start
+		node.State = utils.STATE_NORMAL
+		for _, token := range node.Tokens {
+			ring.NodeMap[token] = node
+		}
+		ring.NodeHashes = append(ring.NodeHashes, node.Tokens...)
 	}
+	ring.NodeHashes = utils.Sort(ring.NodeHashes)
 	return ring
 }
 
-func informRevive(h *read_write.Handler) {
-	ring := h.Ring
-
-	for _, node := range ring.Nodes {
-		if node.Id == h.Node.Id {
-			fmt.Printf("Revive node %d.\n", node.Id)
-			continue
-		}
-		fmt.Printf("Sending revive request to node %d.\n", node.Id)
-
-		// Inform nodes that this node is revived
-		h.SendReviveRequest(node)
+// setLocation puts node in a datacenter and a rack, the default ones if they are empty.
+func setLocation(node *utils.Node, datacenter string, rack string) {
+	node.Datacenter = datacenter
+	if node.Datacenter == "" {
+		node.Datacenter = utils.DEFAULT_DATACENTER
 	}
+	node.Rack = rack
+	if node.Rack == "" {
+		node.Rack = utils.DEFAULT_RACK
+	}
+}
 
-	fmt.Println("All nodes informed.")
+// newJoiningNode creates a node that is not in config.yml, reachable at address, e.g. http://127.0.0.1:8004.
+// It joins the ring unless it already did before being restarted, in which case it keeps the tokens it was given.
+func newJoiningNode(nodeID int, address string, datacenter string, rack string, partitioner utils.Partitioner, numTokens int, tokensFile string) (*utils.Node, error) {
+	separator := strings.LastIndex(address, ":")
+	if separator <= 0 {
+		return nil, fmt.Errorf("invalid address %s, expected e.g. http://127.0.0.1:8004", address)
+	}
+	node := &utils.Node{
+		Id:        nodeID,
+		IPAddress: address[:separator],
+		Port:      address[separator:],
+		Tokens:    utils.NodeTokens(partitioner, nodeID, numTokens),
+		Status:    utils.ALIVE,
+		State:     utils.STATE_JOINING,
+	}
+	setLocation(node, datacenter, rack)
+	if tokens, err := streaming.LoadTokens(tokensFile); err == nil {
+		node.Tokens = tokens
+		node.State = utils.STATE_NORMAL
+	}
+	return node, nil
 }
 
-func performSanityCheck(config c.Configurations) {
+func performSanityCheck(config *c.Configurations) {
 	if config.ReplicationFactor > len(config.Ring.Nodes) {
 		fmt.Println("ERROR: Replication factor can not be more than the number of nodes in the ring.")
 		os.Exit(1)
@@ -100,11 +112,28 @@ func performSanityCheck(config c.Configurations) {
 	}
 }
 
+// performKeyspaceSanityCheck makes sure that the ring has enough nodes in every datacenter for the replication of every keyspace.
+// It only warns, as the nodes that joined the ring after it was created are not known until they are gossiped.
+func performKeyspaceSanityCheck(ring *utils.Ring, storage *db.StorageEngine) {
+	passed := true
+	for _, keyspace := range storage.Keyspaces() {
+		if err := keyspace.Strategy(ring.Strategy).CheckNodes(ring.AllNodes()); err != nil {
+			fmt.Printf("WARNING: Keyspace %s has %s in the ring.\n", keyspace.Name, err.Error())
+			passed = false
+		}
+	}
+	if passed {
+		fmt.Println("Keyspace Sanity Check: CLEAR.")
+	}
+}
+
 func main() {
 	var (
 		config c.Configurations
 	)
-	app := fiber.New()
+	app := fiber.New(fiber.Config{
+		ErrorHandler: read_write.ErrorHandler,
+	})
 
 	app.Use(cors.New())
 
@@ -123,28 +152,102 @@ func main() {
 		return
 	}
 
-	performSanityCheck(config)
+	performSanityCheck(&config)
+	if config.NumTokens < 1 {
+		config.NumTokens = 1
+	}
+
+	defaultConsistency := messages.DEFAULT_CONSISTENCY_LEVEL
+	if config.ConsistencyLevel != "" {
+		consistency, err := messages.ParseConsistencyLevel(config.ConsistencyLevel)
+		if err != nil {
+			log.Fatalf("Error in reading consistency_level: %s", err)
+		}
+		defaultConsistency = consistency
+	}
+	partitioner, err := utils.NewPartitioner(config.Partitioner)
+	if err != nil {
+		log.Fatalf("Error in reading partitioner: %s", err)
+	}
 
 	nodeID, err := strconv.Atoi(args[1])
-	//initialize a Node
-	node := &utils.Node{
-		Id:        nodeID,
-		IPAddress: config.Ring.Nodes[nodeID].IPAddress,
-		Port:      config.Ring.Nodes[nodeID].Port,
-		Hash:      utils.GetHash(strconv.Itoa(nodeID)),
+	if err != nil {
+		fmt.Println("Please enter a valid node ID.")
+		return
 	}
-	fmt.Printf("Node #%d: Hash: %d", node.Id, node.Hash)
 	// Initialize the Ring
-	ring := setupRing(config)
+	ring := setupRing(&config, partitioner)
+	tokensFile := fmt.Sprintf("data/tokens/%d.json", nodeID)
+	//initialize a Node
+	node := ring.NodeByID(nodeID)
+	if node == nil {
+		if len(args) < 3 {
+			fmt.Println("Node is not in config.yml, please enter its address after the node ID, e.g. http://127.0.0.1:8004, optionally followed by its datacenter and rack")
+			return
+		}
+		datacenter, rack := "", ""
+		if len(args) > 3 {
+			datacenter = args[3]
+		}
+		if len(args) > 4 {
+			rack = args[4]
+		}
+		node, err = newJoiningNode(nodeID, args[2], datacenter, rack, partitioner, config.NumTokens, tokensFile)
+		if err != nil {
+			log.Fatalf("Error in creating node: %s", err)
+		}
+		ring.AddNode(node)
+	}
+	fmt.Printf("Node #%d: Tokens: %d, Partitioner: %s, Datacenter: %s, Rack: %s\n", node.Id, len(node.Tokens), partitioner.Name(), node.Datacenter, node.Rack)
 
 	requestHandler := &read_write.Handler{
 		Node:    node,
 		Ring:    ring,
 		Timeout: time.Duration(config.Timeout) * time.Second,
+		// Consistency level of requests that do not ask for one
+		DefaultConsistency: defaultConsistency,
 	}
 	ring.CurrentNode = node
-	// Inform of Node's existence
-	informRevive(requestHandler)
+
+	// Replay the commit log into the storage engine before anything else can touch the data
+	commitLog, err := db.OpenCommitLog(
+		fmt.Sprintf("data/commitlog/%d.log", nodeID),
+		db.CommitLogSyncMode(config.CommitLogSync),
+		time.Duration(config.CommitLogSyncPeriod)*time.Millisecond,
+		time.Duration(config.CommitLogBatchWindow)*time.Millisecond,
+	)
+	if err != nil {
+		log.Fatalf("Error in opening commit log: %s", err)
+	}
+	storage, err := db.OpenStorageEngine(fmt.Sprintf("data/%d", nodeID), commitLog, db.StorageOptions{
+		MemtableMaxMutations: config.MemtableMaxMutations,
+		// Same interpretation of gc_grace_seconds as the anti-entropy repair
+		GCGrace: time.Duration(config.GCGraceSeconds*24) * time.Hour,
+	})
+	if err != nil {
+		log.Fatalf("Error in opening storage engine: %s", err)
+	}
+	hints, err := db.OpenHintStore(fmt.Sprintf("data/hints/%d", nodeID), time.Duration(config.MaxHintWindow)*time.Millisecond)
+	if err != nil {
+		log.Fatalf("Error in opening hint store: %s", err)
+	}
+	requestHandler.Hints = hints
+	requestHandler.Storage = storage
+	// The replicas of the views are only known to the ring, so the rows of the views changed by writes are delivered through the request handler
+	storage.SetViewWriter(requestHandler)
+	performKeyspaceSanityCheck(ring, storage)
+	dbHandler := &db.Handler{
+		Node:    node,
+		Storage: storage,
+	}
+	// Membership and failure detection
+	gossiper := gossip.NewGossiper(node, ring, config.Seeds,
+		time.Duration(config.GossipInterval)*time.Millisecond, config.PhiConvictThreshold)
+	gossiper.Subscribe(requestHandler)
+	gossiper.SchemaVersion = storage.SchemaVersion
+	requestHandler.Gossiper = gossiper
+	// Hints stored before a restart are delivered to the nodes that are already alive
+	go requestHandler.ReplayHints()
 	////Reading configuration files
 	//viper.SetConfigFile("./config/config.yml")
 	//if err := viper.ReadInConfig(); err != nil {
@@ -176,7 +279,18 @@ func main() {
 		RepairTimeout:          time.Duration(config.RepairTimeout) * time.Hour,
 		InternalRequestTimeout: time.Duration(config.InternalRequestTimeout) * time.Second,
 		GCGraceSeconds:         config.GCGraceSeconds,
+		Storage:                storage,
 	}
+	streamHandler := &streaming.StreamHandler{
+		Node:     node,
+		Ring:     ring,
+		Storage:  storage,
+		Gossiper: gossiper,
+		Timeout:  time.Duration(config.InternalRequestTimeout) * time.Second,
+		// Saved once the node has joined the ring, so that it keeps its token when restarted
+		TokensFile: tokensFile,
+	}
+	gossiper.SubscribeSchema(streamHandler)
 	ring.CurrentNode = node
 	app.Get("/", hello)
 	app.Post("/repair", antiEntropyHandler.HandleRepairRequest)
@@ -189,23 +303,65 @@ func main() {
 	// err = app.Listen(node.Port)
 	//app.Post("/request", requestHandler.HandleRequest)
 	app.Post("/create", requestHandler.HandleClientCreateRequest)
+	app.Post("/keyspace/create", requestHandler.HandleClientCreateKeyspaceRequest)
+	app.Post("/keyspace/alter", requestHandler.HandleClientAlterKeyspaceRequest)
+	app.Post("/keyspace/drop", requestHandler.HandleClientDropKeyspaceRequest)
+	app.Get("/keyspaces", requestHandler.HandleKeyspaces)
+	app.Post("/alter", requestHandler.HandleClientAlterTableRequest)
+	app.Post("/index/create", requestHandler.HandleClientCreateIndexRequest)
+	app.Post("/view/create", requestHandler.HandleClientCreateViewRequest)
+	app.Post("/drop", requestHandler.HandleClientDropTableRequest)
+	app.Post("/truncate", requestHandler.HandleClientTruncateRequest)
+	app.Get("/schema", requestHandler.HandleDescribeSchema)
 	app.Post("/insert", requestHandler.HandleClientWriteRequest)
 	app.Post("/read", requestHandler.HandleClientReadRequest)
+	app.Post("/scan", requestHandler.HandleClientScanRequest)
+	app.Post("/delete", requestHandler.HandleClientDeleteRequest)
 	//internalGroup := app.Group("/internal")
 	//internalGroup.Post("/read", requestHandler.HandleCoordinatorRead)
 	//internalGroup.Post("/write", requestHandler.HandleCoordinatorWrite)
-	app.Post("/kill", requestHandler.HandleKillNode)
 	app.Post("/killNode", requestHandler.HandleClientKillRequest)
-	app.Post("/revive", requestHandler.HandleReviveNode)
+	internalGroup.Post("/gossip", gossiper.HandleGossip)
+	app.Get("/gossipinfo", gossiper.HandleGossipInfo)
+	app.Get("/status", gossiper.HandleStatus)
+	app.Get("/hints", requestHandler.HandleHintStats)
+	internalGroup.Get("/schema", streamHandler.HandleSchemaRequest)
+	internalGroup.Post("/stream", streamHandler.HandleStreamRequest)
+	internalGroup.Post("/stream/receive", streamHandler.HandleStreamReceive)
+	internalGroup.Post("/restore", streamHandler.HandleRestore)
+	app.Post("/cleanup", streamHandler.HandleCleanup)
+	app.Post("/decommission", streamHandler.HandleDecommission)
+	app.Post("/removenode", streamHandler.HandleRemoveNode)
+	app.Get("/netstats", streamHandler.HandleNetStats)
 
-	dbHandler := &db.Handler{
-		Node: node,
-	}
 	dbGroup := app.Group("/db")
 	dbGroup.Post("/insert", dbHandler.HandleDBInsert)
 	dbGroup.Post("/new", dbHandler.HandleCreateTable)
+	dbGroup.Post("/keyspace/create", dbHandler.HandleCreateKeyspace)
+	dbGroup.Post("/keyspace/alter", dbHandler.HandleAlterKeyspace)
+	dbGroup.Post("/keyspace/drop", dbHandler.HandleDropKeyspace)
+	dbGroup.Post("/alter", dbHandler.HandleAlterTable)
+	dbGroup.Post("/index/create", dbHandler.HandleCreateIndex)
+	dbGroup.Post("/drop", dbHandler.HandleDropTable)
+	dbGroup.Post("/truncate", dbHandler.HandleTruncateTable)
 	dbGroup.Post("/read", dbHandler.HandleDBRead)
-	go gracefulShutdown(requestHandler)
+	dbGroup.Post("/scan", dbHandler.HandleDBScan)
+	dbGroup.Post("/delete", dbHandler.HandleDBDelete)
+	dbGroup.Post("/repair", dbHandler.HandleDBRepair)
+	dbGroup.Post("/flush", dbHandler.HandleFlush)
+	app.Post("/compact", dbHandler.HandleCompactRequest)
+	app.Get("/compactionstats", dbHandler.HandleCompactionStats)
+	app.Get("/tablestats", dbHandler.HandleTableStats)
+	app.Get("/index/status", dbHandler.HandleIndexStatus)
+	go gracefulShutdown(requestHandler, storage)
+	gossiper.Start()
+	if node.State == utils.STATE_JOINING {
+		go func() {
+			if err := streamHandler.Bootstrap(); err != nil {
+				log.Fatalf("Error in bootstrapping node %d: %s", node.Id, err)
+			}
+		}()
+	}
 	err = app.Listen(node.Port)
 	if err != nil {
 		log.Fatalf("Error in starting up server: %s", err)
@@ -0,0 +1,77 @@
+package messages
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConsistencyLevel is the number of replicas that have to answer a request before the coordinator replies to the client.
+type ConsistencyLevel string
+
+const (
+	CONSISTENCY_ANY    ConsistencyLevel = "ANY"
+	CONSISTENCY_ONE    ConsistencyLevel = "ONE"
+	CONSISTENCY_TWO    ConsistencyLevel = "TWO"
+	CONSISTENCY_QUORUM ConsistencyLevel = "QUORUM"
+	CONSISTENCY_ALL    ConsistencyLevel = "ALL"
+	// CONSISTENCY_LOCAL_QUORUM only counts the answers of the replicas in the datacenter of the coordinator
+	CONSISTENCY_LOCAL_QUORUM ConsistencyLevel = "LOCAL_QUORUM"
+	// CONSISTENCY_EACH_QUORUM needs a quorum of the replicas of every datacenter
+	CONSISTENCY_EACH_QUORUM ConsistencyLevel = "EACH_QUORUM"
+)
+
+const DEFAULT_CONSISTENCY_LEVEL = CONSISTENCY_QUORUM
+
+// ParseConsistencyLevel reads a consistency level regardless of its case.
+func ParseConsistencyLevel(level string) (ConsistencyLevel, error) {
+	switch cl := ConsistencyLevel(strings.ToUpper(level)); cl {
+	case CONSISTENCY_ANY, CONSISTENCY_ONE, CONSISTENCY_TWO, CONSISTENCY_QUORUM, CONSISTENCY_ALL, CONSISTENCY_LOCAL_QUORUM, CONSISTENCY_EACH_QUORUM:
+		return cl, nil
+	}
+	return "", fmt.Errorf("unknown consistency level %q, expected one of ANY, ONE, TWO, QUORUM, LOCAL_QUORUM, EACH_QUORUM or ALL", level)
+}
+
+// BlockFor returns the number of replicas that have to answer a request at this consistency level.
+func (cl ConsistencyLevel) BlockFor(replicationFactor int) int {
+	switch cl {
+	case CONSISTENCY_ANY, CONSISTENCY_ONE:
+		return 1
+	case CONSISTENCY_TWO:
+		return 2
+	case CONSISTENCY_ALL:
+		return replicationFactor
+	default:
+		return replicationFactor/2 + 1
+	}
+}
+
+// IsDatacenterAware reports whether the answers required by the consistency level are counted per datacenter.
+func (cl ConsistencyLevel) IsDatacenterAware() bool {
+	return cl == CONSISTENCY_LOCAL_QUORUM || cl == CONSISTENCY_EACH_QUORUM
+}
+
+// BlockForDatacenters returns the number of replicas of each datacenter that have to answer a request at a datacenter-aware consistency level.
+// datacenterFactors are the replication factors of the datacenters, nil if the replicas are placed regardless of datacenters,
+// in which case LOCAL_QUORUM needs a quorum of replicationFactor from the local datacenter, like in Cassandra.
+func (cl ConsistencyLevel) BlockForDatacenters(localDatacenter string, datacenterFactors map[string]int, replicationFactor int) (map[string]int, error) {
+	switch cl {
+	case CONSISTENCY_LOCAL_QUORUM:
+		factor := replicationFactor
+		if datacenterFactors != nil {
+			factor = datacenterFactors[localDatacenter]
+		}
+		return map[string]int{localDatacenter: factor/2 + 1}, nil
+	case CONSISTENCY_EACH_QUORUM:
+		if datacenterFactors == nil {
+			return nil, fmt.Errorf("consistency level %s is only supported by NetworkTopologyStrategy", cl)
+		}
+		required := make(map[string]int)
+		for dc, factor := range datacenterFactors {
+			if factor > 0 {
+				required[dc] = factor/2 + 1
+			}
+		}
+		return required, nil
+	}
+	return nil, fmt.Errorf("consistency level %s is not counted per datacenter", cl)
+}
@@ -0,0 +1,37 @@
+package messages
+
+import "strings"
+
+// DEFAULT_KEYSPACE holds the tables whose name is not prefixed with a keyspace, which all tables created before keyspaces existed are in
+const DEFAULT_KEYSPACE = "sanddb"
+
+/* KeyspaceRequest
+Name: name of the keyspace, its tables are addressed as <name>.<table>, e.g. shop.orders
+Replication: how the replicas of the partitions of every table of the keyspace are placed, left out to drop a keyspace
+Timestamp: time of the change in microseconds since epoch, assigned by the coordinator
+*/
+type KeyspaceRequest struct {
+	Name        string              `json:"name"`
+	Replication *ReplicationOptions `json:"replication,omitempty"`
+	Timestamp   int64               `json:"timestamp,omitempty"`
+}
+
+// SplitTableName returns the keyspace of a table addressed as keyspace.table, and the name of the table within it.
+// A table addressed by its name alone is in the default keyspace.
+func SplitTableName(tableName string) (string, string) {
+	separator := strings.Index(tableName, ".")
+	if separator < 0 {
+		return DEFAULT_KEYSPACE, tableName
+	}
+	return tableName[:separator], tableName[separator+1:]
+}
+
+// CanonicalTableName returns the name that a table is stored under, keyspace.table,
+// except for the tables of the default keyspace which keep their name alone, so that the data written before keyspaces existed is still found.
+func CanonicalTableName(tableName string) string {
+	keyspace, table := SplitTableName(tableName)
+	if keyspace == DEFAULT_KEYSPACE {
+		return table
+	}
+	return keyspace + "." + table
+}
@@ -15,6 +15,11 @@ const (
 	KILL_ACK
 	REVIVED
 	REVIVED_ACK
+	COORDINATOR_DELETE
+	DELETE_ACK
+	KEYSPACE_ACK
+	SCHEMA_ACK
+	TRUNCATE_ACK
 )
 
 //PeerMessage means message from other SandDB nodes
@@ -0,0 +1,43 @@
+package messages
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+var ErrInvalidPagingState = errors.New("invalid paging_state")
+
+/* PagingState
+Where a paged read stopped, handed to clients as an opaque string that they send back to read the next page from any coordinator.
+Token: token of the partition of the last row returned
+ClusteringKeyValues: clustering keys of the last row returned
+Remaining: number of rows left to return under the limit of the read, 0 if it has no limit
+*/
+type PagingState struct {
+	Token               int64    `json:"token"`
+	ClusteringKeyValues []string `json:"clustering_keys"`
+	Remaining           int      `json:"remaining,omitempty"`
+}
+
+// Encode returns the opaque form of the paging state.
+func (s *PagingState) Encode() string {
+	content, _ := json.Marshal(s)
+	return base64.RawURLEncoding.EncodeToString(content)
+}
+
+// DecodePagingState reads the paging state of a read, which is nil if the read starts from the beginning.
+func DecodePagingState(encoded string) (*PagingState, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	content, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidPagingState
+	}
+	state := &PagingState{}
+	if err = json.Unmarshal(content, state); err != nil || state.Remaining < 0 {
+		return nil, ErrInvalidPagingState
+	}
+	return state, nil
+}
@@ -9,34 +9,221 @@ const (
 	REQUEST_READ
 	REQUEST_CREATE
 	REQUEST_KILL
+	REQUEST_DELETE
 )
 
 func (r RequestType) String() string {
-	return [...]string{"Write", "Read", "Kill"}[r]
+	return [...]string{"Write", "Read", "Create", "Kill", "Delete"}[r]
 }
 
+/* CreateRequest
+TableName: keyspace.table, or the name alone for a table of the default keyspace
+Columns: name and type of every column, the key columns that are left out are text. Without columns, any cell can be written and every value is text
+ClusteringOrder: ASC or DESC for every clustering key, the order in which rows are sorted within a partition, ascending if left out
+View: set if the table is a materialized view of another table, see CreateViewRequest
+Timestamp: time of the creation in microseconds since epoch, assigned by the coordinator
+*/
 type CreateRequest struct {
-	TableName          string   `json:"table_name"`
-	PartitionKeyNames  []string `json:"partition_key_names"`
-	ClusteringKeyNames []string `json:"clustering_key_names"`
+	TableName           string             `json:"table_name"`
+	PartitionKeyNames   []string           `json:"partition_key_names"`
+	ClusteringKeyNames  []string           `json:"clustering_key_names"`
+	Columns             []ColumnDefinition `json:"columns,omitempty"`
+	ClusteringOrder     []string           `json:"clustering_order,omitempty"`
+	Compaction          *CompactionOptions `json:"compaction,omitempty"`
+	BloomFilterFPChance float64            `json:"bloom_filter_fp_chance,omitempty"`
+	View                *ViewOptions       `json:"view,omitempty"`
+	Timestamp           int64              `json:"timestamp,omitempty"`
 }
 
+/* CreateViewRequest
+ViewName: keyspace.view, in the keyspace of the base table
+BaseTable: table whose rows the view holds under other keys
+PartitionKeyNames/ClusteringKeyNames: keys of the view, which have to include every key of the base table, and at most one of its other columns
+ClusteringOrder: ASC or DESC for every clustering key of the view, ascending if left out
+*/
+type CreateViewRequest struct {
+	ViewName           string             `json:"view_name"`
+	BaseTable          string             `json:"base_table"`
+	PartitionKeyNames  []string           `json:"partition_key_names"`
+	ClusteringKeyNames []string           `json:"clustering_key_names"`
+	ClusteringOrder    []string           `json:"clustering_order,omitempty"`
+	Compaction         *CompactionOptions `json:"compaction,omitempty"`
+}
+
+/* ViewOptions
+BaseTable: table whose rows the view holds
+KeyColumn: column of the base table that is a key of the view without being a key of the base table, empty if there is none
+*/
+type ViewOptions struct {
+	BaseTable string `json:"base_table"`
+	KeyColumn string `json:"key_column,omitempty"`
+}
+
+/* AlterTableRequest
+AddColumns: columns added to a typed table
+DropColumns: columns removed from a typed table, the values they held are no longer read even if the column is added again
+Timestamp: time of the change in microseconds since epoch, assigned by the coordinator
+*/
+type AlterTableRequest struct {
+	TableName   string             `json:"table_name"`
+	AddColumns  []ColumnDefinition `json:"add_columns,omitempty"`
+	DropColumns []string           `json:"drop_columns,omitempty"`
+	Timestamp   int64              `json:"timestamp,omitempty"`
+}
+
+/* CreateIndexRequest
+IndexName: name of the index, unique within its table, table_column_idx if left out
+Column: regular column of the table whose values are indexed
+Timestamp: time of the change in microseconds since epoch, assigned by the coordinator
+*/
+type CreateIndexRequest struct {
+	TableName string `json:"table_name"`
+	IndexName string `json:"index_name,omitempty"`
+	Column    string `json:"column"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+}
+
+/* TableRequest
+Names the table to drop or truncate.
+Timestamp: time of the drop or truncation in microseconds since epoch, assigned by the coordinator
+*/
+type TableRequest struct {
+	TableName string `json:"table_name"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+}
+
+/* ColumnDefinition
+Type: text, int, bigint, boolean, double, timestamp, uuid or blob
+*/
+type ColumnDefinition struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+/* ReplicationOptions
+Class: SimpleStrategy (default) or NetworkTopologyStrategy
+ReplicationFactor: number of replicas of every partition (SimpleStrategy)
+Datacenters: number of replicas of every partition in each datacenter, e.g. {"dc1": 2, "dc2": 1} (NetworkTopologyStrategy)
+*/
+type ReplicationOptions struct {
+	Class             string         `json:"class"`
+	ReplicationFactor int            `json:"replication_factor,omitempty"`
+	Datacenters       map[string]int `json:"datacenters,omitempty"`
+}
+
+/* CompactionOptions
+Class: SizeTieredCompactionStrategy (default), LeveledCompactionStrategy or TimeWindowCompactionStrategy
+MinThreshold/MaxThreshold: minimum and maximum number of SSTables compacted together (size-tiered and time-window)
+SSTableSizeKB: target size of each SSTable (leveled)
+WindowUnit/WindowSize: size of each time window, e.g. 1 HOURS (time-window)
+*/
+type CompactionOptions struct {
+	Class         string `json:"class"`
+	MinThreshold  int    `json:"min_threshold,omitempty"`
+	MaxThreshold  int    `json:"max_threshold,omitempty"`
+	SSTableSizeKB int    `json:"sstable_size_in_kb,omitempty"`
+	WindowUnit    string `json:"compaction_window_unit,omitempty"`
+	WindowSize    int    `json:"compaction_window_size,omitempty"`
+}
+
+type CompactRequest struct {
+	TableName string `json:"table_name"`
+}
+
+/* WriteRequest
+Timestamp: write timestamp in microseconds since epoch, assigned by the coordinator unless the client provides one
+TTL: number of seconds after which the written cells expire, 0 if they never do
+Consistency: number of replicas that have to acknowledge the write, the node's default consistency level if left out
+*/
 type WriteRequest struct {
-	TableName           string      `json:"table_name"`
-	PartitionKeyValues  []string    `json:"partition_keys"`
-	HashedPK            int64       `json:"pk_hash"`
-	ClusteringKeyValues []string    `json:"clustering_keys"`
-	CellNames           []string    `json:"cell_names"`
-	CellValues          []string    `json:"cell_values"`
-	Type                MessageType `json:"type"`
+	TableName           string           `json:"table_name"`
+	PartitionKeyValues  Values           `json:"partition_keys"`
+	HashedPK            int64            `json:"pk_hash"`
+	ClusteringKeyValues Values           `json:"clustering_keys"`
+	CellNames           []string         `json:"cell_names"`
+	CellValues          Values           `json:"cell_values"`
+	Timestamp           int64            `json:"timestamp,omitempty"`
+	TTL                 int              `json:"ttl,omitempty"`
+	Consistency         ConsistencyLevel `json:"consistency,omitempty"`
+	Type                MessageType      `json:"type"`
 }
 
+/* ReadRequest
+ClusteringKeyValues: all clustering keys to read a single row, or a prefix of them, possibly empty, to read every row of the partition that starts with it
+Range: bounds on the clustering key that follows ClusteringKeyValues, to read a slice of the rows that start with them
+Limit: maximum number of rows returned by a slice, 0 for no limit
+Reverse: returns the rows of a slice in the reverse of the clustering order
+PageSize: maximum number of rows returned by a slice at once, 0 to return every row. Replicas are asked for at most as many rows
+PagingState: where the previous page of the read stopped, as returned along with it
+Consistency: number of replicas that have to answer the read, the node's default consistency level if left out
+*/
 type ReadRequest struct {
-	TableName           string      `json:"table_name"`
-	PartitionKeyValues  []string    `json:"partition_keys"`
-	HashedPK            int64       `json:"pk_hash"`
-	ClusteringKeyValues []string    `json:"clustering_keys"`
-	Type                MessageType `json:"type"`
+	TableName           string           `json:"table_name"`
+	PartitionKeyValues  Values           `json:"partition_keys"`
+	HashedPK            int64            `json:"pk_hash"`
+	ClusteringKeyValues Values           `json:"clustering_keys"`
+	Range               *SliceRange      `json:"range,omitempty"`
+	Limit               int              `json:"limit,omitempty"`
+	Reverse             bool             `json:"reverse,omitempty"`
+	PageSize            int              `json:"page_size,omitempty"`
+	PagingState         string           `json:"paging_state,omitempty"`
+	Consistency         ConsistencyLevel `json:"consistency,omitempty"`
+	Type                MessageType      `json:"type"`
+}
+
+/* SliceRange
+Bounds on the value of a clustering key, at most one of GreaterThan and GreaterThanOrEqual, and of LessThan and LessThanOrEqual
+*/
+type SliceRange struct {
+	GreaterThan        *Value `json:"gt,omitempty"`
+	GreaterThanOrEqual *Value `json:"gte,omitempty"`
+	LessThan           *Value `json:"lt,omitempty"`
+	LessThanOrEqual    *Value `json:"lte,omitempty"`
+}
+
+/* ScanRequest
+StartToken/EndToken: tokens of the partitions to scan, from StartToken, excluded, up to EndToken, i.e. token(pk) > StartToken AND token(pk) <= EndToken.
+Every token from the lowest one if StartToken is left out, and up to the highest one if EndToken is
+PageSize: maximum number of rows returned at once, 0 to return every row. Replicas are asked for at most as many rows
+PagingState: where the previous page of the scan stopped, as returned along with it
+Where: value of an indexed column that the rows must hold, i.e. WHERE column = value, to scan every row if left out
+Consistency: number of replicas of each token range that have to answer the scan, the node's default consistency level if left out
+*/
+type ScanRequest struct {
+	TableName   string           `json:"table_name"`
+	StartToken  *int64           `json:"start_token,omitempty"`
+	EndToken    *int64           `json:"end_token,omitempty"`
+	PageSize    int              `json:"page_size,omitempty"`
+	PagingState string           `json:"paging_state,omitempty"`
+	Where       map[string]Value `json:"where,omitempty"`
+	Consistency ConsistencyLevel `json:"consistency,omitempty"`
+}
+
+/* DeleteRequest
+ClusteringKeyValues: all clustering keys to delete a single row, or a prefix of them to delete every row that starts with it
+ClusteringRange: bounds of the rows to delete, instead of ClusteringKeyValues
+CellNames: columns to delete from a single row, instead of the whole row
+Timestamp: time of the deletion in microseconds since epoch, assigned by the coordinator unless the client provides one
+Consistency: number of replicas that have to acknowledge the deletion, the node's default consistency level if left out
+Leaving out both ClusteringKeyValues and ClusteringRange deletes the whole partition.
+*/
+type DeleteRequest struct {
+	TableName           string           `json:"table_name"`
+	PartitionKeyValues  Values           `json:"partition_keys"`
+	HashedPK            int64            `json:"pk_hash"`
+	ClusteringKeyValues Values           `json:"clustering_keys"`
+	ClusteringRange     *ClusteringRange `json:"clustering_range,omitempty"`
+	CellNames           []string         `json:"cell_names,omitempty"`
+	Timestamp           int64            `json:"timestamp,omitempty"`
+	Consistency         ConsistencyLevel `json:"consistency,omitempty"`
+	Type                MessageType      `json:"type"`
+}
+
+type ClusteringRange struct {
+	Start          Values `json:"start"`
+	StartInclusive bool   `json:"start_inclusive"`
+	End            Values `json:"end"`
+	EndInclusive   bool   `json:"end_inclusive"`
 }
 
 type KillRequest struct {
@@ -0,0 +1,57 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Values are the values of keys or cells in a request. They are given as JSON strings, numbers or booleans,
+// and kept as text until they are checked against the type of their column.
+type Values []string
+
+func (v *Values) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw == nil {
+		*v = nil
+		return nil
+	}
+	values := make(Values, 0, len(raw))
+	for _, value := range raw {
+		text, err := parseValue(value)
+		if err != nil {
+			return err
+		}
+		values = append(values, text)
+	}
+	*v = values
+	return nil
+}
+
+// Value is a single value of a key or cell in a request, given the same way as Values.
+type Value string
+
+func (v *Value) UnmarshalJSON(data []byte) error {
+	text, err := parseValue(data)
+	if err != nil {
+		return err
+	}
+	*v = Value(text)
+	return nil
+}
+
+func parseValue(value json.RawMessage) (string, error) {
+	value = bytes.TrimSpace(value)
+	if bytes.Equal(value, []byte("null")) || value[0] == '{' || value[0] == '[' {
+		return "", fmt.Errorf("invalid value %s: values must be strings, numbers or booleans", string(value))
+	}
+	var text string
+	if err := json.Unmarshal(value, &text); err == nil {
+		return text, nil
+	}
+	// Numbers and booleans are kept as they are written
+	return string(value), nil
+}
@@ -0,0 +1,235 @@
+package read_write
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sanddb/db"
+	"sanddb/messages"
+	"sanddb/utils"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const testTable = "t"
+
+/* stubReplica
+A node of the test ring served by httptest, which answers the requests of a coordinator like the db handler of a node would.
+Delay: time the replica takes to answer inserts, deletes and reads, cut short if the coordinator abandons the request
+Fail: whether inserts and deletes are answered with an error once the delay has passed
+Row: version of the row answered to reads, nil if the replica does not hold it
+StallRepairs: whether repairs are left unanswered until the coordinator gives up on them
+*/
+type stubReplica struct {
+	Node         *utils.Node
+	Delay        time.Duration
+	Fail         bool
+	Row          *db.Row
+	StallRepairs bool
+
+	server    *httptest.Server
+	mu        sync.Mutex
+	repairs   []*db.RepairRequest
+	received  int32
+	cancelled int32
+}
+
+func (s *stubReplica) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/db/repair" {
+		var req db.RepairRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if s.StallRepairs {
+			<-r.Context().Done()
+			return
+		}
+		s.mu.Lock()
+		s.repairs = append(s.repairs, &req)
+		s.mu.Unlock()
+		s.ack(w)
+		return
+	}
+	// The server only notices that the coordinator abandoned the request once the body has been read
+	_, _ = ioutil.ReadAll(r.Body)
+	atomic.AddInt32(&s.received, 1)
+	select {
+	case <-time.After(s.Delay):
+	case <-r.Context().Done():
+		atomic.AddInt32(&s.cancelled, 1)
+		return
+	}
+	switch {
+	case r.URL.Path == "/db/read" && s.Row == nil:
+		http.Error(w, "Row not found.", http.StatusNotFound)
+	case r.URL.Path == "/db/read":
+		body, _ := json.Marshal(db.ReadResponse{SourceNode: s.Node, Row: s.Row})
+		_, _ = w.Write(body)
+	case s.Fail:
+		http.Error(w, "replica failure", http.StatusInternalServerError)
+	default:
+		s.ack(w)
+	}
+}
+
+func (s *stubReplica) ack(w http.ResponseWriter) {
+	body, _ := json.Marshal(messages.PeerMessage{Type: messages.WRITE_ACK, Content: "1", SourceID: s.Node.Id})
+	_, _ = w.Write(body)
+}
+
+// Repairs returns the repairs the replica has been sent so far.
+func (s *stubReplica) Repairs() []*db.RepairRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*db.RepairRequest{}, s.repairs...)
+}
+
+// Cancelled returns the number of requests that the coordinator abandoned before the replica answered them.
+func (s *stubReplica) Cancelled() int {
+	return int(atomic.LoadInt32(&s.cancelled))
+}
+
+// Received returns the number of inserts, deletes and reads that reached the replica.
+func (s *stubReplica) Received() int {
+	return int(atomic.LoadInt32(&s.received))
+}
+
+// Abandoned reports whether the coordinator abandoned every request that reached the replica, which a request cancelled early may not have.
+func (s *stubReplica) Abandoned() bool {
+	return atomic.LoadInt32(&s.cancelled) == atomic.LoadInt32(&s.received)
+}
+
+/* testCluster
+A ring of stub replicas, coordinated by the handler of the first of them. Every partition is replicated to every node.
+*/
+type testCluster struct {
+	Handler  *Handler
+	Replicas []*stubReplica
+	app      *fiber.App
+}
+
+// newTestCluster starts a ring of n stub replicas, and a coordinator that waits for them for at most timeout.
+// The schema of the coordinator holds a single table, with partition key k and clustering key c.
+func newTestCluster(t *testing.T, n int, timeout time.Duration) *testCluster {
+	t.Helper()
+	partitioner, err := utils.NewPartitioner(utils.PARTITIONER_MURMUR3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring := &utils.Ring{
+		NodeMap:     make(map[int64]*utils.Node),
+		Strategy:    &utils.SimpleStrategy{Factor: n},
+		Partitioner: partitioner,
+	}
+	cluster := &testCluster{}
+	for id := 0; id < n; id++ {
+		replica := &stubReplica{}
+		replica.server = httptest.NewServer(replica)
+		t.Cleanup(replica.server.Close)
+		address, err := url.Parse(replica.server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		replica.Node = &utils.Node{
+			Id:         id,
+			IPAddress:  "http://" + address.Hostname(),
+			Port:       ":" + address.Port(),
+			Tokens:     utils.NodeTokens(partitioner, id, 8),
+			Status:     utils.ALIVE,
+			State:      utils.STATE_NORMAL,
+			Datacenter: utils.DEFAULT_DATACENTER,
+			Rack:       utils.DEFAULT_RACK,
+		}
+		ring.Nodes = append(ring.Nodes, replica.Node)
+		for _, token := range replica.Node.Tokens {
+			ring.NodeMap[token] = replica.Node
+		}
+		ring.NodeHashes = append(ring.NodeHashes, replica.Node.Tokens...)
+		cluster.Replicas = append(cluster.Replicas, replica)
+	}
+	ring.NodeHashes = utils.Sort(ring.NodeHashes)
+	ring.CurrentNode = ring.Nodes[0]
+
+	dir := t.TempDir()
+	commitLog, err := db.OpenCommitLog(filepath.Join(dir, "commit.log"), db.SYNC_PER_WRITE, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, err := db.OpenStorageEngine(filepath.Join(dir, "data"), commitLog, db.StorageOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = storage.Close() })
+	if err = storage.CreateTable(messages.CreateRequest{TableName: testTable, PartitionKeyNames: []string{"k"}, ClusteringKeyNames: []string{"c"}}); err != nil {
+		t.Fatal(err)
+	}
+	hints, err := db.OpenHintStore(filepath.Join(dir, "hints"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster.Handler = &Handler{
+		Node:               ring.Nodes[0],
+		Ring:               ring,
+		Timeout:            timeout,
+		DefaultConsistency: messages.CONSISTENCY_QUORUM,
+		Hints:              hints,
+		Storage:            storage,
+	}
+	cluster.app = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	cluster.app.Post("/insert", cluster.Handler.HandleClientWriteRequest)
+	cluster.app.Post("/read", cluster.Handler.HandleClientReadRequest)
+	cluster.app.Post("/delete", cluster.Handler.HandleClientDeleteRequest)
+	return cluster
+}
+
+// Post sends a client request to the coordinator, and returns its response along with the time it took.
+func (c *testCluster) Post(t *testing.T, path string, payload interface{}) (*http.Response, time.Duration) {
+	t.Helper()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	start := time.Now()
+	resp, err := c.app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, time.Since(start)
+}
+
+// Hints returns the number of hints the coordinator keeps for each node.
+func (c *testCluster) Hints(t *testing.T) map[int]int {
+	t.Helper()
+	stats, err := c.Handler.Hints.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hints := make(map[int]int)
+	for _, stat := range stats {
+		hints[stat.Target] = stat.Hints
+	}
+	return hints
+}
+
+// waitFor polls condition until it holds, and fails the test if it still does not after timeout.
+func waitFor(t *testing.T, timeout time.Duration, description string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", description)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
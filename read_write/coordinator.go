@@ -0,0 +1,254 @@
+package read_write
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sanddb/db"
+	. "sanddb/messages"
+	"sanddb/utils"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// lastRequestID numbers the requests coordinated by this node, so that their logs can be told apart
+var lastRequestID uint64
+
+/* Coordinator
+Holds the state of a single client request, so that a node can coordinate any number of them at the same time.
+ID: identifies the request in the logs of the coordinator
+Replicas: alive replicas the request is sent to
+Required: number of replicas that have to answer for the consistency level to be met
+RequiredPerDatacenter: number of replicas of each datacenter that have to answer, only for LOCAL_QUORUM and EACH_QUORUM,
+the answers of the other datacenters are then not counted
+Deadline: time after which the replicas that have not answered are given up on, and their requests cancelled
+*/
+type Coordinator struct {
+	ID                    uint64
+	Consistency           ConsistencyLevel
+	Replicas              []*utils.Node
+	Required              int
+	RequiredPerDatacenter map[string]int
+	Deadline              time.Time
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	responses chan replicaResponse
+	pending   int
+}
+
+// replicaResponse is the answer of a single replica to a request sent by the coordinator.
+type replicaResponse struct {
+	Node *utils.Node
+	Read db.ReadResponse
+	Scan db.ScanResponse
+	Err  error
+}
+
+// newCoordinator works out the number of answers required by the consistency level for replicas placed by strategy,
+// and fails right away if fewer replicas are alive. Like Cassandra, ANY is only checked against the answers actually received.
+func (h *Handler) newCoordinator(consistency ConsistencyLevel, replicas []*utils.Node, strategy utils.ReplicationStrategy) (*Coordinator, error) {
+	id := atomic.AddUint64(&lastRequestID, 1)
+	required := consistency.BlockFor(strategy.ReplicationFactor())
+	var requiredPerDatacenter map[string]int
+	if consistency.IsDatacenterAware() {
+		var err error
+		requiredPerDatacenter, err = consistency.BlockForDatacenters(h.Node.Datacenter, strategy.DatacenterFactors(), strategy.ReplicationFactor())
+		if err != nil {
+			return nil, fiber.NewError(http.StatusBadRequest, err.Error())
+		}
+		required = 0
+		alive := countPerDatacenter(replicas)
+		for dc, dcRequired := range requiredPerDatacenter {
+			required += dcRequired
+			if alive[dc] < dcRequired {
+				fmt.Printf("Request %d: %d replicas alive in datacenter %s, %d required for consistency level %s\n", id, alive[dc], dc, dcRequired, consistency)
+				return nil, &ConsistencyError{
+					Kind:        ERR_UNAVAILABLE,
+					Consistency: consistency,
+					Datacenter:  dc,
+					Required:    dcRequired,
+					Alive:       alive[dc],
+				}
+			}
+		}
+	}
+	if consistency != CONSISTENCY_ANY && len(replicas) < required {
+		fmt.Printf("Request %d: %d replicas alive, %d required for consistency level %s\n", id, len(replicas), required, consistency)
+		return nil, &ConsistencyError{
+			Kind:        ERR_UNAVAILABLE,
+			Consistency: consistency,
+			Required:    required,
+			Alive:       len(replicas),
+		}
+	}
+	deadline := time.Now().Add(h.Timeout)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	return &Coordinator{
+		ID:                    id,
+		Consistency:           consistency,
+		Replicas:              replicas,
+		Required:              required,
+		RequiredPerDatacenter: requiredPerDatacenter,
+		Deadline:              deadline,
+		ctx:                   ctx,
+		cancel:                cancel,
+	}, nil
+}
+
+func countPerDatacenter(nodes []*utils.Node) map[string]int {
+	counts := make(map[string]int)
+	for _, node := range nodes {
+		counts[node.Datacenter]++
+	}
+	return counts
+}
+
+// AddPending also sends the request to joining nodes that will replicate the partition.
+// Like in Cassandra, each of them raises the number of answers required, so that the consistency level still holds once they own it.
+func (co *Coordinator) AddPending(nodes []*utils.Node) {
+	co.Replicas = append(co.Replicas, nodes...)
+	if co.Consistency == CONSISTENCY_ANY {
+		return
+	}
+	for _, node := range nodes {
+		if co.RequiredPerDatacenter == nil {
+			co.Required++
+		} else if _, counted := co.RequiredPerDatacenter[node.Datacenter]; counted {
+			co.RequiredPerDatacenter[node.Datacenter]++
+			co.Required++
+		}
+	}
+}
+
+// counts reports whether the answer of node counts towards the consistency level.
+func (co *Coordinator) counts(node *utils.Node) bool {
+	if co.RequiredPerDatacenter == nil {
+		return true
+	}
+	_, counted := co.RequiredPerDatacenter[node.Datacenter]
+	return counted
+}
+
+// met reports whether the answers counted so far, in total and per datacenter, meet the consistency level.
+func (co *Coordinator) met(succeeded int, succeededPerDatacenter map[string]int) bool {
+	if co.RequiredPerDatacenter == nil {
+		return succeeded >= co.Required
+	}
+	for dc, required := range co.RequiredPerDatacenter {
+		if succeededPerDatacenter[dc] < required {
+			return false
+		}
+	}
+	return true
+}
+
+// FanOut sends the request to every replica at once.
+// The responses are buffered, so replicas that answer after the coordinator has replied to the client never block.
+func (co *Coordinator) FanOut(send func(ctx context.Context, node *utils.Node) replicaResponse) {
+	co.responses = make(chan replicaResponse, len(co.Replicas))
+	co.pending = len(co.Replicas)
+	for _, node := range co.Replicas {
+		go func(node *utils.Node) {
+			co.responses <- send(co.ctx, node)
+		}(node)
+	}
+}
+
+// Await collects the answers of the replicas until enough of them succeeded for the consistency level.
+// Validation errors of a replica are returned as is, since every replica would reject the request the same way.
+func (co *Coordinator) Await() ([]replicaResponse, error) {
+	received := make([]replicaResponse, 0, co.pending)
+	succeeded := 0
+	succeededPerDatacenter := make(map[string]int)
+	for !co.met(succeeded, succeededPerDatacenter) && co.pending > 0 {
+		select {
+		case resp := <-co.responses:
+			co.pending--
+			received = append(received, resp)
+			if resp.Err == nil && !co.counts(resp.Node) {
+				fmt.Printf("Request %d: Data received from node %d, which is not counted for consistency level %s\n", co.ID, resp.Node.Id, co.Consistency)
+				continue
+			}
+			if resp.Err == nil {
+				succeeded++
+				succeededPerDatacenter[resp.Node.Datacenter]++
+				fmt.Printf("Request %d: Data received from node %d. Current ACKs: %d\n", co.ID, resp.Node.Id, succeeded)
+				continue
+			}
+			if fiberErr, ok := resp.Err.(*fiber.Error); ok && fiberErr.Code == http.StatusBadRequest {
+				return received, resp.Err
+			}
+			fmt.Printf("Request %d: Error in request to node %d: %s\n", co.ID, resp.Node.Id, resp.Err.Error())
+		case <-co.ctx.Done():
+			fmt.Printf("Request %d: Number of votes received: %d\tNumber of votes required:%d\n", co.ID, succeeded, co.Required)
+			return received, co.consistencyError(ERR_TIMEOUT, succeeded)
+		}
+	}
+	fmt.Printf("Request %d: Number of votes received: %d\tNumber of votes required:%d\n", co.ID, succeeded, co.Required)
+	if !co.met(succeeded, succeededPerDatacenter) {
+		return received, co.consistencyError(ERR_FAILURE, succeeded)
+	}
+	return received, nil
+}
+
+// AwaitLate collects, in the background, the answers of the replicas that the coordinator did not wait for,
+// and hands them to handle once every replica has answered or the deadline has passed. The request is then released.
+func (co *Coordinator) AwaitLate(handle func(late []replicaResponse)) {
+	go func() {
+		defer co.cancel()
+		late := make([]replicaResponse, 0, co.pending)
+		for ; co.pending > 0; co.pending-- {
+			select {
+			case resp := <-co.responses:
+				late = append(late, resp)
+			case <-co.ctx.Done():
+				handle(late)
+				return
+			}
+		}
+		handle(late)
+	}()
+}
+
+// Cancel gives up on the replicas that have not answered yet, and releases the request.
+func (co *Coordinator) Cancel() {
+	co.cancel()
+}
+
+func (co *Coordinator) consistencyError(kind string, received int) *ConsistencyError {
+	return &ConsistencyError{
+		Kind:        kind,
+		Consistency: co.Consistency,
+		Required:    co.Required,
+		Alive:       len(co.Replicas),
+		Received:    received,
+	}
+}
+
+// postJSON sends a request to another node, which is abandoned as soon as ctx is done.
+func postJSON(ctx context.Context, url string, payload interface{}) (*http.Response, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return http.DefaultClient.Do(req)
+}
+
+// replicaError passes the error answered by a replica on to the client.
+func replicaError(response *http.Response) error {
+	jsonResponse, _ := ioutil.ReadAll(response.Body)
+	errResponse := &fiber.Error{Code: response.StatusCode, Message: string(jsonResponse)}
+	_ = json.Unmarshal(jsonResponse, errResponse)
+	return errResponse
+}
@@ -0,0 +1,189 @@
+package read_write
+
+import (
+	"context"
+	"errors"
+	"sanddb/messages"
+	"sanddb/utils"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// concurrentRequests is the number of requests that every test coordinates at the same time
+const concurrentRequests = 50
+
+// startWrites coordinates a write to every node of the cluster through a coordinator of its own, and fans it out.
+// Whatever the coordinator does with them, the answers of the replicas are also sent to sent if it is not nil.
+func startWrites(t *testing.T, cluster *testCluster, consistency messages.ConsistencyLevel, sent chan<- replicaResponse) *Coordinator {
+	h := cluster.Handler
+	replicas := make([]*utils.Node, 0, len(cluster.Replicas))
+	for _, replica := range cluster.Replicas {
+		replicas = append(replicas, replica.Node)
+	}
+	co, err := h.newCoordinator(consistency, replicas, h.Ring.Strategy)
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	req := testWrite(consistency)
+	co.FanOut(func(ctx context.Context, node *utils.Node) replicaResponse {
+		resp := replicaResponse{Node: node, Err: h.sendWriteRequest(ctx, node, req)}
+		if sent != nil {
+			sent <- resp
+		}
+		return resp
+	})
+	return co
+}
+
+// runConcurrently runs request concurrentRequests times at once, and waits for all of them.
+func runConcurrently(request func()) {
+	var wg sync.WaitGroup
+	for i := 0; i < concurrentRequests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			request()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestConcurrentCoordinatorsReturnEarly(t *testing.T) {
+	cluster := newTestCluster(t, 3, 3*time.Second)
+	slow := cluster.Replicas[2]
+	slow.Delay = time.Second
+
+	ids := make(chan uint64, concurrentRequests)
+	runConcurrently(func() {
+		co := startWrites(t, cluster, messages.CONSISTENCY_QUORUM, nil)
+		if co == nil {
+			return
+		}
+		start := time.Now()
+		received, err := co.Await()
+		elapsed := time.Since(start)
+		co.Cancel()
+		if err != nil {
+			t.Errorf("request %d failed: %s", co.ID, err)
+			return
+		}
+		if elapsed >= slow.Delay/2 {
+			t.Errorf("request %d waited %s for the slow replica", co.ID, elapsed)
+		}
+		for _, resp := range received {
+			if resp.Node.Id == slow.Node.Id {
+				t.Errorf("request %d was answered by the slow replica", co.ID)
+			}
+		}
+		ids <- co.ID
+	})
+	close(ids)
+	seen := make(map[uint64]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("request ID %d was given to two requests", id)
+		}
+		seen[id] = true
+	}
+	waitFor(t, time.Second, "the requests to the slow replica to be cancelled", func() bool {
+		return slow.Abandoned()
+	})
+}
+
+func TestCoordinatorCancelAbandonsReplicas(t *testing.T) {
+	cluster := newTestCluster(t, 3, 3*time.Second)
+	for _, replica := range cluster.Replicas[1:] {
+		replica.Delay = time.Minute
+	}
+
+	runConcurrently(func() {
+		sent := make(chan replicaResponse, len(cluster.Replicas))
+		co := startWrites(t, cluster, messages.CONSISTENCY_ONE, sent)
+		if co == nil {
+			return
+		}
+		if _, err := co.Await(); err != nil {
+			t.Errorf("request %d failed: %s", co.ID, err)
+		}
+		<-sent
+		co.Cancel()
+		// The requests still in flight fail right away instead of waiting for the replicas or the deadline
+		for i := 1; i < len(cluster.Replicas); i++ {
+			select {
+			case resp := <-sent:
+				if !errors.Is(resp.Err, context.Canceled) {
+					t.Errorf("request %d: expected the request to node %d to be cancelled, got %v", co.ID, resp.Node.Id, resp.Err)
+				}
+			case <-time.After(time.Second):
+				t.Errorf("request %d: cancelled requests are still in flight", co.ID)
+				return
+			}
+		}
+	})
+	for _, replica := range cluster.Replicas[1:] {
+		waitFor(t, time.Second, "the slow replicas to see their requests cancelled", func() bool {
+			return replica.Abandoned()
+		})
+	}
+}
+
+func TestCoordinatorCollectsLateResponses(t *testing.T) {
+	cluster := newTestCluster(t, 3, 3*time.Second)
+	cluster.Replicas[1].Delay = 300 * time.Millisecond
+	cluster.Replicas[2].Delay = 600 * time.Millisecond
+	cluster.Replicas[2].Fail = true
+
+	var handled int32
+	runConcurrently(func() {
+		co := startWrites(t, cluster, messages.CONSISTENCY_ONE, nil)
+		if co == nil {
+			return
+		}
+		received, err := co.Await()
+		if err != nil || len(received) != 1 || received[0].Node.Id != 0 {
+			t.Errorf("request %d: expected the answer of node 0 only, got %d answers and %v", co.ID, len(received), err)
+			return
+		}
+		done := make(chan []replicaResponse, 1)
+		co.AwaitLate(func(late []replicaResponse) {
+			atomic.AddInt32(&handled, 1)
+			done <- late
+		})
+		late := <-done
+		missed := missedReplicas(co.Replicas, append(received, late...))
+		if len(late) != 2 || len(missed) != 1 || missed[0].Id != 2 {
+			t.Errorf("request %d: expected 2 late answers with a failure of node 2, got %d answers and %d failures", co.ID, len(late), len(missed))
+		}
+	})
+	if handled := atomic.LoadInt32(&handled); handled != concurrentRequests {
+		t.Fatalf("late answers of %d requests were handled, expected %d", handled, concurrentRequests)
+	}
+}
+
+func TestCoordinatorTimesOut(t *testing.T) {
+	cluster := newTestCluster(t, 3, time.Second)
+	slow := cluster.Replicas[2]
+	slow.Delay = time.Minute
+
+	runConcurrently(func() {
+		co := startWrites(t, cluster, messages.CONSISTENCY_ALL, nil)
+		if co == nil {
+			return
+		}
+		received, err := co.Await()
+		co.Cancel()
+		consistencyErr, ok := err.(*ConsistencyError)
+		if !ok || consistencyErr.Kind != ERR_TIMEOUT || consistencyErr.Received != 2 {
+			t.Errorf("request %d: expected a timeout with 2 answers, got %v", co.ID, err)
+		}
+		if len(received) != 2 {
+			t.Errorf("request %d: expected the answers of the 2 fast replicas, got %d", co.ID, len(received))
+		}
+	})
+	waitFor(t, time.Second, "the requests to the slow replica to be cancelled", func() bool {
+		return slow.Abandoned()
+	})
+}
@@ -1,13 +1,13 @@
 package read_write
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"io/ioutil"
 	"net/http"
+	"sanddb/db"
 	"sanddb/messages"
 	"sanddb/utils"
 )
@@ -21,53 +21,69 @@ func (h *Handler) HandleClientCreateRequest(c *fiber.Ctx) error {
 		return err
 	}
 	fmt.Printf("Request received from client by receiverNode %d.\n", h.Node.Id)
-	err = h.createQuorum(messages.REQUEST_CREATE)
-	if err != nil {
-		return err
+	request.TableName = messages.CanonicalTableName(request.TableName)
+	if request.View != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Materialized views are created through /view/create.")
 	}
-	for _, receiverNode := range h.Ring.Nodes {
-		err = h.sendCreateRequest(receiverNode, request)
-		if err != nil {
-			fmt.Printf("Error in sending coordinator request to %d: %s\n", receiverNode.Id, err.Error())
-			return err
-		}
-	}
-	err = h.closeQuorum()
-	if err != nil {
-		return fiber.NewError(fiber.StatusServiceUnavailable, err.Error())
+	if err = h.createTable(request); err != nil {
+		return err
 	}
 	successMsg := fmt.Sprintf("Table %s has been successfully created!", request.TableName)
 	_ = c.Status(http.StatusCreated).SendString(successMsg)
 	return nil
 }
 
-func (h *Handler) sendCreateRequest(node *utils.Node, data messages.CreateRequest) error {
-	var (
-		responseMsg messages.PeerMessage
-	)
-	fmt.Printf("Sending coordinator request to node with has %d\n", node.Hash)
-	body, err := json.Marshal(data)
+// createTable checks the definition of a table, and creates it on every node.
+func (h *Handler) createTable(request messages.CreateRequest) error {
+	if err := db.ValidateTableOptions(request); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if keyspace, _ := messages.SplitTableName(request.TableName); h.Storage.Keyspace(keyspace) == nil {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Keyspace %s does not exist.", keyspace))
+	}
+	request.Timestamp = newWriteTimestamp()
+	//Create Request has to be replicated to all nodes, not just replicas
+	nodes := h.Ring.AliveNodes()
+	// Schema changes always need a quorum of the nodes in the ring
+	co, err := h.newCoordinator(messages.CONSISTENCY_QUORUM, nodes, &utils.SimpleStrategy{Factor: len(h.Ring.Nodes)})
 	if err != nil {
-		fmt.Printf("Erorr in marshalling coordinator request: %s", err.Error())
 		return err
 	}
-	postBody := bytes.NewBuffer(body)
-	response, err := http.Post(node.IPAddress+node.Port+"/db/new", "application/json", postBody)
+	co.FanOut(func(ctx context.Context, receiverNode *utils.Node) replicaResponse {
+		return replicaResponse{Node: receiverNode, Err: h.sendCreateRequest(ctx, receiverNode, request)}
+	})
+	received, err := co.Await()
+	if _, ok := err.(*ConsistencyError); err != nil && !ok {
+		co.Cancel()
+		return err
+	}
+	co.AwaitLate(func(late []replicaResponse) {
+		for _, node := range missedReplicas(nodes, append(received, late...)) {
+			fmt.Printf("Request %d: Node %d missed the creation of table %s.\n", co.ID, node.Id, request.TableName)
+		}
+	})
+	return err
+}
 
+func (h *Handler) sendCreateRequest(ctx context.Context, node *utils.Node, data messages.CreateRequest) error {
+	var (
+		responseMsg messages.PeerMessage
+	)
+	fmt.Printf("Sending coordinator request to node %d\n", node.Id)
+	response, err := postJSON(ctx, node.IPAddress+node.Port+"/db/new", data)
 	if err != nil {
-		fmt.Printf("Error in posting coordinator request: %s", err.Error())
+		fmt.Printf("Error in posting coordinator request: %s\n", err.Error())
 		return err
 	}
 	defer response.Body.Close()
-	jsonResponse, err := ioutil.ReadAll(response.Body)
 	if response.StatusCode != http.StatusCreated {
-		return errors.New(string(jsonResponse))
+		return replicaError(response)
 	}
+	jsonResponse, err := ioutil.ReadAll(response.Body)
 	err = json.Unmarshal([]byte(jsonResponse), &responseMsg)
 	if err != nil {
 		fmt.Printf("Error in unmarshalling: %s\n", err.Error())
 		return err
 	}
-	h.QuorumChannel <- responseMsg
 	return nil
 }
@@ -0,0 +1,129 @@
+package read_write
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"io/ioutil"
+	"net/http"
+	"sanddb/db"
+	"sanddb/messages"
+	"sanddb/utils"
+)
+
+func (h *Handler) HandleClientDeleteRequest(c *fiber.Ctx) error {
+	var (
+		req messages.DeleteRequest
+	)
+	fmt.Printf("Delete request received from client by receiverNode %d.\n", h.Node.Id)
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	req.TableName = messages.CanonicalTableName(req.TableName)
+	table, err := h.table(req.TableName)
+	if err != nil {
+		return err
+	}
+	if len(req.PartitionKeyValues) == 0 {
+		return fiber.NewError(http.StatusBadRequest, "partition_keys are required.")
+	}
+	if req.ClusteringRange != nil && len(req.ClusteringKeyValues) > 0 {
+		return fiber.NewError(http.StatusBadRequest, "clustering_keys and clustering_range cannot be used together.")
+	}
+	if len(req.CellNames) > 0 && req.ClusteringRange != nil {
+		return fiber.NewError(http.StatusBadRequest, "cell_names can only be deleted from a single row.")
+	}
+	if err = table.ValidateDelete(&req); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	partitionKeyConcat := ""
+	// Hash partition key sent by client
+	for _, partitionKey := range req.PartitionKeyValues {
+		partitionKeyConcat += partitionKey
+	}
+	if err := h.Ring.Partitioner.ValidateKey(partitionKeyConcat); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	req.HashedPK = h.Ring.Token(partitionKeyConcat)
+	if req.Timestamp < 0 {
+		return fiber.NewError(http.StatusBadRequest, "timestamp must be a positive number of microseconds since epoch.")
+	} else if req.Timestamp == 0 {
+		// Every replica has to record the same deletion time for the tombstone to be reconciled the same way everywhere
+		req.Timestamp = newWriteTimestamp()
+	}
+
+	consistency, err := h.consistencyLevel(req.Consistency)
+	if err != nil {
+		return err
+	}
+
+	strategy := table.Strategy(h.Ring.Strategy)
+	natural := h.Ring.NaturalReplicas(strategy, partitionKeyConcat)
+	nodes := h.aliveReplicas(natural)
+	co, err := h.newCoordinator(consistency, nodes, strategy)
+	if err != nil {
+		return err
+	}
+	if len(nodes) > 0 {
+		fmt.Printf("Routing delete request to receiverNode %d...\n", nodes[0].Id)
+	}
+
+	req.Type = messages.COORDINATOR_DELETE
+	hint := db.Hint{Delete: &req}
+	hinted := h.hintDeadReplicas(co, natural, nodes, hint)
+	co.AddPending(h.Ring.PendingReplicas(strategy, partitionKeyConcat))
+	co.FanOut(func(ctx context.Context, node *utils.Node) replicaResponse {
+		return replicaResponse{Node: node, Err: h.sendDeleteRequest(ctx, node, req)}
+	})
+	received, err := co.Await()
+	if _, ok := err.(*ConsistencyError); err != nil && !ok {
+		co.Cancel()
+		return err
+	}
+	if err != nil && consistency == messages.CONSISTENCY_ANY {
+		hinted += h.storeHints(co, missedReplicas(nodes, received), hint)
+		co.Cancel()
+		if hinted == 0 {
+			return err
+		}
+		err = nil
+	} else {
+		co.AwaitLate(func(late []replicaResponse) {
+			missed := missedReplicas(nodes, append(received, late...))
+			for _, node := range missed {
+				fmt.Printf("Request %d: Node %d missed the delete from table %s at %d.\n", co.ID, node.Id, req.TableName, req.Timestamp)
+			}
+			h.storeHints(co, missed, hint)
+		})
+	}
+	if err != nil {
+		return err
+	}
+	_ = c.Status(http.StatusOK).SendString("Successfully deleted.")
+	return nil
+}
+
+func (h *Handler) sendDeleteRequest(ctx context.Context, node *utils.Node, req messages.DeleteRequest) error {
+	var (
+		responseMsg messages.PeerMessage
+	)
+	fmt.Printf("Sending delete request to node %d.\n", node.Id)
+	response, err := postJSON(ctx, node.IPAddress+node.Port+"/db/delete", req)
+	if err != nil {
+		fmt.Printf("Error in posting delete request: %s\n", err.Error())
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		// Pass validation errors of the replica on to the client
+		return replicaError(response)
+	}
+	jsonResponse, err := ioutil.ReadAll(response.Body)
+	err = json.Unmarshal(jsonResponse, &responseMsg)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Delete acknowledged by node %d.\n", responseMsg.SourceID)
+	return nil
+}
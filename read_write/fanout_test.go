@@ -0,0 +1,167 @@
+package read_write
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sanddb/db"
+	"sanddb/messages"
+	"sanddb/utils"
+	"testing"
+	"time"
+)
+
+func testWrite(consistency messages.ConsistencyLevel) messages.WriteRequest {
+	return messages.WriteRequest{
+		TableName:           testTable,
+		PartitionKeyValues:  messages.Values{"a"},
+		ClusteringKeyValues: messages.Values{"1"},
+		CellNames:           []string{"v"},
+		CellValues:          messages.Values{"x"},
+		Consistency:         consistency,
+	}
+}
+
+// testRow returns a version of the row written by testWrite, created at created and last updated at timestamp.
+func testRow(value string, created time.Time, timestamp time.Time) *db.Row {
+	return &db.Row{
+		CreatedAt:           db.EpochTime(created),
+		UpdatedAt:           db.EpochTime(timestamp),
+		DeletedAt:           db.EpochTime(time.Time{}),
+		ClusteringKeyHash:   utils.GetHashFromKeys([]string{"1"}),
+		ClusteringKeyValues: []string{"1"},
+		Cells:               []*db.Cell{{Name: "v", Value: value, Timestamp: db.EpochTime(timestamp)}},
+	}
+}
+
+func TestWriteRepliesOnceConsistencyIsMet(t *testing.T) {
+	cluster := newTestCluster(t, 3, 3*time.Second)
+	slow := cluster.Replicas[2]
+	slow.Delay = time.Second
+	slow.Fail = true
+
+	resp, elapsed := cluster.Post(t, "/insert", testWrite(messages.CONSISTENCY_QUORUM))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("write failed with %d", resp.StatusCode)
+	}
+	if elapsed >= slow.Delay/2 {
+		t.Fatalf("write took %s, the coordinator waited for the slow replica", elapsed)
+	}
+	// The slow replica fails the write once the client has its answer, which is only found out in the background
+	waitFor(t, 3*time.Second, "the hint of the slow replica", func() bool {
+		return cluster.Hints(t)[slow.Node.Id] == 1
+	})
+	if hints := cluster.Hints(t); len(hints) != 1 {
+		t.Fatalf("expected a hint for node %d only, got %v", slow.Node.Id, hints)
+	}
+}
+
+func TestWriteHintsReplicaThatTimesOut(t *testing.T) {
+	cluster := newTestCluster(t, 3, 500*time.Millisecond)
+	slow := cluster.Replicas[1]
+	slow.Delay = time.Minute
+
+	resp, elapsed := cluster.Post(t, "/insert", testWrite(messages.CONSISTENCY_QUORUM))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("write failed with %d", resp.StatusCode)
+	}
+	if elapsed >= cluster.Handler.Timeout/2 {
+		t.Fatalf("write took %s, the coordinator waited for the slow replica", elapsed)
+	}
+	waitFor(t, 3*time.Second, "the hint of the slow replica", func() bool {
+		return cluster.Hints(t)[slow.Node.Id] == 1
+	})
+	waitFor(t, time.Second, "the request to the slow replica to be cancelled", func() bool {
+		return slow.Cancelled() == 1
+	})
+}
+
+func TestWriteFailsWithoutEnoughAnswers(t *testing.T) {
+	cluster := newTestCluster(t, 3, 300*time.Millisecond)
+	cluster.Replicas[1].Delay = time.Minute
+	cluster.Replicas[2].Delay = time.Minute
+
+	resp, elapsed := cluster.Post(t, "/insert", testWrite(messages.CONSISTENCY_QUORUM))
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("expected the write to time out, got %d", resp.StatusCode)
+	}
+	if elapsed < cluster.Handler.Timeout || elapsed > 3*cluster.Handler.Timeout {
+		t.Fatalf("write timed out after %s instead of %s", elapsed, cluster.Handler.Timeout)
+	}
+}
+
+func TestReadRepliesOnceConsistencyIsMet(t *testing.T) {
+	cluster := newTestCluster(t, 3, 3*time.Second)
+	now := time.Now()
+	created := now.Add(-time.Hour)
+	cluster.Replicas[0].Row = testRow("new", created, now)
+	cluster.Replicas[1].Row = testRow("new", created, now)
+	slow := cluster.Replicas[2]
+	slow.Delay = 500 * time.Millisecond
+	slow.Row = testRow("old", created, created)
+
+	resp, elapsed := cluster.Post(t, "/read", messages.ReadRequest{
+		TableName:           testTable,
+		PartitionKeyValues:  messages.Values{"a"},
+		ClusteringKeyValues: messages.Values{"1"},
+		Consistency:         messages.CONSISTENCY_QUORUM,
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("read failed with %d", resp.StatusCode)
+	}
+	if elapsed >= slow.Delay/2 {
+		t.Fatalf("read took %s, the coordinator waited for the slow replica", elapsed)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var row db.Row
+	if err = json.Unmarshal(body, &row); err != nil {
+		t.Fatal(err)
+	}
+	if len(row.Cells) != 1 || row.Cells[0].Value != "new" {
+		t.Fatalf("expected the latest version of the row, got %s", string(body))
+	}
+	// The stale version of the slow replica is only seen once the client has its answer, and repaired in the background
+	waitFor(t, 3*time.Second, "the read repair of the slow replica", func() bool {
+		return len(slow.Repairs()) == 1
+	})
+	repair := slow.Repairs()[0]
+	if cells := repair.Partition.Rows[0].Cells; len(cells) != 1 || cells[0].Value != "new" {
+		t.Fatalf("slow replica was repaired with %v", cells)
+	}
+	for _, replica := range cluster.Replicas[:2] {
+		if repairs := replica.Repairs(); len(repairs) != 0 {
+			t.Fatalf("node %d holds the latest version but was sent %d repairs", replica.Node.Id, len(repairs))
+		}
+	}
+}
+
+func TestReadHintsRepairOfStalledReplica(t *testing.T) {
+	cluster := newTestCluster(t, 3, 500*time.Millisecond)
+	now := time.Now()
+	created := now.Add(-time.Hour)
+	cluster.Replicas[0].Row = testRow("new", created, now)
+	stale := cluster.Replicas[1]
+	stale.Row = testRow("old", created, created)
+	stale.StallRepairs = true
+	cluster.Replicas[2].Delay = time.Minute
+
+	resp, elapsed := cluster.Post(t, "/read", messages.ReadRequest{
+		TableName:           testTable,
+		PartitionKeyValues:  messages.Values{"a"},
+		ClusteringKeyValues: messages.Values{"1"},
+		Consistency:         messages.CONSISTENCY_QUORUM,
+	})
+	// The read has met its consistency level, so the repair that the stale replica never answers is hinted instead of failing it
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("read failed with %d", resp.StatusCode)
+	}
+	if elapsed > 3*cluster.Handler.Timeout {
+		t.Fatalf("read took %s, the coordinator waited for the stalled repair past the timeout", elapsed)
+	}
+	if hints := cluster.Hints(t); len(hints) != 1 || hints[stale.Node.Id] != 1 {
+		t.Fatalf("expected a hint of the repair for node %d only, got %v", stale.Node.Id, hints)
+	}
+}
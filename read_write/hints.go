@@ -0,0 +1,112 @@
+package read_write
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sanddb/db"
+	"sanddb/utils"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// hintDeadReplicas stores a hint for every natural replica of the partition that the mutation is not sent to, since the coordinator does not even try to reach dead replicas.
+// Both lists are taken from the same view of the ring, so that each replica either gets the mutation or a hint, even if gossip marks it dead or alive in the meantime.
+func (h *Handler) hintDeadReplicas(co *Coordinator, natural []*utils.Node, replicas []*utils.Node, hint db.Hint) int {
+	dead := make([]*utils.Node, 0)
+	for _, node := range natural {
+		if !containsReplica(replicas, node) && !containsReplica(dead, node) {
+			dead = append(dead, node)
+		}
+	}
+	return h.storeHints(co, dead, hint)
+}
+
+func containsReplica(nodes []*utils.Node, node *utils.Node) bool {
+	for _, n := range nodes {
+		if n.Id == node.Id {
+			return true
+		}
+	}
+	return false
+}
+
+// storeHints stores a copy of hint for each of the nodes, and returns how many of them were stored.
+func (h *Handler) storeHints(co *Coordinator, nodes []*utils.Node, hint db.Hint) int {
+	stored := 0
+	for _, node := range nodes {
+		nodeHint := hint
+		nodeHint.Target = node.Id
+		nodeHint.CreatedAt = db.EpochTime(time.Now())
+		if err := h.Hints.Store(&nodeHint); err != nil {
+			fmt.Printf("Request %d: Error in storing hint for node %d: %s\n", co.ID, node.Id, err.Error())
+			continue
+		}
+		fmt.Printf("Request %d: Stored hint for node %d.\n", co.ID, node.Id)
+		stored++
+	}
+	return stored
+}
+
+// ReplayHints delivers the hints kept for every alive node, e.g. those stored before this node was restarted.
+func (h *Handler) ReplayHints() {
+	for _, target := range h.Hints.Targets() {
+		if node := h.Ring.NodeByID(target); node != nil && h.Ring.IsAlive(node) {
+			h.replayHintsTo(node)
+		}
+	}
+}
+
+// OnAlive hands a node that gossip marked alive the writes it missed while it was dead.
+func (h *Handler) OnAlive(node *utils.Node) {
+	go h.replayHintsTo(node)
+}
+
+// OnDead has nothing to do, the writes a dead node misses are hinted as they come.
+func (h *Handler) OnDead(node *utils.Node) {}
+
+// replayHintsTo delivers the hints kept for node, with the timestamps of the original mutations.
+// A hint that the node rejects as invalid is dropped, any other error leaves the remaining hints for the next time the node is revived.
+func (h *Handler) replayHintsTo(node *utils.Node) error {
+	delivered, err := h.Hints.Replay(node.Id, func(hint *db.Hint) error {
+		ctx, cancel := context.WithTimeout(context.Background(), h.Timeout)
+		defer cancel()
+		var err error
+		if hint.Write != nil {
+			err = h.sendWriteRequest(ctx, node, *hint.Write)
+		} else if hint.Delete != nil {
+			err = h.sendDeleteRequest(ctx, node, *hint.Delete)
+		} else if hint.View != nil {
+			err = h.sendRepair(ctx, node, hint.View.TableName, hint.View.Partition)
+		} else if hint.Repair != nil {
+			err = h.sendRepair(ctx, node, hint.Repair.TableName, hint.Repair.Partition)
+		}
+		if fiberErr, ok := err.(*fiber.Error); ok && fiberErr.Code == http.StatusBadRequest {
+			fmt.Printf("Dropping hint for node %d: %s\n", node.Id, fiberErr.Message)
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		fmt.Printf("Error in replaying hints to node %d after %d delivered: %s\n", node.Id, delivered, err.Error())
+		return err
+	}
+	if delivered > 0 {
+		fmt.Printf("Replayed %d hints to node %d.\n", delivered, node.Id)
+	}
+	return nil
+}
+
+func (h *Handler) HandleHintStats(c *fiber.Ctx) error {
+	stats, err := h.Hints.Stats()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).Send(body)
+}
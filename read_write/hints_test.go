@@ -0,0 +1,46 @@
+package read_write
+
+import (
+	"net/http"
+	"sanddb/messages"
+	"sanddb/utils"
+	"testing"
+	"time"
+)
+
+func TestWriteHintsDeadReplicaInsteadOfReplacingIt(t *testing.T) {
+	cluster := newTestCluster(t, 4, 3*time.Second)
+	ring := cluster.Handler.Ring
+	ring.Strategy = &utils.SimpleStrategy{Factor: 3}
+	natural := ring.NaturalReplicas(ring.Strategy, "a")
+	var dead *utils.Node
+	for _, node := range natural {
+		if node.Id != cluster.Handler.Node.Id {
+			dead = node
+		}
+	}
+	ring.MarkDead(dead)
+
+	resp, _ := cluster.Post(t, "/insert", testWrite(messages.CONSISTENCY_QUORUM))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("write failed with %d", resp.StatusCode)
+	}
+	if hints := cluster.Hints(t); len(hints) != 1 || hints[dead.Id] != 1 {
+		t.Fatalf("expected a hint for node %d only, got %v", dead.Id, hints)
+	}
+	// The node that follows the dead replica on the ring is not a replica, and must not be sent the write in its place
+	for _, replica := range cluster.Replicas {
+		expected := 0
+		if replica.Node != dead && containsReplica(natural, replica.Node) {
+			expected = 1
+		}
+		if received := replica.Received(); received != expected {
+			t.Errorf("node %d was sent %d writes, expected %d", replica.Node.Id, received, expected)
+		}
+	}
+
+	resp, _ = cluster.Post(t, "/insert", testWrite(messages.CONSISTENCY_ALL))
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected a write at ALL to fail with a dead replica, got %d", resp.StatusCode)
+	}
+}
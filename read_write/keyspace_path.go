@@ -0,0 +1,94 @@
+package read_write
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"sanddb/db"
+	"sanddb/messages"
+	"sanddb/utils"
+)
+
+func (h *Handler) HandleClientCreateKeyspaceRequest(c *fiber.Ctx) error {
+	var req messages.KeyspaceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return err
+	}
+	if err := h.validateKeyspace(req); err != nil {
+		return err
+	}
+	req.Timestamp = newWriteTimestamp()
+	if h.Storage.Keyspace(req.Name) != nil {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Keyspace %s already exists.", req.Name))
+	}
+	if err := h.broadcastSchemaChange("/db/keyspace/create", req, "creation of keyspace "+req.Name); err != nil {
+		return err
+	}
+	return c.Status(http.StatusCreated).SendString(fmt.Sprintf("Keyspace %s has been successfully created!", req.Name))
+}
+
+func (h *Handler) HandleClientAlterKeyspaceRequest(c *fiber.Ctx) error {
+	var req messages.KeyspaceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return err
+	}
+	if err := h.validateKeyspace(req); err != nil {
+		return err
+	}
+	req.Timestamp = newWriteTimestamp()
+	if h.Storage.Keyspace(req.Name) == nil {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Keyspace %s does not exist.", req.Name))
+	}
+	if err := h.broadcastSchemaChange("/db/keyspace/alter", req, "alteration of keyspace "+req.Name); err != nil {
+		return err
+	}
+	// The replicas only hold the data written from now on, a repair brings them the rest
+	return c.Status(http.StatusOK).SendString(fmt.Sprintf("Keyspace %s has been successfully altered, run a full repair to move its data to its new replicas.", req.Name))
+}
+
+func (h *Handler) HandleClientDropKeyspaceRequest(c *fiber.Ctx) error {
+	var req messages.KeyspaceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return err
+	}
+	if req.Name == messages.DEFAULT_KEYSPACE {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Keyspace %s is the default keyspace, it can not be dropped.", req.Name))
+	}
+	if h.Storage.Keyspace(req.Name) == nil {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Keyspace %s does not exist.", req.Name))
+	}
+	if err := h.broadcastSchemaChange("/db/keyspace/drop", messages.KeyspaceRequest{Name: req.Name, Timestamp: newWriteTimestamp()}, "drop of keyspace "+req.Name); err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).SendString(fmt.Sprintf("Keyspace %s has been successfully dropped!", req.Name))
+}
+
+// HandleKeyspaces returns the definition of every keyspace.
+func (h *Handler) HandleKeyspaces(c *fiber.Ctx) error {
+	body, err := json.Marshal(h.Storage.Keyspaces())
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).Send(body)
+}
+
+// validateKeyspace checks the options of a keyspace that is about to be created or altered,
+// and that the ring has enough nodes in every datacenter for its replication.
+func (h *Handler) validateKeyspace(req messages.KeyspaceRequest) error {
+	if err := db.ValidateKeyspace(req); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	strategy, _ := db.NewReplicationStrategy(req.Replication)
+	nodes := make([]*utils.Node, 0)
+	for _, node := range h.Ring.AllNodes() {
+		if h.Ring.IsNormal(node) {
+			nodes = append(nodes, node)
+		}
+	}
+	if err := strategy.CheckNodes(nodes); err != nil {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Keyspace %s can not be replicated: %s in the ring.", req.Name, err.Error()))
+	}
+	return nil
+}
+
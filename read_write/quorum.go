@@ -1,51 +1,127 @@
 package read_write
 
 import (
-	"errors"
 	"fmt"
-	"math"
+	"net/http"
+	"sanddb/db"
 	. "sanddb/messages"
-	"time"
+	"sanddb/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	ERR_UNAVAILABLE = "unavailable"
+	ERR_TIMEOUT     = "timeout"
+	ERR_FAILURE     = "failure"
 )
 
-func (h *Handler) createQuorum(requestType RequestType) error {
-	h.Responses = 0
-	//Create Request has to be replicated to all nodes, not just replicas
-	if requestType == REQUEST_CREATE {
-		h.Ring.MinVotes = int(math.Ceil(float64(len(h.Ring.Nodes) / 2)))
-	} else {
-		h.Ring.MinVotes = int(math.Ceil(float64(h.Ring.ReplicationFactor / 2)))
-	}
-	quorumChannel := make(chan PeerMessage)
-	h.QuorumChannel = quorumChannel
-	go h.collectReplies()
-	return nil
-}
-
-func (h *Handler) closeQuorum() error {
-	defer close(h.QuorumChannel)
-	time.Sleep(h.Timeout)
-	if h.Responses >= h.Ring.MinVotes {
-		fmt.Println("Quorum passed!")
-		fmt.Printf("Node %d: Number of votes received: %d\tNumber of votes required:%d\n", h.Node.Id, h.Responses, h.Ring.MinVotes)
-		return nil
-	} else {
-		fmt.Println("Insufficient Quorum")
-		fmt.Printf("Node %d: Number of votes received: %d\tNumber of votes required:%d\n", h.Node.Id, h.Responses, h.Ring.MinVotes)
-		return errors.New("insufficient ACKs")
-	}
-}
-
-func (h *Handler) collectReplies() {
-	//TODO: channel should close and node should move on once quorum is reached
-	for {
-		select {
-		case _ = <-h.QuorumChannel:
-			h.Responses++
-			fmt.Printf("Data received. Current ACKs: %d\n", h.Responses)
-		case <-time.After(h.Timeout):
-			fmt.Printf("\nTimeout\n")
-			return
+/* ConsistencyError
+Kind: unavailable if not enough replicas were alive to attempt the request, timeout if not enough of them answered in time,
+failure if too many of them failed to answer at all
+Datacenter: datacenter that does not have enough replicas alive, for LOCAL_QUORUM and EACH_QUORUM
+Alive: number of replicas that were alive when the request was received
+Received: number of replicas that answered before the timeout
+*/
+type ConsistencyError struct {
+	Kind        string           `json:"error"`
+	Consistency ConsistencyLevel `json:"consistency"`
+	Datacenter  string           `json:"datacenter,omitempty"`
+	Required    int              `json:"required"`
+	Alive       int              `json:"alive"`
+	Received    int              `json:"received"`
+}
+
+func (e *ConsistencyError) Error() string {
+	switch {
+	case e.Kind == ERR_UNAVAILABLE && e.Datacenter != "":
+		return fmt.Sprintf("cannot achieve consistency level %s: %d replicas required in datacenter %s but only %d alive", e.Consistency, e.Required, e.Datacenter, e.Alive)
+	case e.Kind == ERR_UNAVAILABLE:
+		return fmt.Sprintf("cannot achieve consistency level %s: %d replicas required but only %d alive", e.Consistency, e.Required, e.Alive)
+	case e.Kind == ERR_FAILURE:
+		return fmt.Sprintf("operation failed at consistency level %s: %d replicas required but only %d responded", e.Consistency, e.Required, e.Received)
+	}
+	return fmt.Sprintf("operation timed out at consistency level %s: %d replicas required but only %d responded", e.Consistency, e.Required, e.Received)
+}
+
+// StatusCode returns the HTTP status the error is reported to the client with.
+func (e *ConsistencyError) StatusCode() int {
+	switch e.Kind {
+	case ERR_UNAVAILABLE:
+		return http.StatusServiceUnavailable
+	case ERR_FAILURE:
+		return http.StatusBadGateway
+	}
+	return http.StatusGatewayTimeout
+}
+
+// ErrorHandler reports consistency errors to the client as JSON, and leaves every other error to fiber.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	if consistencyErr, ok := err.(*ConsistencyError); ok {
+		return c.Status(consistencyErr.StatusCode()).JSON(consistencyErr)
+	}
+	return fiber.DefaultErrorHandler(c, err)
+}
+
+// consistencyLevel returns the consistency level requested by the client, or the node's default one.
+func (h *Handler) consistencyLevel(requested ConsistencyLevel) (ConsistencyLevel, error) {
+	if requested == "" {
+		return h.DefaultConsistency, nil
+	}
+	consistency, err := ParseConsistencyLevel(string(requested))
+	if err != nil {
+		return "", fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	return consistency, nil
+}
+
+// table returns the definition of a table in the schema of this node, which tells the types of its columns and how its replicas are placed.
+func (h *Handler) table(tableName string) (*db.Table, error) {
+	table := h.Storage.GetSchema(tableName)
+	if table == nil {
+		return nil, fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Table %s does not exist.", tableName))
+	}
+	return table, nil
+}
+
+// replicaNodes returns the alive natural replicas of a partition, starting with the node that owns it.
+// A dead replica is not replaced by the next node of the ring, which would only hold data that no read looks for:
+// it counts as missing for the consistency level, and writes keep a hint for it instead, see hintDeadReplicas.
+func (h *Handler) replicaNodes(strategy utils.ReplicationStrategy, partitionKey string) []*utils.Node {
+	return h.aliveReplicas(h.Ring.NaturalReplicas(strategy, partitionKey))
+}
+
+// tokenReplicas returns the alive natural replicas of the partitions with the given token, like replicaNodes.
+func (h *Handler) tokenReplicas(strategy utils.ReplicationStrategy, token int64) []*utils.Node {
+	return h.aliveReplicas(h.Ring.NaturalReplicasForToken(strategy, token))
+}
+
+func (h *Handler) aliveReplicas(nodes []*utils.Node) []*utils.Node {
+	replicas := make([]*utils.Node, 0, len(nodes))
+	seen := make(map[int]bool)
+	for _, node := range nodes {
+		if seen[node.Id] || !h.Ring.IsAlive(node) {
+			continue
+		}
+		seen[node.Id] = true
+		replicas = append(replicas, node)
+	}
+	return replicas
+}
+
+// missedReplicas returns the replicas that did not acknowledge a write, either because they failed or because they did not answer in time.
+func missedReplicas(replicas []*utils.Node, responses []replicaResponse) []*utils.Node {
+	acked := make(map[int]bool)
+	for _, resp := range responses {
+		if resp.Err == nil {
+			acked[resp.Node.Id] = true
+		}
+	}
+	missed := make([]*utils.Node, 0)
+	for _, node := range replicas {
+		if !acked[node.Id] {
+			missed = append(missed, node)
 		}
 	}
+	return missed
 }
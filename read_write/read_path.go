@@ -1,7 +1,7 @@
 package read_write
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -9,6 +9,7 @@ import (
 	"sanddb/db"
 	"sanddb/messages"
 	"sanddb/utils"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -25,89 +26,87 @@ func (h *Handler) HandleClientReadRequest(c *fiber.Ctx) error {
 	fmt.Println("=============================================")
 
 	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	req.TableName = messages.CanonicalTableName(req.TableName)
+	table, err := h.table(req.TableName)
+	if err != nil {
 		return err
 	}
+	if err = table.ValidateRead(&req); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
 	partitionKeyConcat := ""
 	// Look for the receiverNode
 	for _, partitionKey := range req.PartitionKeyValues {
 		partitionKeyConcat += partitionKey
 	}
-	req.HashedPK = utils.GetHash(partitionKeyConcat)
+	if err := h.Ring.Partitioner.ValidateKey(partitionKeyConcat); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	req.HashedPK = h.Ring.Token(partitionKeyConcat)
 
-	receiverNode := h.Ring.GetNode(partitionKeyConcat)
-	fmt.Printf("Routing request to receiverNode %d at position %d...\n", receiverNode.Id, receiverNode.Hash)
-	err := h.createQuorum(messages.REQUEST_READ)
+	consistency, err := h.consistencyLevel(req.Consistency)
 	if err != nil {
-		fmt.Printf("Error in creating quorum: %s", err.Error())
 		return err
 	}
-	responses := make([]db.ReadResponse, 0)
-	response, err := h.sendReadRequest(receiverNode, req)
-	if err != nil {
-		fmt.Printf("Error in sending coordinator request: %s", err.Error())
-		return err
+	if consistency == messages.CONSISTENCY_ANY {
+		return fiber.NewError(http.StatusBadRequest, "consistency level ANY is only supported for writes.")
 	}
-	responses = append(responses, response)
-	fmt.Printf("Ring replication factor is %d.\n", h.Ring.ReplicationFactor)
 
-	replicas := h.Ring.Replicate(partitionKeyConcat)
-	fmt.Printf("Replica content: %v\n", replicas)
-
-	for _, receivingNode := range replicas {
-		fmt.Println("Sending request to node", receivingNode.Id)
-		response, err = h.sendReadRequest(receivingNode, req)
-		if err != nil {
-			fmt.Printf("Error sending read request: %s", err.Error())
-			return err
-		}
-		responses = append(responses, response)
+	strategy := table.Strategy(h.Ring.Strategy)
+	replicas := h.replicaNodes(strategy, partitionKeyConcat)
+	fmt.Printf("Table replication factor is %d.\n", strategy.ReplicationFactor())
+	if table.ReadsSlice(&req) {
+		return h.readSlice(c, table, req, consistency, strategy, replicas)
 	}
-	err = h.closeQuorum()
+	co, err := h.newCoordinator(consistency, replicas, strategy)
 	if err != nil {
-		fmt.Printf("Closing quorum error: %s", err.Error())
 		return err
 	}
-	latestVersion := &db.Row{}
-	if len(responses) > 1 {
-		// fmt.Println("Data collected from nodes:", node.DataStore)
-		for _, resp := range responses {
-			if latestVersion.ClusteringKeyHash == 0 || resp.Row.UpdatedAt.UnixNano() > latestVersion.UpdatedAt.UnixNano() {
-				latestVersion.CreatedAt = resp.Row.CreatedAt
-				latestVersion.UpdatedAt = resp.Row.UpdatedAt
-				latestVersion.DeletedAt = resp.Row.DeletedAt
-				latestVersion.ClusteringKeyHash = resp.Row.ClusteringKeyHash
-				latestVersion.ClusteringKeyValues = resp.Row.ClusteringKeyValues
-				latestVersion.Cells = resp.Row.Cells
-			}
-		}
-		cellNames := make([]string, 0)
-		cellValues := make([]string, 0)
-		for _, cell := range latestVersion.Cells {
-			cellNames = append(cellNames, cell.Name)
-			cellValues = append(cellValues, cell.Value)
-		}
-		writeReq := messages.WriteRequest{
-			TableName:           req.TableName,
-			PartitionKeyValues:  req.PartitionKeyValues,
-			ClusteringKeyValues: req.ClusteringKeyValues,
-			CellNames:           cellNames,
-			CellValues:          cellValues,
-			Type:                messages.READ_REPAIR,
+	fmt.Printf("Routing request to receiverNode %d...\n", replicas[0].Id)
+	co.FanOut(func(ctx context.Context, receivingNode *utils.Node) replicaResponse {
+		fmt.Printf("Request %d: Sending request to node %d\n", co.ID, receivingNode.Id)
+		response, err := h.sendReadRequest(ctx, receivingNode, req)
+		return replicaResponse{Node: receivingNode, Read: response, Err: err}
+	})
+	received, err := co.Await()
+	if err != nil {
+		fmt.Printf("Request %d: Closing quorum error: %s\n", co.ID, err.Error())
+		co.Cancel()
+		return err
+	}
+
+	// Replicas that answered in time are repaired before the client gets the row, so that a following read sees it
+	versions := make(map[int]*db.Row)
+	var latestVersion *db.Row
+	for _, resp := range received {
+		if resp.Err == nil {
+			versions[resp.Node.Id] = resp.Read.Row
+			latestVersion = db.MergeRows(latestVersion, resp.Read.Row)
 		}
-		for _, resp := range responses {
-			if resp.Row.UpdatedAt.UnixNano() < latestVersion.UpdatedAt.UnixNano() {
-				fmt.Printf("Sending read repair to node %d\n", resp.SourceNode.Id)
-				if err = h.sendWriteRequest(resp.SourceNode, writeReq); err != nil {
-					return err
-				}
-			}
+	}
+	for _, resp := range received {
+		if resp.Err == nil && latestVersion != nil && !db.SameRow(resp.Read.Row, latestVersion) {
+			fmt.Printf("Request %d: Sending read repair to node %d\n", co.ID, resp.Node.Id)
+			h.repairReplica(co.ctx, co, resp.Node, req.TableName, readRepairPartition(req, latestVersion))
+			versions[resp.Node.Id] = latestVersion
 		}
-	} else {
-		fmt.Println("No data received from nodes")
-		return fiber.NewError(http.StatusInternalServerError, "Read fail: Insufficient responses for Quorum")
 	}
+	// The others are repaired in the background once they answer, which may also bring a newer version to light
+	co.AwaitLate(func(late []replicaResponse) {
+		h.repairLateReplicas(co, req, replicas, versions, late)
+	})
 
-	body, err := json.Marshal(latestVersion)
+	// Deleted rows and cells are only kept around as tombstones to be reconciled, the client never sees them
+	if latestVersion == nil {
+		return fiber.NewError(http.StatusNotFound, "Row not found.")
+	}
+	liveRow := table.LiveView(latestVersion, time.Now())
+	if liveRow == nil {
+		return fiber.NewError(http.StatusNotFound, "Row not found.")
+	}
+	body, err := json.Marshal(table.TypedRow(liveRow))
 	if err != nil {
 		fmt.Printf("Error in marshalling response: %s", err.Error())
 		return err
@@ -116,37 +115,105 @@ func (h *Handler) HandleClientReadRequest(c *fiber.Ctx) error {
 	return nil
 }
 
-func (h *Handler) sendReadRequest(receivingNode *utils.Node, req messages.ReadRequest) (db.ReadResponse, error) {
-	readResponse := db.ReadResponse{}
-	body, err := json.Marshal(req)
+// repairLateReplicas reconciles the versions of a row held by the replicas that answered a read after the coordinator replied,
+// and repairs every replica whose version, early or late, differs from the result.
+func (h *Handler) repairLateReplicas(co *Coordinator, req messages.ReadRequest, replicas []*utils.Node, versions map[int]*db.Row, late []replicaResponse) {
+	answered := 0
+	for _, resp := range late {
+		if resp.Err != nil {
+			fmt.Printf("Error in late read request to node %d: %s\n", resp.Node.Id, resp.Err.Error())
+			continue
+		}
+		versions[resp.Node.Id] = resp.Read.Row
+		answered++
+	}
+	if answered == 0 {
+		return
+	}
+	var latestVersion *db.Row
+	for _, row := range versions {
+		latestVersion = db.MergeRows(latestVersion, row)
+	}
+	if latestVersion == nil {
+		return
+	}
+	for _, node := range replicas {
+		row, ok := versions[node.Id]
+		if !ok || db.SameRow(row, latestVersion) {
+			continue
+		}
+		fmt.Printf("Request %d: Sending read repair to node %d\n", co.ID, node.Id)
+		h.repairLateReplica(co, node, req.TableName, readRepairPartition(req, latestVersion))
+	}
+}
+
+// readRepairPartition holds the latest version of a row, which is either a tombstone or live data, to bring a stale replica up to date with.
+// The version is written as is, so that the replica ends up with the same timestamps as the others.
+func readRepairPartition(req messages.ReadRequest, latestVersion *db.Row) *db.Partition {
+	return &db.Partition{
+		Metadata: &db.PartitionMetadata{
+			PartitionKey:       req.HashedPK,
+			PartitionKeyValues: req.PartitionKeyValues,
+		},
+		Rows: []*db.Row{latestVersion},
+	}
+}
+
+// repairReplica writes the versions of rows and tombstones of a partition that a stale replica is missing, within ctx.
+// The read has already met its consistency level, so a repair that fails does not fail it: the repair is hinted to the replica instead.
+func (h *Handler) repairReplica(ctx context.Context, co *Coordinator, node *utils.Node, tableName string, partition *db.Partition) {
+	if err := h.sendRepair(ctx, node, tableName, partition); err != nil {
+		fmt.Printf("Request %d: Error in read repair of node %d: %s\n", co.ID, node.Id, err.Error())
+		h.storeHints(co, []*utils.Node{node}, db.Hint{Repair: &db.RepairRequest{TableName: tableName, Partition: partition}})
+	}
+}
+
+// repairLateReplica is repairReplica for the repairs sent once the client has its answer, each of which is given the timeout of the node.
+func (h *Handler) repairLateReplica(co *Coordinator, node *utils.Node, tableName string, partition *db.Partition) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.Timeout)
+	defer cancel()
+	h.repairReplica(ctx, co, node, tableName, partition)
+}
+
+// sendRepair writes the versions of rows and tombstones of a partition to a replica as they are.
+func (h *Handler) sendRepair(ctx context.Context, node *utils.Node, tableName string, partition *db.Partition) error {
+	repairReq := db.RepairRequest{
+		TableName: tableName,
+		Partition: partition,
+	}
+	response, err := postJSON(ctx, node.IPAddress+node.Port+"/db/repair", repairReq)
 	if err != nil {
-		fmt.Printf("Error marshalling read request: %s", err.Error())
-		return readResponse, err
+		fmt.Printf("Error in posting read repair: %s", err.Error())
+		return err
 	}
-	postBody := bytes.NewBuffer(body)
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		jsonResponse, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("read repair of node %d failed: %s", node.Id, string(jsonResponse))
+	}
+	return nil
+}
 
-	response, err := http.Post(receivingNode.IPAddress+receivingNode.Port+"/db/read", "application/json", postBody)
+func (h *Handler) sendReadRequest(ctx context.Context, receivingNode *utils.Node, req messages.ReadRequest) (db.ReadResponse, error) {
+	readResponse := db.ReadResponse{}
+	response, err := postJSON(ctx, receivingNode.IPAddress+receivingNode.Port+"/db/read", req)
 	if err != nil {
-		fmt.Printf("Error posting read request: %s", err.Error())
+		fmt.Printf("Error posting read request: %s\n", err.Error())
 		return readResponse, err
 	}
 	defer response.Body.Close()
-	if response.StatusCode == http.StatusOK {
+	if response.StatusCode == http.StatusNotFound {
+		// The replica does not hold the row, which still counts as an answer
+		return db.ReadResponse{SourceNode: receivingNode}, nil
+	} else if response.StatusCode == http.StatusOK {
 		jsonResponse, err := ioutil.ReadAll(response.Body)
 		err = json.Unmarshal([]byte(jsonResponse), &readResponse)
 		if err != nil {
 			return db.ReadResponse{}, err
 		}
-		reply := messages.PeerMessage{
-			Type:     messages.READ_OK,
-			Content:  "1",
-			SourceID: readResponse.SourceNode.Id,
-		}
-		h.QuorumChannel <- reply
 		return readResponse, nil
 	} else {
-		errMsg := fmt.Sprintf("Row not found.")
-		err = fiber.NewError(http.StatusBadRequest, errMsg)
-		return db.ReadResponse{}, err
+		// Pass validation errors of the replica on to the client
+		return db.ReadResponse{}, replicaError(response)
 	}
 }
@@ -0,0 +1,348 @@
+package read_write
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sanddb/db"
+	"sanddb/messages"
+	"sanddb/utils"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// scanPosition is a row of a scan along with its partition. A scan goes through the partitions in token order, and through the rows of each one in clustering order.
+type scanPosition struct {
+	Partition *db.PartitionMetadata
+	Row       *db.Row
+}
+
+// HandleClientScanRequest coordinates a scan of the rows of a table, or of the ones whose token(pk) is in a given range, a page at a time if the client asks for it.
+// The range is cut at the tokens of the nodes, and each part is read from its own replicas at the consistency level of the scan, one after the other,
+// so that rows come back in token order. Clients can split the ring into ranges of their own to scan them in parallel.
+func (h *Handler) HandleClientScanRequest(c *fiber.Ctx) error {
+	var req messages.ScanRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	req.TableName = messages.CanonicalTableName(req.TableName)
+	table, err := h.table(req.TableName)
+	if err != nil {
+		return err
+	}
+	consistency, err := h.consistencyLevel(req.Consistency)
+	if err != nil {
+		return err
+	}
+	if consistency == messages.CONSISTENCY_ANY {
+		return fiber.NewError(http.StatusBadRequest, "consistency level ANY is only supported for writes.")
+	}
+	start, end := h.Ring.Partitioner.MinToken(), h.Ring.Partitioner.MaxToken()
+	if req.StartToken != nil {
+		start = *req.StartToken
+	}
+	if req.EndToken != nil {
+		end = *req.EndToken
+	}
+	if err = table.ValidateScan(&req, start, end); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	cursor, _ := messages.DecodePagingState(req.PagingState)
+
+	strategy := table.Strategy(h.Ring.Strategy)
+	rows := make([]*scanPosition, 0)
+	for _, tokenRange := range h.Ring.SplitRange(start, end) {
+		if req.PageSize > 0 && len(rows) >= req.PageSize {
+			break
+		}
+		if cursor != nil && cursor.Token > tokenRange.End {
+			continue
+		}
+		// The partitions of the range all have the same replicas as its last token
+		replicas := h.tokenReplicas(strategy, tokenRange.End)
+		rangeStart, rangeEnd := tokenRange.Start, tokenRange.End
+		for req.PageSize == 0 || len(rows) < req.PageSize {
+			round := messages.ScanRequest{
+				TableName:  req.TableName,
+				StartToken: &rangeStart,
+				EndToken:   &rangeEnd,
+				Where:      req.Where,
+			}
+			if req.PageSize > 0 {
+				round.PageSize = req.PageSize - len(rows)
+			}
+			if cursor != nil && cursor.Token > rangeStart {
+				round.PagingState = cursor.Encode()
+			}
+			roundRows, frontier, err := h.scanRound(table, round, consistency, strategy, replicas)
+			if err != nil {
+				return err
+			}
+			rows = append(rows, roundRows...)
+			if frontier == nil {
+				break
+			}
+			cursor = &messages.PagingState{Token: frontier.Partition.PartitionKey, ClusteringKeyValues: frontier.Row.ClusteringKeyValues}
+		}
+	}
+
+	result := &ScanResult{Rows: make([]*ScanRow, 0, len(rows))}
+	if req.PageSize > 0 && len(rows) >= req.PageSize {
+		rows = rows[:req.PageSize]
+		last := rows[len(rows)-1]
+		result.PagingState = (&messages.PagingState{Token: last.Partition.PartitionKey, ClusteringKeyValues: last.Row.ClusteringKeyValues}).Encode()
+	}
+	for _, row := range rows {
+		result.Rows = append(result.Rows, &ScanRow{
+			Token:              row.Partition.PartitionKey,
+			PartitionKeyValues: table.TypedPartitionKeys(row.Partition.PartitionKeyValues),
+			TypedRow:           table.TypedRow(row.Row),
+		})
+	}
+	body, err := json.Marshal(result)
+	if err != nil {
+		fmt.Printf("Error in marshalling response: %s", err.Error())
+		return err
+	}
+	return c.Status(http.StatusOK).Send(body)
+}
+
+// scanRound reads a token range from its replicas once, repairs the partitions whose versions differ, and returns their live rows in scan order.
+// As for slices, the rows are only complete up to the earliest of the last rows of the replicas that returned as many rows as they were asked for,
+// which is returned as the frontier of the round.
+func (h *Handler) scanRound(table *db.Table, req messages.ScanRequest, consistency messages.ConsistencyLevel, strategy utils.ReplicationStrategy, replicas []*utils.Node) ([]*scanPosition, *scanPosition, error) {
+	co, err := h.newCoordinator(consistency, replicas, strategy)
+	if err != nil {
+		return nil, nil, err
+	}
+	co.FanOut(func(ctx context.Context, receivingNode *utils.Node) replicaResponse {
+		fmt.Printf("Request %d: Sending scan of tokens %d to %d to node %d\n", co.ID, *req.StartToken, *req.EndToken, receivingNode.Id)
+		response, err := h.sendScanRequest(ctx, receivingNode, req)
+		return replicaResponse{Node: receivingNode, Scan: response, Err: err}
+	})
+	received, err := co.Await()
+	if err != nil {
+		fmt.Printf("Request %d: Closing quorum error: %s\n", co.ID, err.Error())
+		co.Cancel()
+		return nil, nil, err
+	}
+
+	versions := make(map[int][]*db.Partition)
+	answers := make([][]*db.Partition, 0, len(received))
+	for _, resp := range received {
+		if resp.Err == nil {
+			versions[resp.Node.Id] = resp.Scan.Partitions
+			answers = append(answers, resp.Scan.Partitions)
+		}
+	}
+	frontier := scanFrontier(answers, nil, req.PageSize)
+	merged := mergeScans(versions, frontier)
+	if len(req.Where) > 0 {
+		if err = h.fetchFilteredRows(co, req, received, versions, merged); err != nil {
+			co.Cancel()
+			return nil, nil, err
+		}
+		merged = mergeScans(versions, frontier)
+	}
+	for _, resp := range received {
+		if resp.Err != nil {
+			continue
+		}
+		for _, diff := range scanDiffs(merged, versions[resp.Node.Id]) {
+			fmt.Printf("Request %d: Sending read repair of %d rows and %d tombstones of partition %d to node %d\n", co.ID, len(diff.Rows), len(diff.Tombstones), diff.Metadata.PartitionKey, resp.Node.Id)
+			h.repairReplica(co.ctx, co, resp.Node, req.TableName, diff)
+		}
+		versions[resp.Node.Id] = merged
+	}
+	co.AwaitLate(func(late []replicaResponse) {
+		h.repairLateScans(co, req, replicas, versions, frontier, late)
+	})
+
+	now := time.Now()
+	rows := make([]*scanPosition, 0)
+	for _, partition := range merged {
+		for _, row := range table.LiveRows(partition, now) {
+			if len(req.Where) == 0 || table.Matches(row, req.Where) {
+				rows = append(rows, &scanPosition{Partition: partition.Metadata, Row: row})
+			}
+		}
+	}
+	return rows, frontier, nil
+}
+
+// fetchFilteredRows reads, from every replica that answered a scan of an index, the rows that the others returned but it left out, and adds them to its version.
+// A replica leaves out the rows that do not hold the value in its own version of them, which may be the latest one,
+// so without it a row could be returned with a value that it no longer holds. This is what Cassandra calls replica filtering protection.
+func (h *Handler) fetchFilteredRows(co *Coordinator, req messages.ScanRequest, received []replicaResponse, versions map[int][]*db.Partition, merged []*db.Partition) error {
+	for _, resp := range received {
+		if resp.Err != nil {
+			continue
+		}
+		held := make(map[int64]*db.Partition)
+		for _, partition := range versions[resp.Node.Id] {
+			held[partition.Metadata.PartitionKey] = partition
+		}
+		for _, partition := range merged {
+			version := held[partition.Metadata.PartitionKey]
+			for _, row := range partition.Rows {
+				if version != nil && version.HasRow(row.ClusteringKeyHash) {
+					continue
+				}
+				read, err := h.sendReadRequest(co.ctx, resp.Node, messages.ReadRequest{
+					TableName:           req.TableName,
+					PartitionKeyValues:  partition.Metadata.PartitionKeyValues,
+					HashedPK:            partition.Metadata.PartitionKey,
+					ClusteringKeyValues: row.ClusteringKeyValues,
+				})
+				if err != nil {
+					return err
+				}
+				if read.Row == nil {
+					continue
+				}
+				fmt.Printf("Request %d: Node %d filtered out a version of row %v of partition %d\n", co.ID, resp.Node.Id, row.ClusteringKeyValues, partition.Metadata.PartitionKey)
+				if version == nil {
+					version = &db.Partition{Metadata: partition.Metadata, Rows: make([]*db.Row, 0)}
+					held[partition.Metadata.PartitionKey] = version
+					versions[resp.Node.Id] = append(versions[resp.Node.Id], version)
+				}
+				version.Rows = append(version.Rows, read.Row)
+			}
+		}
+	}
+	return nil
+}
+
+// repairLateScans does for scans what repairLateSlices does for slices.
+func (h *Handler) repairLateScans(co *Coordinator, req messages.ScanRequest, replicas []*utils.Node, versions map[int][]*db.Partition, frontier *scanPosition, late []replicaResponse) {
+	answers := make([][]*db.Partition, 0, len(late))
+	for _, resp := range late {
+		if resp.Err != nil {
+			fmt.Printf("Error in late scan request to node %d: %s\n", resp.Node.Id, resp.Err.Error())
+			continue
+		}
+		versions[resp.Node.Id] = resp.Scan.Partitions
+		answers = append(answers, resp.Scan.Partitions)
+	}
+	if len(answers) == 0 {
+		return
+	}
+	frontier = scanFrontier(answers, frontier, req.PageSize)
+	merged := mergeScans(versions, frontier)
+	for _, node := range replicas {
+		partitions, ok := versions[node.Id]
+		if !ok {
+			continue
+		}
+		for _, diff := range scanDiffs(merged, partitions) {
+			fmt.Printf("Request %d: Sending read repair of %d rows and %d tombstones of partition %d to node %d\n", co.ID, len(diff.Rows), len(diff.Tombstones), diff.Metadata.PartitionKey, node.Id)
+			h.repairLateReplica(co, node, req.TableName, diff)
+		}
+	}
+}
+
+func (h *Handler) sendScanRequest(ctx context.Context, receivingNode *utils.Node, req messages.ScanRequest) (db.ScanResponse, error) {
+	scanResponse := db.ScanResponse{}
+	response, err := postJSON(ctx, receivingNode.IPAddress+receivingNode.Port+"/db/scan", req)
+	if err != nil {
+		fmt.Printf("Error posting scan request: %s\n", err.Error())
+		return scanResponse, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return scanResponse, replicaError(response)
+	}
+	jsonResponse, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return scanResponse, err
+	}
+	err = json.Unmarshal(jsonResponse, &scanResponse)
+	return scanResponse, err
+}
+
+// scanFrontier returns the earliest, in scan order, of frontier and of the last rows of the scans that have as many rows as were asked for.
+func scanFrontier(scans [][]*db.Partition, frontier *scanPosition, fetchSize int) *scanPosition {
+	if fetchSize == 0 {
+		return frontier
+	}
+	for _, partitions := range scans {
+		count := 0
+		var last *scanPosition
+		for _, partition := range partitions {
+			count += len(partition.Rows)
+			if len(partition.Rows) > 0 {
+				last = &scanPosition{Partition: partition.Metadata, Row: partition.Rows[len(partition.Rows)-1]}
+			}
+		}
+		if count < fetchSize || last == nil {
+			continue
+		}
+		if frontier == nil || scansBefore(last, frontier) {
+			frontier = last
+		}
+	}
+	return frontier
+}
+
+// mergeScans cuts every version of a scan down to the rows up to frontier, and reconciles the versions of each partition, which are returned in token order.
+func mergeScans(versions map[int][]*db.Partition, frontier *scanPosition) []*db.Partition {
+	byToken := make(map[int64]*db.Partition)
+	for id, partitions := range versions {
+		if frontier != nil {
+			trimmed := make([]*db.Partition, 0, len(partitions))
+			for _, partition := range partitions {
+				if partition.Metadata.PartitionKey > frontier.Partition.PartitionKey {
+					continue
+				}
+				cut := *partition
+				cut.Rows = make([]*db.Row, 0, len(partition.Rows))
+				for _, row := range partition.Rows {
+					if !scansBefore(frontier, &scanPosition{Partition: partition.Metadata, Row: row}) {
+						cut.Rows = append(cut.Rows, row)
+					}
+				}
+				trimmed = append(trimmed, &cut)
+			}
+			versions[id] = trimmed
+		}
+		for _, partition := range versions[id] {
+			token := partition.Metadata.PartitionKey
+			byToken[token] = db.MergePartitions(byToken[token], partition)
+		}
+	}
+	merged := make([]*db.Partition, 0, len(byToken))
+	for _, partition := range byToken {
+		merged = append(merged, partition)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Metadata.PartitionKey < merged[j].Metadata.PartitionKey
+	})
+	return merged
+}
+
+// scanDiffs returns what a replica that returned the given partitions of a scan needs to be repaired with, a partition at a time.
+func scanDiffs(merged []*db.Partition, partitions []*db.Partition) []*db.Partition {
+	held := make(map[int64]*db.Partition)
+	for _, partition := range partitions {
+		held[partition.Metadata.PartitionKey] = partition
+	}
+	diffs := make([]*db.Partition, 0)
+	for _, partition := range merged {
+		if diff := partition.Diff(held[partition.Metadata.PartitionKey]); diff != nil {
+			diffs = append(diffs, diff)
+		}
+	}
+	return diffs
+}
+
+// scansBefore reports whether row a comes before row b in a scan.
+func scansBefore(a *scanPosition, b *scanPosition) bool {
+	if a.Partition.PartitionKey != b.Partition.PartitionKey {
+		return a.Partition.PartitionKey < b.Partition.PartitionKey
+	}
+	return a.Row.ClusteringKey < b.Row.ClusteringKey
+}
@@ -0,0 +1,171 @@
+package read_write
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"sanddb/db"
+	"sanddb/messages"
+	"sanddb/utils"
+	"sort"
+)
+
+func (h *Handler) HandleClientAlterTableRequest(c *fiber.Ctx) error {
+	var req messages.AlterTableRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	req.TableName = messages.CanonicalTableName(req.TableName)
+	table, err := h.table(req.TableName)
+	if err != nil {
+		return err
+	}
+	if err = db.ValidateAlterTable(table, h.Storage.Views(req.TableName), req); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	req.Timestamp = newWriteTimestamp()
+	if err = h.broadcastSchemaChange("/db/alter", req, "alteration of table "+req.TableName); err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).SendString(fmt.Sprintf("Table %s has been successfully altered!", req.TableName))
+}
+
+// HandleClientCreateIndexRequest adds a secondary index to a table. Every node builds its own part of the index in the background,
+// see /index/status, and scans that use it fail on the nodes that have not built it yet.
+func (h *Handler) HandleClientCreateIndexRequest(c *fiber.Ctx) error {
+	var req messages.CreateIndexRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	req.TableName = messages.CanonicalTableName(req.TableName)
+	table, err := h.table(req.TableName)
+	if err != nil {
+		return err
+	}
+	// Names the index, so that every node gives it the same name
+	if err = db.ValidateCreateIndex(table, &req); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	req.Timestamp = newWriteTimestamp()
+	if err = h.broadcastSchemaChange("/db/index/create", req, "creation of index "+req.IndexName); err != nil {
+		return err
+	}
+	return c.Status(http.StatusCreated).SendString(fmt.Sprintf("Index %s has been successfully created on table %s, it is being built.", req.IndexName, req.TableName))
+}
+
+func (h *Handler) HandleClientDropTableRequest(c *fiber.Ctx) error {
+	var req messages.TableRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	req.TableName = messages.CanonicalTableName(req.TableName)
+	if _, err := h.table(req.TableName); err != nil {
+		return err
+	}
+	// Like in Cassandra, the views of a table have to be dropped first, views themselves are dropped like tables
+	if views := h.Storage.Views(req.TableName); len(views) > 0 {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Table %s has materialized views, %s has to be dropped first.", req.TableName, views[0].TableName))
+	}
+	req.Timestamp = newWriteTimestamp()
+	if err := h.broadcastSchemaChange("/db/drop", req, "drop of table "+req.TableName); err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).SendString(fmt.Sprintf("Table %s has been successfully dropped!", req.TableName))
+}
+
+// HandleClientTruncateRequest deletes all of the data of a table. Like in Cassandra, every node has to be alive,
+// as a node that kept the data would hand it back to the others through repairs.
+func (h *Handler) HandleClientTruncateRequest(c *fiber.Ctx) error {
+	var req messages.TableRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	req.TableName = messages.CanonicalTableName(req.TableName)
+	if _, err := h.table(req.TableName); err != nil {
+		return err
+	}
+	req.Timestamp = newWriteTimestamp()
+	if err := h.broadcast(messages.CONSISTENCY_ALL, "/db/truncate", req, "truncation of table "+req.TableName); err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).SendString(fmt.Sprintf("Table %s has been successfully truncated!", req.TableName))
+}
+
+// HandleDescribeSchema returns the schema of this node, along with the schema version of every node, similar to "nodetool describecluster".
+func (h *Handler) HandleDescribeSchema(c *fiber.Ctx) error {
+	schema := h.Storage.Schema()
+	description := &SchemaDescription{
+		Version:   schema.Version,
+		Agreement: true,
+		Nodes:     make([]*NodeSchemaVersion, 0),
+		Keyspaces: schema.Keyspaces,
+		Tables:    schema.Tables,
+	}
+	versions := h.Gossiper.SchemaVersions()
+	for _, node := range h.Ring.AllNodes() {
+		status := utils.DEAD
+		if h.Ring.IsAlive(node) {
+			status = utils.ALIVE
+			if versions[node.Id] != schema.Version {
+				description.Agreement = false
+			}
+		}
+		description.Nodes = append(description.Nodes, &NodeSchemaVersion{
+			NodeID:        node.Id,
+			Status:        status.String(),
+			SchemaVersion: versions[node.Id],
+		})
+	}
+	sort.Slice(description.Nodes, func(i, j int) bool {
+		return description.Nodes[i].NodeID < description.Nodes[j].NodeID
+	})
+	body, err := json.Marshal(description)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).Send(body)
+}
+
+// broadcastSchemaChange sends a change to the schema to every node, like the creation of a table.
+func (h *Handler) broadcastSchemaChange(path string, req interface{}, change string) error {
+	// Schema changes always need a quorum of the nodes in the ring, the others catch up by pulling the schema once they gossip
+	return h.broadcast(messages.CONSISTENCY_QUORUM, path, req, change)
+}
+
+// broadcast sends a request to every alive node, and waits for as many of them to answer as consistency requires out of every node in the ring.
+func (h *Handler) broadcast(consistency messages.ConsistencyLevel, path string, req interface{}, change string) error {
+	nodes := h.Ring.AliveNodes()
+	co, err := h.newCoordinator(consistency, nodes, &utils.SimpleStrategy{Factor: len(h.Ring.Nodes)})
+	if err != nil {
+		return err
+	}
+	co.FanOut(func(ctx context.Context, receiverNode *utils.Node) replicaResponse {
+		return replicaResponse{Node: receiverNode, Err: h.sendBroadcast(ctx, receiverNode, path, req)}
+	})
+	received, err := co.Await()
+	if _, ok := err.(*ConsistencyError); err != nil && !ok {
+		co.Cancel()
+		return err
+	}
+	co.AwaitLate(func(late []replicaResponse) {
+		for _, node := range missedReplicas(nodes, append(received, late...)) {
+			fmt.Printf("Request %d: Node %d missed the %s.\n", co.ID, node.Id, change)
+		}
+	})
+	return err
+}
+
+func (h *Handler) sendBroadcast(ctx context.Context, node *utils.Node, path string, req interface{}) error {
+	response, err := postJSON(ctx, node.IPAddress+node.Port+path, req)
+	if err != nil {
+		fmt.Printf("Error in posting %s request to node %d: %s\n", path, node.Id, err.Error())
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return replicaError(response)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package read_write
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sanddb/db"
+	"sanddb/messages"
+	"sanddb/utils"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// readSlice coordinates the read of a slice of a partition, a page at a time if the client asks for it.
+// Like in Cassandra, each replica is asked for no more rows than the page still needs, and rounds are sent until the page is full
+// or the replicas run out of rows, since rows that some replicas return may turn out to be deleted by the others.
+// Pages resume after the last row returned rather than from an offset, so they stay right whichever replicas answer and however they are repaired in between.
+func (h *Handler) readSlice(c *fiber.Ctx, table *db.Table, req messages.ReadRequest, consistency messages.ConsistencyLevel, strategy utils.ReplicationStrategy, replicas []*utils.Node) error {
+	state, err := messages.DecodePagingState(req.PagingState)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	remaining := req.Limit
+	if state != nil {
+		if state.Token != req.HashedPK {
+			return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("%s: it belongs to another partition", messages.ErrInvalidPagingState.Error()))
+		}
+		remaining = state.Remaining
+	}
+	want := req.PageSize
+	if remaining > 0 && (want == 0 || remaining < want) {
+		want = remaining
+	}
+
+	rows := make([]*db.Row, 0)
+	exhausted := false
+	for !exhausted && (want == 0 || len(rows) < want) {
+		round := req
+		round.PageSize = 0
+		if want > 0 {
+			round.PageSize = want - len(rows)
+		}
+		roundRows, frontier, err := h.readSliceRound(table, round, consistency, strategy, replicas)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, roundRows...)
+		if frontier == nil {
+			exhausted = true
+		} else {
+			req.PagingState = (&messages.PagingState{Token: req.HashedPK, ClusteringKeyValues: frontier.ClusteringKeyValues}).Encode()
+		}
+	}
+	if want > 0 && len(rows) > want {
+		rows = rows[:want]
+		exhausted = false
+	}
+
+	result := &SliceResult{Rows: make([]*db.TypedRow, 0, len(rows))}
+	for _, row := range rows {
+		result.Rows = append(result.Rows, table.TypedRow(row))
+	}
+	if !exhausted && len(rows) > 0 && (remaining == 0 || remaining > len(rows)) {
+		next := &messages.PagingState{
+			Token:               req.HashedPK,
+			ClusteringKeyValues: rows[len(rows)-1].ClusteringKeyValues,
+		}
+		if remaining > 0 {
+			next.Remaining = remaining - len(rows)
+		}
+		result.PagingState = next.Encode()
+	}
+	body, err := json.Marshal(result)
+	if err != nil {
+		fmt.Printf("Error in marshalling response: %s", err.Error())
+		return err
+	}
+	_ = c.Status(http.StatusOK).Send(body)
+	return nil
+}
+
+// readSliceRound reads a slice from the replicas once, repairs the ones whose versions of it differ, and returns its live rows in the order of the read.
+// A replica that returned as many rows as it was asked for may hold more, so the rows are only complete up to the earliest of the last rows of such replicas.
+// That row is returned as the frontier of the round, nil if every replica returned all of its rows.
+func (h *Handler) readSliceRound(table *db.Table, req messages.ReadRequest, consistency messages.ConsistencyLevel, strategy utils.ReplicationStrategy, replicas []*utils.Node) ([]*db.Row, *db.Row, error) {
+	co, err := h.newCoordinator(consistency, replicas, strategy)
+	if err != nil {
+		return nil, nil, err
+	}
+	co.FanOut(func(ctx context.Context, receivingNode *utils.Node) replicaResponse {
+		fmt.Printf("Request %d: Sending request to node %d\n", co.ID, receivingNode.Id)
+		response, err := h.sendReadRequest(ctx, receivingNode, req)
+		return replicaResponse{Node: receivingNode, Read: response, Err: err}
+	})
+	received, err := co.Await()
+	if err != nil {
+		fmt.Printf("Request %d: Closing quorum error: %s\n", co.ID, err.Error())
+		co.Cancel()
+		return nil, nil, err
+	}
+
+	versions := make(map[int]*db.Partition)
+	answers := make([]*db.Partition, 0, len(received))
+	for _, resp := range received {
+		if resp.Err == nil {
+			versions[resp.Node.Id] = resp.Read.Partition
+			answers = append(answers, resp.Read.Partition)
+		}
+	}
+	frontier := sliceFrontier(answers, nil, req.PageSize, req.Reverse)
+	latestVersion := mergeSlices(versions, frontier, req.Reverse)
+	for _, resp := range received {
+		if resp.Err != nil || latestVersion == nil {
+			continue
+		}
+		if diff := latestVersion.Diff(versions[resp.Node.Id]); diff != nil {
+			fmt.Printf("Request %d: Sending read repair of %d rows and %d tombstones to node %d\n", co.ID, len(diff.Rows), len(diff.Tombstones), resp.Node.Id)
+			h.repairReplica(co.ctx, co, resp.Node, req.TableName, diff)
+			versions[resp.Node.Id] = latestVersion
+		}
+	}
+	co.AwaitLate(func(late []replicaResponse) {
+		h.repairLateSlices(co, req, replicas, versions, frontier, late)
+	})
+
+	rows := table.LiveRows(latestVersion, time.Now())
+	if req.Reverse {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+	return rows, frontier, nil
+}
+
+// repairLateSlices does for slices what repairLateReplicas does for single rows, once the late versions are cut down to the same frontier as the others.
+func (h *Handler) repairLateSlices(co *Coordinator, req messages.ReadRequest, replicas []*utils.Node, versions map[int]*db.Partition, frontier *db.Row, late []replicaResponse) {
+	answers := make([]*db.Partition, 0, len(late))
+	for _, resp := range late {
+		if resp.Err != nil {
+			fmt.Printf("Error in late read request to node %d: %s\n", resp.Node.Id, resp.Err.Error())
+			continue
+		}
+		versions[resp.Node.Id] = resp.Read.Partition
+		answers = append(answers, resp.Read.Partition)
+	}
+	if len(answers) == 0 {
+		return
+	}
+	frontier = sliceFrontier(answers, frontier, req.PageSize, req.Reverse)
+	latestVersion := mergeSlices(versions, frontier, req.Reverse)
+	if latestVersion == nil {
+		return
+	}
+	for _, node := range replicas {
+		partition, ok := versions[node.Id]
+		if !ok {
+			continue
+		}
+		if diff := latestVersion.Diff(partition); diff != nil {
+			fmt.Printf("Request %d: Sending read repair of %d rows and %d tombstones to node %d\n", co.ID, len(diff.Rows), len(diff.Tombstones), node.Id)
+			h.repairLateReplica(co, node, req.TableName, diff)
+		}
+	}
+}
+
+// sliceFrontier returns the earliest, in the order of the read, of frontier and of the last rows of the slices that have as many rows as were asked for.
+func sliceFrontier(slices []*db.Partition, frontier *db.Row, fetchSize int, reverse bool) *db.Row {
+	if fetchSize == 0 {
+		return frontier
+	}
+	for _, slice := range slices {
+		if slice == nil || len(slice.Rows) < fetchSize {
+			continue
+		}
+		// Replicas return the rows of a slice in clustering order, whichever order it is read in
+		last := slice.Rows[len(slice.Rows)-1]
+		if reverse {
+			last = slice.Rows[0]
+		}
+		if frontier == nil || comesBefore(last, frontier, reverse) {
+			frontier = last
+		}
+	}
+	return frontier
+}
+
+// mergeSlices cuts every version of a slice down to the rows up to frontier, and reconciles them.
+func mergeSlices(versions map[int]*db.Partition, frontier *db.Row, reverse bool) *db.Partition {
+	var merged *db.Partition
+	for id, slice := range versions {
+		if slice != nil && frontier != nil {
+			trimmed := *slice
+			trimmed.Rows = make([]*db.Row, 0, len(slice.Rows))
+			for _, row := range slice.Rows {
+				if !comesBefore(frontier, row, reverse) {
+					trimmed.Rows = append(trimmed.Rows, row)
+				}
+			}
+			versions[id] = &trimmed
+		}
+		merged = db.MergePartitions(merged, versions[id])
+	}
+	return merged
+}
+
+// comesBefore reports whether row a comes before row b in a slice read in clustering order, or in reverse.
+func comesBefore(a *db.Row, b *db.Row, reverse bool) bool {
+	if reverse {
+		return a.ClusteringKey > b.ClusteringKey
+	}
+	return a.ClusteringKey < b.ClusteringKey
+}
@@ -1,18 +1,72 @@
 package read_write
 
 import (
+	"encoding/json"
+	"sanddb/db"
+	"sanddb/gossip"
 	"sanddb/messages"
 	"sanddb/utils"
 	"time"
 )
 
+
 type Handler struct {
 	//Request       *Request
-	Node          *utils.Node
-	Ring          *utils.Ring
-	Timeout       time.Duration
-	QuorumChannel chan messages.PeerMessage
-	Responses     int
+	Node               *utils.Node
+	Ring               *utils.Ring
+	Timeout            time.Duration
+	DefaultConsistency messages.ConsistencyLevel
+	Hints              *db.HintStore
+	// Storage holds the schema, which tells how the replicas of every table are placed
+	Storage  *db.StorageEngine
+	Gossiper *gossip.Gossiper
+}
+
+/* SliceResult
+Rows: live rows of a slice of a partition, in clustering order unless the read is reversed
+PagingState: where the page stopped, to be sent back to read the next page, empty once every row has been returned
+*/
+type SliceResult struct {
+	Rows        []*db.TypedRow `json:"rows"`
+	PagingState string         `json:"paging_state,omitempty"`
+}
+
+/* ScanResult
+Rows: live rows of the scanned token range, in token order and in clustering order within each partition
+PagingState: where the page stopped, to be sent back to read the next page, empty once every row has been returned
+*/
+type ScanResult struct {
+	Rows        []*ScanRow `json:"rows"`
+	PagingState string     `json:"paging_state,omitempty"`
+}
+
+/* ScanRow
+Token: token of the partition of the row, i.e. token(pk)
+PartitionKeyValues: partition keys of the row
+*/
+type ScanRow struct {
+	Token              int64             `json:"token"`
+	PartitionKeyValues []json.RawMessage `json:"partition_key_values"`
+	*db.TypedRow
+}
+
+/* SchemaDescription
+Version: schema version of this node
+Agreement: whether every alive node has the same schema version as this node
+Nodes: schema version of every node of the ring, as last gossiped
+*/
+type SchemaDescription struct {
+	Version   string               `json:"version"`
+	Agreement bool                 `json:"agreement"`
+	Nodes     []*NodeSchemaVersion `json:"nodes"`
+	Keyspaces []*db.Keyspace       `json:"keyspaces"`
+	Tables    db.LocalData         `json:"tables"`
+}
+
+type NodeSchemaVersion struct {
+	NodeID        int    `json:"node_id"`
+	Status        string `json:"status"`
+	SchemaVersion string `json:"schema_version"`
 }
 
 //Request means message from client
@@ -0,0 +1,92 @@
+package read_write
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sanddb/db"
+	"sanddb/messages"
+	"sanddb/utils"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleClientCreateViewRequest creates a materialized view of a table, which every node fills in the background with the rows of the base table it holds.
+// From then on, the replicas of the base table keep the view up to date with every write they apply.
+func (h *Handler) HandleClientCreateViewRequest(c *fiber.Ctx) error {
+	var req messages.CreateViewRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	req.ViewName = messages.CanonicalTableName(req.ViewName)
+	req.BaseTable = messages.CanonicalTableName(req.BaseTable)
+	base, err := h.table(req.BaseTable)
+	if err != nil {
+		return err
+	}
+	if h.Storage.GetSchema(req.ViewName) != nil {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Table %s already exists.", req.ViewName))
+	}
+	request, err := db.NewViewRequest(base, req)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	if err = h.createTable(request); err != nil {
+		return err
+	}
+	return c.Status(http.StatusCreated).SendString(fmt.Sprintf("Materialized view %s of table %s has been successfully created, it is being built.", req.ViewName, req.BaseTable))
+}
+
+// WriteView delivers rows of a materialized view changed by a write that this node applied to the base table.
+// Like in Cassandra, every replica of the base partition sends the rows to a single replica of the view partition, the one at the same place among the replicas,
+// so that each replica of the view gets every change once. Replicas of the view that can not be reached are hinted.
+func (h *Handler) WriteView(update *db.ViewUpdate) {
+	view := h.Storage.GetSchema(update.ViewName)
+	base := h.Storage.GetSchema(update.BaseTable)
+	if view == nil || base == nil {
+		return
+	}
+	partitionKey := strings.Join(update.Partition.Metadata.PartitionKeyValues, "")
+	update.Partition.Metadata.PartitionKey = h.Ring.Token(partitionKey)
+	strategy := view.Strategy(h.Ring.Strategy)
+	targets := h.pairedViewReplicas(base.Strategy(h.Ring.Strategy), update.BasePartitionKey, strategy, partitionKey)
+	targets = append(targets, h.Ring.PendingReplicas(strategy, partitionKey)...)
+	for _, node := range targets {
+		var err error
+		if node.Id == h.Node.Id {
+			err = h.Storage.WritePartition(update.ViewName, update.Partition)
+		} else if h.Ring.IsAlive(node) {
+			ctx, cancel := context.WithTimeout(context.Background(), h.Timeout)
+			err = h.sendRepair(ctx, node, update.ViewName, update.Partition)
+			cancel()
+		} else {
+			err = fmt.Errorf("node %d is dead", node.Id)
+		}
+		if err == nil {
+			continue
+		}
+		fmt.Printf("Error in writing %d rows of materialized view %s to node %d: %s\n", len(update.Partition.Rows), update.ViewName, node.Id, err.Error())
+		hint := &db.Hint{
+			Target:    node.Id,
+			CreatedAt: db.EpochTime(time.Now()),
+			View:      &db.RepairRequest{TableName: update.ViewName, Partition: update.Partition},
+		}
+		if err = h.Hints.Store(hint); err != nil {
+			fmt.Printf("Error in storing hint for node %d: %s\n", node.Id, err.Error())
+		}
+	}
+}
+
+// pairedViewReplicas returns the replica of a view partition paired with this node as a replica of the base partition.
+// A node that is not a replica of the base partition, e.g. one that is still streaming it, has no pair and writes to every replica of the view partition.
+func (h *Handler) pairedViewReplicas(baseStrategy utils.ReplicationStrategy, baseToken int64, viewStrategy utils.ReplicationStrategy, partitionKey string) []*utils.Node {
+	viewReplicas := h.Ring.NaturalReplicas(viewStrategy, partitionKey)
+	for i, node := range h.Ring.NaturalReplicasForToken(baseStrategy, baseToken) {
+		if node.Id == h.Node.Id && i < len(viewReplicas) {
+			return []*utils.Node{viewReplicas[i]}
+		}
+	}
+	return viewReplicas
+}
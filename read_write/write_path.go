@@ -1,14 +1,16 @@
 package read_write
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"io/ioutil"
 	"net/http"
+	"sanddb/db"
 	"sanddb/messages"
 	"sanddb/utils"
+	"time"
 )
 
 //TODO: HandleClientWriteRequest to take fiber context as an argument
@@ -18,82 +20,117 @@ func (h *Handler) HandleClientWriteRequest(c *fiber.Ctx) error {
 	)
 	fmt.Printf("Request received from client by receiverNode %d.\n", h.Node.Id)
 	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	// Tables are addressed as keyspace.table, the tables of the default keyspace also by their name alone
+	req.TableName = messages.CanonicalTableName(req.TableName)
+	table, err := h.table(req.TableName)
+	if err != nil {
 		return err
 	}
+	// Values are checked and put in their canonical form before the partition key is hashed
+	if err = table.ValidateWrite(&req); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
 	partitionKeyConcat := ""
 	// Hash partition key sent by client
 	for _, partitionKey := range req.PartitionKeyValues {
 		partitionKeyConcat += partitionKey
 	}
-	hashedPK := utils.GetHash(partitionKeyConcat)
+	if err := h.Ring.Partitioner.ValidateKey(partitionKeyConcat); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	hashedPK := h.Ring.Token(partitionKeyConcat)
 	req.HashedPK = hashedPK
 	fmt.Printf("Partition key %s hashed to %d\n", partitionKeyConcat, hashedPK)
+	if req.Timestamp < 0 {
+		return fiber.NewError(http.StatusBadRequest, "timestamp must be a positive number of microseconds since epoch.")
+	} else if req.Timestamp == 0 {
+		// Replicas store the write with this timestamp as is, so last-write-wins does not depend on replica clocks
+		req.Timestamp = newWriteTimestamp()
+	}
+	if req.TTL < 0 {
+		return fiber.NewError(http.StatusBadRequest, "ttl must be a positive number of seconds.")
+	}
 
-	fmt.Println("Node positions (hashes) in the ring:")
-	fmt.Println(h.Ring.NodeHashes)
+	consistency, err := h.consistencyLevel(req.Consistency)
+	if err != nil {
+		return err
+	}
 
-	// Look for the receiverNode
-	receiverNode := h.Ring.GetNode(partitionKeyConcat)
-	fmt.Printf("Routing request to receiverNode %d at position %d...\n", receiverNode.Id, receiverNode.Hash)
-	go h.collectReplies()
+	strategy := table.Strategy(h.Ring.Strategy)
+	// Look for the receiverNode, the write is only sent to the alive natural replicas and the dead ones are hinted
+	natural := h.Ring.NaturalReplicas(strategy, partitionKeyConcat)
+	replicas := h.aliveReplicas(natural)
+	fmt.Printf("Table replication factor is %d.\n", strategy.ReplicationFactor())
 
-	err := h.createQuorum(messages.REQUEST_WRITE)
+	co, err := h.newCoordinator(consistency, replicas, strategy)
 	if err != nil {
 		return err
 	}
+	if len(replicas) > 0 {
+		fmt.Printf("Routing request to receiverNode %d...\n", replicas[0].Id)
+	}
 
 	req.Type = messages.COORDINATOR_WRITE
-	err = h.sendWriteRequest(receiverNode, req)
-	if err != nil {
-		fmt.Printf("Error in sending coordinator request: %s", err.Error())
+	hint := db.Hint{Write: &req}
+	hinted := h.hintDeadReplicas(co, natural, replicas, hint)
+	co.AddPending(h.Ring.PendingReplicas(strategy, partitionKeyConcat))
+	co.FanOut(func(ctx context.Context, replNode *utils.Node) replicaResponse {
+		fmt.Printf("Request %d: Replicating to node %d\n", co.ID, replNode.Id)
+		return replicaResponse{Node: replNode, Err: h.sendWriteRequest(ctx, replNode, req)}
+	})
+	received, err := co.Await()
+	if _, ok := err.(*ConsistencyError); err != nil && !ok {
+		co.Cancel()
 		return err
 	}
-	fmt.Printf("Ring replication factor is %d.\n", h.Ring.ReplicationFactor)
-
-	nodesToReplicateTo := h.Ring.Replicate(partitionKeyConcat)
-	for _, replNode := range nodesToReplicateTo {
-		fmt.Printf("Replicating to node with hash %d\n", replNode.Hash)
-		err = h.sendWriteRequest(replNode, req)
-		if err != nil {
-			fmt.Printf("Error in sending coordinator replication message: %s", err.Error())
-			return err
+	if err != nil && consistency == messages.CONSISTENCY_ANY {
+		// A stored hint is enough for ANY, so the replicas that have not acknowledged the write yet are hinted right away
+		hinted += h.storeHints(co, missedReplicas(replicas, received), hint)
+		co.Cancel()
+		if hinted > 0 {
+			return nil
 		}
-	}
-	err = h.closeQuorum()
-	if err != nil {
 		return err
 	}
+	// Replicas that did not make it in time are still waited for, to find out which of them missed the write
+	co.AwaitLate(func(late []replicaResponse) {
+		missed := missedReplicas(replicas, append(received, late...))
+		for _, node := range missed {
+			fmt.Printf("Request %d: Node %d missed the write to table %s at %d.\n", co.ID, node.Id, req.TableName, req.Timestamp)
+		}
+		h.storeHints(co, missed, hint)
+	})
+	return err
+}
 
-	return nil
+// newWriteTimestamp returns the current time in microseconds since epoch, the precision of write timestamps.
+func newWriteTimestamp() int64 {
+	return time.Now().UnixNano() / int64(time.Microsecond)
 }
-func (h *Handler) sendWriteRequest(node *utils.Node, req messages.WriteRequest) error {
+
+func (h *Handler) sendWriteRequest(ctx context.Context, node *utils.Node, req messages.WriteRequest) error {
 	var (
 		responseMsg messages.PeerMessage
 	)
-	fmt.Printf("Sending coordinator request to node with hash %d.\n", node.Hash)
-	body, err := json.Marshal(req)
+	fmt.Printf("Sending coordinator request to node %d.\n", node.Id)
+	response, err := postJSON(ctx, node.IPAddress+node.Port+"/db/insert", req)
 	if err != nil {
-		fmt.Printf("Error in marshalling coordinator request: %s", err.Error())
-		return err
-	}
-	postBody := bytes.NewBuffer(body)
-	response, err := http.Post(node.IPAddress+node.Port+"/db/insert", "application/json", postBody)
-
-	if err != nil {
-		fmt.Printf("Error in posting coordinator request: %s", err.Error())
+		fmt.Printf("Error in posting coordinator request: %s\n", err.Error())
 		return err
 	}
 	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		// Pass validation errors of the replica on to the client
+		return replicaError(response)
+	}
 	jsonResponse, err := ioutil.ReadAll(response.Body)
 	err = json.Unmarshal(jsonResponse, &responseMsg)
 	if err != nil {
 		return err
 	}
 
-	//READ_REPAIR does not require quorum
-	if req.Type == messages.COORDINATOR_WRITE {
-		h.QuorumChannel <- responseMsg
-	}
 	fmt.Printf("Successfully routed request: %s\n\n", string(jsonResponse))
 
 	return nil
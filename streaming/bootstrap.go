@@ -0,0 +1,187 @@
+package streaming
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sanddb/db"
+	"sanddb/utils"
+)
+
+// Bootstrap makes a joining node part of the ring. Once it has learnt the ring through gossip, it creates the tables
+// and announces its tokens, then streams the partitions it will replicate from the nodes that own them, and only then starts owning its tokens.
+// Writes are sent to the joining node from the moment its token is announced, so it does not miss the ones made while it streams.
+func (h *StreamHandler) Bootstrap() error {
+	fmt.Printf("Node %d is joining the ring with %d tokens.\n", h.Node.Id, len(h.Node.Tokens))
+	op, err := h.startOperation(OPERATION_BOOTSTRAP, h.Node.Id)
+	if err != nil {
+		return err
+	}
+	err = h.bootstrap(op)
+	h.finish(op, err)
+	return err
+}
+
+func (h *StreamHandler) bootstrap(op *StreamOperation) error {
+	h.Gossiper.WaitForRing()
+
+	sources := make([]*utils.Node, 0)
+	for _, node := range h.Ring.AliveNodes() {
+		if node.Id != h.Node.Id && h.Ring.IsNormal(node) {
+			sources = append(sources, node)
+		}
+	}
+	if len(sources) == 0 {
+		return errors.New("no node to bootstrap from")
+	}
+	for _, source := range sources {
+		if err := h.fetchSchema(source); err != nil {
+			return fmt.Errorf("error in fetching the schema of node %d: %s", source.Id, err.Error())
+		}
+	}
+	h.Gossiper.AnnounceTokens()
+	for _, source := range sources {
+		streamed, err := h.streamFrom(op, source)
+		if err != nil {
+			return fmt.Errorf("error in streaming from node %d: %s", source.Id, err.Error())
+		}
+		fmt.Printf("Streamed %d partitions from node %d.\n", streamed, source.Id)
+	}
+
+	if err := SaveTokens(h.TokensFile, h.Node.Tokens); err != nil {
+		return err
+	}
+	h.Gossiper.SetState(utils.STATE_NORMAL)
+	fmt.Printf("Node %d has joined the ring.\n", h.Node.Id)
+	return nil
+}
+
+// fetchSchema brings the schema of this node up to date with the one of source.
+func (h *StreamHandler) fetchSchema(source *utils.Node) error {
+	client := &http.Client{Timeout: h.Timeout}
+	response, err := client.Get(source.IPAddress + source.Port + "/internal/schema")
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	var schema db.Schema
+	if err = json.NewDecoder(response.Body).Decode(&schema); err != nil {
+		return err
+	}
+	changes, err := h.Storage.MergeSchema(&schema)
+	if err != nil {
+		return err
+	}
+	if changes > 0 {
+		fmt.Printf("Made %d changes from the schema of node %d, schema version is now %s.\n", changes, source.Id, h.Storage.SchemaVersion())
+	}
+	return nil
+}
+
+// streamFrom writes the partitions sent by source, with their timestamps preserved, one page at a time.
+func (h *StreamHandler) streamFrom(op *StreamOperation, source *utils.Node) (int, error) {
+	streamed := 0
+	var after *StreamPosition
+	for {
+		page, err := h.fetchStreamPage(source, after)
+		if err != nil {
+			return streamed, err
+		}
+		h.addPartitions(op, batchSize(page), 0)
+		written, err := h.writeStreamed(page)
+		streamed += written
+		h.addPartitions(op, 0, written)
+		if err != nil || page.Next == nil {
+			return streamed, err
+		}
+		after = page.Next
+	}
+}
+
+// fetchStreamPage asks source for the page of the partitions that this node will replicate that comes after the position where the last one ended.
+func (h *StreamHandler) fetchStreamPage(source *utils.Node, after *StreamPosition) (*StreamResponse, error) {
+	client := &http.Client{Timeout: h.Timeout}
+	body, err := json.Marshal(StreamRequest{NodeID: h.Node.Id, After: after})
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Post(source.IPAddress+source.Port+"/internal/stream", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(response.Body)
+		return nil, fmt.Errorf("%s: %s", response.Status, string(message))
+	}
+	var page StreamResponse
+	if err = json.NewDecoder(response.Body).Decode(&page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// writeStreamed writes the partitions streamed from another node, with their timestamps preserved.
+func (h *StreamHandler) writeStreamed(streamed *StreamResponse) (int, error) {
+	written := 0
+	for _, table := range streamed.Tables {
+		if table.Keyspace != nil {
+			err := h.Storage.CreateKeyspace(*table.Keyspace)
+			if err == db.ErrKeyspaceDropped {
+				fmt.Printf("Skipping streamed partitions of table %s, its keyspace has been dropped.\n", table.TableName)
+				continue
+			} else if err != nil && err != db.ErrKeyspaceExists {
+				return written, err
+			}
+		}
+		if table.Schema != nil {
+			err := h.Storage.CreateTable(*table.Schema)
+			if err == db.ErrTableDropped {
+				fmt.Printf("Skipping streamed partitions of table %s, it has been dropped.\n", table.TableName)
+				continue
+			} else if err != nil && err != db.ErrTableExists {
+				return written, err
+			}
+		}
+		for _, partition := range table.Partitions {
+			if err := h.Storage.WritePartition(table.TableName, partition); err != nil {
+				return written, err
+			}
+			written++
+		}
+	}
+	return written, nil
+}
+
+// LoadTokens returns the tokens that a node was given when it joined the ring, or an error if it never did.
+func LoadTokens(tokensFile string) ([]int64, error) {
+	content, err := ioutil.ReadFile(tokensFile)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []int64
+	if err = json.Unmarshal(content, &tokens); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no tokens in %s", tokensFile)
+	}
+	return tokens, nil
+}
+
+// SaveTokens records the tokens of a node that joined the ring, so that it keeps them, and does not bootstrap again, when restarted.
+func SaveTokens(tokensFile string, tokens []int64) error {
+	if err := os.MkdirAll(filepath.Dir(tokensFile), 0755); err != nil {
+		return err
+	}
+	content, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(tokensFile, content, 0644)
+}
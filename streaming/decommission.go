@@ -0,0 +1,267 @@
+package streaming
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sanddb/db"
+	"sanddb/gossip"
+	"sanddb/utils"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// STREAM_BATCH_PARTITIONS is the number of partitions pushed to a node per request, to stay under the body limit of its server
+const STREAM_BATCH_PARTITIONS = 100
+
+// HandleDecommission makes this node leave the ring, after streaming the ranges it replicates to the nodes that take them over.
+// It answers right away, the progress is reported by /netstats.
+func (h *StreamHandler) HandleDecommission(c *fiber.Ctx) error {
+	if state := h.Ring.State(h.Node); state != utils.STATE_NORMAL {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Node %d is %s, only a NORMAL node can be decommissioned.", h.Node.Id, state))
+	}
+	remaining := make([]*utils.Node, 0)
+	for _, node := range h.Ring.AllNodes() {
+		if node.Id != h.Node.Id && h.Ring.IsNormal(node) {
+			remaining = append(remaining, node)
+		}
+	}
+	if err := h.Ring.Strategy.CheckNodes(remaining); err != nil {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Decommissioning node %d would leave %s.", h.Node.Id, err.Error()))
+	}
+	for _, keyspace := range h.Storage.Keyspaces() {
+		if err := keyspace.Strategy(h.Ring.Strategy).CheckNodes(remaining); err != nil {
+			return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Decommissioning node %d would leave %s of keyspace %s.", h.Node.Id, err.Error(), keyspace.Name))
+		}
+	}
+	op, err := h.startOperation(OPERATION_DECOMMISSION, h.Node.Id)
+	if err != nil {
+		return err
+	}
+	body, err := h.marshalOperation(op)
+	if err != nil {
+		return err
+	}
+	go h.decommission(op)
+	return c.Status(http.StatusAccepted).Send(body)
+}
+
+func (h *StreamHandler) decommission(op *StreamOperation) {
+	fmt.Printf("Node %d is leaving the ring.\n", h.Node.Id)
+	h.Gossiper.SetState(utils.STATE_LEAVING)
+	// Wait for every node to also send the writes to the ranges of this node to the nodes that take them over
+	time.Sleep(gossip.RING_DELAY_ROUNDS * h.Gossiper.Interval)
+	if err := h.handOff(op, h.Node); err != nil {
+		// The node can be decommissioned again, it keeps its ranges in the meantime
+		h.Gossiper.SetState(utils.STATE_NORMAL)
+		h.finish(op, err)
+		return
+	}
+	h.Gossiper.SetState(utils.STATE_LEFT)
+	time.Sleep(gossip.RING_DELAY_ROUNDS * h.Gossiper.Interval)
+	h.Gossiper.Stop()
+	// The node has to bootstrap again if it is ever restarted
+	if err := os.Remove(h.TokensFile); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Error in removing %s: %s\n", h.TokensFile, err.Error())
+	}
+	h.finish(op, nil)
+	fmt.Printf("Node %d has been decommissioned, it can be stopped.\n", h.Node.Id)
+}
+
+// HandleRemoveNode takes a dead node out of the ring. Every node re-replicates the ranges of the removed node that it holds
+// to the nodes that take them over, and the node is only removed once they are all done. The progress is reported by /netstats.
+func (h *StreamHandler) HandleRemoveNode(c *fiber.Ctx) error {
+	var req RemoveNodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return err
+	}
+	removed := h.Ring.NodeByID(req.NodeID)
+	if removed == nil {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Node %d is not in the ring.", req.NodeID))
+	}
+	if removed.Id == h.Node.Id || h.Ring.IsAlive(removed) {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Node %d is alive, decommission it instead.", removed.Id))
+	}
+	// A removenode that failed is retried on a node that is still REMOVING
+	if state := h.Ring.State(removed); state != utils.STATE_NORMAL && state != utils.STATE_REMOVING {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Node %d is %s, only a NORMAL node can be removed.", removed.Id, state))
+	}
+	op, err := h.startOperation(OPERATION_REMOVENODE, removed.Id)
+	if err != nil {
+		return err
+	}
+	body, err := h.marshalOperation(op)
+	if err != nil {
+		return err
+	}
+	go h.removeNode(op, removed)
+	return c.Status(http.StatusAccepted).Send(body)
+}
+
+func (h *StreamHandler) removeNode(op *StreamOperation, removed *utils.Node) {
+	fmt.Printf("Removing node %d from the ring.\n", removed.Id)
+	h.Gossiper.SetNodeState(removed, utils.STATE_REMOVING)
+	// Wait for every node to know that the ranges of the removed node are being taken over, before they stream them
+	time.Sleep(gossip.RING_DELAY_ROUNDS * h.Gossiper.Interval)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+	for _, node := range h.Ring.AliveNodes() {
+		if !h.Ring.IsNormal(node) {
+			continue
+		}
+		h.setNodeStatus(op, node.Id, STREAM_RUNNING)
+		wg.Add(1)
+		go func(node *utils.Node) {
+			defer wg.Done()
+			var err error
+			if node.Id == h.Node.Id {
+				err = h.handOff(op, removed)
+			} else {
+				err = h.requestRestore(node, removed)
+			}
+			if err != nil {
+				fmt.Printf("Node %d failed to re-replicate the ranges of node %d: %s\n", node.Id, removed.Id, err.Error())
+				h.setNodeStatus(op, node.Id, STREAM_FAILED)
+				mu.Lock()
+				failed++
+				mu.Unlock()
+				return
+			}
+			h.setNodeStatus(op, node.Id, STREAM_COMPLETED)
+		}(node)
+	}
+	wg.Wait()
+	if failed > 0 {
+		h.finish(op, fmt.Errorf("%d nodes failed to re-replicate the ranges of node %d", failed, removed.Id))
+		return
+	}
+	h.Gossiper.SetNodeState(removed, utils.STATE_LEFT)
+	h.finish(op, nil)
+	fmt.Printf("Node %d has been removed from the ring.\n", removed.Id)
+}
+
+// requestRestore asks node to re-replicate the ranges of removed that it holds, and waits for it to be done.
+func (h *StreamHandler) requestRestore(node *utils.Node, removed *utils.Node) error {
+	client := &http.Client{Timeout: h.Timeout}
+	body, err := json.Marshal(RemoveNodeRequest{NodeID: removed.Id})
+	if err != nil {
+		return err
+	}
+	response, err := client.Post(node.IPAddress+node.Port+"/internal/restore", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("%s: %s", response.Status, string(message))
+	}
+	return nil
+}
+
+// HandleRestore re-replicates the ranges of a removed node that this node holds, for the node that runs the removenode.
+func (h *StreamHandler) HandleRestore(c *fiber.Ctx) error {
+	var req RemoveNodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return err
+	}
+	removed := h.Ring.NodeByID(req.NodeID)
+	if removed == nil {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Node %d is not in the ring of node %d.", req.NodeID, h.Node.Id))
+	}
+	op, err := h.startOperation(OPERATION_RESTORE, removed.Id)
+	if err != nil {
+		return err
+	}
+	err = h.handOff(op, removed)
+	h.finish(op, err)
+	if err != nil {
+		return err
+	}
+	return c.SendStatus(http.StatusOK)
+}
+
+// handOff pushes the partitions of this node to the nodes that will replicate them once leaving is out of the ring.
+func (h *StreamHandler) handOff(op *StreamOperation, leaving *utils.Node) error {
+	targets := make(map[int]*utils.Node)
+	batches := make(map[int][]*StreamResponse)
+	total := 0
+	for _, table := range h.Storage.Tables() {
+		partitions, err := h.Storage.ReadTable(table.TableName)
+		if err != nil {
+			return err
+		}
+		strategy := table.Strategy(h.Ring.Strategy)
+		for _, partition := range partitions {
+			for _, target := range h.Ring.GainedReplicas(strategy, leaving, partition.Metadata.PartitionKey) {
+				if target.Id == h.Node.Id {
+					continue
+				}
+				targets[target.Id] = target
+				batches[target.Id] = addToBatch(batches[target.Id], h.Node.Id, table, partition)
+				total++
+			}
+		}
+	}
+	h.addPartitions(op, total, 0)
+	for id, targetBatches := range batches {
+		streamed := 0
+		for _, batch := range targetBatches {
+			if err := h.pushTo(targets[id], batch); err != nil {
+				return fmt.Errorf("error in streaming to node %d: %s", id, err.Error())
+			}
+			streamed += batchSize(batch)
+			h.addPartitions(op, 0, batchSize(batch))
+		}
+		fmt.Printf("Streamed %d partitions of node %d to node %d.\n", streamed, leaving.Id, id)
+	}
+	return nil
+}
+
+// addToBatch adds a partition to the last batch of a node, or to a new one once the last is full.
+func addToBatch(batches []*StreamResponse, sourceID int, table *db.Table, partition *db.Partition) []*StreamResponse {
+	if len(batches) == 0 || batchSize(batches[len(batches)-1]) >= STREAM_BATCH_PARTITIONS {
+		batches = append(batches, &StreamResponse{SourceID: sourceID, Tables: make([]*StreamedTable, 0)})
+	}
+	batch := batches[len(batches)-1]
+	if len(batch.Tables) == 0 || batch.Tables[len(batch.Tables)-1].TableName != table.TableName {
+		schema := table.CreateRequest()
+		batch.Tables = append(batch.Tables, &StreamedTable{TableName: table.TableName, Schema: &schema, Keyspace: table.KeyspaceRequest()})
+	}
+	streamedTable := batch.Tables[len(batch.Tables)-1]
+	streamedTable.Partitions = append(streamedTable.Partitions, partition)
+	return batches
+}
+
+func batchSize(batch *StreamResponse) int {
+	size := 0
+	for _, table := range batch.Tables {
+		size += len(table.Partitions)
+	}
+	return size
+}
+
+func (h *StreamHandler) pushTo(node *utils.Node, batch *StreamResponse) error {
+	client := &http.Client{Timeout: h.Timeout}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	response, err := client.Post(node.IPAddress+node.Port+"/internal/stream/receive", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("%s: %s", response.Status, string(message))
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package streaming
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// startOperation records the progress of a new operation, unless another one is still running on this node.
+func (h *StreamHandler) startOperation(operation string, nodeID int) (*StreamOperation, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.operation != nil && h.operation.Status == STREAM_RUNNING {
+		return nil, fiber.NewError(http.StatusConflict, fmt.Sprintf("Node %d is already running a %s of node %d.", h.Node.Id, h.operation.Operation, h.operation.NodeID))
+	}
+	h.operation = &StreamOperation{
+		Operation: operation,
+		NodeID:    nodeID,
+		Status:    STREAM_RUNNING,
+		StartedAt: time.Now(),
+	}
+	return h.operation, nil
+}
+
+func (h *StreamHandler) addPartitions(op *StreamOperation, total int, streamed int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	op.PartitionsTotal += total
+	op.PartitionsStreamed += streamed
+}
+
+func (h *StreamHandler) setNodeStatus(op *StreamOperation, nodeID int, status string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if op.Nodes == nil {
+		op.Nodes = make(map[int]string)
+	}
+	op.Nodes[nodeID] = status
+}
+
+// finish records the end of op, which failed if err is not nil.
+func (h *StreamHandler) finish(op *StreamOperation, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	op.FinishedAt = &now
+	op.Status = STREAM_COMPLETED
+	if err != nil {
+		op.Status = STREAM_FAILED
+		op.Error = err.Error()
+		fmt.Printf("%s of node %d failed: %s\n", op.Operation, op.NodeID, err.Error())
+	}
+}
+
+// marshalOperation encodes op while no stream is updating it.
+func (h *StreamHandler) marshalOperation(op *StreamOperation) ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return json.Marshal(op)
+}
+
+// HandleNetStats reports the state of this node in the ring and the progress of its last bootstrap, decommission or removenode, similar to "nodetool netstats".
+func (h *StreamHandler) HandleNetStats(c *fiber.Ctx) error {
+	h.mu.Lock()
+	stats := NetStats{
+		NodeID:    h.Node.Id,
+		Mode:      h.Ring.State(h.Node),
+		Operation: h.operation,
+	}
+	body, err := json.Marshal(stats)
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).Send(body)
+}
@@ -0,0 +1,32 @@
+package streaming
+
+import (
+	"fmt"
+	"sanddb/utils"
+)
+
+// OnSchemaMismatch pulls the schema of a node that gossips another schema version than this node, and merges it into the schema of this node.
+// The other node does the same with the schema of this node, after which both agree on the latest version of every keyspace and table.
+func (h *StreamHandler) OnSchemaMismatch(node *utils.Node, version string) {
+	h.mu.Lock()
+	if h.schemaPulls == nil {
+		h.schemaPulls = make(map[int]bool)
+	}
+	if h.schemaPulls[node.Id] {
+		h.mu.Unlock()
+		return
+	}
+	h.schemaPulls[node.Id] = true
+	h.mu.Unlock()
+	go func() {
+		defer func() {
+			h.mu.Lock()
+			delete(h.schemaPulls, node.Id)
+			h.mu.Unlock()
+		}()
+		fmt.Printf("Node %d has schema version %s, pulling its schema.\n", node.Id, version)
+		if err := h.fetchSchema(node); err != nil {
+			fmt.Printf("Error in pulling the schema of node %d: %s\n", node.Id, err.Error())
+		}
+	}()
+}
@@ -0,0 +1,139 @@
+package streaming
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sanddb/db"
+	"sanddb/utils"
+	"sort"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleSchemaRequest returns the definition of every keyspace and table, for a joining node to create them before data is streamed to it,
+// or for a node that does not agree on the schema to catch up with it.
+func (h *StreamHandler) HandleSchemaRequest(c *fiber.Ctx) error {
+	body, err := json.Marshal(h.Storage.Schema())
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).Send(body)
+}
+
+// HandleStreamRequest sends a joining node a page of the partitions of this node that it will replicate once it owns its tokens.
+// Like the batches pushed by a decommission, a page holds at most STREAM_BATCH_PARTITIONS partitions, so that a large data set
+// is not held in memory or sent in a single response, which could not make it within the timeout of the joining node.
+func (h *StreamHandler) HandleStreamRequest(c *fiber.Ctx) error {
+	var req StreamRequest
+	if err := c.BodyParser(&req); err != nil {
+		return err
+	}
+	target := h.Ring.NodeByID(req.NodeID)
+	if target == nil {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Node %d has not been gossiped to node %d yet.", req.NodeID, h.Node.Id))
+	}
+	response, err := h.streamPage(target, req.After)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Streaming %d partitions to node %d.\n", batchSize(response), target.Id)
+	body, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).Send(body)
+}
+
+// streamPage returns the partitions that target will replicate from the position after which the last page ended, up to a full batch.
+func (h *StreamHandler) streamPage(target *utils.Node, after *StreamPosition) (*StreamResponse, error) {
+	tables := h.Storage.Tables()
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].TableName < tables[j].TableName
+	})
+	var batches []*StreamResponse
+	var last *StreamPosition
+	for _, table := range tables {
+		start := int64(math.MinInt64)
+		if after != nil && table.TableName < after.TableName {
+			continue
+		} else if after != nil && table.TableName == after.TableName {
+			start = after.PartitionKey
+		}
+		partitions, err := h.Storage.ReadTableAfter(table.TableName, start)
+		if err == db.ErrTableNotFound {
+			// Dropped since the tables were listed
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		strategy := table.Strategy(h.Ring.Strategy)
+		for _, partition := range partitions {
+			if !h.Ring.WillReplicate(strategy, target, partition.Metadata.PartitionKey) {
+				continue
+			}
+			if len(batches) > 0 && batchSize(batches[0]) >= STREAM_BATCH_PARTITIONS {
+				return &StreamResponse{SourceID: h.Node.Id, Tables: batches[0].Tables, Next: last}, nil
+			}
+			batches = addToBatch(batches, h.Node.Id, table, partition)
+			last = &StreamPosition{TableName: table.TableName, PartitionKey: partition.Metadata.PartitionKey}
+		}
+	}
+	if len(batches) == 0 {
+		return &StreamResponse{SourceID: h.Node.Id, Tables: make([]*StreamedTable, 0)}, nil
+	}
+	return batches[0], nil
+}
+
+// HandleStreamReceive writes the partitions pushed by a node that hands its ranges over to this one.
+func (h *StreamHandler) HandleStreamReceive(c *fiber.Ctx) error {
+	var req StreamResponse
+	if err := c.BodyParser(&req); err != nil {
+		return err
+	}
+	written, err := h.writeStreamed(&req)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Received %d partitions from node %d.\n", written, req.SourceID)
+	return c.SendStatus(http.StatusOK)
+}
+
+// HandleCleanup drops the partitions that this node no longer replicates, e.g. after a node joined and took over part of its range.
+func (h *StreamHandler) HandleCleanup(c *fiber.Ctx) error {
+	response := CleanupResponse{
+		NodeID:            h.Node.Id,
+		PartitionsDropped: make(map[string]int),
+	}
+	for _, table := range h.Storage.Tables() {
+		partitions, err := h.Storage.ReadTable(table.TableName)
+		if err != nil {
+			return err
+		}
+		strategy := table.Strategy(h.Ring.Strategy)
+		dropped := 0
+		for _, partition := range partitions {
+			if !h.Ring.IsReplica(strategy, h.Node, partition.Metadata.PartitionKey) {
+				dropped++
+			}
+		}
+		response.PartitionsDropped[table.TableName] = dropped
+		if dropped == 0 {
+			continue
+		}
+		// Partitions can only be dropped by rewriting the table's SSTables without them
+		fmt.Printf("Dropping %d partitions of table %s that node %d no longer replicates.\n", dropped, table.TableName, h.Node.Id)
+		err = h.Storage.Rewrite(table.TableName, func(metadata *db.PartitionMetadata, row *db.Row) bool {
+			return h.Ring.IsReplica(strategy, h.Node, metadata.PartitionKey)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	body, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusOK).Send(body)
+}
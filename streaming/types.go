@@ -0,0 +1,126 @@
+package streaming
+
+import (
+	"sanddb/db"
+	"sanddb/gossip"
+	"sanddb/messages"
+	"sanddb/utils"
+	"sync"
+	"time"
+)
+
+const (
+	OPERATION_BOOTSTRAP    = "BOOTSTRAP"
+	OPERATION_DECOMMISSION = "DECOMMISSION"
+	OPERATION_REMOVENODE   = "REMOVENODE"
+	// OPERATION_RESTORE is run by every node during a removenode, to re-replicate the ranges of the removed node that it holds
+	OPERATION_RESTORE = "RESTORE"
+)
+
+const (
+	STREAM_RUNNING   = "RUNNING"
+	STREAM_COMPLETED = "COMPLETED"
+	STREAM_FAILED    = "FAILED"
+)
+
+/* StreamHandler
+Moves the partitions of a token range between nodes when the ring changes.
+Timeout: time after which a node that does not answer a stream request is given up on
+TokensFile: where the tokens of this node are saved once it has joined the ring
+operation: the last bootstrap, decommission or removenode run by this node, only one can run at a time
+schemaPulls: nodes whose schema is being pulled, so that a node is not asked for it again every gossip round
+*/
+type StreamHandler struct {
+	Node       *utils.Node
+	Ring       *utils.Ring
+	Storage    *db.StorageEngine
+	Gossiper   *gossip.Gossiper
+	Timeout    time.Duration
+	TokensFile string
+
+	mu          sync.Mutex
+	operation   *StreamOperation
+	schemaPulls map[int]bool
+}
+
+/* StreamOperation
+Progress of a bootstrap, decommission or removenode, as reported by /netstats.
+NodeID: node whose ranges are streamed, i.e. the joining, leaving or removed node
+PartitionsTotal: number of partitions this node has to stream, counted once per node that they are sent to or received from.
+A bootstrap only learns it page by page, as the nodes it streams from send them
+Nodes: status of every node that re-replicates the ranges of the removed node, only for a removenode
+*/
+type StreamOperation struct {
+	Operation          string         `json:"operation"`
+	NodeID             int            `json:"node_id"`
+	Status             string         `json:"status"`
+	StartedAt          time.Time      `json:"started_at"`
+	FinishedAt         *time.Time     `json:"finished_at,omitempty"`
+	PartitionsTotal    int            `json:"partitions_total"`
+	PartitionsStreamed int            `json:"partitions_streamed"`
+	Nodes              map[int]string `json:"nodes,omitempty"`
+	Error              string         `json:"error,omitempty"`
+}
+
+/* NetStats
+Mode: state of this node in the ring
+Operation: last bootstrap, decommission or removenode run by this node, if any
+*/
+type NetStats struct {
+	NodeID    int              `json:"node_id"`
+	Mode      utils.NodeState  `json:"mode"`
+	Operation *StreamOperation `json:"operation"`
+}
+
+/* RemoveNodeRequest
+NodeID: dead node to take out of the ring, its ranges are re-replicated from the nodes that hold a copy of them
+*/
+type RemoveNodeRequest struct {
+	NodeID int `json:"node_id"`
+}
+
+/* StreamRequest
+NodeID: node that the partitions are streamed to, only the partitions it replicates once it owns its tokens are sent
+After: where the last page of the stream ended, nil for the first page
+*/
+type StreamRequest struct {
+	NodeID int             `json:"node_id"`
+	After  *StreamPosition `json:"after,omitempty"`
+}
+
+/* StreamPosition
+The last partition of a page of a stream. Tables are streamed in the order of their names, and the partitions of a table in token order.
+*/
+type StreamPosition struct {
+	TableName    string `json:"table_name"`
+	PartitionKey int64  `json:"partition_key"`
+}
+
+/* StreamedTable
+Schema: definition of the table, sent along with the partitions pushed to a node, for it to create the table if it does not have it
+Keyspace: definition of the keyspace of the table, sent along with Schema
+*/
+type StreamedTable struct {
+	TableName  string                    `json:"table_name"`
+	Schema     *messages.CreateRequest   `json:"schema,omitempty"`
+	Keyspace   *messages.KeyspaceRequest `json:"keyspace,omitempty"`
+	Partitions []*db.Partition           `json:"partitions"`
+}
+
+/* StreamResponse
+Partitions sent by SourceID, either in answer to a StreamRequest, or pushed to a node that takes over a range during a decommission or removenode.
+Next: where the page ends, for the joining node to ask for the next one, nil once every partition has been sent
+*/
+type StreamResponse struct {
+	SourceID int              `json:"source_id"`
+	Tables   []*StreamedTable `json:"tables"`
+	Next     *StreamPosition  `json:"next,omitempty"`
+}
+
+/* CleanupResponse
+PartitionsDropped: number of partitions dropped from every table, because this node no longer replicates them
+*/
+type CleanupResponse struct {
+	NodeID            int            `json:"node_id"`
+	PartitionsDropped map[string]int `json:"partitions_dropped"`
+}
@@ -4,7 +4,21 @@ import (
 	"fmt"
 )
 
+// TOKEN_SPACE is the number of tokens on the ring, tokens being the signed 64-bit integers given to partition keys by the partitioner
+const TOKEN_SPACE = float64(1 << 64)
+
+// Token returns the token of a partition key.
+func (r *Ring) Token(partitionKey string) int64 {
+	return r.Partitioner.Token(partitionKey)
+}
+
 func (r *Ring) Search(hash int64) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.search(hash)
+}
+
+func (r *Ring) search(hash int64) int {
 	index := 0
 	for idx, nodeHash := range r.NodeHashes {
 		if hash <= nodeHash {
@@ -17,31 +31,184 @@ func (r *Ring) Search(hash int64) int {
 }
 
 func (r *Ring) GetNode(partitionKey string) *Node {
-	hash := GetHash(partitionKey)
-	index := r.Search(hash)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	hash := r.Token(partitionKey)
+	index := r.search(hash)
 
 	nodeHash := r.NodeHashes[index]
 	return r.NodeMap[nodeHash]
 }
 
-func (r *Ring) Replicate(partitionKey string) []*Node {
-	nodesToReplicateTo := []*Node{}
-	hash := GetHash(partitionKey)
-	index := r.Search(hash)
-	fmt.Printf("Replicating from node with hash %d\n", hash)
-
-	// replicated nodes
-	fmt.Println("Nodes to replicate to:")
-	for i := 1; i < r.ReplicationFactor; i++ {
-		replIdx := (index + i) % len(r.NodeHashes)
-		fmt.Println(replIdx)
-		nodeHash := r.NodeHashes[replIdx]
-		node := r.NodeMap[nodeHash]
-		nodesToReplicateTo = append(nodesToReplicateTo, node)
-		fmt.Println(node.Hash)
+/* TokenRange
+Tokens from Start, excluded, up to End
+*/
+type TokenRange struct {
+	Start int64 `json:"start_token"`
+	End   int64 `json:"end_token"`
+}
+
+// SplitRange cuts the tokens from left, excluded, up to right, which has to be greater, at the tokens of the nodes that own them, dead or alive,
+// so that the partitions of each range have the same natural replicas. The ranges are returned in token order.
+func (r *Ring) SplitRange(left int64, right int64) []TokenRange {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ranges := make([]TokenRange, 0)
+	for _, token := range r.normalTokens() {
+		if token > left && token < right {
+			ranges = append(ranges, TokenRange{Start: left, End: token})
+			left = token
+		}
+	}
+	return append(ranges, TokenRange{Start: left, End: right})
+}
+
+// NaturalReplicas returns the nodes a partition is replicated to when none of them is dead, starting with the one that owns it.
+// Dead nodes are left out of NodeHashes but not out of these, so coordinators route requests to them, and keep hints for the dead ones.
+func (r *Ring) NaturalReplicas(strategy ReplicationStrategy, partitionKey string) []*Node {
+	return r.NaturalReplicasForToken(strategy, r.Token(partitionKey))
+}
+
+func (r *Ring) NaturalReplicasForToken(strategy ReplicationStrategy, token int64) []*Node {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return strategy.replicas(r.normalTokens(), r.NodeMap, token)
+}
+
+// PrimaryReplica returns the first of the natural replicas of token, nil if no node owns a token.
+func (r *Ring) PrimaryReplica(strategy ReplicationStrategy, token int64) *Node {
+	replicas := r.NaturalReplicasForToken(strategy, token)
+	if len(replicas) == 0 {
+		return nil
+	}
+	return replicas[0]
+}
+
+// PrimaryRangeReplicas returns the nodes that replicate the token ranges node is the primary replica of with any of the strategies, node included.
+// With several tokens per node, these ranges are spread over the ring, so they are usually replicated by most nodes.
+func (r *Ring) PrimaryRangeReplicas(node *Node, strategies []ReplicationStrategy) []*Node {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	nodeHashes := r.normalTokens()
+	replicas := []*Node{node}
+	for _, strategy := range strategies {
+		for _, token := range node.Tokens {
+			for _, replica := range strategy.replicas(nodeHashes, r.NodeMap, token) {
+				if !containsNode(replicas, replica) {
+					replicas = append(replicas, replica)
+				}
+			}
+		}
+	}
+	return replicas
+}
+
+// Ownership returns the share of the token space, between 0 and 1, that every node is the primary replica of,
+// and the share that it holds a replica of with strategy, keyed by node ID.
+func (r *Ring) Ownership(strategy ReplicationStrategy) (map[int]float64, map[int]float64) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	primary := make(map[int]float64)
+	effective := make(map[int]float64)
+	nodeHashes := r.normalTokens()
+	for i, token := range nodeHashes {
+		// A token owns the range from the previous token, excluded, up to itself, wrapping around the ring
+		previous := nodeHashes[(i+len(nodeHashes)-1)%len(nodeHashes)]
+		share := r.Partitioner.RangeSize(previous, token)
+		primary[r.NodeMap[token].Id] += share
+		for _, replica := range strategy.replicas(nodeHashes, r.NodeMap, token) {
+			effective[replica.Id] += share
+		}
+	}
+	return primary, effective
+}
+
+// IsReplica reports whether node is one of the natural replicas of token.
+func (r *Ring) IsReplica(strategy ReplicationStrategy, node *Node, token int64) bool {
+	return containsNode(r.NaturalReplicasForToken(strategy, token), node)
+}
+
+// PendingReplicas returns the nodes that will replicate a partition once the joining and leaving nodes are done streaming,
+// i.e. the joining nodes that will own it, and the nodes that take it over from a leaving or removed node.
+// They have to receive the writes to the partition already, so that they do not miss the ones made while its data is streamed.
+func (r *Ring) PendingReplicas(strategy ReplicationStrategy, partitionKey string) []*Node {
+	token := r.Token(partitionKey)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	pending := make([]*Node, 0)
+	for _, node := range r.Nodes {
+		if node.State == STATE_JOINING && node.Status == ALIVE && r.replicatesOnceNormal(strategy, node, token) {
+			pending = append(pending, node)
+		}
+		if node.State == STATE_LEAVING || node.State == STATE_REMOVING {
+			for _, gained := range r.gainedReplicas(strategy, node, token) {
+				if gained.Status == ALIVE && !containsNode(pending, gained) {
+					pending = append(pending, gained)
+				}
+			}
+		}
 	}
+	return pending
+}
+
+// GainedReplicas returns the nodes that will start replicating token once leaving is out of the ring.
+func (r *Ring) GainedReplicas(strategy ReplicationStrategy, leaving *Node, token int64) []*Node {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.gainedReplicas(strategy, leaving, token)
+}
+
+func (r *Ring) gainedReplicas(strategy ReplicationStrategy, leaving *Node, token int64) []*Node {
+	nodeHashes := r.normalTokens()
+	replicas := strategy.replicas(nodeHashes, r.NodeMap, token)
+	if !containsNode(replicas, leaving) {
+		return nil
+	}
+	gained := make([]*Node, 0, 1)
+	for _, node := range strategy.replicas(withoutTokens(nodeHashes, leaving.Tokens), r.NodeMap, token) {
+		if !containsNode(replicas, node) {
+			gained = append(gained, node)
+		}
+	}
+	return gained
+}
 
-	return nodesToReplicateTo
+// WillReplicate reports whether a joining node will replicate token once it owns its tokens.
+func (r *Ring) WillReplicate(strategy ReplicationStrategy, node *Node, token int64) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.replicatesOnceNormal(strategy, node, token)
+}
+
+func (r *Ring) replicatesOnceNormal(strategy ReplicationStrategy, node *Node, token int64) bool {
+	nodeHashes := r.normalTokens()
+	for _, nodeToken := range node.Tokens {
+		if !IsInNodeHash(nodeHashes, nodeToken) {
+			nodeHashes = append(nodeHashes, nodeToken)
+		}
+	}
+	nodeHashes = Sort(nodeHashes)
+	return containsNode(strategy.replicas(nodeHashes, r.NodeMap, token), node)
+}
+
+// normalTokens returns the sorted tokens of every node that owns its tokens, dead or alive. r.mu must be held.
+func (r *Ring) normalTokens() []int64 {
+	nodeHashes := make([]int64, 0, len(r.Nodes))
+	for _, node := range r.Nodes {
+		if node.State.ownsToken() {
+			nodeHashes = append(nodeHashes, node.Tokens...)
+		}
+	}
+	return Sort(nodeHashes)
+}
+
+func containsNode(nodes []*Node, node *Node) bool {
+	for _, n := range nodes {
+		if n.Id == node.Id {
+			return true
+		}
+	}
+	return false
 }
 
 // func (r *Ring) AddNode(node *Node) {
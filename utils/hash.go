@@ -2,25 +2,37 @@ package utils
 
 import (
 	"crypto/md5"
+	"encoding/binary"
+	"fmt"
 	"sort"
-	"unsafe"
+	"strconv"
 )
 
+// ByteArrayToInt reads the first 8 bytes of arr, padded with zero bytes, as a little-endian number, whatever the byte order of the platform.
 func ByteArrayToInt(arr []byte) int64 {
-	val := int64(0)
-	size := len(arr)
-	for i := 0; i < size; i++ {
-		*(*uint8)(unsafe.Pointer(uintptr(unsafe.Pointer(&val)) + uintptr(i))) = arr[i]
-	}
-	return val
+	var buf [8]byte
+	copy(buf[:], arr)
+	return int64(binary.LittleEndian.Uint64(buf[:]))
 }
 
+// GetHash hashes id with md5 into 64 bits. Rows are identified by the hash of their clustering key,
+// while partitions are placed on the ring by the token their partition key is given by the Partitioner of the ring.
 func GetHash(id string) int64 {
 	data := []byte(id)
 	hash := md5.Sum(data)
 	return ByteArrayToInt(hash[:])
 }
 
+// NodeTokens returns the tokens of a node, spread over the ring by the partitioner from its ID along with the index of every token.
+// The first token is derived from the ID alone, which is the only token of a node when num_tokens is 1.
+func NodeTokens(partitioner Partitioner, id int, numTokens int) []int64 {
+	tokens := []int64{partitioner.NodeToken(strconv.Itoa(id))}
+	for i := 1; i < numTokens; i++ {
+		tokens = append(tokens, partitioner.NodeToken(fmt.Sprintf("%d-%d", id, i)))
+	}
+	return tokens
+}
+
 func GetHashFromKeys(keys []string) int64 {
 	concatKey := ""
 	for _, key := range keys {
@@ -28,20 +40,12 @@ func GetHashFromKeys(keys []string) int64 {
 	}
 	return GetHash(concatKey)
 }
+
+// Sort returns a sorted copy of the tokens, compared as int64 so that the ring has the same order on every platform.
 func Sort(int64Values []int64) []int64 {
 	out := make([]int64, len(int64Values))
-	int64AsIntValues := make([]int, len(int64Values))
-
-	for i, val := range int64Values {
-		int64AsIntValues[i] = int(val)
-	}
-
-	sort.Ints(int64AsIntValues)
-
-	for i, val := range int64AsIntValues {
-		out[i] = int64(val)
-	}
-
+	copy(out, int64Values)
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
 	return out
 }
 
@@ -0,0 +1,200 @@
+package utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"strings"
+
+	"github.com/spaolacci/murmur3"
+)
+
+const (
+	// PARTITIONER_MURMUR3 spreads partitions evenly over the ring by hashing their key with murmur3
+	PARTITIONER_MURMUR3 = "Murmur3Partitioner"
+	// PARTITIONER_RANDOM hashes partition keys with md5, as tokens were computed before partitioners could be chosen
+	PARTITIONER_RANDOM = "RandomPartitioner"
+	// PARTITIONER_ORDER_PRESERVING keeps partitions in the order of their key, for range scan experiments
+	PARTITIONER_ORDER_PRESERVING = "OrderPreservingPartitioner"
+)
+
+// ORDER_PRESERVING_KEY_SIZE is the number of bytes of a partition key that fit in a token of the order-preserving partitioner
+const ORDER_PRESERVING_KEY_SIZE = 8
+
+// Partitioner places partitions on the ring, by turning their partition key into a token.
+// Every node of a cluster has to use the same one, as the tokens of a partition are compared across nodes.
+type Partitioner interface {
+	// Name is the value of partitioner in config.yml that selects the partitioner
+	Name() string
+	// Token returns the token of a partition key, i.e. the concatenation of its values
+	Token(partitionKey string) int64
+	// ValidateKey returns an error if partitionKey can not be given a token of its own
+	ValidateKey(partitionKey string) error
+	// NodeToken returns a token to place a node at, derived from seed so that every node computes the same tokens for a node
+	NodeToken(seed string) int64
+	// MinToken and MaxToken are the lowest and highest tokens, the ring wraps around from MaxToken to MinToken.
+	// MinToken is never the token of a partition, it stands for the excluded start of a range that covers the whole ring.
+	MinToken() int64
+	MaxToken() int64
+	// RangeSize returns the share of the ring, between 0 and 1, covered by the range from left, excluded, up to right, wrapping around the ring.
+	// The range is the whole ring when left equals right.
+	RangeSize(left int64, right int64) float64
+	// Midpoint returns the token halfway through the range from left, excluded, up to right
+	Midpoint(left int64, right int64) int64
+	// Split returns the parts-1 tokens that cut the range from left, excluded, up to right into parts of the same size, in ring order
+	Split(left int64, right int64, parts int) []int64
+}
+
+// NewPartitioner returns the partitioner called name, Murmur3Partitioner if name is empty.
+func NewPartitioner(name string) (Partitioner, error) {
+	switch name {
+	case "", PARTITIONER_MURMUR3:
+		return murmur3Partitioner{}, nil
+	case PARTITIONER_RANDOM:
+		return randomPartitioner{}, nil
+	case PARTITIONER_ORDER_PRESERVING:
+		return orderPreservingPartitioner{}, nil
+	}
+	return nil, fmt.Errorf("unknown partitioner %s, expected %s, %s or %s", name, PARTITIONER_MURMUR3, PARTITIONER_RANDOM, PARTITIONER_ORDER_PRESERVING)
+}
+
+// int64Tokens is the token range arithmetic of the partitioners, whose tokens all are signed 64-bit integers.
+type int64Tokens struct{}
+
+func (int64Tokens) MinToken() int64 {
+	return math.MinInt64
+}
+
+func (int64Tokens) MaxToken() int64 {
+	return math.MaxInt64
+}
+
+// width returns the number of tokens in the range from left, excluded, up to right, 0 standing for the whole ring.
+func width(left int64, right int64) uint64 {
+	return uint64(right) - uint64(left)
+}
+
+// partitionToken moves a key whose token is the lowest one to the highest, as Cassandra does, since the lowest token is not given to partitions.
+func partitionToken(token int64) int64 {
+	if token == math.MinInt64 {
+		return math.MaxInt64
+	}
+	return token
+}
+
+func (int64Tokens) RangeSize(left int64, right int64) float64 {
+	w := width(left, right)
+	if w == 0 {
+		return 1
+	}
+	return float64(w) / TOKEN_SPACE
+}
+
+func (t int64Tokens) Midpoint(left int64, right int64) int64 {
+	return t.Split(left, right, 2)[0]
+}
+
+func (int64Tokens) Split(left int64, right int64, parts int) []int64 {
+	tokens := make([]int64, 0)
+	w := width(left, right)
+	for i := 1; i < parts; i++ {
+		// i/parts of the range, computed on 128 bits since the range can be the whole ring of 1 << 64 tokens
+		hi, lo := bits.Mul64(w, uint64(i))
+		if w == 0 {
+			hi, lo = uint64(i), 0
+		}
+		offset, _ := bits.Div64(hi, lo, uint64(parts))
+		tokens = append(tokens, int64(uint64(left)+offset))
+	}
+	return tokens
+}
+
+type murmur3Partitioner struct {
+	int64Tokens
+}
+
+func (murmur3Partitioner) Name() string {
+	return PARTITIONER_MURMUR3
+}
+
+// Token returns the first 64 bits of the 128-bit murmur3 hash of the key, as Cassandra does.
+func (murmur3Partitioner) Token(partitionKey string) int64 {
+	h1, _ := murmur3.Sum128([]byte(partitionKey))
+	return partitionToken(int64(h1))
+}
+
+func (murmur3Partitioner) ValidateKey(partitionKey string) error {
+	return nil
+}
+
+func (p murmur3Partitioner) NodeToken(seed string) int64 {
+	return p.Token(seed)
+}
+
+type randomPartitioner struct {
+	int64Tokens
+}
+
+func (randomPartitioner) Name() string {
+	return PARTITIONER_RANDOM
+}
+
+func (randomPartitioner) Token(partitionKey string) int64 {
+	return partitionToken(GetHash(partitionKey))
+}
+
+func (randomPartitioner) ValidateKey(partitionKey string) error {
+	return nil
+}
+
+func (randomPartitioner) NodeToken(seed string) int64 {
+	return GetHash(seed)
+}
+
+// orderPreservingPartitioner uses the first bytes of a partition key as its token, so that the ring is sorted by key.
+// Keys are not spread evenly, the nodes own shares of the ring that depend on which keys are used.
+type orderPreservingPartitioner struct {
+	int64Tokens
+}
+
+// orderPreservingAlphabet is what the tokens of the nodes are made of, so that they split the keys written in it
+const orderPreservingAlphabet = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+func (orderPreservingPartitioner) Name() string {
+	return PARTITIONER_ORDER_PRESERVING
+}
+
+// Token reads the key, padded with zero bytes, as a big-endian number. Flipping its top bit makes the signed order of tokens the byte order of keys.
+// The empty key, which would be read as the lowest token, comes right after it instead, still before every other key.
+func (orderPreservingPartitioner) Token(partitionKey string) int64 {
+	if partitionKey == "" {
+		return math.MinInt64 + 1
+	}
+	var prefix [ORDER_PRESERVING_KEY_SIZE]byte
+	copy(prefix[:], partitionKey)
+	return int64(binary.BigEndian.Uint64(prefix[:]) ^ (1 << 63))
+}
+
+// ValidateKey rejects the keys that do not get a token of their own, since partitions are told apart by their token alone:
+// the keys that do not fit in a token, and the ones with zero bytes, which could not be told apart from the padding, e.g. "a" and "a\x00".
+func (orderPreservingPartitioner) ValidateKey(partitionKey string) error {
+	if len(partitionKey) > ORDER_PRESERVING_KEY_SIZE {
+		return fmt.Errorf("partition key %q is longer than the %d bytes supported by the %s", partitionKey, ORDER_PRESERVING_KEY_SIZE, PARTITIONER_ORDER_PRESERVING)
+	}
+	if strings.IndexByte(partitionKey, 0) >= 0 {
+		return fmt.Errorf("partition key %q contains a zero byte, which is not supported by the %s", partitionKey, PARTITIONER_ORDER_PRESERVING)
+	}
+	return nil
+}
+
+// NodeToken places a node at a key of lowercase letters and digits picked by hashing seed.
+func (p orderPreservingPartitioner) NodeToken(seed string) int64 {
+	hash := murmur3.Sum64([]byte(seed))
+	key := make([]byte, ORDER_PRESERVING_KEY_SIZE)
+	for i := range key {
+		key[i] = orderPreservingAlphabet[hash%uint64(len(orderPreservingAlphabet))]
+		hash /= uint64(len(orderPreservingAlphabet))
+	}
+	return p.Token(string(key))
+}
@@ -0,0 +1,230 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// STRATEGY_SIMPLE places the replicas of a partition on the next nodes clockwise, whatever their datacenter and rack
+	STRATEGY_SIMPLE = "SimpleStrategy"
+	// STRATEGY_NETWORK_TOPOLOGY places a given number of replicas in every datacenter, on as many racks as possible
+	STRATEGY_NETWORK_TOPOLOGY = "NetworkTopologyStrategy"
+)
+
+const (
+	// DEFAULT_DATACENTER and DEFAULT_RACK are where nodes that are not given a datacenter or a rack are
+	DEFAULT_DATACENTER = "datacenter1"
+	DEFAULT_RACK       = "rack1"
+)
+
+// ReplicationStrategy decides which nodes hold the replicas of a partition.
+type ReplicationStrategy interface {
+	// Class is the name of the strategy in the replication options of a table
+	Class() string
+	// ReplicationFactor is the number of replicas of every partition, across all datacenters
+	ReplicationFactor() int
+	// DatacenterFactors returns the number of replicas of every partition in each datacenter, nil if the strategy ignores datacenters
+	DatacenterFactors() map[string]int
+	// CheckNodes returns an error if nodes are too few to hold every replica of a partition
+	CheckNodes(nodes []*Node) error
+	// replicas walks the sorted nodeHashes clockwise from token, and returns the nodes that hold a replica of it, starting with its primary replica
+	replicas(nodeHashes []int64, owners map[int64]*Node, token int64) []*Node
+}
+
+// NewReplicationStrategy returns the strategy called class. SimpleStrategy uses replicationFactor,
+// NetworkTopologyStrategy uses the number of replicas of every datacenter in datacenters.
+func NewReplicationStrategy(class string, replicationFactor int, datacenters map[string]int) (ReplicationStrategy, error) {
+	switch class {
+	case "", STRATEGY_SIMPLE:
+		if replicationFactor < 1 {
+			return nil, fmt.Errorf("invalid replication_factor %d for %s: must be at least 1", replicationFactor, STRATEGY_SIMPLE)
+		}
+		return &SimpleStrategy{Factor: replicationFactor}, nil
+	case STRATEGY_NETWORK_TOPOLOGY:
+		if len(datacenters) == 0 {
+			return nil, fmt.Errorf("%s needs the replication factor of at least one datacenter", STRATEGY_NETWORK_TOPOLOGY)
+		}
+		total := 0
+		for dc, factor := range datacenters {
+			if factor < 0 {
+				return nil, fmt.Errorf("invalid replication factor %d for datacenter %s: must be positive", factor, dc)
+			}
+			total += factor
+		}
+		if total == 0 {
+			return nil, fmt.Errorf("%s needs at least one replica", STRATEGY_NETWORK_TOPOLOGY)
+		}
+		return &NetworkTopologyStrategy{Datacenters: datacenters}, nil
+	}
+	return nil, fmt.Errorf("unknown replication class %s, expected %s or %s", class, STRATEGY_SIMPLE, STRATEGY_NETWORK_TOPOLOGY)
+}
+
+/* SimpleStrategy
+Factor: number of replicas of every partition, held by the first distinct nodes met clockwise from its token
+*/
+type SimpleStrategy struct {
+	Factor int
+}
+
+func (s *SimpleStrategy) Class() string {
+	return STRATEGY_SIMPLE
+}
+
+func (s *SimpleStrategy) ReplicationFactor() int {
+	return s.Factor
+}
+
+func (s *SimpleStrategy) DatacenterFactors() map[string]int {
+	return nil
+}
+
+func (s *SimpleStrategy) CheckNodes(nodes []*Node) error {
+	if len(nodes) < s.Factor {
+		return fmt.Errorf("%d nodes for a replication factor of %d", len(nodes), s.Factor)
+	}
+	return nil
+}
+
+// replicas skips the tokens of the nodes that already hold a replica.
+func (s *SimpleStrategy) replicas(nodeHashes []int64, owners map[int64]*Node, token int64) []*Node {
+	replicas := make([]*Node, 0, s.Factor)
+	if len(nodeHashes) == 0 {
+		return replicas
+	}
+	index := firstTokenIndex(nodeHashes, token)
+	seen := make(map[int]bool)
+	for i := 0; i < len(nodeHashes) && len(replicas) < s.Factor; i++ {
+		node := owners[nodeHashes[(index+i)%len(nodeHashes)]]
+		if seen[node.Id] {
+			continue
+		}
+		seen[node.Id] = true
+		replicas = append(replicas, node)
+	}
+	return replicas
+}
+
+/* NetworkTopologyStrategy
+Datacenters: number of replicas of every partition in each datacenter, the datacenters that are left out hold none
+*/
+type NetworkTopologyStrategy struct {
+	Datacenters map[string]int
+}
+
+func (s *NetworkTopologyStrategy) Class() string {
+	return STRATEGY_NETWORK_TOPOLOGY
+}
+
+func (s *NetworkTopologyStrategy) ReplicationFactor() int {
+	total := 0
+	for _, factor := range s.Datacenters {
+		total += factor
+	}
+	return total
+}
+
+func (s *NetworkTopologyStrategy) DatacenterFactors() map[string]int {
+	return s.Datacenters
+}
+
+func (s *NetworkTopologyStrategy) CheckNodes(nodes []*Node) error {
+	perDatacenter := make(map[string]int)
+	for _, node := range nodes {
+		perDatacenter[node.Datacenter]++
+	}
+	dcs := make([]string, 0, len(s.Datacenters))
+	for dc := range s.Datacenters {
+		dcs = append(dcs, dc)
+	}
+	sort.Strings(dcs)
+	for _, dc := range dcs {
+		if perDatacenter[dc] < s.Datacenters[dc] {
+			return fmt.Errorf("%d nodes in datacenter %s for a replication factor of %d", perDatacenter[dc], dc, s.Datacenters[dc])
+		}
+	}
+	return nil
+}
+
+// replicas walks the ring like Cassandra does: in every datacenter, a node on a rack that already holds a replica is skipped
+// until every rack of the datacenter holds one, and the skipped nodes are then used in the order they were met.
+func (s *NetworkTopologyStrategy) replicas(nodeHashes []int64, owners map[int64]*Node, token int64) []*Node {
+	replicas := make([]*Node, 0, s.ReplicationFactor())
+	if len(nodeHashes) == 0 {
+		return replicas
+	}
+	// Racks and nodes of every datacenter, as no datacenter can hold more replicas than it has nodes
+	racks := make(map[string]map[string]bool)
+	nodes := make(map[string]map[int]bool)
+	for _, nodeHash := range nodeHashes {
+		node := owners[nodeHash]
+		if racks[node.Datacenter] == nil {
+			racks[node.Datacenter] = make(map[string]bool)
+			nodes[node.Datacenter] = make(map[int]bool)
+		}
+		racks[node.Datacenter][node.Rack] = true
+		nodes[node.Datacenter][node.Id] = true
+	}
+	wanted := make(map[string]int)
+	missing := 0
+	for dc, factor := range s.Datacenters {
+		if factor > len(nodes[dc]) {
+			factor = len(nodes[dc])
+		}
+		wanted[dc] = factor
+		missing += factor
+	}
+
+	placed := make(map[string]int)
+	seenRacks := make(map[string]map[string]bool)
+	skipped := make(map[string][]*Node)
+	chosen := make(map[int]bool)
+	add := func(node *Node) {
+		chosen[node.Id] = true
+		replicas = append(replicas, node)
+		placed[node.Datacenter]++
+		missing--
+	}
+	index := firstTokenIndex(nodeHashes, token)
+	for i := 0; i < len(nodeHashes) && missing > 0; i++ {
+		node := owners[nodeHashes[(index+i)%len(nodeHashes)]]
+		dc := node.Datacenter
+		if chosen[node.Id] || placed[dc] >= wanted[dc] {
+			continue
+		}
+		if seenRacks[dc] == nil {
+			seenRacks[dc] = make(map[string]bool)
+		}
+		if len(seenRacks[dc]) == len(racks[dc]) {
+			// Every rack already holds a replica, any node will do
+			add(node)
+			continue
+		}
+		if seenRacks[dc][node.Rack] {
+			if !containsNode(skipped[dc], node) {
+				skipped[dc] = append(skipped[dc], node)
+			}
+			continue
+		}
+		add(node)
+		seenRacks[dc][node.Rack] = true
+		if len(seenRacks[dc]) == len(racks[dc]) {
+			for _, skippedNode := range skipped[dc] {
+				if placed[dc] >= wanted[dc] {
+					break
+				}
+				add(skippedNode)
+			}
+		}
+	}
+	return replicas
+}
+
+// firstTokenIndex returns the index of the first of the sorted nodeHashes at or after token, wrapping around to 0.
+func firstTokenIndex(nodeHashes []int64, token int64) int {
+	index := sort.Search(len(nodeHashes), func(i int) bool { return nodeHashes[i] >= token })
+	if index == len(nodeHashes) {
+		return 0
+	}
+	return index
+}
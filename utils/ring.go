@@ -1,5 +1,10 @@
 package utils
 
+import (
+	"fmt"
+	"sync"
+)
+
 type NodeStatus int
 
 const (
@@ -11,22 +16,211 @@ func (s NodeStatus) String() string {
 	return [...]string{"Alive", "Dead"}[s]
 }
 
+// NodeState is the part a node plays in the ring, as opposed to NodeStatus, which only tells whether it is reachable.
+type NodeState string
+
+const (
+	// STATE_NORMAL nodes own their tokens and serve reads and writes
+	STATE_NORMAL NodeState = "NORMAL"
+	// STATE_JOINING nodes are streaming the data of their tokens from the other nodes, and only receive writes
+	STATE_JOINING NodeState = "JOINING"
+	// STATE_SHUTDOWN is gossiped by a node that is being stopped, so that the others mark it dead right away
+	STATE_SHUTDOWN NodeState = "SHUTDOWN"
+	// STATE_LEAVING nodes are being decommissioned, they still own their tokens while they stream their data to the nodes that take it over
+	STATE_LEAVING NodeState = "LEAVING"
+	// STATE_REMOVING is gossiped on behalf of a dead node whose data is being re-replicated by removenode
+	STATE_REMOVING NodeState = "REMOVING"
+	// STATE_LEFT nodes have handed their token over and are taken out of the ring
+	STATE_LEFT NodeState = "LEFT"
+)
+
+// ownsToken reports whether the tokens of a node in state s is used to place data.
+func (s NodeState) ownsToken() bool {
+	return s == STATE_NORMAL || s == STATE_LEAVING || s == STATE_REMOVING
+}
+
 type Node struct {
 	//DataStore is for coordinator to store responses from other nodes before being sent back to the client
 	//DataStore map[int]PeerMessage
 	Id        int        `json:"id"`
 	IPAddress string     `json:"ip_address"`
 	Port      string     `json:"port"`
-	Hash      int64      `json:"hash"`
+	Tokens    []int64    `json:"tokens"`
 	Status    NodeStatus `json:"node_status"`
+	State     NodeState  `json:"state"`
+	// Datacenter and Rack are the failure domains of the node, NetworkTopologyStrategy spreads replicas over them
+	Datacenter string `json:"datacenter"`
+	Rack       string `json:"rack"`
 }
 
 //Ring consists of multiple Nodes
 type Ring struct {
-	Nodes             []*Node         `json:"nodes" yaml:"nodes"`
-	MinVotes          int             `json:"min_votes" yaml:"min_votes"`
-	CurrentNode       *Node           `json:"current_node"`
-	NodeMap           map[int64]*Node `json:"nodeMap"`
-	NodeHashes        []int64         `json:"nodeHashes"`
-	ReplicationFactor int             `json:"replication_factor"` // replicates at N-1 nodes
+	Nodes       []*Node         `json:"nodes" yaml:"nodes"`
+	CurrentNode *Node           `json:"current_node"`
+	NodeMap     map[int64]*Node `json:"nodeMap"`
+	NodeHashes  []int64         `json:"nodeHashes"`
+	// Strategy places the replicas of the tables that do not define their own replication, it is a SimpleStrategy with replication_factor from config.yml
+	Strategy ReplicationStrategy `json:"-" mapstructure:"-"`
+	// Partitioner gives partition keys their token, it is set from partitioner in config.yml
+	Partitioner Partitioner `json:"-" mapstructure:"-"`
+	// mu guards the status of the nodes and NodeHashes, which are updated by gossip while requests are being routed
+	mu sync.RWMutex
+}
+
+// ownsToken reports whether the tokens of node belong in NodeHashes. r.mu must be held.
+func (r *Ring) ownsToken(node *Node) bool {
+	return node.Status == ALIVE && node.State.ownsToken()
+}
+
+// addTokens puts the tokens of node in NodeHashes. r.mu must be held.
+func (r *Ring) addTokens(node *Node) {
+	for _, token := range node.Tokens {
+		if !IsInNodeHash(r.NodeHashes, token) {
+			r.NodeHashes = append(r.NodeHashes, token)
+		}
+	}
+	r.NodeHashes = Sort(r.NodeHashes)
+}
+
+// removeTokens takes the tokens of node out of NodeHashes. r.mu must be held.
+func (r *Ring) removeTokens(node *Node) {
+	r.NodeHashes = withoutTokens(r.NodeHashes, node.Tokens)
+}
+
+func withoutTokens(nodeHashes []int64, tokens []int64) []int64 {
+	remaining := make([]int64, 0, len(nodeHashes))
+	for _, nodeHash := range nodeHashes {
+		if !IsInNodeHash(tokens, nodeHash) {
+			remaining = append(remaining, nodeHash)
+		}
+	}
+	return remaining
+}
+
+// AddNode adds a node that was just learnt of to the ring.
+func (r *Ring) AddNode(node *Node) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Nodes = append(r.Nodes, node)
+	for _, token := range node.Tokens {
+		r.NodeMap[token] = node
+	}
+	if r.ownsToken(node) {
+		r.addTokens(node)
+	}
+}
+
+// RemoveNode takes a node that left the ring out of it for good.
+func (r *Ring) RemoveNode(node *Node) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	nodes := make([]*Node, 0, len(r.Nodes))
+	for _, n := range r.Nodes {
+		if n.Id != node.Id {
+			nodes = append(nodes, n)
+		}
+	}
+	r.Nodes = nodes
+	for _, token := range node.Tokens {
+		if r.NodeMap[token] == node {
+			delete(r.NodeMap, token)
+		}
+	}
+	r.removeTokens(node)
+}
+
+// SetState changes the state of node, e.g. once it has finished joining, and reports whether it changed.
+func (r *Ring) SetState(node *Node, state NodeState) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if node.State == state {
+		return false
+	}
+	node.State = state
+	if r.ownsToken(node) {
+		r.addTokens(node)
+	} else {
+		r.removeTokens(node)
+	}
+	fmt.Printf("Node %d state is %s. tokens: %d\n", node.Id, node.State, len(node.Tokens))
+	return true
+}
+
+// MarkDead takes node out of NodeHashes, so that requests are routed to the next nodes of the ring instead.
+// It reports whether the node was alive until now.
+func (r *Ring) MarkDead(node *Node) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if node.Status == DEAD {
+		return false
+	}
+	node.Status = DEAD
+	r.removeTokens(node)
+	fmt.Printf("Node %d status is %s. tokens: %d\n", node.Id, node.Status.String(), len(node.Tokens))
+	return true
+}
+
+// MarkAlive puts node back into NodeHashes. It reports whether the node was dead until now.
+func (r *Ring) MarkAlive(node *Node) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if node.Status == ALIVE {
+		return false
+	}
+	node.Status = ALIVE
+	if r.ownsToken(node) {
+		r.addTokens(node)
+	}
+	fmt.Printf("Node %d status is %s. tokens: %d\n", node.Id, node.Status.String(), len(node.Tokens))
+	return true
+}
+
+func (r *Ring) IsAlive(node *Node) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return node.Status == ALIVE
+}
+
+func (r *Ring) IsNormal(node *Node) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return node.State == STATE_NORMAL
+}
+
+func (r *Ring) State(node *Node) NodeState {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return node.State
+}
+
+// AllNodes returns every node of the ring, whatever its status or state.
+func (r *Ring) AllNodes() []*Node {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]*Node{}, r.Nodes...)
+}
+
+// AliveNodes returns the nodes of the ring that are currently alive.
+func (r *Ring) AliveNodes() []*Node {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	nodes := make([]*Node, 0, len(r.Nodes))
+	for _, node := range r.Nodes {
+		if node.Status == ALIVE {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// NodeByID returns the node of the ring with the given ID, nil if there is none.
+func (r *Ring) NodeByID(id int) *Node {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, node := range r.Nodes {
+		if node.Id == id {
+			return node
+		}
+	}
+	return nil
 }